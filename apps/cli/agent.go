@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	serviceName        = "lacia"
+	serviceDisplayName = "Lacia Watcher"
+	serviceDescription = "Tails application logs and reports errors to Lacia"
+)
+
+// runAgentCommand keeps `agent install` working as an alias for
+// `service install`.
+func runAgentCommand(args []string) {
+	if len(args) != 1 || args[0] != "install" {
+		printServiceUsage()
+		os.Exit(1)
+	}
+	runServiceCommand(args)
+}
+
+func runServiceCommand(args []string) {
+	if len(args) != 1 {
+		printServiceUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installAgent()
+	case "uninstall":
+		err = checkInstallPrivileges()
+		if err == nil {
+			err = uninstallService()
+		}
+		if err == nil {
+			fmt.Println("✓ Lacia service removed")
+		}
+	case "start":
+		err = startService()
+		if err == nil {
+			fmt.Println("✓ Lacia service started")
+		}
+	case "stop":
+		err = stopService()
+		if err == nil {
+			fmt.Println("✓ Lacia service stopped")
+		}
+	default:
+		printServiceUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Service %s failed: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func printServiceUsage() {
+	fmt.Println(`Usage:
+  lacia-cli service install      Install the watcher as a system service and start it
+  lacia-cli service uninstall    Stop and remove the system service
+  lacia-cli service start        Start the installed service
+  lacia-cli service stop         Stop the installed service`)
+}
+
+// installAgent copies the current configuration into the system config
+// directory, registers the platform service and starts it.
+func installAgent() error {
+	if err := checkInstallPrivileges(); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate executable failed: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	dir := serviceConfigDir()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("create config dir failed: %w", err)
+	}
+	if err := os.Chmod(dir, 0750); err != nil {
+		return fmt.Errorf("chmod config dir failed: %w", err)
+	}
+
+	// An existing config is copied as written, so its format and any
+	// ${VAR} references are kept instead of the expanded values.
+	var cfgPath string
+	if ConfigExists() {
+		if _, err := LoadConfig(); err != nil {
+			return err
+		}
+		src := ConfigPath()
+		cfgPath = filepath.Join(dir, filepath.Base(src))
+		if err := copyConfig(src, cfgPath, 0640); err != nil {
+			return fmt.Errorf("write config failed: %w", err)
+		}
+	} else {
+		cfg, err := RunSetup()
+		if err != nil {
+			return err
+		}
+		cfgPath = filepath.Join(dir, configFileName)
+		if err := WriteConfig(cfgPath, cfg, 0640); err != nil {
+			return fmt.Errorf("write config failed: %w", err)
+		}
+	}
+	fmt.Printf("✓ Configuration written to %s\n", cfgPath)
+
+	if err := installService(exe, cfgPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Lacia agent installed and started (%s)\n", runtime.GOOS)
+	return nil
+}
+
+func copyConfig(src, dst string, perm os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+func runCommand(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const launchdLabel = "com.lacia.agent"
+
+const launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>LACIA_CONFIG</key>
+		<string>%s</string>
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>/Library/Logs/lacia.log</string>
+	<key>StandardErrorPath</key>
+	<string>/Library/Logs/lacia.log</string>
+</dict>
+</plist>
+`
+
+func serviceConfigDir() string {
+	return "/Library/Application Support/Lacia"
+}
+
+func checkInstallPrivileges() error {
+	if os.Geteuid() != 0 {
+		return errors.New("must be run as root")
+	}
+	return nil
+}
+
+func installService(exe, cfgPath string) error {
+	plist := fmt.Sprintf(launchdPlist, launchdLabel, xmlEscape(exe), xmlEscape(cfgPath))
+	if err := os.WriteFile(launchdPlistPath, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("write plist failed: %w", err)
+	}
+	fmt.Printf("✓ Launch daemon written to %s\n", launchdPlistPath)
+
+	// Unload first so reinstalling picks up the new definition
+	runCommand("launchctl", "unload", launchdPlistPath)
+	return runCommand("launchctl", "load", "-w", launchdPlistPath)
+}
+
+func uninstallService() error {
+	runCommand("launchctl", "unload", "-w", launchdPlistPath)
+	if err := os.Remove(launchdPlistPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove plist failed: %w", err)
+	}
+	return nil
+}
+
+// KeepAlive would restart a job stopped with `launchctl stop`, so start and
+// stop load and unload the daemon instead.
+func startService() error {
+	return runCommand("launchctl", "load", launchdPlistPath)
+}
+
+func stopService() error {
+	return runCommand("launchctl", "unload", launchdPlistPath)
+}
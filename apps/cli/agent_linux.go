@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const systemdUnitPath = "/etc/systemd/system/" + serviceName + ".service"
+
+const systemdUnit = `[Unit]
+Description=%s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart="%s"
+Environment="LACIA_CONFIG=%s"
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func serviceConfigDir() string {
+	return "/etc/lacia"
+}
+
+func checkInstallPrivileges() error {
+	if os.Geteuid() != 0 {
+		return errors.New("must be run as root")
+	}
+	return nil
+}
+
+func installService(exe, cfgPath string) error {
+	unit := fmt.Sprintf(systemdUnit, serviceDescription, exe, cfgPath)
+	if err := os.WriteFile(systemdUnitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("write unit failed: %w", err)
+	}
+	fmt.Printf("✓ Service unit written to %s\n", systemdUnitPath)
+
+	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return runCommand("systemctl", "enable", "--now", serviceName)
+}
+
+func uninstallService() error {
+	runCommand("systemctl", "disable", "--now", serviceName)
+	if err := os.Remove(systemdUnitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove unit failed: %w", err)
+	}
+	return runCommand("systemctl", "daemon-reload")
+}
+
+func startService() error {
+	return runCommand("systemctl", "start", serviceName)
+}
+
+func stopService() error {
+	return runCommand("systemctl", "stop", serviceName)
+}
@@ -0,0 +1,36 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func serviceConfigDir() string {
+	return "/usr/local/etc/lacia"
+}
+
+func checkInstallPrivileges() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("must be run as root")
+	}
+	return nil
+}
+
+func installService(exe, cfgPath string) error {
+	return fmt.Errorf("service install is not supported on %s", runtime.GOOS)
+}
+
+func uninstallService() error {
+	return fmt.Errorf("service uninstall is not supported on %s", runtime.GOOS)
+}
+
+func startService() error {
+	return fmt.Errorf("service start is not supported on %s", runtime.GOOS)
+}
+
+func stopService() error {
+	return fmt.Errorf("service stop is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func serviceConfigDir() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "Lacia")
+}
+
+// The service manager rejects the connection when not elevated, which
+// installService reports with a clearer message.
+func checkInstallPrivileges() error {
+	return nil
+}
+
+func installService(exe, cfgPath string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to service manager failed (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	svcConfig := mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		if err := s.UpdateConfig(svcConfig); err != nil {
+			s.Close()
+			return fmt.Errorf("update service failed: %w", err)
+		}
+	} else {
+		s, err = m.CreateService(serviceName, exe, svcConfig)
+		if err != nil {
+			return fmt.Errorf("create service failed: %w", err)
+		}
+	}
+	defer s.Close()
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("open service key failed: %w", err)
+	}
+	defer key.Close()
+
+	if err := key.SetStringsValue("Environment", []string{"LACIA_CONFIG=" + cfgPath}); err != nil {
+		return fmt.Errorf("set service environment failed: %w", err)
+	}
+	fmt.Printf("✓ Windows service %q registered\n", serviceName)
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("start service failed: %w", err)
+	}
+	return nil
+}
+
+func openService() (*mgr.Mgr, *mgr.Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to service manager failed (run as Administrator): %w", err)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		m.Disconnect()
+		return nil, nil, fmt.Errorf("service %q is not installed: %w", serviceName, err)
+	}
+	return m, s, nil
+}
+
+func uninstallService() error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+
+	stopAndWait(s)
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("delete service failed: %w", err)
+	}
+	return nil
+}
+
+func startService() error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	return s.Start()
+}
+
+func stopService() error {
+	m, s, err := openService()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	defer s.Close()
+	return stopAndWait(s)
+}
+
+func stopAndWait(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(10 * time.Second); status.State != svc.Stopped; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service did not stop in time")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/noobiethe13/lacia/apps/cli/pkg/dedupe"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/redact"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// The pipeline lives in importable packages: pkg/watch reads the logs,
+// pkg/detect finds the errors in them, pkg/dedupe drops repeats,
+// pkg/redact masks secrets and pkg/ship reports what is left. The agent refers to their types by the
+// names they had before.
+type (
+	LogEvent        = detect.Event
+	Detector        = detect.Detector
+	PatternsConfig  = detect.PatternsConfig
+	MultilineConfig = detect.MultilineConfig
+	StackFrame      = detect.StackFrame
+	Duration        = detect.Duration
+
+	Watcher = watch.Watcher
+	Target  = watch.Target
+
+	Deduper      = dedupe.Deduper
+	DedupeConfig = dedupe.Config
+
+	Redactor     = redact.Redactor
+	RedactConfig = redact.Config
+
+	Client          = ship.Client
+	IncidentPayload = ship.IncidentPayload
+	GitInfo         = ship.GitInfo
+	BlameInfo       = ship.BlameInfo
+	StatusError     = ship.StatusError
+	ThrottleError   = ship.ThrottleError
+	RetryConfig     = ship.RetryConfig
+	RetryPolicy     = ship.RetryPolicy
+	AuthConfig      = ship.AuthConfig
+	PayloadConfig   = ship.PayloadConfig
+	TLSConfig       = ship.TLSConfig
+	ProxyConfig     = ship.ProxyConfig
+	GRPCConfig      = ship.GRPCConfig
+)
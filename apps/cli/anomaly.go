@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+type AnomalyConfig struct {
+	Enabled     bool     `json:"enabled"`
+	Interval    Duration `json:"interval,omitempty"`
+	Alpha       float64  `json:"alpha,omitempty"`
+	Threshold   float64  `json:"threshold,omitempty"`
+	Warmup      int      `json:"warmup,omitempty"`
+	MinLineRate float64  `json:"min_line_rate,omitempty"`
+	Seasonal    bool     `json:"seasonal,omitempty"`
+}
+
+func (c *AnomalyConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+	if c.Alpha < 0 || c.Alpha > 1 {
+		return errors.New("alpha must be between 0 and 1")
+	}
+	if c.Threshold < 0 {
+		return errors.New("threshold must be positive")
+	}
+	if c.Warmup < 0 {
+		return errors.New("warmup must be positive")
+	}
+	return nil
+}
+
+func (c *AnomalyConfig) withDefaults() AnomalyConfig {
+	out := *c
+	if out.Interval == 0 {
+		out.Interval = Duration(time.Minute)
+	}
+	if out.Alpha == 0 {
+		out.Alpha = 0.1
+	}
+	if out.Threshold == 0 {
+		out.Threshold = 3
+	}
+	if out.Warmup == 0 {
+		out.Warmup = 15
+	}
+	if out.MinLineRate == 0 {
+		out.MinLineRate = 1
+	}
+	return out
+}
+
+// ewma tracks an exponentially weighted mean and variance.
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+func (e *ewma) update(x float64, alpha float64) {
+	if e.samples == 0 {
+		e.mean = x
+	} else {
+		diff := x - e.mean
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.samples++
+}
+
+func (e *ewma) stddev() float64 {
+	return math.Sqrt(e.variance)
+}
+
+type baseline struct {
+	lines  ewma
+	errors ewma
+}
+
+// AnomalyDetector learns the normal line and error rate of a watcher and
+// raises a synthetic incident when the log goes silent or errors spike.
+type AnomalyDetector struct {
+	cfg       AnomalyConfig
+	watcher   *Watcher
+	global    baseline
+	hourly    [24]baseline
+	lastLines int64
+	lastErrs  int64
+	silent    bool
+	spiking   bool
+}
+
+func NewAnomalyDetector(cfg *AnomalyConfig, watcher *Watcher) *AnomalyDetector {
+	d := &AnomalyDetector{
+		cfg:     cfg.withDefaults(),
+		watcher: watcher,
+	}
+	d.lastLines, d.lastErrs = watcher.Counts()
+	return d
+}
+
+func (d *AnomalyDetector) Run(ctx context.Context, events chan<- LogEvent) {
+	ticker := time.NewTicker(time.Duration(d.cfg.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if event, ok := d.sample(now); ok {
+				events <- event
+			}
+		}
+	}
+}
+
+func (d *AnomalyDetector) sample(now time.Time) (LogEvent, bool) {
+	totalLines, totalErrs := d.watcher.Counts()
+	lines := float64(totalLines - d.lastLines)
+	errs := float64(totalErrs - d.lastErrs)
+	d.lastLines, d.lastErrs = totalLines, totalErrs
+
+	model := &d.global
+	if d.cfg.Seasonal && d.hourly[now.Hour()].lines.samples >= d.cfg.Warmup {
+		model = &d.hourly[now.Hour()]
+	}
+
+	var reason string
+	if model.lines.samples >= d.cfg.Warmup {
+		if lines == 0 && model.lines.mean >= d.cfg.MinLineRate {
+			if !d.silent {
+				reason = fmt.Sprintf("log volume dropped to zero (baseline %.1f lines per %v)",
+					model.lines.mean, time.Duration(d.cfg.Interval))
+			}
+			d.silent = true
+		} else {
+			d.silent = false
+		}
+
+		// Only upward deviations are reported; a quieter error rate is good news.
+		z := (errs - model.errors.mean) / math.Max(model.errors.stddev(), 1)
+		if z > d.cfg.Threshold {
+			if !d.spiking && reason == "" {
+				reason = fmt.Sprintf("error rate %.0f per %v is %.1f deviations above baseline %.1f",
+					errs, time.Duration(d.cfg.Interval), z, model.errors.mean)
+			}
+			d.spiking = true
+		} else {
+			d.spiking = false
+		}
+	}
+
+	// Don't learn from anomalous intervals, otherwise a long outage
+	// gradually becomes the new normal.
+	if !d.silent && !d.spiking {
+		d.global.lines.update(lines, d.cfg.Alpha)
+		d.global.errors.update(errs, d.cfg.Alpha)
+		if d.cfg.Seasonal {
+			d.hourly[now.Hour()].lines.update(lines, d.cfg.Alpha)
+			d.hourly[now.Hour()].errors.update(errs, d.cfg.Alpha)
+		}
+	}
+
+	if reason == "" {
+		return LogEvent{}, false
+	}
+
+	return LogEvent{
+		Line:      "Anomalous log behavior: " + reason,
+		Timestamp: now.UTC(),
+		Context: []string{
+			fmt.Sprintf("log_path: %s", d.watcher.Path()),
+			fmt.Sprintf("lines this interval: %.0f", lines),
+			fmt.Sprintf("errors this interval: %.0f", errs),
+			fmt.Sprintf("baseline lines: %.1f (stddev %.1f)", model.lines.mean, model.lines.stddev()),
+			fmt.Sprintf("baseline errors: %.1f (stddev %.1f)", model.errors.mean, model.errors.stddev()),
+		},
+		Target:   d.watcher.Target().Label,
+		RepoURL:  d.watcher.Target().RepoURL,
+		RepoPath: d.watcher.Target().RepoPath,
+	}, true
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	archiveFileName     = "lacia-incidents.ndjson"
+	defaultArchiveSize  = 100 // MiB
+	defaultArchiveFiles = 5
+)
+
+// ArchiveConfig keeps a local NDJSON copy of every incident, as an audit
+// trail that does not depend on the server accepting them.
+type ArchiveConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path,omitempty"`
+	// The archive is rotated to <path>.1, <path>.2, ... once it reaches
+	// MaxSizeMB. MaxFiles rotated files are kept, and with MaxAge those
+	// older than it are removed too.
+	MaxSizeMB   int      `json:"max_size_mb,omitempty"`
+	MaxFiles    int      `json:"max_files,omitempty"`
+	MaxAge      Duration `json:"max_age,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+}
+
+func (c *ArchiveConfig) Validate() error {
+	if c.MaxSizeMB < 0 || c.MaxFiles < 0 || c.MaxAge < 0 {
+		return errors.New("max_size_mb, max_files and max_age must be positive")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// ArchiveSink appends incidents to a size-rotated NDJSON file.
+type ArchiveSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (c *ArchiveConfig) path() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(filepath.Dir(ConfigPath()), archiveFileName)
+}
+
+func NewArchiveSink(cfg *ArchiveConfig) *ArchiveSink {
+	s := &ArchiveSink{
+		path:     cfg.path(),
+		maxSize:  int64(cfg.MaxSizeMB) << 20,
+		maxFiles: cfg.MaxFiles,
+		maxAge:   time.Duration(cfg.MaxAge),
+	}
+	if s.maxSize == 0 {
+		s.maxSize = defaultArchiveSize << 20
+	}
+	if s.maxFiles == 0 {
+		s.maxFiles = defaultArchiveFiles
+	}
+	return s
+}
+
+func (s *ArchiveSink) Name() string {
+	return "archive"
+}
+
+func (s *ArchiveSink) Send(ctx context.Context, payload IncidentPayload) error {
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate archive: %w", err)
+		}
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *ArchiveSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts <path>.N to <path>.N+1, dropping those past maxFiles or
+// older than maxAge, and moves the current file to <path>.1.
+func (s *ArchiveSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	os.Remove(s.rotated(s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		os.Rename(s.rotated(i), s.rotated(i+1))
+	}
+	if err := os.Rename(s.path, s.rotated(1)); err != nil {
+		return err
+	}
+
+	if s.maxAge > 0 {
+		cutoff := time.Now().Add(-s.maxAge)
+		for i := 2; i <= s.maxFiles; i++ {
+			if info, err := os.Stat(s.rotated(i)); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(s.rotated(i))
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ArchiveSink) rotated(n int) string {
+	return s.path + "." + strconv.Itoa(n)
+}
+
+func (s *ArchiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
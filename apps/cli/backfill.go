@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// runBackfillCommand reads what the watched files already hold from an
+// earlier point and reports the errors in it, for an agent installed on a
+// host that was failing before. Incidents are sent at a throttled rate so a
+// long history does not flood the server.
+func runBackfillCommand(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	since := fs.String("since", "", "start at the first line logged at this time, as RFC 3339 or a duration such as 24h")
+	offset := fs.Int64("offset", -1, "start at this byte offset instead")
+	label := fs.String("target", "", "only backfill the target with this label or log_path")
+	rate := fs.Float64("rate", 1, "incidents sent per second")
+	dryRun := fs.Bool("dry-run", false, "print incidents as JSON instead of sending them")
+	fs.Parse(args)
+	if fs.NArg() > 0 || (*since == "") == (*offset < 0) || *rate <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli backfill --since TIME|--offset BYTES [--target LABEL] [--rate N] [--dry-run]")
+		os.Exit(2)
+	}
+	var cutoff time.Time
+	if *since != "" {
+		var err error
+		if cutoff, err = parseSince(*since); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ --since: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	cfg := loadConfigOrExit()
+	detector, err := detect.NewDetector(cfg.Patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Invalid patterns: %v\n", err)
+		os.Exit(1)
+	}
+	plugins, err := LoadPlugins(context.Background(), cfg.Plugins)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer plugins.Close()
+	detector.Plugins = plugins.Detectors()
+	workers := detect.NewPool(cfg.DetectWorkers)
+	defer workers.Close()
+	detector.Workers = workers
+	client, err := NewClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	// Errors the running watcher already reported are not sent again, but
+	// the cache is left for it to maintain
+	deduper, err := NewDeduper(cfg.Dedupe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Load dedupe cache: %v\n", err)
+		os.Exit(1)
+	}
+	deduper.DisableSave()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rules, err := newConsumerRules(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	b := &backfill{
+		ctx:        ctx,
+		client:     client,
+		detector:   detector,
+		classifier: rules.classifier,
+		ignore:     rules.ignore,
+		redactor:   rules.redactor,
+		deduper:    deduper,
+		git:        NewGitEnricher(cfg.Git),
+		throttle:   time.NewTicker(time.Duration(float64(time.Second) / *rate)),
+		dryRun:     *dryRun,
+	}
+	defer b.throttle.Stop()
+
+	var found bool
+	for _, target := range cfg.WatchTargets() {
+		if *label != "" && *label != target.Label && *label != target.LogPath && *label != target.Dir {
+			continue
+		}
+		paths, err := targetFiles(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		for _, path := range paths {
+			if path == watch.StdinPath || ctx.Err() != nil {
+				continue
+			}
+			found = true
+			target.LogPath = path
+			if err := b.file(target, cutoff, *offset); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ Read %s: %v\n", path, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if !found {
+		fmt.Fprintln(os.Stderr, "✗ No log files to backfill")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "✓ Backfilled %d incidents (%d failed, %d ignored, %d duplicates, %d below severity.min)\n",
+		b.sent, b.failed, b.ignored, b.duplicates, b.below)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "✗ Interrupted before the end of the files")
+		os.Exit(1)
+	}
+	if b.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// backfill runs old log lines through detection and the consumer's ignore,
+// severity, dedupe and git steps and sends the incidents they raise.
+type backfill struct {
+	ctx        context.Context
+	client     *Client
+	detector   *Detector
+	classifier *Classifier
+	ignore     *IgnoreRules
+	redactor   *Redactor
+	deduper    *Deduper
+	git        *GitEnricher
+	throttle   *time.Ticker
+	dryRun     bool
+
+	sent, failed, ignored, duplicates, below int
+}
+
+// file reads target.LogPath from offset, or from the first line logged at
+// or after cutoff, to its current end.
+func (b *backfill) file(target Target, cutoff time.Time, offset int64) error {
+	file, err := os.Open(target.LogPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoding := target.Encoding
+	if encoding == "" || encoding == watch.EncodingAuto {
+		encoding = watch.DetectEncoding(file)
+	}
+	target.Encoding = encoding
+	if !cutoff.IsZero() {
+		offset, err = watch.OffsetSince(file, target, cutoff)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	watcher := watch.NewStreamWatcher(target, b.detector, file)
+	events := make(chan LogEvent, 100)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watcher.Watch(b.ctx, events)
+		close(events)
+	}()
+	for event := range events {
+		b.event(event)
+	}
+	return <-watchErr
+}
+
+func (b *backfill) event(event LogEvent) {
+	if _, ok := b.ignore.Match(event); ok {
+		b.ignored++
+		return
+	}
+	severity, ok := b.classifier.Classify(event)
+	if !ok {
+		b.below++
+		return
+	}
+	if b.deduper.IsDuplicate(&event) {
+		b.duplicates++
+		return
+	}
+	payload := b.client.Payload(event)
+	payload.Severity = severity
+	b.redactor.Payload(&payload)
+	b.git.Enrich(&payload, event.RepoPath)
+	if b.dryRun {
+		printDryRun(payload)
+		b.sent++
+		return
+	}
+
+	select {
+	case <-b.throttle.C:
+	case <-b.ctx.Done():
+		return
+	}
+	if _, err := b.client.SendPayload(b.ctx, payload); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %s %s: %v\n", payload.LogTimestamp, ship.Truncate(payload.ErrorLine, 80), err)
+		b.failed++
+		return
+	}
+	b.sent++
+}
+
+// targetFiles lists the files a target watches.
+func targetFiles(target Target) ([]string, error) {
+	if target.Dir == "" {
+		return []string{target.LogPath}, nil
+	}
+	filter, err := newFileFilter(target)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	err = walkDir(target.Dir, filter, func(path string, _ fs.FileInfo) {
+		paths = append(paths, path)
+	})
+	return paths, err
+}
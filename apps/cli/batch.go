@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 20
+	defaultBatchInterval = 5 * time.Second
+	// maxBatchSize is the most incidents the server takes in one batch
+	maxBatchSize = 100
+)
+
+type BatchConfig struct {
+	Enabled  bool     `json:"enabled"`
+	MaxSize  int      `json:"max_size,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+func (c *BatchConfig) Validate() error {
+	if c.MaxSize < 0 {
+		return errors.New("max_size must be positive")
+	}
+	if c.MaxSize > maxBatchSize {
+		return fmt.Errorf("max_size must be at most %d", maxBatchSize)
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+// Batcher accumulates payloads and flushes them when the batch is full or
+// the interval since the first queued payload has elapsed. Batches are
+// delivered one at a time, in order.
+type Batcher struct {
+	// sending is held while a batch is taken and delivered
+	sending  sync.Mutex
+	mu       sync.Mutex
+	items    []IncidentPayload
+	timer    *time.Timer
+	closed   bool
+	maxSize  int
+	interval time.Duration
+	flush    func([]IncidentPayload)
+	// maxBytes, when set, also flushes a batch once its incidents take up
+	// that much
+	maxBytes int
+	bytes    int
+}
+
+func NewBatcher(cfg *BatchConfig, flush func([]IncidentPayload)) *Batcher {
+	b := &Batcher{
+		maxSize:  cfg.MaxSize,
+		interval: time.Duration(cfg.Interval),
+		flush:    flush,
+	}
+	if b.maxSize == 0 {
+		b.maxSize = defaultBatchSize
+	}
+	if b.interval == 0 {
+		b.interval = defaultBatchInterval
+	}
+	return b
+}
+
+func (b *Batcher) Add(payload IncidentPayload) {
+	b.mu.Lock()
+	b.items = append(b.items, payload)
+	b.bytes += payloadSize(payload)
+	if len(b.items) == 1 && !b.closed {
+		b.timer = time.AfterFunc(b.interval, b.Flush)
+	}
+	full := b.closed || len(b.items) >= b.maxSize || (b.maxBytes > 0 && b.bytes >= b.maxBytes)
+	b.mu.Unlock()
+
+	if full {
+		b.Flush()
+	}
+}
+
+func (b *Batcher) Flush() {
+	b.sending.Lock()
+	defer b.sending.Unlock()
+	b.mu.Lock()
+	items := b.items
+	b.items = nil
+	b.bytes = 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(items) > 0 {
+		b.flush(items)
+	}
+}
+
+// Close stops the interval timer and delivers what is left, after any
+// flush the timer already started. Payloads added later are delivered at
+// once.
+func (b *Batcher) Close() {
+	b.mu.Lock()
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+	b.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatcherDeliversOneBatchAtATime(t *testing.T) {
+	var active, overlaps, delivered atomic.Int64
+	b := NewBatcher(&BatchConfig{MaxSize: 2, Interval: Duration(time.Millisecond)}, func(payloads []IncidentPayload) {
+		if active.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(time.Millisecond)
+		delivered.Add(int64(len(payloads)))
+		active.Add(-1)
+	})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				b.Add(IncidentPayload{ErrorLine: "boom"})
+			}
+		}()
+	}
+	wg.Wait()
+	b.Close()
+
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("%d batches were delivered while another was", n)
+	}
+	if n := delivered.Load(); n != 200 {
+		t.Errorf("delivered %d incidents, want 200", n)
+	}
+}
+
+func TestBatcherClose(t *testing.T) {
+	var batches [][]IncidentPayload
+	b := NewBatcher(&BatchConfig{MaxSize: 10, Interval: Duration(time.Hour)}, func(payloads []IncidentPayload) {
+		batches = append(batches, payloads)
+	})
+	b.Add(IncidentPayload{ErrorLine: "first"})
+	b.Close()
+	b.Add(IncidentPayload{ErrorLine: "late"})
+
+	if len(batches) != 2 || len(batches[0]) != 1 || batches[1][0].ErrorLine != "late" {
+		t.Fatalf("batches = %v, want the pending incident at Close and the late one at once", batches)
+	}
+	if b.timer != nil {
+		t.Fatal("timer left running after Close")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// runBenchCommand measures how fast the configured patterns and the
+// watcher pipeline process a log file, for tuning busy hosts.
+func runBenchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	path := fs.String("file", "", "log file to read")
+	cpuProfile := fs.String("cpuprofile", "", "write a CPU profile of the pipeline run to this file")
+	memProfile := fs.String("memprofile", "", "write a heap profile after the pipeline run to this file")
+	fs.Parse(args)
+	if *path == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli bench --file FILE [--cpuprofile FILE] [--memprofile FILE]")
+		os.Exit(2)
+	}
+
+	cfg := loadConfigOrExit()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	file, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	if *cpuProfile != "" {
+		out, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		defer out.Close()
+		pprof.StartCPUProfile(out)
+	}
+	result, err := benchPipeline(ctx, cfg, file)
+	if *cpuProfile != "" {
+		pprof.StopCPUProfile()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	if *memProfile != "" {
+		if err := writeHeapProfile(*memProfile); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	latency, err := benchDetector(ctx, cfg, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+
+	perSecond := float64(result.lines) / result.elapsed.Seconds()
+	fmt.Printf("Lines:       %d in %v (%.0f lines/s)\n", result.lines, result.elapsed.Round(time.Millisecond), perSecond)
+	fmt.Printf("Incidents:   %d\n", result.events)
+	fmt.Printf("Detection:   p50 %v, p99 %v, max %v per line\n", latency.p50, latency.p99, latency.max)
+	if result.lines > 0 {
+		fmt.Printf("Allocations: %.1f allocs, %.0f bytes per line\n",
+			float64(result.mallocs)/float64(result.lines), float64(result.allocBytes)/float64(result.lines))
+	}
+	fmt.Printf("Memory:      %.1f MiB heap high-water mark\n", float64(result.peakHeap)/(1<<20))
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "✗ Interrupted before the end of the file")
+		os.Exit(1)
+	}
+}
+
+type benchResult struct {
+	lines      int64
+	events     int
+	elapsed    time.Duration
+	mallocs    uint64
+	allocBytes uint64
+	peakHeap   uint64
+}
+
+// benchPipeline runs the file through a watcher and the consumer's
+// ignore, severity and fingerprint steps, as fast as it can be read.
+func benchPipeline(ctx context.Context, cfg *Config, file *os.File) (benchResult, error) {
+	detector, err := detect.NewDetector(cfg.Patterns)
+	if err != nil {
+		return benchResult{}, fmt.Errorf("invalid patterns: %w", err)
+	}
+	workers := detect.NewPool(cfg.DetectWorkers)
+	defer workers.Close()
+	detector.Workers = workers
+	classifier, err := NewClassifier(cfg.Severity)
+	if err != nil {
+		return benchResult{}, err
+	}
+	ignore, err := NewIgnoreRules(cfg.Ignore)
+	if err != nil {
+		return benchResult{}, err
+	}
+	var strategy string
+	if cfg.Dedupe != nil {
+		strategy = cfg.Dedupe.Fingerprint
+	}
+
+	// Sample the heap while the pipeline runs
+	var peak uint64
+	sampled := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var stats runtime.MemStats
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	watcher := watch.NewStreamWatcher(cfg.readDefaults(Target{LogPath: file.Name()}), detector, file)
+	events := make(chan LogEvent, 100)
+	var wg sync.WaitGroup
+	var result benchResult
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for event := range events {
+			if _, ok := ignore.Match(event); ok {
+				continue
+			}
+			if _, ok := classifier.Classify(event); ok {
+				detect.Fingerprint(event, strategy)
+				result.events++
+			}
+		}
+	}()
+	err = watcher.Watch(ctx, events)
+	close(events)
+	wg.Wait()
+
+	result.elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	close(done)
+	<-sampled
+
+	result.lines, _ = watcher.Counts()
+	result.mallocs = after.Mallocs - before.Mallocs
+	result.allocBytes = after.TotalAlloc - before.TotalAlloc
+	result.peakHeap = peak
+	return result, err
+}
+
+type benchLatency struct {
+	p50, p99, max time.Duration
+}
+
+// benchDetector times the error patterns on each line on their own.
+func benchDetector(ctx context.Context, cfg *Config, file *os.File) (benchLatency, error) {
+	detector, err := detect.NewDetector(cfg.Patterns)
+	if err != nil {
+		return benchLatency{}, fmt.Errorf("invalid patterns: %w", err)
+	}
+	var durations []time.Duration
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() && ctx.Err() == nil {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		start := time.Now()
+		detector.IsError(line)
+		durations = append(durations, time.Since(start))
+	}
+	if err := scanner.Err(); err != nil {
+		return benchLatency{}, err
+	}
+	if len(durations) == 0 {
+		return benchLatency{}, nil
+	}
+	slices.Sort(durations)
+	return benchLatency{
+		p50: durations[len(durations)/2],
+		p99: durations[len(durations)*99/100],
+		max: durations[len(durations)-1],
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	runtime.GC()
+	return pprof.WriteHeapProfile(out)
+}
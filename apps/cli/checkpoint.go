@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+const (
+	checkpointFileName  = "lacia-checkpoints.json"
+	checkpointSaveEvery = 5 * time.Second
+	// checkpointHeadSize is how much of the start of a file identifies it,
+	// so a file rotated while the watcher was down is read from the start.
+	checkpointHeadSize = 256
+)
+
+type checkpoint struct {
+	Offset int64  `json:"offset"`
+	Head   string `json:"head"`
+}
+
+// Checkpoints records how far each file with start_from checkpoint has been
+// read, so a restarted watcher picks up the lines logged while it was down.
+type Checkpoints struct {
+	path string
+
+	mu    sync.Mutex
+	files map[string]checkpoint
+}
+
+// CheckpointPath returns where read positions are kept: checkpoint_file,
+// or next to the config.
+func CheckpointPath(cfg *Config) string {
+	if cfg.CheckpointFile != "" {
+		return cfg.CheckpointFile
+	}
+	return filepath.Join(filepath.Dir(ConfigPath()), checkpointFileName)
+}
+
+func OpenCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{path: path, files: make(map[string]checkpoint)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.files); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// StartOffset returns where to start reading path, of the given size, for
+// target when it starts. -1 means the end of the file.
+func (c *Checkpoints) StartOffset(target Target, path string, size int64) int64 {
+	switch target.StartFrom {
+	case watch.StartFromBeginning:
+		return 0
+	case watch.StartFromCheckpoint:
+		if c == nil {
+			return -1
+		}
+		c.mu.Lock()
+		cp, ok := c.files[path]
+		c.mu.Unlock()
+		if !ok {
+			// Nothing recorded yet, e.g. the first run
+			return -1
+		}
+		if cp.Offset > size || fileHead(path, cp.Offset) != cp.Head {
+			slog.Info("file replaced since last checkpoint, reading from the start", "path", path)
+			return 0
+		}
+		return cp.Offset
+	}
+	return -1
+}
+
+// Run saves the read positions until ctx is done.
+func (c *Checkpoints) Run(ctx context.Context, watchers func() []*Watcher) {
+	ticker := time.NewTicker(checkpointSaveEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Save(watchers()); err != nil {
+				slog.Error("save checkpoints failed", "err", err)
+			}
+		}
+	}
+}
+
+// Save records where watchers have read up to. Files that are not being
+// watched right now keep their last checkpoint until they are deleted.
+func (c *Checkpoints) Save(watchers []*Watcher) error {
+	c.mu.Lock()
+	changed := false
+	for _, w := range watchers {
+		if w.Target().StartFrom != watch.StartFromCheckpoint || w.IsStream() {
+			continue
+		}
+		offset := w.Offset()
+		if prev, ok := c.files[w.Path()]; ok && prev.Offset == offset {
+			continue
+		}
+		c.files[w.Path()] = checkpoint{Offset: offset, Head: fileHead(w.Path(), offset)}
+		changed = true
+	}
+	for path := range c.files {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			delete(c.files, path)
+			changed = true
+		}
+	}
+	if !changed || c.path == "" {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.files)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// fileHead hashes the start of path, up to offset bytes.
+func fileHead(path string, offset int64) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hash := sha256.New()
+	io.CopyN(hash, file, min(offset, checkpointHeadSize))
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
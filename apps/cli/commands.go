@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func printUsage() {
+	fmt.Println(`Usage:
+  lacia-cli [start]          Watch the configured log files (default)
+    --daemon                 Detach and run in the background
+    --log-file PATH          Write logs to a file instead of stderr
+    --log-level LEVEL        debug, info (default), warn or error
+    --log-format FORMAT      text (default) or json
+    --stdin                  Read log lines from standard input instead of log files
+    --skip-preflight         Start without checking log files, repo URLs and the server
+    --tui                    Show a live dashboard instead of logging to the terminal
+    --dry-run                Print incidents as JSON instead of sending them
+    --tag KEY=VALUE          Add a tag to every incident, may be repeated
+  lacia-cli setup            Write a config, prompting for anything not given
+    --log-path PATH --server-url URL --repo-url URL [--force]
+  lacia-cli stop             Stop a running watcher
+  lacia-cli reload           Apply changes to the config without restarting
+  lacia-cli validate         Check the config, log files and server connectivity
+  lacia-cli test             Send a synthetic incident and confirm the server accepted it
+  lacia-cli status [--json]  Show the state of a running watcher
+  lacia-cli mute --for DURATION [--reason TEXT]
+                             Stop sending incidents for a while, e.g. during a deploy
+  lacia-cli unmute           Resume sending incidents
+  lacia-cli scan [--json] FILE...
+                             List the incidents the patterns would raise in log files
+  lacia-cli bench --file FILE [--cpuprofile FILE] [--memprofile FILE]
+                             Measure how fast the patterns and pipeline process a log file
+  lacia-cli history [--since TIME] [--outcome OUTCOME] [--grep TEXT] [--json]
+                             List the incidents detected on this host and what became of them
+    [--target LABEL] [--severity SEVERITY] [--fingerprint HASH] [--limit N]
+  lacia-cli replay FILE      Re-send NDJSON incidents, e.g. the archive or offline queue
+    [--since TIME] [--sinks]
+  lacia-cli backfill --since TIME|--offset BYTES
+                             Report errors already in the watched files, e.g. on a new install
+    [--target LABEL] [--rate N] [--dry-run]
+  lacia-cli relay            Accept incidents from other agents and forward them to the server
+  lacia-cli version          Show the version, commit, build date and Go version
+  lacia-cli self-update [--check] [--force]
+                             Install the latest signed release in place of this binary
+  lacia-cli service install|uninstall|start|stop
+                             Manage the watcher as a systemd, launchd or Windows service
+
+Every command accepts --config PATH. Otherwise LACIA_CONFIG is used, or the
+first lacia.config/.yaml/.yml/.toml in the working directory, the user config
+directory (e.g. ~/.config/lacia) or next to the executable.`)
+}
+
+func runSetupCommand(args []string) {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	values := setupValuesFromEnv()
+	fs.StringVar(&values.LogPath, "log-path", values.LogPath, "log file to watch (LACIA_LOG_PATH)")
+	fs.StringVar(&values.ServerURL, "server-url", values.ServerURL, "Lacia server URL (LACIA_SERVER_URL)")
+	fs.StringVar(&values.RepoURL, "repo-url", values.RepoURL, "GitHub repository URL (LACIA_REPO_URL)")
+	force := fs.Bool("force", false, "overwrite an existing config")
+	fs.Parse(args)
+
+	if ConfigExists() && !*force {
+		fmt.Fprintf(os.Stderr, "Config %s already exists; pass --force to overwrite it\n", ConfigPath())
+		os.Exit(1)
+	}
+	if _, err := runSetup(values); err != nil {
+		fmt.Fprintf(os.Stderr, "Setup failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfigOrExit loads an existing config without falling back to the
+// interactive setup, for commands that must not block on stdin.
+func loadConfigOrExit() *Config {
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config error: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func runValidateCommand(args []string) {
+	cfg := loadConfigOrExit()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("✓ Config %s is valid\n", ConfigPath())
+
+	failed := false
+	for _, check := range preflightChecks(ctx, cfg) {
+		if check.Err == nil {
+			fmt.Printf("✓ %s\n", check.OK)
+			continue
+		}
+		fmt.Printf("✗ %s: %v\n", check.Name, check.Err)
+		if check.Hint != "" {
+			fmt.Printf("    %s\n", check.Hint)
+		}
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func runTestCommand(args []string) {
+	cfg := loadConfigOrExit()
+	client, err := NewClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	event := LogEvent{
+		Line:      "ERROR lacia-cli test incident",
+		Timestamp: time.Now().UTC(),
+		Context:   []string{"This incident was sent by `lacia-cli test` to verify connectivity."},
+	}
+	payload := client.Payload(event)
+
+	resp, err := client.SendWithResponse(ctx, payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Test incident failed: %v\n", err)
+		os.Exit(1)
+	}
+	if !resp.Success {
+		fmt.Fprintln(os.Stderr, "✗ Server did not acknowledge the test incident")
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Server accepted test incident (id %d)\n", resp.IncidentID)
+}
+
+func runStatusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the raw status as JSON")
+	fs.Parse(args)
+
+	var cfg *Config
+	if ConfigExists() {
+		cfg, _ = LoadConfig()
+	}
+
+	var report StatusReport
+	if err := queryControl(ControlSocketPath(cfg), "/status", &report); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.Print()
+}
+
+func runReloadCommand(args []string) {
+	// The edited config may not validate, in which case the watcher reports
+	// why; it only has to parse for the control socket path.
+	var cfg *Config
+	if ConfigExists() {
+		cfg, _ = readConfig(ConfigPath())
+	}
+
+	var reply map[string]any
+	if err := requestControl("POST", ControlSocketPath(cfg), "/reload", &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Reload failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("✓ Config reloaded")
+}
+
+func runMuteCommand(args []string) {
+	fs := flag.NewFlagSet("mute", flag.ExitOnError)
+	duration := fs.Duration("for", 0, "how long to mute, e.g. 2h")
+	reason := fs.String("reason", "", "why, shown in status and the log")
+	fs.Parse(args)
+	if *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli mute --for DURATION [--reason TEXT]")
+		os.Exit(2)
+	}
+
+	var cfg *Config
+	if ConfigExists() {
+		cfg, _ = LoadConfig()
+	}
+	query := url.Values{"for": {duration.String()}, "reason": {*reason}}
+	var reply struct {
+		Until time.Time `json:"until"`
+	}
+	if err := requestControl("POST", ControlSocketPath(cfg), "/mute?"+query.Encode(), &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Mute failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Muted until %s\n", reply.Until.Local().Format(time.RFC3339))
+}
+
+func runUnmuteCommand(args []string) {
+	var cfg *Config
+	if ConfigExists() {
+		cfg, _ = LoadConfig()
+	}
+	var reply map[string]any
+	if err := requestControl("POST", ControlSocketPath(cfg), "/unmute", &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Unmute failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("✓ Unmuted")
+}
+
+func checkLogFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("is a directory")
+	}
+	return nil
+}
+
+// healthURL derives the server's health endpoint from the webhook URL.
+func healthURL(serverURL string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/api/webhook")
+	return base + "/api/health"
+}
+
+func checkServer(ctx context.Context, client *http.Client, serverURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL(serverURL), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,36 +2,522 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"cmp"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
 )
 
 const configFileName = "lacia.config"
 
 type Config struct {
-	LogPath   string `json:"log_path"`
-	ServerURL string `json:"server_url"`
-	RepoURL   string `json:"repo_url"`
+	LogPath   string   `json:"log_path,omitempty"`
+	ServerURL string   `json:"server_url"`
+	RepoURL   string   `json:"repo_url,omitempty"`
+	Targets   []Target `json:"targets,omitempty"`
+	// Tags are sent with every incident so the dashboard can filter by
+	// environment, team or service; --tag adds to and overrides them
+	Tags map[string]string `json:"tags,omitempty"`
+	// Service, Environment and Version describe the watched application and
+	// default to $SERVICE_NAME, $DEPLOY_ENV and $SERVICE_VERSION
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Version     string `json:"version,omitempty"`
+
+	ControlSocket string `json:"control_socket,omitempty"`
+	PIDFile       string `json:"pid_file,omitempty"`
+
+	// StartFrom is the default for targets that do not set their own
+	StartFrom      string `json:"start_from,omitempty"`
+	CheckpointFile string `json:"checkpoint_file,omitempty"`
+
+	// The trace settings are the defaults for targets that do not set
+	// their own
+	ContextLines       int      `json:"context_lines,omitempty"`
+	MaxTraceLines      int      `json:"max_trace_lines,omitempty"`
+	TraceTimeout       Duration `json:"trace_timeout,omitempty"`
+	PostContextLines   int      `json:"post_context_lines,omitempty"`
+	PostContextTimeout Duration `json:"post_context_timeout,omitempty"`
+	Timezone           string   `json:"timezone,omitempty"`
+	Encoding           string   `json:"encoding,omitempty"`
+	Format             string   `json:"format,omitempty"`
+	MaxLineLength      int      `json:"max_line_length,omitempty"`
+
+	// DetectWorkers spreads the decoding and detection of lines from busy
+	// files over this many goroutines; with 0 or 1 each file is read and
+	// detected on a goroutine of its own
+	DetectWorkers int `json:"detect_workers,omitempty"`
+	// MemoryLimitMB bounds the buffers for lines, traces and incidents so
+	// the agent fits a small VM or sidecar with this much memory
+	MemoryLimitMB int `json:"memory_limit_mb,omitempty"`
+	// ReportPanics sends the agent's own recovered panics as incidents
+	ReportPanics bool `json:"report_panics,omitempty"`
+
+	Patterns  *PatternsConfig  `json:"patterns,omitempty"`
+	Anomaly   *AnomalyConfig   `json:"anomaly,omitempty"`
+	Queue     *QueueConfig     `json:"queue,omitempty"`
+	Retry     *RetryConfig     `json:"retry,omitempty"`
+	Batch     *BatchConfig     `json:"batch,omitempty"`
+	Auth      *AuthConfig      `json:"auth,omitempty"`
+	Dedupe    *DedupeConfig    `json:"dedupe,omitempty"`
+	Health    *HealthConfig    `json:"health,omitempty"`
+	Log       *LogConfig       `json:"log,omitempty"`
+	Syslog    *SyslogConfig    `json:"syslog,omitempty"`
+	GELF      *GELFConfig      `json:"gelf,omitempty"`
+	Forward   *ForwardConfig   `json:"forward,omitempty"`
+	Ingest    *IngestConfig    `json:"ingest,omitempty"`
+	Git       *GitConfig       `json:"git,omitempty"`
+	Severity  *SeverityConfig  `json:"severity,omitempty"`
+	Ignore    *IgnoreConfig    `json:"ignore,omitempty"`
+	Redact    *RedactConfig    `json:"redact,omitempty"`
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	Sampling  *SamplingConfig  `json:"sampling,omitempty"`
+	Spool     *SpoolConfig     `json:"spool,omitempty"`
+	Slack     *SlackConfig     `json:"slack,omitempty"`
+	PagerDuty *PagerDutyConfig `json:"pagerduty,omitempty"`
+	Sentry    *SentryConfig    `json:"sentry,omitempty"`
+	OTLP      *OTLPConfig      `json:"otlp,omitempty"`
+	Fluent    *FluentConfig    `json:"fluent,omitempty"`
+	Datadog   *DatadogConfig   `json:"datadog,omitempty"`
+	Email     *EmailConfig     `json:"email,omitempty"`
+	Webhooks  []WebhookConfig  `json:"webhooks,omitempty"`
+	Archive   *ArchiveConfig   `json:"archive,omitempty"`
+	GRPC      *GRPCConfig      `json:"grpc,omitempty"`
+	Payload   *PayloadConfig   `json:"payload,omitempty"`
+	TLS       *TLSConfig       `json:"tls,omitempty"`
+	Proxy     *ProxyConfig     `json:"proxy,omitempty"`
+	Relay     *RelayConfig     `json:"relay,omitempty"`
+	Update    *UpdateConfig    `json:"update,omitempty"`
+	Heartbeat *HeartbeatConfig `json:"heartbeat,omitempty"`
+	FixStatus *FixStatusConfig `json:"fix_status,omitempty"`
+	History   *HistoryConfig   `json:"history,omitempty"`
+	Script    *ScriptConfig    `json:"script,omitempty"`
+	Exec      *ExecConfig      `json:"exec,omitempty"`
+	Plugins   []PluginConfig   `json:"plugins,omitempty"`
+	// Privileges keeps the agent from running as root
+	Privileges *PrivilegesConfig `json:"privileges,omitempty"`
+	// Kubernetes watches the logs of every container on the node
+	Kubernetes *KubernetesConfig `json:"kubernetes,omitempty"`
+
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"`
+	// Routes send each incident to the sinks of the first route it matches
+	Routes []RouteConfig `json:"routes,omitempty"`
+	// RepoRules send incidents to the repository of the first rule they
+	// match instead of their target's
+	RepoRules []RepoRuleConfig `json:"repo_rules,omitempty"`
+	// Repositories send incidents no repo rule matches to the repository
+	// their stack trace runs through
+	Repositories []RepositoryConfig `json:"repositories,omitempty"`
+	// Pipeline orders the processors each detected error goes through;
+	// leaving one out disables it
+	Pipeline []string `json:"pipeline,omitempty"`
+
+	// stdin records that the config was loaded for --stdin, so a reload
+	// reads it the same way.
+	stdin bool
+}
+
+// PipelineSteps returns the configured processor order, or the default one
+// when pipeline is not set.
+func (c *Config) PipelineSteps() []string {
+	if c.Pipeline == nil {
+		return defaultPipeline
+	}
+	return c.Pipeline
+}
+
+// WatchTargets returns the configured targets, treating the top-level
+// log_path as a single unlabeled target and filling in the default repo_url.
+func (c *Config) WatchTargets() []Target {
+	targets := c.Targets
+	if len(targets) == 0 && c.LogPath != "" {
+		targets = []Target{{LogPath: c.LogPath}}
+	}
+
+	if c.kubernetes() {
+		targets = append(slices.Clip(targets), c.Kubernetes.target(c.RepoURL))
+	}
+
+	out := make([]Target, len(targets))
+	for i, t := range targets {
+		if t.RepoURL == "" {
+			t.RepoURL = c.RepoURL
+		}
+		if t.RepoPath == "" && c.Git != nil {
+			t.RepoPath = c.Git.RepoPath
+		}
+		if t.StartFrom == "" {
+			t.StartFrom = c.StartFrom
+		}
+		out[i] = c.readDefaults(t)
+	}
+	return out
+}
+
+// readDefaults fills in the top-level settings for how t is read where t
+// does not set its own.
+func (c *Config) readDefaults(t Target) Target {
+	t.ContextLines = cmp.Or(t.ContextLines, c.ContextLines)
+	t.MaxTraceLines = cmp.Or(t.MaxTraceLines, c.MaxTraceLines)
+	t.TraceTimeout = cmp.Or(t.TraceTimeout, c.TraceTimeout)
+	t.PostContextLines = cmp.Or(t.PostContextLines, c.PostContextLines)
+	t.PostContextTimeout = cmp.Or(t.PostContextTimeout, c.PostContextTimeout)
+	t.Timezone = cmp.Or(t.Timezone, c.Timezone)
+	t.Encoding = cmp.Or(t.Encoding, c.Encoding)
+	t.Format = cmp.Or(t.Format, c.Format)
+	t.MaxLineLength = cmp.Or(t.MaxLineLength, c.MaxLineLength)
+	return c.traceLimits(t)
+}
+
+// traceLimits caps the memory t's traces take up by memory_limit_mb, each
+// on its own and together with those of every other input.
+func (c *Config) traceLimits(t Target) Target {
+	if budget := c.memoryBudget(); budget.trace > 0 {
+		t.MaxTraceBytes = budget.trace
+		t.TraceBudget = sharedTraceBudget(budget.traces)
+		// The lines kept as context before an error fit in it too
+		kept := cmp.Or(t.ContextLines, watch.DefaultContextLines) + 1
+		t.MaxLineLength = min(cmp.Or(t.MaxLineLength, watch.DefaultMaxLineLength), budget.trace/kept)
+	}
+	return t
+}
+
+func (c *Config) kubernetes() bool {
+	return c.Kubernetes != nil && c.Kubernetes.Enabled
+}
+
+// hasInputs reports whether the agent has anything to read errors from, as
+// opposed to a config for `lacia-cli relay` alone.
+func (c *Config) hasInputs() bool {
+	return c.LogPath != "" || len(c.Targets) > 0 || c.kubernetes() ||
+		(c.Syslog != nil && c.Syslog.Enabled) ||
+		(c.GELF != nil && c.GELF.Enabled) ||
+		(c.Forward != nil && c.Forward.Enabled) ||
+		(c.Ingest != nil && c.Ingest.Enabled)
 }
 
 func (c *Config) Validate() error {
-	if c.LogPath == "" {
-		return errors.New("log_path is required")
+	if !c.hasInputs() && c.Relay == nil {
+		return errors.New("log_path, targets, kubernetes, syslog, gelf, forward, ingest or relay is required")
 	}
 	if c.ServerURL == "" {
 		return errors.New("server_url is required")
 	}
-	if c.RepoURL == "" {
+	if !watch.ValidStartFrom(c.StartFrom) {
+		return errors.New("start_from must be end, checkpoint or beginning")
+	}
+	if c.DetectWorkers < 0 {
+		return errors.New("detect_workers must be positive")
+	}
+	if c.MemoryLimitMB < 0 {
+		return errors.New("memory_limit_mb must be positive")
+	}
+	if c.Kubernetes != nil {
+		if err := c.Kubernetes.Validate(); err != nil {
+			return fmt.Errorf("kubernetes: %w", err)
+		}
+		if c.Kubernetes.Enabled && c.Kubernetes.RepoURL == "" && c.RepoURL == "" {
+			return errors.New("kubernetes: repo_url is required")
+		}
+	}
+	for i, t := range c.WatchTargets() {
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("targets[%d]: %w", i, err)
+		}
+	}
+	if c.LogPath != "" && len(c.Targets) == 0 && c.RepoURL == "" {
 		return errors.New("repo_url is required")
 	}
+	if c.Syslog != nil && c.Syslog.Enabled && c.Syslog.RepoURL == "" && c.RepoURL == "" {
+		return errors.New("syslog: repo_url is required")
+	}
+	if c.GELF != nil && c.GELF.Enabled && c.GELF.RepoURL == "" && c.RepoURL == "" {
+		return errors.New("gelf: repo_url is required")
+	}
+	if c.Forward != nil && c.Forward.Enabled && c.Forward.RepoURL == "" && c.RepoURL == "" {
+		return errors.New("forward: repo_url is required")
+	}
+	if err := validatePipeline(c.Pipeline); err != nil {
+		return fmt.Errorf("pipeline: %w", err)
+	}
+	if err := validateTags(c.Tags); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+	if _, err := detect.NewDetector(c.Patterns); err != nil {
+		return fmt.Errorf("patterns: %w", err)
+	}
+	if c.Anomaly != nil {
+		if err := c.Anomaly.Validate(); err != nil {
+			return fmt.Errorf("anomaly: %w", err)
+		}
+	}
+	if c.Queue != nil {
+		if err := c.Queue.Validate(); err != nil {
+			return fmt.Errorf("queue: %w", err)
+		}
+	}
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
+			return fmt.Errorf("retry: %w", err)
+		}
+	}
+	if c.Batch != nil {
+		if err := c.Batch.Validate(); err != nil {
+			return fmt.Errorf("batch: %w", err)
+		}
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Validate(); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if c.Dedupe != nil {
+		if err := c.Dedupe.Validate(); err != nil {
+			return fmt.Errorf("dedupe: %w", err)
+		}
+	}
+	if c.Health != nil {
+		if err := c.Health.Validate(); err != nil {
+			return fmt.Errorf("health: %w", err)
+		}
+	}
+	if c.Log != nil {
+		if err := c.Log.Validate(); err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+	}
+	if c.Syslog != nil {
+		if err := c.Syslog.Validate(); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+	}
+	if c.GELF != nil {
+		if err := c.GELF.Validate(); err != nil {
+			return fmt.Errorf("gelf: %w", err)
+		}
+	}
+	if c.Forward != nil {
+		if err := c.Forward.Validate(); err != nil {
+			return fmt.Errorf("forward: %w", err)
+		}
+	}
+	if c.Ingest != nil {
+		if err := c.Ingest.Validate(); err != nil {
+			return fmt.Errorf("ingest: %w", err)
+		}
+	}
+	for i := range c.Maintenance {
+		if err := c.Maintenance[i].Validate(); err != nil {
+			return fmt.Errorf("maintenance[%d]: %w", i, err)
+		}
+	}
+	if c.Ignore != nil {
+		if err := c.Ignore.Validate(); err != nil {
+			return fmt.Errorf("ignore: %w", err)
+		}
+	}
+	if c.Severity != nil {
+		if err := c.Severity.Validate(); err != nil {
+			return fmt.Errorf("severity: %w", err)
+		}
+	}
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+	}
+	if c.Sampling != nil {
+		if err := c.Sampling.Validate(); err != nil {
+			return fmt.Errorf("sampling: %w", err)
+		}
+	}
+	if c.Spool != nil {
+		if err := c.Spool.Validate(); err != nil {
+			return fmt.Errorf("spool: %w", err)
+		}
+	}
+	if c.Slack != nil {
+		if err := c.Slack.Validate(); err != nil {
+			return fmt.Errorf("slack: %w", err)
+		}
+	}
+	if c.PagerDuty != nil {
+		if err := c.PagerDuty.Validate(); err != nil {
+			return fmt.Errorf("pagerduty: %w", err)
+		}
+	}
+	if c.Sentry != nil {
+		if err := c.Sentry.Validate(); err != nil {
+			return fmt.Errorf("sentry: %w", err)
+		}
+	}
+	if c.OTLP != nil {
+		if err := c.OTLP.Validate(); err != nil {
+			return fmt.Errorf("otlp: %w", err)
+		}
+	}
+	if c.Fluent != nil {
+		if err := c.Fluent.Validate(); err != nil {
+			return fmt.Errorf("fluent: %w", err)
+		}
+	}
+	if c.Datadog != nil {
+		if err := c.Datadog.Validate(); err != nil {
+			return fmt.Errorf("datadog: %w", err)
+		}
+	}
+	if c.Email != nil {
+		if err := c.Email.Validate(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+	if c.Proxy != nil {
+		if err := c.Proxy.Validate(); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+	if c.Payload != nil {
+		if err := c.Payload.Validate(); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+	}
+	if c.GRPC != nil {
+		if err := c.GRPC.Validate(); err != nil {
+			return fmt.Errorf("grpc: %w", err)
+		}
+	}
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].Validate(); err != nil {
+			return fmt.Errorf("webhooks[%d]: %w", i, err)
+		}
+	}
+	for i := range c.Routes {
+		if err := c.Routes[i].Validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	if len(c.Routes) > 0 {
+		var sinks []string
+		for _, spec := range sinkSpecs(c, "") {
+			sinks = append(sinks, spec.name)
+		}
+		for i := range c.Plugins {
+			sinks = append(sinks, "plugin "+c.Plugins[i].name())
+		}
+		for i := range c.Routes {
+			for _, name := range c.Routes[i].Sinks {
+				if !slices.Contains(sinks, name) {
+					return fmt.Errorf("routes[%d]: no sink named %q (configured: %s)", i, name, strings.Join(sinks, ", "))
+				}
+			}
+		}
+	}
+	for i := range c.RepoRules {
+		if err := c.RepoRules[i].Validate(); err != nil {
+			return fmt.Errorf("repo_rules[%d]: %w", i, err)
+		}
+	}
+	for i := range c.Repositories {
+		if err := c.Repositories[i].Validate(); err != nil {
+			return fmt.Errorf("repositories[%d]: %w", i, err)
+		}
+	}
+	if c.Archive != nil {
+		if err := c.Archive.Validate(); err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+	}
+	if c.Relay != nil {
+		if err := c.Relay.Validate(); err != nil {
+			return fmt.Errorf("relay: %w", err)
+		}
+	}
+	if c.Update != nil {
+		if err := c.Update.Validate(); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+	}
+	if c.Heartbeat != nil {
+		if err := c.Heartbeat.Validate(); err != nil {
+			return fmt.Errorf("heartbeat: %w", err)
+		}
+	}
+	if c.FixStatus != nil {
+		if err := c.FixStatus.Validate(); err != nil {
+			return fmt.Errorf("fix_status: %w", err)
+		}
+	}
+	if c.History != nil {
+		if err := c.History.Validate(); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+	if c.Redact != nil {
+		if err := c.Redact.Validate(); err != nil {
+			return fmt.Errorf("redact: %w", err)
+		}
+		if c.Redact.Enabled && !slices.Contains(c.PipelineSteps(), "redact") {
+			return errors.New("redact: enabled but left out of the pipeline")
+		}
+	}
+	if c.Privileges != nil {
+		if err := c.Privileges.Validate(); err != nil {
+			return fmt.Errorf("privileges: %w", err)
+		}
+	}
+	if c.Script != nil {
+		if err := c.Script.Validate(); err != nil {
+			return fmt.Errorf("script: %w", err)
+		}
+	}
+	if c.Exec != nil {
+		if err := c.Exec.Validate(); err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+	}
+	names := map[string]bool{}
+	for i := range c.Plugins {
+		if err := c.Plugins[i].Validate(); err != nil {
+			return fmt.Errorf("plugins[%d]: %w", i, err)
+		}
+		name := c.Plugins[i].name()
+		if names[name] {
+			return fmt.Errorf("plugins[%d]: another plugin is named %q", i, name)
+		}
+		names[name] = true
+	}
 	return nil
 }
 
+// ConfigPath resolves the config file: --config, then LACIA_CONFIG, then the
+// first file found in configDirs. Without one, a new config goes in the user
+// config directory, falling back to the executable's directory.
 func ConfigPath() string {
+	if configFlag != "" {
+		return configFlag
+	}
+	if path := os.Getenv("LACIA_CONFIG"); path != "" {
+		return path
+	}
+	for _, dir := range configDirs() {
+		if path, ok := findConfigFile(dir); ok {
+			return path
+		}
+	}
+	if dir := userConfigDir(); dir != "" {
+		return filepath.Join(dir, configFileName)
+	}
 	exe, err := os.Executable()
 	if err != nil {
 		return configFileName
@@ -40,30 +526,71 @@ func ConfigPath() string {
 }
 
 func LoadConfig() (*Config, error) {
-	path := ConfigPath()
-	data, err := os.ReadFile(path)
+	cfg, err := readConfig(ConfigPath())
 	if err != nil {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("invalid config: %w", err)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
+	return cfg, nil
+}
+
+// LoadStdinConfig loads the config for `--stdin`, replacing the configured
+// log files and listeners with a single target that reads standard input.
+func LoadStdinConfig() (*Config, error) {
+	cfg, err := readConfig(ConfigPath())
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.LogPath = watch.StdinPath
+	cfg.Targets = nil
+	cfg.Kubernetes = nil
+	cfg.Syslog = nil
+	cfg.GELF = nil
+	cfg.Forward = nil
+	cfg.Ingest = nil
+	cfg.stdin = true
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
-	return &cfg, nil
+	return cfg, nil
+}
+
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := decodeConfig(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
 }
 
 func SaveConfig(cfg *Config) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	path := ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return WriteConfig(path, cfg, 0644)
+}
+
+func WriteConfig(path string, cfg *Config, perm os.FileMode) error {
+	data, err := encodeConfig(path, cfg)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(ConfigPath(), data, 0644)
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
 }
 
 func ConfigExists() bool {
@@ -71,25 +598,70 @@ func ConfigExists() bool {
 	return err == nil
 }
 
+// SetupValues are answers to the setup questions given up front, so setup
+// can run without a terminal. Missing ones are still prompted for.
+type SetupValues struct {
+	LogPath   string
+	ServerURL string
+	RepoURL   string
+}
+
+// setupValuesFromEnv reads LACIA_LOG_PATH, LACIA_SERVER_URL and
+// LACIA_REPO_URL.
+func setupValuesFromEnv() SetupValues {
+	return SetupValues{
+		LogPath:   os.Getenv("LACIA_LOG_PATH"),
+		ServerURL: os.Getenv("LACIA_SERVER_URL"),
+		RepoURL:   os.Getenv("LACIA_REPO_URL"),
+	}
+}
+
+// errNoSetupInput is returned when a setup answer is needed but standard
+// input is closed, e.g. in a container or under systemd.
+var errNoSetupInput = errors.New("setup needs input but standard input is closed; " +
+	"pass --log-path, --server-url and --repo-url to `lacia-cli setup` or set LACIA_LOG_PATH, LACIA_SERVER_URL and LACIA_REPO_URL")
+
 func RunSetup() (*Config, error) {
-	reader := bufio.NewReader(os.Stdin)
+	return runSetup(setupValuesFromEnv())
+}
 
-	fmt.Println("\n╭─────────────────────────────────────╮")
-	fmt.Println("│       LACIA WATCHER SETUP           │")
-	fmt.Println("╰─────────────────────────────────────╯\n")
+func runSetup(v SetupValues) (*Config, error) {
+	if v.LogPath == "" || v.ServerURL == "" || v.RepoURL == "" {
+		reader := bufio.NewReader(os.Stdin)
 
-	logPath := promptRequired(reader, "Log file path")
-	serverURL := promptRequired(reader, "Next.js server URL")
-	repoURL := promptRequired(reader, "GitHub repository URL")
+		fmt.Println("\n╭─────────────────────────────────────╮")
+		fmt.Println("│       LACIA WATCHER SETUP           │")
+		fmt.Print("╰─────────────────────────────────────╯\n\n")
 
+		prompts := []struct {
+			value *string
+			label string
+		}{
+			{&v.LogPath, "Log file path"},
+			{&v.ServerURL, "Next.js server URL"},
+			{&v.RepoURL, "GitHub repository URL"},
+		}
+		for _, p := range prompts {
+			if *p.value != "" {
+				continue
+			}
+			answer, err := promptRequired(reader, p.label)
+			if err != nil {
+				return nil, err
+			}
+			*p.value = answer
+		}
+	}
+
+	serverURL := v.ServerURL
 	if !strings.HasSuffix(serverURL, "/api/webhook") {
 		serverURL = strings.TrimSuffix(serverURL, "/") + "/api/webhook"
 	}
 
 	cfg := &Config{
-		LogPath:   logPath,
+		LogPath:   v.LogPath,
 		ServerURL: serverURL,
-		RepoURL:   repoURL,
+		RepoURL:   v.RepoURL,
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -104,13 +676,17 @@ func RunSetup() (*Config, error) {
 	return cfg, nil
 }
 
-func promptRequired(reader *bufio.Reader, label string) string {
+func promptRequired(reader *bufio.Reader, label string) (string, error) {
 	for {
 		fmt.Printf("  %s: ", label)
-		input, _ := reader.ReadString('\n')
+		input, err := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
 		if input != "" {
-			return input
+			return input, nil
+		}
+		if err != nil {
+			fmt.Println()
+			return "", errNoSetupInput
 		}
 		fmt.Println("    ✗ This field is required")
 	}
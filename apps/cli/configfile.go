@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are looked for in order in each config directory; the
+// first one that exists is used.
+var configFileNames = []string{configFileName, "lacia.yaml", "lacia.yml", "lacia.toml"}
+
+// configFlag is the --config path, which takes precedence over LACIA_CONFIG
+// and the search directories.
+var configFlag string
+
+// configDirs are searched in order for a config file: the working directory,
+// the user config directory and the executable's directory.
+func configDirs() []string {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if dir := userConfigDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	return dirs
+}
+
+// userConfigDir is lacia's directory under the OS user config directory
+// (e.g. ~/.config/lacia), or "" when there is no home directory.
+func userConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "lacia")
+}
+
+// findConfigFile returns the first config file in dir, if any.
+func findConfigFile(dir string) (string, bool) {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// extractConfigFlag removes --config PATH (or --config=PATH) from args,
+// wherever it appears, and returns the remaining args and the path.
+func extractConfigFlag(args []string) ([]string, string) {
+	var rest []string
+	var path string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--config" || arg == "-config":
+			if i+1 < len(args) {
+				path = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(arg, "--config="):
+			path = strings.TrimPrefix(arg, "--config=")
+		case strings.HasPrefix(arg, "-config="):
+			path = strings.TrimPrefix(arg, "-config=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, path
+}
+
+// decodeConfig parses JSON, YAML or TOML by the file's extension and expands
+// ${VAR} references in string values.
+func decodeConfig(path string, data []byte) (*Config, error) {
+	var raw any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var table map[string]any
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return nil, err
+		}
+		raw = table
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := expandEnv(raw, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Every format goes through JSON so the json tags and Duration parsing
+	// apply to all of them.
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// encodeConfig formats cfg as JSON, YAML or TOML by the file's extension.
+func encodeConfig(path string, cfg *Config) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return data, nil
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if ext == ".toml" {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return yaml.Marshal(raw)
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} in every string value with the environment
+// variable's value. Only the braced form is expanded, so `$` in regex patterns
+// is left alone. Referencing an unset variable is an error rather than
+// silently sending an empty token.
+func expandEnv(v any, path string) (any, error) {
+	switch v := v.(type) {
+	case string:
+		var missing string
+		expanded := envRef.ReplaceAllStringFunc(v, func(ref string) string {
+			name := envRef.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok && missing == "" {
+				missing = name
+			}
+			return value
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("%s: environment variable %s is not set", path, missing)
+		}
+		return expanded, nil
+	case map[string]any:
+		for key, value := range v {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			expanded, err := expandEnv(value, field)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+	case []any:
+		for i, value := range v {
+			expanded, err := expandEnv(value, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	case []map[string]any:
+		// TOML arrays of tables
+		for i, value := range v {
+			if _, err := expandEnv(value, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
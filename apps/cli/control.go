@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const controlSocketName = "lacia.sock"
+
+// ControlSocketPath returns where a running watcher listens for local
+// control requests.
+func ControlSocketPath(cfg *Config) string {
+	if cfg != nil && cfg.ControlSocket != "" {
+		return cfg.ControlSocket
+	}
+	return filepath.Join(runtimeDir(), controlSocketName)
+}
+
+// runtimeDir holds per-run files like the control socket and PID file. It
+// is created private to the user, so no one else can plant or read them.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	dir := defaultRuntimeDir()
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// ControlServer serves HTTP on a Unix socket so local commands like
+// `lacia-cli status` can talk to a running watcher.
+type ControlServer struct {
+	path     string
+	listener net.Listener
+	server   *http.Server
+	mux      *http.ServeMux
+}
+
+func NewControlServer(path string) (*ControlServer, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket || !ownedByUser(info) {
+			return nil, fmt.Errorf("%s exists and is not a socket of this user", path)
+		}
+		// A leftover socket from a crashed instance is safe to remove; a live
+		// one means another watcher is already running.
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another instance is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	return &ControlServer{
+		path:     path,
+		listener: listener,
+		server:   &http.Server{Handler: mux},
+		mux:      mux,
+	}, nil
+}
+
+func (c *ControlServer) Handle(pattern string, handler func() (any, error)) {
+	c.HandleRequest(pattern, func(*http.Request) (any, error) {
+		return handler()
+	})
+}
+
+// HandleRequest is Handle for handlers that read query parameters.
+func (c *ControlServer) HandleRequest(pattern string, handler func(r *http.Request) (any, error)) {
+	c.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		result, err := handler(r)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+func (c *ControlServer) Serve() {
+	if err := c.server.Serve(c.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("control socket failed", "err", err)
+	}
+}
+
+func (c *ControlServer) Close() {
+	c.server.Close()
+	os.Remove(c.path)
+}
+
+// queryControl sends a GET request to the running watcher's control socket and
+// decodes the JSON reply into out.
+func queryControl(path, endpoint string, out any) error {
+	return requestControl(http.MethodGet, path, endpoint, out)
+}
+
+func requestControl(method, path, endpoint string, out any) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	req, err := http.NewRequest(method, "http://lacia"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("no running watcher at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("watcher returned %d: %s", resp.StatusCode, body["error"])
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pidFileName       = "lacia.pid"
+	daemonLogFileName = "lacia.log"
+)
+
+var errNoProcess = errors.New("process not found")
+
+func PIDFilePath(cfg *Config) string {
+	if cfg != nil && cfg.PIDFile != "" {
+		return cfg.PIDFile
+	}
+	return filepath.Join(runtimeDir(), pidFileName)
+}
+
+// writePIDFile creates the PID file, replacing a stale one. It is never
+// written through a symlink, so it cannot be pointed at another file.
+func writePIDFile(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		pid, err := readPIDFile(path)
+		if err == nil && processAlive(pid) {
+			return fmt.Errorf("already running with pid %d (%s)", pid, path)
+		}
+		if errors.Is(err, errUntrusted) {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var errUntrusted = errors.New("not a regular file of this user")
+
+// readPIDFile returns the PID in path. A file another user could have
+// planted is not trusted, since stop signals whatever PID it holds.
+func readPIDFile(path string) (int, error) {
+	if err := checkOwnFile(path); err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// checkOwnFile fails unless path is a regular file, not a symlink, that the
+// current user or root owns.
+func checkOwnFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || !ownedByUser(info) {
+		return fmt.Errorf("%s: %w", path, errUntrusted)
+	}
+	return nil
+}
+
+// daemonize re-executes the binary detached from the terminal with its
+// output redirected to logPath and args passed to the child's start command,
+// then returns in the parent.
+func daemonize(logPath string, args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if logPath == "" {
+		logPath = filepath.Join(runtimeDir(), daemonLogFileName)
+	}
+
+	if err := checkOwnFile(logPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("open log file failed: %w", err)
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log file failed: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, append([]string{"start"}, args...)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start daemon failed: %w", err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	fmt.Printf("✓ Watcher started in background (pid %d)\n", pid)
+	fmt.Printf("  Output: %s\n", logPath)
+	return nil
+}
+
+func runStopCommand(args []string) {
+	var cfg *Config
+	if ConfigExists() {
+		cfg, _ = LoadConfig()
+	}
+
+	var reply map[string]any
+	if err := requestControl("POST", ControlSocketPath(cfg), "/stop", &reply); err == nil {
+		fmt.Println("✓ Stop requested")
+		return
+	}
+
+	// Fall back to signalling the PID from the PID file
+	pidPath := PIDFilePath(cfg)
+	pid, err := readPIDFile(pidPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No running watcher found (%s): %v\n", pidPath, err)
+		os.Exit(1)
+	}
+	if err := terminateProcess(pid); err != nil {
+		fmt.Fprintf(os.Stderr, "Stop failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < 50 && processAlive(pid); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		fmt.Fprintf(os.Stderr, "Watcher (pid %d) did not exit\n", pid)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Watcher (pid %d) stopped\n", pid)
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+func terminateProcess(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return errNoProcess
+	}
+	return proc.Signal(syscall.SIGTERM)
+}
+
+// defaultRuntimeDir is where per-run files go without XDG_RUNTIME_DIR:
+// /run/lacia for root, the user's cache directory otherwise, never a
+// directory other users can write to.
+func defaultRuntimeDir() string {
+	if os.Geteuid() == 0 {
+		return "/run/lacia"
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "lacia")
+	}
+	return filepath.Join(os.TempDir(), "lacia-"+strconv.Itoa(os.Geteuid()))
+}
+
+// ownedByUser reports whether info belongs to the current user or root,
+// the only users whose files are trusted.
+func ownedByUser(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && (int(st.Uid) == os.Geteuid() || st.Uid == 0)
+}
+
+// listenUnix creates the socket at path readable by the current user only
+// from the start, rather than changing its mode once others could connect.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == 259 // STILL_ACTIVE
+}
+
+// Windows has no SIGTERM; the control socket is the graceful path, so this
+// fallback kills the process outright.
+func terminateProcess(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return errNoProcess
+	}
+	return proc.Kill()
+}
+
+// defaultRuntimeDir is where per-run files go without XDG_RUNTIME_DIR. The
+// local app data and temp directories are both private to the user.
+func defaultRuntimeDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "lacia")
+	}
+	return filepath.Join(os.TempDir(), "lacia")
+}
+
+// ownedByUser reports whether info belongs to the current user. Windows
+// guards files with ACLs, which the user's own directories already set.
+func ownedByUser(os.FileInfo) bool {
+	return true
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
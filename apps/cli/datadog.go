@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const (
+	defaultDatadogSite = "datadoghq.com"
+	// Datadog rejects event texts over 4000 characters
+	datadogMaxText = 3900
+)
+
+type DatadogConfig struct {
+	APIKey string `json:"api_key"`
+	// Site is the Datadog site the account is on, e.g. datadoghq.eu
+	Site        string   `json:"site,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+}
+
+func (c *DatadogConfig) Validate() error {
+	if c.APIKey == "" {
+		return errors.New("api_key is required")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// datadogAlertType maps incident severities onto Datadog event alert types.
+var datadogAlertType = map[string]string{
+	SeverityLow:      "info",
+	SeverityMedium:   "warning",
+	SeverityHigh:     "error",
+	SeverityCritical: "error",
+}
+
+// DatadogSink posts incidents to the Datadog Events API. The aggregation
+// key is the error's fingerprint, so Datadog rolls up repeats of an error.
+type DatadogSink struct {
+	apiKey      string
+	eventsURL   string
+	tags        []string
+	fingerprint string
+	retry       RetryPolicy
+	httpClient  *http.Client
+}
+
+func NewDatadogSink(cfg *DatadogConfig, fingerprint string) *DatadogSink {
+	site := cfg.Site
+	if site == "" {
+		site = defaultDatadogSite
+	}
+	return &DatadogSink{
+		apiKey:      cfg.APIKey,
+		eventsURL:   "https://api." + site + "/api/v1/events",
+		tags:        cfg.Tags,
+		fingerprint: fingerprint,
+		retry:       ship.NewRetryPolicy(nil),
+		httpClient:  &http.Client{},
+	}
+}
+
+func (s *DatadogSink) Name() string {
+	return "datadog"
+}
+
+// Send retries like the PagerDuty sink, as the event may be what alerts.
+func (s *DatadogSink) Send(ctx context.Context, payload IncidentPayload) error {
+	event := s.event(payload)
+	headers := map[string]string{"DD-API-KEY": s.apiKey}
+	for attempt := 1; ; attempt++ {
+		err := postJSON(ctx, s.httpClient, s.eventsURL, event, headers)
+		if err == nil || !ship.IsRetryable(err) || attempt >= s.retry.MaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.retry.Delay(attempt)):
+		}
+	}
+}
+
+func (s *DatadogSink) event(p IncidentPayload) map[string]any {
+	tags := append([]string{"source:lacia", "severity:" + p.Severity}, s.tags...)
+	if p.RepoURL != "" {
+		tags = append(tags, "repo_url:"+p.RepoURL)
+	}
+	if p.Target != "" {
+		tags = append(tags, "target:"+p.Target)
+	}
+	if p.Language != "" {
+		tags = append(tags, "language:"+p.Language)
+	}
+	// Datadog's unified service tags
+	if p.Service != "" {
+		tags = append(tags, "service:"+p.Service)
+	}
+	if p.Environment != "" {
+		tags = append(tags, "env:"+p.Environment)
+	}
+	if p.Version != "" {
+		tags = append(tags, "version:"+p.Version)
+	}
+	if c := p.Container; c != nil {
+		for _, t := range [][2]string{
+			{"container_id", c.ID},
+			{"image_name", c.Image},
+			{"pod_name", c.Pod},
+			{"kube_namespace", c.Namespace},
+		} {
+			if t[1] != "" {
+				tags = append(tags, t[0]+":"+t[1])
+			}
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Tags)) {
+		tags = append(tags, k+":"+p.Tags[k])
+	}
+
+	alertType := datadogAlertType[p.Severity]
+	if alertType == "" {
+		alertType = "error"
+	}
+	priority := "normal"
+	if severityRank[p.Severity] < severityRank[SeverityHigh] {
+		priority = "low"
+	}
+
+	// %%% marks the text as Markdown, so the trace keeps its layout
+	trace := ship.Truncate(strings.Join(p.Context, "\n"), datadogMaxText)
+	text := "%%%\n```\n" + trace + "\n```\n"
+	if p.OccurrenceCount > 1 {
+		text += fmt.Sprintf("%d occurrences\n", p.OccurrenceCount)
+	}
+	text += "%%%"
+
+	event := map[string]any{
+		// Datadog truncates titles at 100 characters
+		"title":            ship.Truncate(p.ErrorLine, 100),
+		"text":             text,
+		"host":             p.Hostname,
+		"tags":             tags,
+		"alert_type":       alertType,
+		"priority":         priority,
+		"aggregation_key":  detect.Fingerprint(LogEvent{Line: p.ErrorLine, Context: p.Context}, s.fingerprint),
+		"source_type_name": "lacia",
+	}
+	if ts, err := time.Parse(time.RFC3339, p.Timestamp); err == nil {
+		event["date_happened"] = ts.Unix()
+	}
+	return event
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/dedupe"
+)
+
+// NewDeduper returns the deduper for cfg, keeping a persisted cache next to
+// the config unless it names another path.
+func NewDeduper(cfg *DedupeConfig) (*Deduper, error) {
+	if cfg != nil && cfg.Persist && cfg.Path == "" {
+		withPath := *cfg
+		withPath.Path = dedupeCachePath(cfg)
+		cfg = &withPath
+	}
+	return dedupe.New(cfg)
+}
+
+// dedupeCachePath returns where a persisted cache is kept.
+func dedupeCachePath(cfg *DedupeConfig) string {
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+	return filepath.Join(filepath.Dir(ConfigPath()), dedupe.FileName)
+}
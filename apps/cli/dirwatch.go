@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+const (
+	dirScanInterval   = 2 * time.Second
+	defaultDirIdleTTL = time.Hour
+)
+
+type dirFile struct {
+	watcher   *Watcher
+	cancel    context.CancelFunc
+	exited    chan struct{}
+	startedAt time.Time
+}
+
+// retiredFile is a file that is being stopped. Its offset is remembered
+// unless it was deleted.
+type retiredFile struct {
+	path    string
+	file    *dirFile
+	deleted bool
+}
+
+// DirWatcher tails every matching file under a directory, picking up new
+// files as they appear and retiring files that are deleted or stay idle for
+// longer than the target's idle_ttl.
+type DirWatcher struct {
+	target    Target
+	detector  *Detector
+	filter    fileFilter
+	fromStart bool
+	idleTTL   time.Duration
+
+	mu     sync.Mutex
+	active map[string]*dirFile
+	// resume remembers where to continue reading files that are not being
+	// tailed right now, so a retired file that wakes up is not re-read.
+	resume map[string]int64
+	// guard restarts file watchers that panic
+	guard *panicGuard
+}
+
+func NewDirWatcher(target Target, detector *Detector, checkpoints *Checkpoints) (*DirWatcher, error) {
+	d := &DirWatcher{
+		target:    target,
+		detector:  detector,
+		fromStart: target.StartAt != "end",
+		idleTTL:   time.Duration(target.IdleTTL),
+		active:    make(map[string]*dirFile),
+		resume:    make(map[string]int64),
+	}
+	if d.idleTTL == 0 {
+		d.idleTTL = defaultDirIdleTTL
+	}
+	filter, err := newFileFilter(target)
+	if err != nil {
+		return nil, err
+	}
+	d.filter = filter
+
+	// Files that exist at startup are read like a single log_path, from
+	// start_from; only files created later honour start_at.
+	err = d.walk(func(path string, info fs.FileInfo) {
+		offset := checkpoints.StartOffset(target, path, info.Size())
+		if offset < 0 {
+			offset = info.Size()
+		}
+		d.resume[path] = offset
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetDetector switches every file, including ones found later, to detector.
+func (d *DirWatcher) SetDetector(detector *Detector) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.detector = detector
+	for _, f := range d.active {
+		f.watcher.SetDetector(detector)
+	}
+}
+
+// Resume carries over where prev stopped reading each file, so a directory
+// target that is restarted by a config reload does not skip or re-read
+// anything. prev must have stopped watching.
+func (d *DirWatcher) Resume(prev *DirWatcher) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	for path, offset := range prev.resume {
+		if _, ok := d.resume[path]; ok {
+			d.resume[path] = offset
+		}
+	}
+}
+
+// Watchers returns the files currently being tailed.
+func (d *DirWatcher) Watchers() []*Watcher {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	watchers := make([]*Watcher, 0, len(d.active))
+	for _, f := range d.active {
+		watchers = append(watchers, f.watcher)
+	}
+	return watchers
+}
+
+func (d *DirWatcher) Watch(ctx context.Context, events chan<- LogEvent) {
+	ticker := time.NewTicker(dirScanInterval)
+	defer ticker.Stop()
+
+	for {
+		d.scan(ctx, events)
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			// Signal every file first so their idle waits overlap
+			retired := make([]retiredFile, 0, len(d.active))
+			for path := range d.active {
+				retired = append(retired, d.retire(path, false))
+			}
+			d.active = nil
+			d.mu.Unlock()
+			d.finish(retired)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DirWatcher) scan(ctx context.Context, events chan<- LogEvent) {
+	now := time.Now()
+
+	d.mu.Lock()
+	var retired []retiredFile
+	for path, f := range d.active {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			slog.Info("stopped tailing deleted file", "path", path)
+			retired = append(retired, d.retire(path, true))
+			continue
+		}
+		lastActive := f.startedAt
+		if last := f.watcher.LastRead(); last.After(lastActive) {
+			lastActive = last
+		}
+		if now.Sub(lastActive) > d.idleTTL {
+			slog.Info("stopped tailing idle file", "path", path, "idle", now.Sub(lastActive).Round(time.Second).String())
+			retired = append(retired, d.retire(path, false))
+		}
+	}
+	d.mu.Unlock()
+	// Status and reloads take d.mu, so the files are waited for without it
+	d.finish(retired)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.walk(func(path string, info fs.FileInfo) {
+		if _, ok := d.active[path]; ok || now.Sub(info.ModTime()) > d.idleTTL {
+			return
+		}
+		offset, ok := d.resume[path]
+		if !ok && d.fromStart {
+			offset = 0
+		} else if !ok {
+			offset = -1
+		} else if offset > info.Size() {
+			// Truncated while we were not looking
+			offset = 0
+		}
+		d.start(ctx, path, offset, events)
+	})
+}
+
+func (d *DirWatcher) start(ctx context.Context, path string, offset int64, events chan<- LogEvent) {
+	target := d.target
+	target.LogPath = path
+	watcher, err := watch.NewWatcherAt(target, d.detector, offset)
+	if err != nil {
+		slog.Warn("open log file failed", "path", path, "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	f := &dirFile{
+		watcher:   watcher,
+		cancel:    cancel,
+		exited:    make(chan struct{}),
+		startedAt: time.Now(),
+	}
+	d.active[path] = f
+	delete(d.resume, path)
+	slog.Info("watching", "path", path, "label", d.target.Label)
+
+	go func() {
+		defer close(f.exited)
+		watcher.Supervise(ctx, d.guard, events)
+	}()
+}
+
+// retire signals path's watcher to stop and forgets it. Callers must hold
+// d.mu, and pass what it returns to finish after releasing it.
+func (d *DirWatcher) retire(path string, deleted bool) retiredFile {
+	f := d.active[path]
+	f.cancel()
+	delete(d.active, path)
+	return retiredFile{path: path, file: f, deleted: deleted}
+}
+
+// finish waits for retired files' watchers to exit and remembers where to
+// continue reading the files that still exist.
+func (d *DirWatcher) finish(retired []retiredFile) {
+	for _, r := range retired {
+		<-r.file.exited
+		r.file.watcher.Close()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range retired {
+		if !r.deleted {
+			d.resume[r.path] = r.file.watcher.ResumeOffset()
+		}
+	}
+}
+
+func (d *DirWatcher) walk(fn func(path string, info fs.FileInfo)) error {
+	return walkDir(d.target.Dir, d.filter, fn)
+}
+
+// fileFilter selects the files of a directory target by name.
+type fileFilter struct {
+	match          *regexp.Regexp
+	exclude        *regexp.Regexp
+	followSymlinks bool
+}
+
+func newFileFilter(target Target) (fileFilter, error) {
+	f := fileFilter{followSymlinks: target.FollowSymlinks}
+	var err error
+	if target.Match != "" {
+		if f.match, err = regexp.Compile(target.Match); err != nil {
+			return fileFilter{}, err
+		}
+	}
+	if target.Exclude != "" {
+		if f.exclude, err = regexp.Compile(target.Exclude); err != nil {
+			return fileFilter{}, err
+		}
+	}
+	return f, nil
+}
+
+func (f fileFilter) matches(name string) bool {
+	return (f.match == nil || f.match.MatchString(name)) && (f.exclude == nil || !f.exclude.MatchString(name))
+}
+
+// walkDir calls fn for every regular file under dir whose name the filter
+// selects and, when it follows symlinks, every link to one. Links to
+// directories are not followed.
+func walkDir(dir string, filter fileFilter, fn func(path string, info fs.FileInfo)) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// An unreadable subdirectory should not stop the rest of the scan
+			return nil
+		}
+		var info fs.FileInfo
+		switch {
+		case entry.Type().IsRegular():
+			if info, err = entry.Info(); err != nil {
+				return nil
+			}
+		case entry.Type()&fs.ModeSymlink != 0 && filter.followSymlinks:
+			// Stat follows the link, so the size and time are the file's
+			if info, err = os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		default:
+			return nil
+		}
+		if !filter.matches(entry.Name()) {
+			return nil
+		}
+		fn(path, info)
+		return nil
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+func TestDirWatcherRetire(t *testing.T) {
+	dir := t.TempDir()
+	idle, deleted := filepath.Join(dir, "idle.log"), filepath.Join(dir, "deleted.log")
+	for _, path := range []string{idle, deleted} {
+		if err := os.WriteFile(path, []byte("started\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	detector, err := detect.NewDetector(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDirWatcher(Target{Dir: dir, IdleTTL: detect.Duration(time.Hour)}, detector, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan LogEvent, 10)
+
+	d.scan(ctx, events)
+	if n := len(d.Watchers()); n != 2 {
+		t.Fatalf("tailing %d files, want 2", n)
+	}
+
+	os.Remove(deleted)
+	d.idleTTL = time.Nanosecond
+	d.scan(ctx, events)
+	if n := len(d.Watchers()); n != 0 {
+		t.Fatalf("tailing %d files after retiring them, want 0", n)
+	}
+	if offset, ok := d.resume[idle]; !ok || offset != int64(len("started\n")) {
+		t.Errorf("idle file resumes at %d (%v), want its end", offset, ok)
+	}
+	if _, ok := d.resume[deleted]; ok {
+		t.Error("deleted file's offset kept")
+	}
+}
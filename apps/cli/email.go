@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultEmailSubject = "[Lacia] {{.Severity}}: {{truncate 100 .ErrorLine}}"
+	defaultEmailBody    = `{{.ErrorLine}}
+
+Host:     {{.Hostname}}
+Time:     {{.Timestamp}}
+Severity: {{.Severity}}
+{{- if .Target}}
+Target:   {{.Target}}{{end}}
+{{- if .RepoURL}}
+Repo:     {{.RepoURL}}{{end}}
+{{- if gt .OccurrenceCount 1}}
+Seen:     {{.OccurrenceCount}} times{{end}}
+
+{{join .Context "\n"}}
+`
+	// emailDigestMax caps the incidents listed in one digest; the rest
+	// are only counted.
+	emailDigestMax = 100
+)
+
+// EmailConfig mails incidents through an SMTP server, one message per
+// incident or, with Digest, one per period.
+type EmailConfig struct {
+	// Addr is the SMTP server's host:port. STARTTLS is used when the server
+	// offers it; TLS connects with TLS from the start, as on port 465.
+	Addr     string   `json:"addr"`
+	TLS      bool     `json:"tls,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Subject and Body are text/templates over the incident, with the
+	// webhook template helpers
+	Subject     string   `json:"subject,omitempty"`
+	Body        string   `json:"body,omitempty"`
+	BodyFile    string   `json:"body_file,omitempty"`
+	Digest      Duration `json:"digest,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+}
+
+func (c *EmailConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("addr must be host:port: %w", err)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if len(c.To) == 0 {
+		return errors.New("to is required")
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+	}
+	if c.Body != "" && c.BodyFile != "" {
+		return errors.New("body and body_file are mutually exclusive")
+	}
+	if c.Digest < 0 {
+		return errors.New("digest must be positive")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	if _, _, err := c.templates(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *EmailConfig) templates() (subject, body *template.Template, err error) {
+	subjectText := c.Subject
+	if subjectText == "" {
+		subjectText = defaultEmailSubject
+	}
+	bodyText := c.Body
+	if c.BodyFile != "" {
+		data, err := os.ReadFile(c.BodyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("body_file: %w", err)
+		}
+		bodyText = string(data)
+	}
+	if bodyText == "" {
+		bodyText = defaultEmailBody
+	}
+	if subject, err = template.New("subject").Funcs(webhookFuncs).Parse(subjectText); err != nil {
+		return nil, nil, fmt.Errorf("subject: %w", err)
+	}
+	if body, err = template.New("body").Funcs(webhookFuncs).Parse(bodyText); err != nil {
+		return nil, nil, fmt.Errorf("body: %w", err)
+	}
+	return subject, body, nil
+}
+
+// EmailSink sends incidents by mail. In digest mode incidents are held and
+// sent together once the digest period after the first one has passed.
+type EmailSink struct {
+	addr     string
+	host     string
+	implicit bool
+	auth     smtp.Auth
+	from     string
+	to       []string
+	subject  *template.Template
+	body     *template.Template
+	digest   time.Duration
+
+	mu      sync.Mutex
+	pending []IncidentPayload
+	dropped int
+	timer   *time.Timer
+}
+
+func NewEmailSink(cfg *EmailConfig) (*EmailSink, error) {
+	subject, body, err := cfg.templates()
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(cfg.Addr)
+	s := &EmailSink{
+		addr:     cfg.Addr,
+		host:     host,
+		implicit: cfg.TLS,
+		from:     cfg.From,
+		to:       cfg.To,
+		subject:  subject,
+		body:     body,
+		digest:   time.Duration(cfg.Digest),
+	}
+	if cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to
+		// localhost
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return s, nil
+}
+
+func (s *EmailSink) Name() string {
+	return "email"
+}
+
+func (s *EmailSink) Send(ctx context.Context, payload IncidentPayload) error {
+	if s.digest > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.pending) >= emailDigestMax {
+			s.dropped++
+			return nil
+		}
+		s.pending = append(s.pending, payload)
+		if s.timer == nil {
+			s.timer = time.AfterFunc(s.digest, s.flush)
+		}
+		return nil
+	}
+
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, payload); err != nil {
+		return fmt.Errorf("subject template failed: %w", err)
+	}
+	if err := s.body.Execute(&body, payload); err != nil {
+		return fmt.Errorf("body template failed: %w", err)
+	}
+	return s.send(ctx, subject.String(), body.Bytes())
+}
+
+// flush mails the pending digest.
+func (s *EmailSink) flush() {
+	s.mu.Lock()
+	pending, dropped := s.pending, s.dropped
+	s.pending, s.dropped, s.timer = nil, 0, nil
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	for i, p := range pending {
+		if i > 0 {
+			body.WriteString("\n----------------------------------------\n\n")
+		}
+		if err := s.body.Execute(&body, p); err != nil {
+			slog.Error("email body template failed", "err", err)
+			return
+		}
+	}
+	total := len(pending) + dropped
+	if dropped > 0 {
+		fmt.Fprintf(&body, "\n… and %d more incidents\n", dropped)
+	}
+	subject := fmt.Sprintf("[Lacia] %d incidents since %s", total, pending[0].Timestamp)
+	if total == 1 {
+		var b bytes.Buffer
+		if err := s.subject.Execute(&b, pending[0]); err == nil {
+			subject = b.String()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	if err := s.send(ctx, subject, body.Bytes()); err != nil {
+		slog.Error("sink send failed", "sink", s.Name(), "incidents", total, "err", err)
+	}
+}
+
+// Close sends what is left of the digest.
+func (s *EmailSink) Close() error {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.mu.Unlock()
+	s.flush()
+	return nil
+}
+
+func (s *EmailSink) send(ctx context.Context, subject string, body []byte) error {
+	var d net.Dialer
+	var conn net.Conn
+	var err error
+	if s.implicit {
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: s.host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect failed: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.implicit {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	headers := []string{
+		"From: " + s.from,
+		"To: " + strings.Join(s.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.ReplaceAll(subject, "\n", " ")),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+	}
+	fmt.Fprintf(w, "%s\n\n", strings.Join(headers, "\n"))
+	qp := quotedprintable.NewWriter(w)
+	qp.Write(body)
+	qp.Close()
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const defaultExecTimeout = 5 * time.Second
+
+// ExecConfig pipes each incident through an external command, for teams
+// with enrichment scripts of their own.
+type ExecConfig struct {
+	// Command is run directly, not through a shell
+	Command []string `json:"command"`
+	Timeout Duration `json:"timeout,omitempty"`
+	// OnError is what happens to an incident when the command fails, times
+	// out or prints invalid JSON: "send" it unchanged (default) or "drop" it
+	OnError string `json:"on_error,omitempty"`
+}
+
+func (c *ExecConfig) Validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return errors.New("command is required")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+	if c.OnError != "" && c.OnError != "send" && c.OnError != "drop" {
+		return fmt.Errorf("on_error must be send or drop: %q", c.OnError)
+	}
+	return nil
+}
+
+// ExecHook writes the incident as JSON to the command's stdin and reads the
+// incident to send from its stdout. Empty output drops the incident.
+type ExecHook struct {
+	command     []string
+	timeout     time.Duration
+	dropOnError bool
+}
+
+func NewExecHook(cfg *ExecConfig) *ExecHook {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout == 0 {
+		timeout = defaultExecTimeout
+	}
+	return &ExecHook{command: cfg.Command, timeout: timeout, dropOnError: cfg.OnError == "drop"}
+}
+
+// newExecHookOrNil returns nil without an exec section.
+func newExecHookOrNil(cfg *ExecConfig) *ExecHook {
+	if cfg == nil {
+		return nil
+	}
+	return NewExecHook(cfg)
+}
+
+// Apply replaces c's payload with the command's output and reports whether
+// the incident should be dropped.
+func (h *ExecHook) Apply(c *Candidate) (drop bool) {
+	c.Payload.Severity = c.Severity
+	out, err := h.run(*c.Payload)
+	if err != nil {
+		slog.Warn("exec hook failed", "command", h.command[0], "line", c.Event.Line, "err", err)
+		return h.dropOnError
+	}
+	if out == nil {
+		return true
+	}
+	c.Payload = out
+	if out.Severity != "" {
+		c.Severity = out.Severity
+	}
+	c.Event.Line = out.ErrorLine
+	return false
+}
+
+func (h *ExecHook) run(payload IncidentPayload) (*IncidentPayload, error) {
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal failed: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	// Do not wait for children that keep the pipes open after a timeout
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %v", h.timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, ship.Truncate(msg, 200))
+		}
+		return nil, err
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+
+	var out IncidentPayload
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	if out.ErrorLine == "" {
+		return nil, errors.New("invalid output: error_line is missing")
+	}
+	if out.Severity != "" {
+		if _, ok := severityRank[out.Severity]; !ok {
+			return nil, fmt.Errorf("invalid output: unknown severity %q", out.Severity)
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFixStatusInterval = 30 * time.Second
+	defaultFixStatusTracked  = 20
+	// maxFixStatusTracked is the most incidents the server looks up in one
+	// poll
+	maxFixStatusTracked = 100
+)
+
+// FixStatusConfig polls the server for what became of the incidents this
+// agent reported, so `lacia-cli status` can show whether a fix was already
+// proposed without opening the dashboard.
+type FixStatusConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval,omitempty"`
+	// MaxTracked is how many of the latest incidents are followed
+	MaxTracked int `json:"max_tracked,omitempty"`
+}
+
+func (c *FixStatusConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+	if c.Interval > 0 && time.Duration(c.Interval) < time.Second {
+		return errors.New("interval must be at least 1s")
+	}
+	if c.MaxTracked < 0 || c.MaxTracked > maxFixStatusTracked {
+		return fmt.Errorf("max_tracked must be between 1 and %d", maxFixStatusTracked)
+	}
+	return nil
+}
+
+func (c *FixStatusConfig) interval() time.Duration {
+	if c.Interval == 0 {
+		return defaultFixStatusInterval
+	}
+	return time.Duration(c.Interval)
+}
+
+// IncidentFix is where the server is with fixing one reported incident.
+type IncidentFix struct {
+	ID int `json:"id"`
+	// Fingerprint and RepoURL find the incident on the server until its ID
+	// is known, for incidents sent through a relay, which gives none
+	Fingerprint string `json:"fingerprint,omitempty"`
+	RepoURL     string `json:"repo_url,omitempty"`
+	// Status is the server's: open, processing, fixed, pr_skipped,
+	// not_an_error, failed or clone_failed. Empty until first polled.
+	Status string    `json:"status,omitempty"`
+	PRURL  string    `json:"pr_url,omitempty"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Done reports whether the server has finished with the incident, so it
+// need not be polled again.
+func (f IncidentFix) Done() bool {
+	switch f.Status {
+	case "", "open", "processing":
+		return false
+	}
+	return true
+}
+
+// Describe says where the fix stands in words.
+func (f IncidentFix) Describe() string {
+	switch f.Status {
+	case "":
+		return "reported"
+	case "open":
+		return "waiting for analysis"
+	case "processing":
+		return "analyzing"
+	case "fixed":
+		if f.PRURL != "" {
+			return "PR opened: " + f.PRURL
+		}
+		return "fixed, PR opened"
+	case "pr_skipped":
+		return "fixed, PR skipped (server in dry-run mode)"
+	case "not_an_error":
+		return "not an error"
+	case "failed", "clone_failed":
+		return "no fix found (" + f.Status + ")"
+	}
+	return f.Status
+}
+
+// FixTracker remembers the latest incidents sent and their fix status. A
+// nil tracker tracks nothing.
+type FixTracker struct {
+	mu    sync.Mutex
+	max   int
+	fixes []IncidentFix // oldest first
+}
+
+func NewFixTracker(cfg *FixStatusConfig) *FixTracker {
+	max := cfg.MaxTracked
+	if max == 0 {
+		max = defaultFixStatusTracked
+	}
+	return &FixTracker{max: max}
+}
+
+// Track follows the incidents the server accepted as payloads, under the
+// ids it gave them or, where it gave none, by fingerprint. An incident
+// already tracked, such as one sent again as an update, moves to the end;
+// the oldest beyond the limit are forgotten.
+func (t *FixTracker) Track(payloads []IncidentPayload, ids []int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	for i, p := range payloads {
+		fix := IncidentFix{SentAt: now}
+		if i < len(ids) {
+			fix.ID = ids[i]
+		}
+		if fix.ID == 0 {
+			if p.Fingerprint == "" {
+				continue
+			}
+			fix.Fingerprint, fix.RepoURL = p.Fingerprint, p.RepoURL
+		}
+		t.fixes = slices.DeleteFunc(t.fixes, fix.same)
+		t.fixes = append(t.fixes, fix)
+	}
+	if n := len(t.fixes) - t.max; n > 0 {
+		t.fixes = append(t.fixes[:0], t.fixes[n:]...)
+	}
+}
+
+// same reports whether f and other are the same incident on the server.
+func (f IncidentFix) same(other IncidentFix) bool {
+	if f.ID != 0 && other.ID != 0 {
+		return f.ID == other.ID
+	}
+	return f.Fingerprint != "" && f.Fingerprint == other.Fingerprint && f.RepoURL == other.RepoURL
+}
+
+// Fixes returns the tracked incidents, newest first.
+func (t *FixTracker) Fixes() []IncidentFix {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]IncidentFix, len(t.fixes))
+	for i, f := range t.fixes {
+		out[len(out)-1-i] = f
+	}
+	return out
+}
+
+// pending returns the incidents the server is not done with.
+func (t *FixTracker) pending() []IncidentFix {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var fixes []IncidentFix
+	for _, f := range t.fixes {
+		if !f.Done() {
+			fixes = append(fixes, f)
+		}
+	}
+	return fixes
+}
+
+// update records the statuses the server returned and logs the changes.
+// An incident tracked by fingerprint is tracked by its ID from then on.
+func (t *FixTracker) update(statuses []IncidentFix) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, f := range t.fixes {
+		j := slices.IndexFunc(statuses, func(s IncidentFix) bool {
+			if f.ID != 0 {
+				return s.ID == f.ID
+			}
+			return f.same(s)
+		})
+		if j < 0 {
+			continue
+		}
+		s := statuses[j]
+		if f.ID == 0 {
+			f.ID, f.Fingerprint, f.RepoURL = s.ID, "", ""
+			t.fixes[i] = f
+		}
+		if s.Status == f.Status && s.PRURL == f.PRURL {
+			continue
+		}
+		f.Status, f.PRURL = s.Status, s.PRURL
+		t.fixes[i] = f
+		slog.Info("incident status changed", "incident_id", f.ID, "status", f.Status, "pr_url", f.PRURL)
+	}
+}
+
+// incidentStatusURL derives the server's incident status endpoint from the
+// webhook URL.
+func incidentStatusURL(serverURL string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/api/webhook")
+	return base + "/api/incidents/status"
+}
+
+// incidentKey finds an incident on the server by fingerprint.
+type incidentKey struct {
+	Fingerprint string `json:"fingerprint"`
+	RepoURL     string `json:"repo_url,omitempty"`
+}
+
+// fetchIncidentStatuses asks the server where it is with the given
+// incidents. Incidents it no longer knows are left out of the reply.
+func fetchIncidentStatuses(ctx context.Context, client *Client, fixes []IncidentFix) ([]IncidentFix, error) {
+	var ids []int
+	var keys []incidentKey
+	for _, f := range fixes {
+		if f.ID != 0 {
+			ids = append(ids, f.ID)
+		} else {
+			keys = append(keys, incidentKey{f.Fingerprint, f.RepoURL})
+		}
+	}
+	body, err := json.Marshal(struct {
+		IDs          []int         `json:"ids"`
+		Fingerprints []incidentKey `json:"fingerprints,omitempty"`
+	}{ids, keys})
+	if err != nil {
+		return nil, fmt.Errorf("marshal failed: %w", err)
+	}
+	resp, err := client.Post(ctx, incidentStatusURL(client.ServerURL()), body)
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Incidents []IncidentFix `json:"incidents"`
+	}
+	if err := json.Unmarshal(resp, &reply); err != nil {
+		return nil, fmt.Errorf("invalid status reply: %w", err)
+	}
+	return reply.Incidents, nil
+}
+
+// runFixStatus polls the status of the tracked incidents every interval
+// until ctx is done. A failed poll is not retried, the next one replaces it.
+func runFixStatus(ctx context.Context, cfg *FixStatusConfig, client *Client, tracker *FixTracker) {
+	ticker := time.NewTicker(cfg.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fixes := tracker.pending()
+		if len(fixes) == 0 || client.ThrottledFor() > 0 {
+			continue
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		statuses, err := fetchIncidentStatuses(pollCtx, client, fixes)
+		cancel()
+		if err != nil {
+			slog.Warn("incident status poll failed", "err", err)
+			continue
+		}
+		tracker.update(statuses)
+	}
+}
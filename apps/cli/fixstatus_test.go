@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestFixTrackerFingerprints(t *testing.T) {
+	tracker := NewFixTracker(&FixStatusConfig{MaxTracked: 3})
+	relayed := IncidentPayload{Fingerprint: "ab12", RepoURL: "https://github.com/acme/api"}
+	tracker.Track([]IncidentPayload{relayed, {}}, nil)
+	tracker.Track([]IncidentPayload{{Fingerprint: "cd34"}}, []int{7})
+	// An update for the same error is tracked once
+	tracker.Track([]IncidentPayload{relayed}, []int{0})
+
+	pending := tracker.pending()
+	if len(pending) != 2 || pending[0].ID != 7 || pending[1].Fingerprint != "ab12" {
+		t.Fatalf("pending() = %+v, want #7 then ab12", pending)
+	}
+
+	tracker.update([]IncidentFix{
+		{ID: 7, Status: "processing"},
+		{ID: 9, Status: "fixed", PRURL: "https://github.com/acme/api/pull/1", Fingerprint: "ab12", RepoURL: "https://github.com/acme/api"},
+		{ID: 10, Status: "fixed", Fingerprint: "ab12"},
+	})
+	fixes := tracker.Fixes()
+	if len(fixes) != 2 {
+		t.Fatalf("Fixes() = %+v, want 2", fixes)
+	}
+	if fixes[0].ID != 9 || fixes[0].Fingerprint != "" || !fixes[0].Done() {
+		t.Errorf("fixes[0] = %+v, want #9 fixed and tracked by ID", fixes[0])
+	}
+	if fixes[1].ID != 7 || fixes[1].Status != "processing" {
+		t.Errorf("fixes[1] = %+v, want #7 processing", fixes[1])
+	}
+}
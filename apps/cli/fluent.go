@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+const defaultFluentTag = "lacia"
+
+// FluentConfig forwards incidents to Fluentd or Fluent Bit over the
+// forward protocol, so they join the existing log routing.
+type FluentConfig struct {
+	Addr string `json:"addr"`
+	// Tag is suffixed with the severity, e.g. lacia.critical, so routes
+	// can match on it
+	Tag         string `json:"tag,omitempty"`
+	MinSeverity string `json:"min_severity,omitempty"`
+	// RequireAck waits for the receiver to confirm each record
+	RequireAck bool `json:"require_ack,omitempty"`
+}
+
+func (c *FluentConfig) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("addr must be host:port: %w", err)
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// FluentSink sends each incident as a forward protocol message over one
+// connection, which is opened again after a failed send.
+type FluentSink struct {
+	addr       string
+	tag        string
+	requireAck bool
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewFluentSink(cfg *FluentConfig) *FluentSink {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultFluentTag
+	}
+	return &FluentSink{addr: cfg.Addr, tag: tag, requireAck: cfg.RequireAck}
+}
+
+func (s *FluentSink) Name() string {
+	return "fluent"
+}
+
+// Send is only called from the sink's own worker, so the connection is
+// not shared.
+func (s *FluentSink) Send(ctx context.Context, payload IncidentPayload) error {
+	// The record is the incident as it is posted to the server
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	var record map[string]any
+	json.Unmarshal(data, &record)
+
+	ts, err := time.Parse(time.RFC3339, payload.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	tag := s.tag
+	if payload.Severity != "" {
+		tag += "." + payload.Severity
+	}
+
+	// Message mode: [tag, time, record, option]
+	var msg msgpackWriter
+	var chunk string
+	if s.requireAck {
+		msg.arrayHeader(4)
+	} else {
+		msg.arrayHeader(3)
+	}
+	msg.value(tag)
+	msg.eventTime(ts)
+	msg.value(record)
+	if s.requireAck {
+		var id [16]byte
+		rand.Read(id[:])
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+		msg.value(map[string]any{"chunk": chunk})
+	}
+
+	if err := s.write(ctx, msg.buf, chunk); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *FluentSink) write(ctx context.Context, data []byte, chunk string) error {
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return fmt.Errorf("connect failed: %w", err)
+		}
+		s.conn = conn
+		s.reader = bufio.NewReader(conn)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		return fmt.Errorf("send failed: %w", err)
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	ack, err := readMsgpackStringMap(s.reader)
+	if err != nil {
+		return fmt.Errorf("read ack failed: %w", err)
+	}
+	if ack["ack"] != chunk {
+		return errors.New("receiver acknowledged a different chunk")
+	}
+	return nil
+}
+
+func (s *FluentSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// msgpackWriter encodes the values a JSON-decoded incident holds as
+// MessagePack.
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) value(v any) {
+	switch v := v.(type) {
+	case nil:
+		w.buf = append(w.buf, 0xc0)
+	case bool:
+		if v {
+			w.buf = append(w.buf, 0xc3)
+		} else {
+			w.buf = append(w.buf, 0xc2)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			w.int(int64(v))
+		} else {
+			w.buf = append(w.buf, 0xcb)
+			w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(v))
+		}
+	case string:
+		w.str(v)
+	case []any:
+		w.arrayHeader(len(v))
+		for _, e := range v {
+			w.value(e)
+		}
+	case map[string]any:
+		w.mapHeader(len(v))
+		for k, e := range v {
+			w.str(k)
+			w.value(e)
+		}
+	default:
+		panic(fmt.Sprintf("msgpack: unsupported type %T", v))
+	}
+}
+
+func (w *msgpackWriter) int(n int64) {
+	switch {
+	case n >= 0 && n < 128:
+		w.buf = append(w.buf, byte(n))
+	case n < 0 && n >= -32:
+		w.buf = append(w.buf, byte(n))
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(n))
+	}
+}
+
+func (w *msgpackWriter) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n < 1<<8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n < 1<<16:
+		w.buf = append(w.buf, 0xda)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdb)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) arrayHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n < 1<<16:
+		w.buf = append(w.buf, 0xdc)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdd)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) mapHeader(n int) {
+	switch {
+	case n < 16:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n < 1<<16:
+		w.buf = append(w.buf, 0xde)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdf)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+// eventTime writes the forward protocol's EventTime extension, which keeps
+// nanoseconds.
+func (w *msgpackWriter) eventTime(t time.Time) {
+	w.buf = append(w.buf, 0xd7, 0x00)
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(t.Unix()))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(t.Nanosecond()))
+}
+
+// readMsgpackStringMap reads a map of strings, the shape of an ack.
+func readMsgpackStringMap(r *bufio.Reader) (map[string]string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case b&0xf0 == 0x80:
+		n = int(b & 0x0f)
+	case b == 0xde:
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		n = int(size)
+	default:
+		return nil, fmt.Errorf("expected a map, got 0x%02x", b)
+	}
+	m := make(map[string]string, n)
+	for range n {
+		k, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case b&0xe0 == 0xa0:
+		n = int(b & 0x1f)
+	case b == 0xd9:
+		size, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(size)
+	case b == 0xda:
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return "", err
+		}
+		n = int(size)
+	default:
+		return "", fmt.Errorf("expected a string, got 0x%02x", b)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
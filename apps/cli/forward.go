@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultForwardListen = ":24224"
+	// maxMsgpackSize bounds a single string, binary or collection a sender
+	// may ask us to allocate
+	maxMsgpackSize = 16 << 20
+	// maxMsgpackDepth bounds how deeply values may nest
+	maxMsgpackDepth = 32
+)
+
+// ForwardConfig accepts logs over the Fluentd forward protocol, which is
+// what Docker's fluentd logging driver and Fluent Bit's forward output
+// speak, on a TCP port and/or a Unix socket.
+type ForwardConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"`
+	Socket  string `json:"socket,omitempty"`
+	RepoURL string `json:"repo_url,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+func (c *ForwardConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("invalid address %q: %w", c.Listen, err)
+		}
+	}
+	return nil
+}
+
+// ForwardServer receives forward protocol messages and, like SyslogServer,
+// feeds each host/container pair through its own stream watcher.
+type ForwardServer struct {
+	senderStreams
+	cfg       ForwardConfig
+	listeners []net.Listener
+}
+
+func NewForwardServer(cfg *ForwardConfig, defaults Target, detector *Detector) (*ForwardServer, error) {
+	s := &ForwardServer{cfg: *cfg}
+	if s.cfg.RepoURL == "" {
+		s.cfg.RepoURL = defaults.RepoURL
+	}
+	defaults.RepoURL = s.cfg.RepoURL
+	s.senderStreams = newSenderStreams("forward", s.cfg.Label, defaults, detector)
+	if s.cfg.Listen == "" && s.cfg.Socket == "" {
+		s.cfg.Listen = defaultForwardListen
+	}
+
+	if s.cfg.Listen != "" {
+		listener, err := net.Listen("tcp", s.cfg.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("listen tcp failed: %w", err)
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+	if s.cfg.Socket != "" {
+		listener, err := listenSocket(s.cfg.Socket)
+		if err != nil {
+			for _, l := range s.listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listen unix failed: %w", err)
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+	return s, nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (s *ForwardServer) Addrs() []string {
+	addrs := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr().Network()+"://"+l.Addr().String())
+	}
+	return addrs
+}
+
+func (s *ForwardServer) Run(ctx context.Context, events chan<- LogEvent) {
+	s.start(ctx, events)
+
+	var listeners sync.WaitGroup
+	for _, l := range s.listeners {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			s.serve(l)
+		}()
+	}
+
+	<-ctx.Done()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	listeners.Wait()
+	s.stop()
+}
+
+func (s *ForwardServer) serve(listener net.Listener) {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("forward accept failed", "err", err)
+			continue
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer conn.Close()
+			stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+			defer stop()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads messages in any of the forward protocol's modes until the
+// sender disconnects or sends something that is not one.
+func (s *ForwardServer) serveConn(conn net.Conn) {
+	// Senders on a Unix socket, such as the Docker daemon, are all local
+	host := "local"
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		host = addr.IP.String()
+	}
+	r := bufio.NewReader(conn)
+	for {
+		v, err := readMsgpack(r, 0)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("forward connection dropped", "host", host, "err", err)
+			}
+			return
+		}
+		msg, ok := v.([]any)
+		if !ok || len(msg) < 2 {
+			slog.Debug("forward connection dropped", "host", host, "err", "not a forward message")
+			return
+		}
+		tag, _ := msg[0].(string)
+
+		var option map[string]any
+		switch entries := msg[1].(type) {
+		case []any:
+			// Forward mode: [tag, [[time, record], ...], option]
+			for _, entry := range entries {
+				if pair, ok := entry.([]any); ok && len(pair) >= 2 {
+					s.record(host, tag, pair[1])
+				}
+			}
+			option = forwardOption(msg, 2)
+		case string:
+			// PackedForward mode: [tag, entries, option], with the entries
+			// concatenated and, in CompressedPackedForward, gzipped
+			option = forwardOption(msg, 2)
+			if err := s.packed(host, tag, entries, option["compressed"] == "gzip"); err != nil {
+				slog.Debug("forward entries dropped", "host", host, "err", err)
+			}
+		default:
+			// Message mode: [tag, time, record, option]
+			if len(msg) < 3 {
+				slog.Debug("forward connection dropped", "host", host, "err", "message without a record")
+				return
+			}
+			s.record(host, tag, msg[2])
+			option = forwardOption(msg, 3)
+		}
+
+		if chunk, ok := option["chunk"].(string); ok {
+			var ack msgpackWriter
+			ack.value(map[string]any{"ack": chunk})
+			if _, err := conn.Write(ack.buf); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func forwardOption(msg []any, i int) map[string]any {
+	if i < len(msg) {
+		option, _ := msg[i].(map[string]any)
+		return option
+	}
+	return nil
+}
+
+func (s *ForwardServer) packed(host, tag, entries string, compressed bool) error {
+	var r io.Reader = strings.NewReader(entries)
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	br := bufio.NewReader(r)
+	for {
+		v, err := readMsgpack(br, 0)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if pair, ok := v.([]any); ok && len(pair) >= 2 {
+			s.record(host, tag, pair[1])
+		}
+	}
+}
+
+// record writes the log line of a record: "log" from Docker and Fluent Bit's
+// tail input, "message" or "msg" from other sources. Docker's records are
+// grouped by container, anything else by tag.
+func (s *ForwardServer) record(host, tag string, v any) {
+	rec, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+	var line string
+	for _, key := range []string{"log", "message", "msg"} {
+		if line, ok = rec[key].(string); ok {
+			break
+		}
+	}
+	if !ok {
+		return
+	}
+	app := tag
+	if name, ok := rec["container_name"].(string); ok && name != "" {
+		app = strings.TrimPrefix(name, "/")
+	}
+	s.write(host, app, line)
+}
+
+// readMsgpack decodes one MessagePack value. Maps come back as
+// map[string]any, strings and binary as string, integers as int64, floats
+// as float64 and the forward protocol's EventTime as time.Time; other
+// extensions are read and returned as nil.
+func readMsgpack(r *bufio.Reader, depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nested too deeply")
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(r, int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(r, int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return readMsgpackBytes(r, int(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackUint(r, 1<<(b-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, int(n))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackUint(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, int(n))
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(b-0xcc))
+		return int64(n), err
+	case 0xd0:
+		n, err := readMsgpackUint(r, 1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := readMsgpackUint(r, 2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := readMsgpackUint(r, 4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := readMsgpackUint(r, 8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readMsgpackExt(r, 1<<(b-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readMsgpackUint(r, 1<<(b-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackExt(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unknown type 0x%02x", b)
+}
+
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range buf[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func readMsgpackBytes(r *bufio.Reader, n int) (string, error) {
+	if n < 0 || n > maxMsgpackSize {
+		return "", fmt.Errorf("msgpack: %d bytes is too large", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readMsgpackArray(r *bufio.Reader, n, depth int) ([]any, error) {
+	if n < 0 || n > maxMsgpackSize {
+		return nil, fmt.Errorf("msgpack: %d elements is too many", n)
+	}
+	// The size is the sender's claim, so memory is only taken as elements
+	// actually arrive
+	a := make([]any, 0, min(n, 1024))
+	for range n {
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n, depth int) (map[string]any, error) {
+	if n < 0 || n > maxMsgpackSize {
+		return nil, fmt.Errorf("msgpack: %d entries is too many", n)
+	}
+	m := make(map[string]any, min(n, 1024))
+	for range n {
+		k, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMsgpack(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// readMsgpackExt reads the type and n bytes of data of an extension.
+func readMsgpackExt(r *bufio.Reader, n int) (any, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readMsgpackBytes(r, n)
+	if err != nil {
+		return nil, err
+	}
+	if typ == 0 && n == 8 {
+		sec := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		nsec := uint32(data[4])<<24 | uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7])
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeMsgpackHex(t *testing.T, s string) (any, error) {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readMsgpack(bufio.NewReader(bytes.NewReader(data)), 0)
+}
+
+func TestReadMsgpack(t *testing.T) {
+	eventTime := time.Date(2024, 1, 17, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name string
+		data string
+		want any
+	}{
+		{"positive fixint", "05", int64(5)},
+		{"negative fixint", "ff", int64(-1)},
+		{"nil", "c0", nil},
+		{"false", "c2", false},
+		{"true", "c3", true},
+		{"fixstr", "a3 616263", "abc"},
+		{"str8", "d9 03 616263", "abc"},
+		{"str16", "da 0003 616263", "abc"},
+		{"str32", "db 00000003 616263", "abc"},
+		{"bin8", "c4 02 6869", "hi"},
+		{"float32", "ca 3fc00000", 1.5},
+		{"float64", "cb 3ff8000000000000", 1.5},
+		{"uint8", "cc ff", int64(255)},
+		{"uint16", "cd 0100", int64(256)},
+		{"uint32", "ce 00010000", int64(65536)},
+		{"uint64", "cf 0000000100000000", int64(1 << 32)},
+		{"int8", "d0 ff", int64(-1)},
+		{"int16", "d1 ff00", int64(-256)},
+		{"int32", "d2 ffffffff", int64(-1)},
+		{"int64", "d3 ffffffffffffffff", int64(-1)},
+		{"fixarray", "92 01 a178", []any{int64(1), "x"}},
+		{"array16", "dc 0002 01 02", []any{int64(1), int64(2)}},
+		{"fixmap", "81 a16b 01", map[string]any{"k": int64(1)}},
+		{"map16 with an integer key", "de 0001 07 a176", map[string]any{"7": "v"}},
+		{"event time", "d7 00 65a7c140 000001f4", eventTime},
+		{"event time ext8", "c7 08 00 65a7c140 000001f4", eventTime},
+		{"other fixext", "d4 05 00", nil},
+		{"other ext8", "c7 02 05 0000", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMsgpackHex(t, tt.data)
+			if err != nil {
+				t.Fatalf("readMsgpack() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("readMsgpack() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMsgpackErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"unknown type", "c1", "unknown type"},
+		{"oversized str32", "db 7fffffff", "too large"},
+		{"oversized bin32", "c6 ffffffff", "too large"},
+		{"oversized array32", "dd ffffffff", "too many"},
+		{"oversized map32", "df ffffffff", "too many"},
+		{"oversized ext32", "c9 ffffffff 00", "too large"},
+		{"claimed elements that never arrive", "dd 000f4240 01", "EOF"},
+		{"short string", "a5 61", "EOF"},
+		{"short integer", "cd 01", "EOF"},
+		{"short event time", "d7 00 0000", "EOF"},
+		{"nested too deeply", strings.Repeat("91", maxMsgpackDepth+2) + "01", "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeMsgpackHex(t, tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("readMsgpack() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := decodeMsgpackHex(t, ""); !errors.Is(err, io.EOF) {
+		t.Fatalf("readMsgpack() on no input = %v, want io.EOF", err)
+	}
+}
+
+// TestReadMsgpackRoundTrip reads back what the fluent sink writes.
+func TestReadMsgpackRoundTrip(t *testing.T) {
+	record := map[string]any{
+		"log":     strings.Repeat("x", 300),
+		"count":   float64(70000),
+		"ratio":   0.25,
+		"ok":      true,
+		"missing": nil,
+		"lines":   []any{"a", "b"},
+	}
+	ts := time.Date(2024, 1, 17, 12, 0, 0, 123, time.UTC)
+	var w msgpackWriter
+	w.arrayHeader(3)
+	w.str("app")
+	w.eventTime(ts)
+	w.value(record)
+
+	got, err := readMsgpack(bufio.NewReader(bytes.NewReader(w.buf)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{"app", ts, map[string]any{
+		"log":     record["log"],
+		"count":   int64(70000),
+		"ratio":   0.25,
+		"ok":      true,
+		"missing": nil,
+		"lines":   []any{"a", "b"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readMsgpack() = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGELFAddr = ":12201"
+	// maxGELFMessage bounds a message once reassembled and decompressed
+	maxGELFMessage = 1 << 20
+	maxGELFChunks  = 128
+	// Chunks of a message that has not arrived whole by then are dropped,
+	// as the GELF spec asks
+	gelfChunkTimeout = 5 * time.Second
+	// maxGELFPending bounds the messages being reassembled at once
+	maxGELFPending = 1024
+)
+
+// GELFConfig accepts GELF, as sent by Docker's gelf logging driver and GELF
+// logging libraries, over UDP and/or TCP.
+type GELFConfig struct {
+	Enabled bool   `json:"enabled"`
+	UDP     string `json:"udp,omitempty"`
+	TCP     string `json:"tcp,omitempty"`
+	RepoURL string `json:"repo_url,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+func (c *GELFConfig) Validate() error {
+	for _, addr := range []string{c.UDP, c.TCP} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+type gelfMessage struct {
+	Host         string `json:"host"`
+	ShortMessage string `json:"short_message"`
+	FullMessage  string `json:"full_message"`
+	// Set by Docker's logging driver
+	ContainerName string `json:"_container_name"`
+	Tag           string `json:"_tag"`
+	// Set by older libraries
+	Facility string `json:"facility"`
+}
+
+// App names the sender: the container, or failing that what the sender
+// tagged its messages with.
+func (m gelfMessage) App() string {
+	if m.ContainerName != "" {
+		return strings.TrimPrefix(m.ContainerName, "/")
+	}
+	if m.Tag != "" {
+		return m.Tag
+	}
+	return m.Facility
+}
+
+// Text is the message with its trace, which libraries send as the full
+// message.
+func (m gelfMessage) Text() string {
+	if m.FullMessage != "" {
+		return m.FullMessage
+	}
+	return m.ShortMessage
+}
+
+var errNotGELF = errors.New("not a GELF message")
+
+// parseGELF decodes a whole message, uncompressed or compressed with gzip
+// or zlib.
+func parseGELF(data []byte) (gelfMessage, error) {
+	var r io.Reader
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) > 0 && data[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return gelfMessage{}, err
+	}
+	if r != nil {
+		if data, err = io.ReadAll(io.LimitReader(r, maxGELFMessage)); err != nil {
+			return gelfMessage{}, err
+		}
+	}
+
+	var msg gelfMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return gelfMessage{}, err
+	}
+	if msg.ShortMessage == "" && msg.FullMessage == "" {
+		return gelfMessage{}, errNotGELF
+	}
+	return msg, nil
+}
+
+type gelfChunks struct {
+	parts    [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// gelfAssembler puts chunked UDP messages back together.
+type gelfAssembler struct {
+	pending map[[8]byte]*gelfChunks
+}
+
+// add takes a chunk and returns the whole message once its last chunk has
+// arrived.
+func (a *gelfAssembler) add(chunk []byte, now time.Time) ([]byte, error) {
+	// magic, message ID, sequence number, sequence count
+	if len(chunk) < 12 {
+		return nil, errNotGELF
+	}
+	var id [8]byte
+	copy(id[:], chunk[2:10])
+	seq, count := int(chunk[10]), int(chunk[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		return nil, fmt.Errorf("invalid chunk %d of %d", seq, count)
+	}
+
+	for key, c := range a.pending {
+		if now.Sub(c.started) > gelfChunkTimeout {
+			delete(a.pending, key)
+		}
+	}
+	c, ok := a.pending[id]
+	if !ok {
+		if len(a.pending) >= maxGELFPending {
+			return nil, errors.New("too many chunked messages in flight")
+		}
+		c = &gelfChunks{parts: make([][]byte, count), started: now}
+		a.pending[id] = c
+	}
+	if len(c.parts) != count {
+		delete(a.pending, id)
+		return nil, errors.New("chunk count changed within a message")
+	}
+	if c.parts[seq] != nil {
+		return nil, nil
+	}
+	c.parts[seq] = bytes.Clone(chunk[12:])
+	c.received++
+	c.size += len(chunk) - 12
+	if c.size > maxGELFMessage {
+		delete(a.pending, id)
+		return nil, errors.New("chunked message too large")
+	}
+	if c.received < count {
+		return nil, nil
+	}
+	delete(a.pending, id)
+	return bytes.Join(c.parts, nil), nil
+}
+
+// GELFServer receives GELF over UDP and/or TCP and, like SyslogServer,
+// feeds each host/container pair through its own stream watcher.
+type GELFServer struct {
+	senderStreams
+	cfg GELFConfig
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+func NewGELFServer(cfg *GELFConfig, defaults Target, detector *Detector) (*GELFServer, error) {
+	s := &GELFServer{cfg: *cfg}
+	if s.cfg.RepoURL == "" {
+		s.cfg.RepoURL = defaults.RepoURL
+	}
+	defaults.RepoURL = s.cfg.RepoURL
+	s.senderStreams = newSenderStreams("gelf", s.cfg.Label, defaults, detector)
+	if s.cfg.UDP == "" && s.cfg.TCP == "" {
+		s.cfg.UDP = defaultGELFAddr
+	}
+
+	var err error
+	if s.cfg.UDP != "" {
+		if s.udp, err = net.ListenPacket("udp", s.cfg.UDP); err != nil {
+			return nil, fmt.Errorf("listen udp failed: %w", err)
+		}
+	}
+	if s.cfg.TCP != "" {
+		if s.tcp, err = net.Listen("tcp", s.cfg.TCP); err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return nil, fmt.Errorf("listen tcp failed: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (s *GELFServer) Addrs() []string {
+	var addrs []string
+	if s.udp != nil {
+		addrs = append(addrs, "udp://"+s.udp.LocalAddr().String())
+	}
+	if s.tcp != nil {
+		addrs = append(addrs, "tcp://"+s.tcp.Addr().String())
+	}
+	return addrs
+}
+
+func (s *GELFServer) Run(ctx context.Context, events chan<- LogEvent) {
+	s.start(ctx, events)
+
+	var listeners sync.WaitGroup
+	if s.udp != nil {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			s.serveUDP()
+		}()
+	}
+	if s.tcp != nil {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			s.serveTCP()
+		}()
+	}
+
+	<-ctx.Done()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	listeners.Wait()
+	s.stop()
+}
+
+func (s *GELFServer) serveUDP() {
+	assembler := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("gelf udp read failed", "err", err)
+			continue
+		}
+		data := buf[:n]
+		if bytes.HasPrefix(data, []byte{0x1e, 0x0f}) {
+			if data, err = assembler.add(data, time.Now()); err != nil {
+				slog.Debug("gelf chunk dropped", "err", err)
+				continue
+			}
+			if data == nil {
+				continue
+			}
+		}
+		s.handle(data)
+	}
+}
+
+func (s *GELFServer) serveTCP() {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("gelf tcp accept failed", "err", err)
+			continue
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer conn.Close()
+			stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+			defer stop()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads messages terminated by a null byte, as GELF over TCP
+// frames them.
+func (s *GELFServer) serveConn(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxGELFMessage)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		if frame := bytes.TrimSpace(scanner.Bytes()); len(frame) > 0 {
+			s.handle(frame)
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("gelf tcp read failed", "err", err)
+	}
+}
+
+func (s *GELFServer) handle(data []byte) {
+	msg, err := parseGELF(data)
+	if err != nil {
+		slog.Debug("ignoring non-GELF input", "bytes", len(data), "err", err)
+		return
+	}
+	s.write(msg.Host, msg.App(), msg.Text())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGELF(t *testing.T) {
+	plain := []byte(`{"version":"1.1","host":"web01","short_message":"boom","full_message":"panic: boom\n\tmain.go:1","_container_name":"/api","_tag":"tag"}`)
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(plain)
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(plain)
+	zw.Close()
+
+	for name, data := range map[string][]byte{"plain": plain, "gzip": gz.Bytes(), "zlib": zl.Bytes()} {
+		msg, err := parseGELF(data)
+		if err != nil {
+			t.Fatalf("%s: parseGELF() error = %v", name, err)
+		}
+		if msg.Host != "web01" || msg.App() != "api" || msg.Text() != "panic: boom\n\tmain.go:1" {
+			t.Fatalf("%s: parseGELF() = %+v", name, msg)
+		}
+	}
+
+	tests := []struct {
+		data string
+		app  string
+		text string
+	}{
+		{`{"short_message":"boom","_tag":"worker","facility":"old"}`, "worker", "boom"},
+		{`{"short_message":"boom","facility":"old"}`, "old", "boom"},
+		{`{"short_message":"boom"}`, "", "boom"},
+	}
+	for _, tt := range tests {
+		msg, err := parseGELF([]byte(tt.data))
+		if err != nil || msg.App() != tt.app || msg.Text() != tt.text {
+			t.Errorf("parseGELF(%s) = %+v, %v; want app %q, text %q", tt.data, msg, err, tt.app, tt.text)
+		}
+	}
+
+	for _, data := range []string{"", "not json", `{"host":"web01"}`, "\x1f\x8bnot gzip", "\x78not zlib"} {
+		if _, err := parseGELF([]byte(data)); err == nil {
+			t.Errorf("parseGELF(%q) accepted", data)
+		}
+	}
+	if _, err := parseGELF([]byte(`{"host":"web01"}`)); !errors.Is(err, errNotGELF) {
+		t.Errorf("parseGELF() without a message = %v, want errNotGELF", err)
+	}
+}
+
+func TestParseGELFDecompressionLimit(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(`{"short_message":"` + strings.Repeat("x", maxGELFMessage) + `"}`))
+	gw.Close()
+	if _, err := parseGELF(gz.Bytes()); err == nil {
+		t.Fatal("parseGELF() accepted a message over maxGELFMessage once decompressed")
+	}
+}
+
+func gelfChunk(id byte, seq, count int, data string) []byte {
+	return append([]byte{0x1e, 0x0f, id, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(count)}, data...)
+}
+
+func TestGELFAssembler(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   string
+	}{
+		{"single chunk", [][]byte{gelfChunk(1, 0, 1, "whole")}, "whole"},
+		{"in order", [][]byte{gelfChunk(1, 0, 3, "a"), gelfChunk(1, 1, 3, "b"), gelfChunk(1, 2, 3, "c")}, "abc"},
+		{"out of order", [][]byte{gelfChunk(1, 2, 3, "c"), gelfChunk(1, 0, 3, "a"), gelfChunk(1, 1, 3, "b")}, "abc"},
+		{"duplicate chunk", [][]byte{gelfChunk(1, 0, 2, "a"), gelfChunk(1, 0, 2, "x"), gelfChunk(1, 1, 2, "b")}, "ab"},
+		{"interleaved messages", [][]byte{gelfChunk(1, 0, 2, "a"), gelfChunk(2, 0, 2, "x"), gelfChunk(1, 1, 2, "b")}, "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+			var got []byte
+			for i, chunk := range tt.chunks {
+				data, err := a.add(chunk, now)
+				if err != nil {
+					t.Fatalf("chunk %d: %v", i, err)
+				}
+				if data != nil && i != len(tt.chunks)-1 {
+					t.Fatalf("chunk %d completed the message early: %q", i, data)
+				}
+				got = data
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGELFAssemblerRejects(t *testing.T) {
+	now := time.Now()
+	a := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+	for name, chunk := range map[string][]byte{
+		"short header":      {0x1e, 0x0f, 1, 2, 3},
+		"no chunks":         gelfChunk(1, 0, 0, "a"),
+		"too many chunks":   gelfChunk(1, 0, maxGELFChunks+1, "a"),
+		"sequence past end": gelfChunk(1, 3, 3, "a"),
+	} {
+		if _, err := a.add(chunk, now); err == nil {
+			t.Errorf("%s: add() accepted", name)
+		}
+	}
+	if len(a.pending) != 0 {
+		t.Fatalf("rejected chunks left %d messages pending", len(a.pending))
+	}
+
+	a.add(gelfChunk(1, 0, 3, "a"), now)
+	if _, err := a.add(gelfChunk(1, 1, 2, "b"), now); err == nil {
+		t.Error("add() accepted a chunk count that changed within a message")
+	}
+	if len(a.pending) != 0 {
+		t.Error("a message whose chunk count changed was kept")
+	}
+}
+
+func TestGELFAssemblerLimits(t *testing.T) {
+	now := time.Now()
+
+	t.Run("size", func(t *testing.T) {
+		a := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+		part := strings.Repeat("x", 60000)
+		var err error
+		for seq := 0; seq < maxGELFChunks && err == nil; seq++ {
+			_, err = a.add(gelfChunk(1, seq, maxGELFChunks, part), now)
+		}
+		if err == nil || len(a.pending) != 0 {
+			t.Fatalf("a message over maxGELFMessage was kept: %v", err)
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		a := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+		for i := range maxGELFPending {
+			chunk := gelfChunk(0, 0, 2, "a")
+			chunk[2], chunk[3] = byte(i), byte(i>>8)
+			if _, err := a.add(chunk, now); err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+		}
+		extra := gelfChunk(0, 0, 2, "a")
+		extra[9] = 1
+		if _, err := a.add(extra, now); err == nil {
+			t.Fatal("add() accepted a message past maxGELFPending")
+		}
+		// Once they time out there is room again
+		if _, err := a.add(extra, now.Add(gelfChunkTimeout+time.Second)); err != nil {
+			t.Fatalf("add() after the timeout: %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		a := &gelfAssembler{pending: make(map[[8]byte]*gelfChunks)}
+		a.add(gelfChunk(1, 0, 2, "a"), now)
+		data, err := a.add(gelfChunk(1, 1, 2, "b"), now.Add(gelfChunkTimeout+time.Second))
+		if err != nil || data != nil {
+			t.Fatalf("a chunk completed a timed out message: %q, %v", data, err)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gitCommandTimeout = 5 * time.Second
+	// HEAD changes on deploy, so it is re-read at most this often
+	gitHeadCacheTTL = 30 * time.Second
+)
+
+type GitConfig struct {
+	RepoPath string `json:"repo_path,omitempty"`
+	Blame    bool   `json:"blame,omitempty"`
+}
+
+type gitHead struct {
+	commit  string
+	branch  string
+	readAt  time.Time
+	warned  bool
+	lastErr error
+}
+
+// GitEnricher adds the deployed commit, branch and optionally blame for the
+// failing line to payloads from targets with a local repo_path.
+type GitEnricher struct {
+	blame bool
+
+	mu    sync.Mutex
+	heads map[string]*gitHead
+}
+
+func NewGitEnricher(cfg *GitConfig) *GitEnricher {
+	e := &GitEnricher{heads: make(map[string]*gitHead)}
+	if cfg != nil {
+		e.blame = cfg.Blame
+	}
+	return e
+}
+
+func (e *GitEnricher) Enrich(payload *IncidentPayload, repoPath string) {
+	if repoPath == "" {
+		return
+	}
+	commit, branch, err := e.head(repoPath)
+	if err != nil {
+		return
+	}
+
+	info := &GitInfo{Commit: commit, Branch: branch}
+	if e.blame {
+		info.Blame = blameFrames(repoPath, payload.Frames)
+	}
+	payload.Git = info
+}
+
+func (e *GitEnricher) head(repoPath string) (string, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	h := e.heads[repoPath]
+	if h != nil && time.Since(h.readAt) < gitHeadCacheTTL {
+		return h.commit, h.branch, h.lastErr
+	}
+	if h == nil {
+		h = &gitHead{}
+		e.heads[repoPath] = h
+	}
+
+	h.readAt = time.Now()
+	h.commit, h.lastErr = runGit(repoPath, "rev-parse", "HEAD")
+	if h.lastErr != nil {
+		// Only complain once per repo; a missing repo stays missing
+		if !h.warned {
+			slog.Warn("git enrichment unavailable", "repo_path", repoPath, "err", h.lastErr)
+			h.warned = true
+		}
+		return "", "", h.lastErr
+	}
+	h.branch, _ = runGit(repoPath, "rev-parse", "--abbrev-ref", "HEAD")
+	if h.branch == "HEAD" {
+		// Detached, as is common for deploys of a tag or SHA
+		h.branch = ""
+	}
+	return h.commit, h.branch, nil
+}
+
+// blameFrames blames the innermost frame whose file exists in the repo,
+// skipping frames in the standard library and dependencies.
+func blameFrames(repoPath string, frames []StackFrame) *BlameInfo {
+	for _, frame := range frames {
+		if frame.Line <= 0 {
+			continue
+		}
+		rel, ok := resolveRepoFile(repoPath, frame.File)
+		if !ok {
+			continue
+		}
+		blame, err := gitBlame(repoPath, rel, frame.Line)
+		if err != nil {
+			slog.Debug("git blame failed", "file", rel, "line", frame.Line, "err", err)
+			return nil
+		}
+		return blame
+	}
+	return nil
+}
+
+// resolveRepoFile maps a path from a stack trace to a file in the repo. Paths
+// are often from another machine or container (/app/src/x.py for a repo
+// checked out elsewhere), so leading components are dropped until one
+// matches.
+func resolveRepoFile(repoPath, file string) (string, bool) {
+	file = filepath.ToSlash(strings.TrimPrefix(file, "file://"))
+	if abs, err := filepath.Abs(repoPath); err == nil {
+		if rel, err := filepath.Rel(abs, filepath.FromSlash(file)); err == nil && !strings.HasPrefix(rel, "..") {
+			file = filepath.ToSlash(rel)
+		}
+	}
+
+	parts := strings.Split(strings.TrimLeft(file, "/"), "/")
+	for i := range parts {
+		candidate := strings.Join(parts[i:], "/")
+		if candidate == "" {
+			break
+		}
+		if info, err := os.Stat(filepath.Join(repoPath, filepath.FromSlash(candidate))); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func gitBlame(repoPath, file string, line int) (*BlameInfo, error) {
+	out, err := runGit(repoPath, "blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", line, line), "--", file)
+	if err != nil {
+		return nil, err
+	}
+
+	blame := &BlameInfo{File: file, Line: line}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for first := true; scanner.Scan(); first = false {
+		text := scanner.Text()
+		if first {
+			blame.Commit, _, _ = strings.Cut(text, " ")
+			continue
+		}
+		key, value, _ := strings.Cut(text, " ")
+		switch key {
+		case "author":
+			blame.Author = value
+		case "author-mail":
+			blame.AuthorEmail = strings.Trim(value, "<>")
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				blame.AuthoredAt = time.Unix(sec, 0).UTC()
+			}
+		case "summary":
+			blame.Summary = value
+		}
+	}
+	if blame.Commit == "" {
+		return nil, errors.New("empty blame output")
+	}
+	return blame, nil
+}
+
+func runGit(repoPath string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoPath}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -1,3 +1,21 @@
 module github.com/noobiethe13/lacia/apps/cli
 
-go 1.23
+go 1.23.0
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/expr-lang/expr v1.17.8
+	github.com/tetratelabs/wazero v1.10.1
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/net v0.40.0
+	golang.org/x/sys v0.35.0
+	google.golang.org/grpc v1.71.0
+	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/stretchr/testify v1.10.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const defaultHealthAddr = "127.0.0.1:8686"
+
+type HealthConfig struct {
+	Enabled bool   `json:"enabled"`
+	Addr    string `json:"addr,omitempty"`
+}
+
+func (c *HealthConfig) Validate() error {
+	if c.Addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid addr: %w", err)
+	}
+	return nil
+}
+
+type HealthReport struct {
+	Healthy    bool           `json:"healthy"`
+	Targets    []TargetHealth `json:"targets"`
+	LastSendAt *time.Time     `json:"last_send_at,omitempty"`
+	LastSendOK *bool          `json:"last_send_ok,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+}
+
+type TargetHealth struct {
+	LogPath    string     `json:"log_path"`
+	Label      string     `json:"label,omitempty"`
+	Open       bool       `json:"open"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+	State      string     `json:"state,omitempty"`
+	Restarts   int        `json:"restarts,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// HealthServer exposes /healthz over TCP for orchestrator liveness probes.
+// The watcher is unhealthy when a log file is no longer being read; a failing
+// server is reported but does not fail the probe, since restarting the agent
+// would not fix it.
+type HealthServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+func NewHealthServer(cfg *HealthConfig, watchers func() []*Watcher, sender *Sender) (*HealthServer, error) {
+	addr := cfg.Addr
+	if addr == "" {
+		addr = defaultHealthAddr
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := buildHealthReport(watchers(), sender)
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+
+	return &HealthServer{
+		listener: listener,
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+	}, nil
+}
+
+func (h *HealthServer) Addr() string {
+	return h.listener.Addr().String()
+}
+
+func (h *HealthServer) Serve() {
+	if err := h.server.Serve(h.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("health server failed", "err", err)
+	}
+}
+
+func (h *HealthServer) Close() {
+	h.server.Close()
+}
+
+func buildHealthReport(watchers []*Watcher, sender *Sender) HealthReport {
+	report := HealthReport{Healthy: true}
+	for _, w := range watchers {
+		t := TargetHealth{
+			LogPath: w.Path(),
+			Label:   w.Target().Label,
+			Open:    w.Watching(),
+		}
+		t.State, t.Restarts, t.LastError = w.Health()
+		if last := w.LastRead(); !last.IsZero() {
+			t.LastReadAt = &last
+		}
+		if !t.Open {
+			report.Healthy = false
+		}
+		report.Targets = append(report.Targets, t)
+	}
+
+	stats := sender.Stats()
+	if !stats.LastSend.IsZero() {
+		ok := stats.LastError == nil
+		report.LastSendAt = &stats.LastSend
+		report.LastSendOK = &ok
+	}
+	if stats.LastError != nil {
+		report.LastError = stats.LastError.Error()
+	}
+	return report
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const defaultHeartbeatInterval = time.Minute
+
+// HeartbeatConfig makes the agent check in with the server periodically, so
+// the dashboard can tell running watchers from ones that died silently.
+type HeartbeatConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval,omitempty"`
+	// Name identifies the agent on the dashboard, the hostname by default.
+	// Agents sharing a host need one each.
+	Name string `json:"name,omitempty"`
+}
+
+func (c *HeartbeatConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("interval must be positive")
+	}
+	if c.Interval > 0 && time.Duration(c.Interval) < time.Second {
+		return errors.New("interval must be at least 1s")
+	}
+	return nil
+}
+
+func (c *HeartbeatConfig) interval() time.Duration {
+	if c.Interval == 0 {
+		return defaultHeartbeatInterval
+	}
+	return time.Duration(c.Interval)
+}
+
+// AgentHeartbeat is what the agent posts to /api/agents.
+type AgentHeartbeat struct {
+	Name           string         `json:"name"`
+	Hostname       string         `json:"hostname"`
+	AgentVersion   string         `json:"agent_version"`
+	PID            int            `json:"pid"`
+	StartedAt      time.Time      `json:"started_at"`
+	SentAt         time.Time      `json:"sent_at"`
+	IntervalSecs   int            `json:"interval_seconds"`
+	Targets        []TargetStatus `json:"targets"`
+	Sent           int64          `json:"sent"`
+	Failed         int64          `json:"failed"`
+	LastIncidentAt *time.Time     `json:"last_incident_at,omitempty"`
+	// Stopping is set on the last heartbeat of a clean shutdown
+	Stopping bool `json:"stopping,omitempty"`
+}
+
+// agentsURL derives the server's agents endpoint from the webhook URL.
+func agentsURL(serverURL string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/api/webhook")
+	return base + "/api/agents"
+}
+
+// sendHeartbeat posts hb once. A missed heartbeat is not retried, the next
+// one replaces it.
+func sendHeartbeat(ctx context.Context, client *Client, hb AgentHeartbeat) error {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	_, err = client.Post(ctx, agentsURL(client.ServerURL()), body)
+	return err
+}
+
+// runHeartbeats checks in every interval until ctx is done, then sends a
+// final heartbeat marking the agent as stopped.
+func runHeartbeats(ctx context.Context, cfg *HeartbeatConfig, client *Client, status func() StatusReport, lastIncident func() time.Time) {
+	name := cfg.Name
+	if name == "" {
+		name = client.Hostname()
+	}
+	interval := cfg.interval()
+	beat := func(ctx context.Context, stopping bool) {
+		report := status()
+		hb := AgentHeartbeat{
+			Name:         name,
+			Hostname:     client.Hostname(),
+			AgentVersion: agentVersion(),
+			PID:          report.PID,
+			StartedAt:    report.StartedAt,
+			SentAt:       time.Now().UTC(),
+			IntervalSecs: int(interval / time.Second),
+			Targets:      report.Targets,
+			Sent:         report.Sent,
+			Failed:       report.Failed,
+			Stopping:     stopping,
+		}
+		if t := lastIncident(); !t.IsZero() {
+			hb.LastIncidentAt = &t
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := sendHeartbeat(ctx, client, hb); err != nil {
+			slog.Warn("heartbeat failed", "err", err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	beat(ctx, false)
+	for {
+		select {
+		case <-ctx.Done():
+			beat(context.WithoutCancel(ctx), true)
+			return
+		case <-ticker.C:
+			beat(ctx, false)
+		}
+	}
+}
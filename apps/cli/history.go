@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	historyFileName   = "lacia-history.db"
+	historyMaxAge     = 30 * 24 * time.Hour
+	historyMaxEntries = 100000
+	// historyBuffer is how many entries may wait to be written before new
+	// ones are dropped, so a slow disk cannot hold up delivery
+	historyBuffer     = 1024
+	historyPruneEvery = time.Hour
+	historyLimit      = 50
+)
+
+var historyBucket = []byte("incidents")
+
+// runHistoryCommand lists the incidents recorded on this host, newest first.
+func runHistoryCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := fs.String("since", "", "only incidents detected from this time on, as RFC 3339 or a duration such as 24h")
+	var filter HistoryFilter
+	fs.StringVar(&filter.Outcome, "outcome", "", "only incidents whose outcome starts with this, e.g. sent, failed or duplicate")
+	fs.StringVar(&filter.Target, "target", "", "only incidents from the target with this label")
+	fs.StringVar(&filter.Severity, "severity", "", "only incidents of this severity")
+	fs.StringVar(&filter.Fingerprint, "fingerprint", "", "only incidents whose fingerprint starts with this")
+	fs.StringVar(&filter.Text, "grep", "", "only incidents whose error line contains this")
+	fs.IntVar(&filter.Limit, "limit", historyLimit, "show at most this many")
+	asJSON := fs.Bool("json", false, "print the incidents as NDJSON")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli history [--since TIME] [--outcome OUTCOME] [--target LABEL] [--severity SEVERITY] [--fingerprint HASH] [--grep TEXT] [--limit N] [--json]")
+		os.Exit(2)
+	}
+	if *since != "" {
+		var err error
+		if filter.Since, err = parseSince(*since); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ --since: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	cfg := loadConfigOrExit()
+	entries, err := queryHistory(cfg, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, e := range entries {
+		if *asJSON {
+			encoder.Encode(e)
+			continue
+		}
+		outcome := e.Outcome
+		if e.Error != "" {
+			outcome += ": " + e.Error
+		}
+		fmt.Printf("%s  %-8s  %s  %s  [%s]\n", e.DetectedAt.Local().Format(time.RFC3339), e.Severity, e.Fingerprint, e.ErrorLine, outcome)
+	}
+}
+
+// queryHistory reads the history file, or asks the running agent, which
+// holds it open, to.
+func queryHistory(cfg *Config, filter HistoryFilter) ([]HistoryEntry, error) {
+	h, err := openHistoryReadOnly(cfg.History)
+	if errors.Is(err, bolt.ErrTimeout) {
+		var entries []HistoryEntry
+		err := queryControl(ControlSocketPath(cfg), "/history?"+filter.query().Encode(), &entries)
+		return entries, err
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no history at %s, is history enabled?", cfg.History.path())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+	return h.Query(filter)
+}
+
+// HistoryConfig keeps a record of every incident detected on this host,
+// whether or not it was sent, in a local database that `lacia-cli history`
+// reads even while no agent is running.
+type HistoryConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path,omitempty"`
+	// MaxAge and MaxEntries bound the history, the oldest entries go first
+	MaxAge     Duration `json:"max_age,omitempty"`
+	MaxEntries int      `json:"max_entries,omitempty"`
+}
+
+func (c *HistoryConfig) Validate() error {
+	if c.MaxAge < 0 {
+		return errors.New("max_age must be positive")
+	}
+	if c.MaxEntries < 0 {
+		return errors.New("max_entries must be positive")
+	}
+	return nil
+}
+
+func (c *HistoryConfig) path() string {
+	if c != nil && c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(filepath.Dir(ConfigPath()), historyFileName)
+}
+
+// HistoryEntry is one detected incident and what became of it.
+type HistoryEntry struct {
+	ID          uint64    `json:"id"`
+	DetectedAt  time.Time `json:"detected_at"`
+	HandledAt   time.Time `json:"handled_at"`
+	Fingerprint string    `json:"fingerprint"`
+	Target      string    `json:"target,omitempty"`
+	Severity    string    `json:"severity,omitempty"`
+	ErrorLine   string    `json:"error_line"`
+	// Outcome is what the pipeline or sender did with it, e.g. sent,
+	// duplicate or failed
+	Outcome string `json:"outcome"`
+	// IncidentID and Error are the server's reply to a send
+	IncidentID int    `json:"incident_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HistoryFilter selects entries; zero fields match everything.
+type HistoryFilter struct {
+	Since       time.Time
+	Outcome     string
+	Target      string
+	Severity    string
+	Fingerprint string
+	// Text is looked for in the error line
+	Text  string
+	Limit int
+}
+
+func (f HistoryFilter) match(e *HistoryEntry) bool {
+	return !e.DetectedAt.Before(f.Since) &&
+		(f.Outcome == "" || strings.HasPrefix(e.Outcome, f.Outcome)) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Severity == "" || e.Severity == f.Severity) &&
+		strings.HasPrefix(e.Fingerprint, f.Fingerprint) &&
+		strings.Contains(e.ErrorLine, f.Text)
+}
+
+// query encodes f for the control socket.
+func (f HistoryFilter) query() url.Values {
+	q := url.Values{}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.Format(time.RFC3339Nano))
+	}
+	for key, value := range map[string]string{
+		"outcome":     f.Outcome,
+		"target":      f.Target,
+		"severity":    f.Severity,
+		"fingerprint": f.Fingerprint,
+		"text":        f.Text,
+	} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return q
+}
+
+func parseHistoryFilter(q url.Values) (HistoryFilter, error) {
+	f := HistoryFilter{
+		Outcome:     q.Get("outcome"),
+		Target:      q.Get("target"),
+		Severity:    q.Get("severity"),
+		Fingerprint: q.Get("fingerprint"),
+		Text:        q.Get("text"),
+	}
+	if s := q.Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return f, fmt.Errorf("invalid since: %w", err)
+		}
+		f.Since = since
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return f, fmt.Errorf("invalid limit: %w", err)
+		}
+		f.Limit = limit
+	}
+	return f, nil
+}
+
+// History records incidents in a bbolt database. Entries are written in
+// the background, so recording one never waits for the disk.
+type History struct {
+	db         *bolt.DB
+	maxAge     time.Duration
+	maxEntries int
+	entries    chan HistoryEntry
+	done       chan struct{}
+	closeOnce  sync.Once
+	dropped    atomic.Int64
+}
+
+// OpenHistory opens the database for writing. Only one process can have it
+// open this way; `lacia-cli history` asks the running agent instead.
+func OpenHistory(cfg *HistoryConfig) (*History, error) {
+	db, err := bolt.Open(cfg.path(), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", cfg.path(), err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	h := &History{
+		db:         db,
+		maxAge:     time.Duration(cfg.MaxAge),
+		maxEntries: cfg.MaxEntries,
+		entries:    make(chan HistoryEntry, historyBuffer),
+		done:       make(chan struct{}),
+	}
+	if h.maxAge == 0 {
+		h.maxAge = historyMaxAge
+	}
+	if h.maxEntries == 0 {
+		h.maxEntries = historyMaxEntries
+	}
+	go h.run()
+	return h, nil
+}
+
+// openHistoryReadOnly opens the database of an agent that is not running.
+// It fails with bolt.ErrTimeout while an agent has it open.
+func openHistoryReadOnly(cfg *HistoryConfig) (*History, error) {
+	db, err := bolt.Open(cfg.path(), 0600, &bolt.Options{ReadOnly: true, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		return nil, err
+	}
+	return &History{db: db}, nil
+}
+
+// Add records entry, dropping it if too many are waiting to be written.
+func (h *History) Add(entry HistoryEntry) {
+	select {
+	case h.entries <- entry:
+	default:
+		if h.dropped.Add(1) == 1 {
+			slog.Warn("history cannot keep up, dropping entries")
+		}
+	}
+}
+
+func (h *History) run() {
+	defer close(h.done)
+	h.prune()
+	ticker := time.NewTicker(historyPruneEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-h.entries:
+			if !ok {
+				return
+			}
+			// Write whatever else is waiting in the same transaction
+			batch := []HistoryEntry{entry}
+		drain:
+			for len(batch) < historyBuffer {
+				select {
+				case entry, ok := <-h.entries:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, entry)
+				default:
+					break drain
+				}
+			}
+			if err := h.write(batch); err != nil {
+				slog.Error("write history failed", "err", err)
+			}
+		case <-ticker.C:
+			h.prune()
+		}
+	}
+}
+
+func (h *History) write(batch []HistoryEntry) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		for _, entry := range batch {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			entry.ID = id
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(historyKey(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// prune deletes the entries beyond max_age and max_entries. Keys grow with
+// time, so these are the first ones.
+func (h *History) prune() {
+	cutoff := time.Now().Add(-h.maxAge)
+	deleted := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		excess := b.Stats().KeyN - h.maxEntries
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			if deleted >= excess {
+				var entry HistoryEntry
+				if err := json.Unmarshal(v, &entry); err == nil && !entry.DetectedAt.Before(cutoff) {
+					return nil
+				}
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("prune history failed", "err", err)
+	} else if deleted > 0 {
+		slog.Debug("pruned history", "entries", deleted)
+	}
+}
+
+// Query returns the entries matching f, newest first.
+func (h *History) Query(f HistoryFilter) ([]HistoryEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = historyLimit
+	}
+	entries := []HistoryEntry{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < f.Limit; k, v = c.Prev() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if f.match(&entry) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Close writes the entries still waiting and closes the database.
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.closeOnce.Do(func() {
+		if h.entries != nil {
+			close(h.entries)
+			<-h.done
+		}
+	})
+	return h.db.Close()
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// IgnoreConfig lists errors that are expected, such as failed health-check
+// probes or known flaky warnings, and should never become incidents.
+type IgnoreConfig struct {
+	// Patterns are matched against the error line and each context line.
+	// The context includes the lines logged just before the error, so
+	// ErrorLineOnly keeps a noisy line from hiding an error that follows it.
+	Patterns      []string `json:"patterns"`
+	ErrorLineOnly bool     `json:"error_line_only,omitempty"`
+}
+
+func (c *IgnoreConfig) Validate() error {
+	for i, p := range c.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("patterns[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+type IgnoreRules struct {
+	patterns      []*regexp.Regexp
+	errorLineOnly bool
+}
+
+func NewIgnoreRules(cfg *IgnoreConfig) (*IgnoreRules, error) {
+	r := &IgnoreRules{}
+	if cfg == nil {
+		return r, nil
+	}
+	r.errorLineOnly = cfg.ErrorLineOnly
+	for i, p := range cfg.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("ignore: patterns[%d]: %w", i, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Match returns the first pattern that matches the event, if any.
+func (r *IgnoreRules) Match(event LogEvent) (string, bool) {
+	for _, p := range r.patterns {
+		if p.MatchString(event.Line) {
+			return p.String(), true
+		}
+		if r.errorLineOnly {
+			continue
+		}
+		for _, line := range event.Context {
+			if p.MatchString(line) {
+				return p.String(), true
+			}
+		}
+	}
+	return "", false
+}
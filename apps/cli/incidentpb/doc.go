@@ -0,0 +1,5 @@
+// Package incidentpb holds the protobuf messages and gRPC client for the
+// incident transport, generated from incident.proto.
+package incidentpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative incident.proto
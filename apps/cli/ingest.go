@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+const (
+	defaultIngestListen = "127.0.0.1:8788"
+	defaultIngestLabel  = "ingest"
+	ingestMaxBody       = 1 << 20
+)
+
+// IngestConfig accepts error reports from applications on the same host,
+// in any language, as JSON posted to a local port or Unix socket. They go
+// through the same dedupe, enrichment and sending as errors read from logs.
+type IngestConfig struct {
+	Enabled bool `json:"enabled"`
+	// Listen is a loopback address; the ingest endpoint is not meant to be
+	// reachable from other hosts.
+	Listen string `json:"listen,omitempty"`
+	// Socket is the path of a Unix socket to listen on, instead of Listen
+	// unless that is set too
+	Socket string `json:"socket,omitempty"`
+	// RepoURL and Label are used for reports that do not name their own
+	RepoURL string `json:"repo_url,omitempty"`
+	Label   string `json:"label,omitempty"`
+	// RepoPath is the checkout the git enricher reads for reports sent to
+	// RepoURL. Reports cannot name one: anyone on the host may send them,
+	// and the path is handed to git.
+	RepoPath string `json:"repo_path,omitempty"`
+}
+
+func (c *IngestConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("invalid address %q: %w", c.Listen, err)
+		}
+		if !isLoopbackAddr(c.Listen) {
+			return fmt.Errorf("listen %q is not a loopback address", c.Listen)
+		}
+	}
+	return nil
+}
+
+// isLoopbackAddr reports whether the host:port addr only accepts
+// connections from this machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
+// isLoopbackHost reports whether host, as in a Host header with or without
+// a port, names this machine.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return strings.EqualFold(host, "localhost") || (ip != nil && ip.IsLoopback())
+}
+
+// ingestReport is one error as applications post it. Stack and Context may
+// be given as an array of lines or as one string.
+type ingestReport struct {
+	// Message is the error, such as "ValueError: bad input"
+	Message string `json:"message"`
+	// Stack is the trace, in whatever format the language prints it
+	Stack ingestLines `json:"stack,omitempty"`
+	// Context is what the application logged before the error
+	Context   ingestLines `json:"context,omitempty"`
+	Timestamp string      `json:"timestamp,omitempty"`
+	Target    string      `json:"target,omitempty"`
+	RepoURL   string      `json:"repo_url,omitempty"`
+}
+
+type ingestLines []string
+
+func (l *ingestLines) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*l = strings.Split(strings.TrimRight(text, "\r\n"), "\n")
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return errors.New("must be a string or an array of strings")
+	}
+	*l = lines
+	return nil
+}
+
+// IngestServer serves the ingest endpoint on a loopback port, a Unix
+// socket, or both.
+type IngestServer struct {
+	cfg       IngestConfig
+	listeners []net.Listener
+	server    *http.Server
+	events    chan<- LogEvent
+}
+
+func NewIngestServer(cfg *IngestConfig, repoURL, repoPath string) (*IngestServer, error) {
+	s := &IngestServer{cfg: *cfg}
+	if s.cfg.RepoURL == "" {
+		s.cfg.RepoURL = repoURL
+	}
+	if s.cfg.RepoPath == "" {
+		s.cfg.RepoPath = repoPath
+	}
+	if s.cfg.Label == "" {
+		s.cfg.Label = defaultIngestLabel
+	}
+	if s.cfg.Listen == "" && s.cfg.Socket == "" {
+		s.cfg.Listen = defaultIngestListen
+	}
+
+	if s.cfg.Listen != "" {
+		listener, err := net.Listen("tcp", s.cfg.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("listen tcp failed: %w", err)
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+	if s.cfg.Socket != "" {
+		listener, err := listenSocket(s.cfg.Socket)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("listen unix failed: %w", err)
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("POST /api/errors", s.handle)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return s, nil
+}
+
+// listenSocket listens on the Unix socket at path, replacing a socket left
+// behind by an agent that did not shut down cleanly.
+func listenSocket(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another instance is listening on %s", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Any local user can reach a local port as well, and senders rarely run
+	// as the agent's user
+	os.Chmod(path, 0666)
+	return listener, nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (s *IngestServer) Addrs() []string {
+	var addrs []string
+	for _, l := range s.listeners {
+		if l.Addr().Network() == "unix" {
+			addrs = append(addrs, "unix://"+l.Addr().String())
+		} else {
+			addrs = append(addrs, "http://"+l.Addr().String())
+		}
+	}
+	return addrs
+}
+
+func (s *IngestServer) close() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+}
+
+// Run serves reports until ctx is done and lets in-flight requests finish,
+// so nothing is sent on events once it returns.
+func (s *IngestServer) Run(ctx context.Context, events chan<- LogEvent) {
+	s.events = events
+
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("ingest listener failed", "addr", l.Addr().String(), "err", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(shutdownCtx)
+	wg.Wait()
+}
+
+// handle accepts one report, or an array of them, all or nothing.
+func (s *IngestServer) handle(w http.ResponseWriter, req *http.Request) {
+	if status, err := checkIngestRequest(req); err != nil {
+		relayError(w, status, err.Error())
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, ingestMaxBody))
+	if err != nil {
+		relayError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	var reports []ingestReport
+	if body = bytes.TrimSpace(body); bytes.HasPrefix(body, []byte("[")) {
+		err = json.Unmarshal(body, &reports)
+	} else {
+		reports = make([]ingestReport, 1)
+		err = json.Unmarshal(body, &reports[0])
+	}
+	if err != nil {
+		relayError(w, http.StatusBadRequest, "invalid report: "+err.Error())
+		return
+	}
+
+	events := make([]LogEvent, len(reports))
+	for i, r := range reports {
+		if events[i], err = s.event(r); err != nil {
+			relayError(w, http.StatusBadRequest, fmt.Sprintf("report %d: %v", i, err))
+			return
+		}
+	}
+	for _, e := range events {
+		select {
+		case s.events <- e:
+		case <-req.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "accepted": len(events)})
+}
+
+// checkIngestRequest refuses requests a web page could have made. A page
+// can post a form or text/plain body to a loopback port without a CORS
+// preflight, or reach it under its own name by DNS rebinding; neither can
+// set a JSON content type, and both send an Origin or a foreign Host.
+func checkIngestRequest(req *http.Request) (int, error) {
+	if req.Header.Get("Origin") != "" {
+		return http.StatusForbidden, errors.New("cross-origin requests are not accepted")
+	}
+	// Browsers cannot reach a Unix socket, and clients put all sorts in the
+	// Host header for one
+	local, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if (local == nil || local.Network() != "unix") && !isLoopbackHost(req.Host) {
+		return http.StatusForbidden, fmt.Errorf("host %q is not a loopback host", req.Host)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json")
+	}
+	return 0, nil
+}
+
+// event turns a report into the event a watcher would have detected for
+// it: the error line, then its trace, after the lines logged before it.
+func (s *IngestServer) event(r ingestReport) (LogEvent, error) {
+	message := strings.TrimSpace(r.Message)
+	if message == "" {
+		return LogEvent{}, errors.New("message is required")
+	}
+	event := LogEvent{
+		Timestamp: time.Now().UTC(),
+		Target:    cmp.Or(r.Target, s.cfg.Label),
+		RepoURL:   cmp.Or(r.RepoURL, s.cfg.RepoURL),
+	}
+	if event.RepoURL == "" {
+		return LogEvent{}, errors.New("repo_url is required")
+	}
+	if event.RepoURL == s.cfg.RepoURL {
+		event.RepoPath = s.cfg.RepoPath
+	}
+	if r.Timestamp != "" {
+		ts, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+		if err != nil {
+			return LogEvent{}, fmt.Errorf("timestamp: %w", err)
+		}
+		event.LogTime = ts.UTC()
+	}
+
+	lines := make([]string, 0, len(r.Context)+len(r.Stack)+1)
+	lines = append(lines, r.Context...)
+	lines = append(lines, strings.Split(message, "\n")...)
+	lines = append(lines, r.Stack...)
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if len(line) > watch.DefaultMaxLineLength {
+			line = ship.Truncate(line, watch.DefaultMaxLineLength)
+			event.Truncated = true
+		}
+		lines[i] = line
+	}
+	event.Line = lines[len(r.Context)]
+	event.Context = lines
+	return event, nil
+}
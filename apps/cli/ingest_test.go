@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngestHandleRequestChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		contentType string
+		origin      string
+		unix        bool
+		want        int
+	}{
+		{"json on loopback", "127.0.0.1:8788", "application/json", "", false, http.StatusAccepted},
+		{"localhost", "localhost:8788", "application/json; charset=utf-8", "", false, http.StatusAccepted},
+		{"ipv6 loopback", "[::1]:8788", "application/json", "", false, http.StatusAccepted},
+		{"text/plain", "127.0.0.1:8788", "text/plain", "", false, http.StatusUnsupportedMediaType},
+		{"form", "127.0.0.1:8788", "application/x-www-form-urlencoded", "", false, http.StatusUnsupportedMediaType},
+		{"no content type", "127.0.0.1:8788", "", "", false, http.StatusUnsupportedMediaType},
+		{"origin", "127.0.0.1:8788", "application/json", "https://evil.example", false, http.StatusForbidden},
+		{"null origin", "127.0.0.1:8788", "application/json", "null", false, http.StatusForbidden},
+		{"rebound host", "evil.example:8788", "application/json", "", false, http.StatusForbidden},
+		{"unix socket host", "%2Frun%2Flacia%2Fingest.sock", "application/json", "", true, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := make(chan LogEvent, 1)
+			s := &IngestServer{cfg: IngestConfig{RepoURL: "https://github.com/acme/app", Label: defaultIngestLabel}, events: events}
+			req := httptest.NewRequest(http.MethodPost, "/api/errors", strings.NewReader(`{"message": "ValueError: bad input"}`))
+			req.Host = tt.host
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.unix {
+				addr := &net.UnixAddr{Name: "/run/lacia/ingest.sock", Net: "unix"}
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, addr))
+			}
+			rec := httptest.NewRecorder()
+			s.handle(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := len(events); (got == 1) != (tt.want == http.StatusAccepted) {
+				t.Fatalf("%d events queued", got)
+			}
+		})
+	}
+}
+
+func TestIngestRepoPath(t *testing.T) {
+	s := &IngestServer{cfg: IngestConfig{RepoURL: "https://github.com/acme/app", RepoPath: "/srv/app"}}
+	tests := []struct {
+		report ingestReport
+		want   string
+	}{
+		{ingestReport{Message: "boom"}, "/srv/app"},
+		{ingestReport{Message: "boom", RepoURL: "https://github.com/acme/app"}, "/srv/app"},
+		{ingestReport{Message: "boom", RepoURL: "https://github.com/acme/other"}, ""},
+	}
+	for _, tt := range tests {
+		event, err := s.event(tt.report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.RepoPath != tt.want {
+			t.Errorf("event(%+v).RepoPath = %q, want %q", tt.report, event.RepoPath, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// defaultKubernetesLogDir is where the kubelet links the log of every
+// container on the node.
+const defaultKubernetesLogDir = "/var/log/containers"
+
+var (
+	kubeNamespace = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// The kubelet names the links <pod>_<namespace>_<container>-<id>.log
+	kubeLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{12,})\.log$`)
+)
+
+// KubernetesConfig runs the agent as a DaemonSet that watches the logs of
+// every container on its node, read in the CRI format container runtimes
+// write them in.
+type KubernetesConfig struct {
+	Enabled bool `json:"enabled"`
+	// LogDir is where the container logs are linked, /var/log/containers
+	// by default
+	LogDir string `json:"log_dir,omitempty"`
+	// Namespaces, when set, are the only ones watched; ExcludeNamespaces
+	// are never watched
+	Namespaces        []string `json:"namespaces,omitempty"`
+	ExcludeNamespaces []string `json:"exclude_namespaces,omitempty"`
+	RepoURL           string   `json:"repo_url,omitempty"`
+}
+
+func (c *KubernetesConfig) Validate() error {
+	for _, ns := range append(c.Namespaces, c.ExcludeNamespaces...) {
+		if !kubeNamespace.MatchString(ns) {
+			return fmt.Errorf("invalid namespace %q", ns)
+		}
+	}
+	return nil
+}
+
+// target is the directory target for the node's container logs. The
+// agent's own pod is left out, so its logs about failed sends cannot turn
+// into incidents of their own.
+func (c *KubernetesConfig) target(repoURL string) Target {
+	t := Target{
+		Dir:            cmp.Or(c.LogDir, defaultKubernetesLogDir),
+		Match:          `\.log$`,
+		RepoURL:        cmp.Or(c.RepoURL, repoURL),
+		FollowSymlinks: true,
+		Format:         watch.FormatCRI,
+	}
+	if len(c.Namespaces) > 0 {
+		t.Match = `^[^_]+_(` + strings.Join(c.Namespaces, "|") + `)_.+\.log$`
+	}
+	var exclude []string
+	if len(c.ExcludeNamespaces) > 0 {
+		exclude = append(exclude, `[^_]+_(`+strings.Join(c.ExcludeNamespaces, "|")+`)_`)
+	}
+	if self := ship.DetectContainer(); self != nil && self.Pod != "" && self.Namespace != "" {
+		exclude = append(exclude, regexp.QuoteMeta(self.Pod+"_"+self.Namespace+"_"))
+	}
+	if len(exclude) > 0 {
+		t.Exclude = `^(` + strings.Join(exclude, "|") + `)`
+	}
+	return t
+}
+
+// kubeContainer is what the name of a container's log tells about it.
+type kubeContainer struct {
+	Pod       string
+	Namespace string
+	Container string
+	ID        string
+}
+
+func parseKubeLogName(name string) (kubeContainer, error) {
+	m := kubeLogName.FindStringSubmatch(name)
+	if m == nil {
+		return kubeContainer{}, errors.New("not a container log")
+	}
+	return kubeContainer{Pod: m[1], Namespace: m[2], Container: m[3], ID: m[4]}, nil
+}
+
+// enrichKubernetes describes the container whose log source is, rather
+// than the agent's own, in p: its pod, namespace and ID, its name as the
+// target unless the target has a label, and the node as the hostname when
+// NODE_NAME is mapped from spec.nodeName.
+func enrichKubernetes(p *IncidentPayload, source string) {
+	c, err := parseKubeLogName(filepath.Base(source))
+	if err != nil {
+		return
+	}
+	p.Container = &ship.ContainerInfo{ID: c.ID, Pod: c.Pod, Namespace: c.Namespace}
+	if p.Target == "" {
+		p.Target = c.Container
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		p.Hostname = node
+	}
+}
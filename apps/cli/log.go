@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// LogConfig controls the watcher's own diagnostics. Flags given on the
+// command line take precedence over these values.
+type LogConfig struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+	File   string `json:"file,omitempty"`
+}
+
+func (c *LogConfig) Validate() error {
+	if _, err := parseLogLevel(c.Level); err != nil {
+		return err
+	}
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return errors.New("format must be text or json")
+	}
+	return nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("level must be debug, info, warn or error: %q", s)
+	}
+	return level, nil
+}
+
+// setupLogger installs the default slog logger, writing to out unless
+// cfg.File is set. It returns the log file so the caller can close it on
+// shutdown.
+func setupLogger(cfg LogConfig, out io.Writer) (*os.File, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	level, _ := parseLogLevel(cfg.Level)
+
+	var file *os.File
+	if cfg.File != "" {
+		var err error
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open log file failed: %w", err)
+		}
+		out = file
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return file, nil
+}
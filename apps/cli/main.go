@@ -1,49 +1,240 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-)
 
-// Duplicate prevention
-var (
-	lastErrorHash    string
-	lastErrorTime    time.Time
-	cooldownDuration = 30 * time.Second
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/redact"
 )
 
-func hashError(event LogEvent) string {
-	// Hash the error line and first few context lines
-	data := event.Line
-	if len(event.Context) > 3 {
-		for i := 0; i < 3; i++ {
-			data += event.Context[i]
+func main() {
+	args, path := extractConfigFlag(os.Args[1:])
+	if path != "" {
+		// Absolute, so it still holds for a daemon or service child
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
 		}
+		configFlag = path
 	}
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:8]) // First 8 bytes for shorter hash
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "start":
+			args = args[1:]
+		case "setup":
+			runSetupCommand(args[1:])
+			return
+		case "stop":
+			runStopCommand(args[1:])
+			return
+		case "reload":
+			runReloadCommand(args[1:])
+			return
+		case "validate":
+			runValidateCommand(args[1:])
+			return
+		case "test":
+			runTestCommand(args[1:])
+			return
+		case "status":
+			runStatusCommand(args[1:])
+			return
+		case "mute":
+			runMuteCommand(args[1:])
+			return
+		case "unmute":
+			runUnmuteCommand(args[1:])
+			return
+		case "scan":
+			runScanCommand(args[1:])
+			return
+		case "bench":
+			runBenchCommand(args[1:])
+			return
+		case "history":
+			runHistoryCommand(args[1:])
+			return
+		case "replay":
+			runReplayCommand(args[1:])
+			return
+		case "backfill":
+			runBackfillCommand(args[1:])
+			return
+		case "service":
+			runServiceCommand(args[1:])
+			return
+		case "agent":
+			runAgentCommand(args[1:])
+			return
+		case "relay":
+			runRelayCommand(args[1:])
+			return
+		case "version":
+			runVersionCommand(args[1:])
+			return
+		case "self-update":
+			runSelfUpdateCommand(args[1:])
+			return
+		case "help":
+			printUsage()
+			return
+		default:
+			printUsage()
+			os.Exit(1)
+		}
+	}
+
+	runStartCommand(args)
 }
 
-func isDuplicate(event LogEvent) bool {
-	hash := hashError(event)
-	now := time.Now()
+func runStartCommand(args []string) {
+	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	daemon := fs.Bool("daemon", false, "detach and run in the background")
+	logFile := fs.String("log-file", "", "write logs to this file instead of stderr")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "text or json")
+	stdin := fs.Bool("stdin", false, "read log lines from standard input")
+	skipPreflight := fs.Bool("skip-preflight", false, "do not check log files, repo URLs and the server before starting")
+	tui := fs.Bool("tui", false, "show a live dashboard instead of logging to the terminal")
+	dryRun := fs.Bool("dry-run", false, "print incidents as JSON instead of sending them")
+	tags := tagFlags{}
+	fs.Var(tags, "tag", "add key=value to every incident, may be repeated")
+	fs.Usage = printUsage
+	fs.Parse(args)
+
+	var cfg *Config
+	if *stdin {
+		if *daemon {
+			fmt.Fprintln(os.Stderr, "--stdin cannot be combined with --daemon")
+			os.Exit(1)
+		}
+		// Setup would read its answers from the piped input, so a config
+		// must already exist.
+		var err error
+		cfg, err = LoadStdinConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Config error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		cfg = loadOrSetupConfig()
+	}
+	cfg.Tags = withTags(cfg.Tags, tags)
+	if err := checkPrivileges(cfg.Privileges); err != nil {
+		fmt.Fprintf(os.Stderr, "Privileges: %v\n", err)
+		os.Exit(1)
+	}
+	if !cfg.hasInputs() {
+		fmt.Fprintln(os.Stderr, "Nothing to watch: the config only has a relay section, run `lacia-cli relay`")
+		os.Exit(1)
+	}
+
+	// Interrupting setup above should still kill the process, so signals
+	// are only caught from here on.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var logCfg LogConfig
+	if cfg.Log != nil {
+		logCfg = *cfg.Log
+	}
+	if *logLevel != "" {
+		logCfg.Level = *logLevel
+	}
+	if *logFormat != "" {
+		logCfg.Format = *logFormat
+	}
+	if *logFile != "" {
+		logCfg.File = *logFile
+	}
+
+	if *daemon && (*tui || *dryRun) {
+		fmt.Fprintln(os.Stderr, "--tui and --dry-run cannot be combined with --daemon")
+		os.Exit(1)
+	}
+	if *daemon {
+		// Check in the parent so problems are reported on the terminal
+		// rather than in the daemon's log.
+		if !*skipPreflight && !runPreflight(ctx, cfg) {
+			os.Exit(1)
+		}
+		// The child resolves the config from its own environment, so pin the
+		// file chosen here.
+		childArgs := []string{"--config", ConfigPath(), "--skip-preflight"}
+		if *logLevel != "" {
+			childArgs = append(childArgs, "--log-level", *logLevel)
+		}
+		if *logFormat != "" {
+			childArgs = append(childArgs, "--log-format", *logFormat)
+		}
+		for k, v := range tags {
+			childArgs = append(childArgs, "--tag", k+"="+v)
+		}
+		if err := daemonize(logCfg.File, childArgs...); err != nil {
+			fmt.Fprintf(os.Stderr, "Daemon failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The dashboard shows the log itself unless it goes to a file
+	opts := runOptions{dryRun: *dryRun, tags: tags}
+	var logTo io.Writer = os.Stderr
+	if *tui {
+		opts.dash = NewDashboard(os.Stdout)
+		logTo = opts.dash
+	}
+	logOut, err := setupLogger(logCfg, logTo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Log config error: %v\n", err)
+		os.Exit(1)
+	}
+	if logOut != nil {
+		defer logOut.Close()
+	}
+
+	if !*skipPreflight && !runPreflight(ctx, cfg) {
+		os.Exit(1)
+	}
+
+	if isWindowsService() {
+		if err := runWindowsService(cfg); err != nil {
+			slog.Error("service failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	if hash == lastErrorHash && now.Sub(lastErrorTime) < cooldownDuration {
-		fmt.Printf("Skipping duplicate error (same error within %v)\n", cooldownDuration)
-		return true
+	// A piped watcher lives and dies with its pipeline and may run next to
+	// the main one, so it does not claim the PID file.
+	if !*stdin {
+		pidPath := PIDFilePath(cfg)
+		if err := writePIDFile(pidPath); err != nil {
+			slog.Error("write PID file failed", "err", err)
+			os.Exit(1)
+		}
+		defer os.Remove(pidPath)
 	}
 
-	lastErrorHash = hash
-	lastErrorTime = now
-	return false
+	run(ctx, cfg, opts)
+	slog.Info("shutdown complete")
 }
 
-func main() {
+func loadOrSetupConfig() *Config {
 	var cfg *Config
 	var err error
 
@@ -60,45 +251,721 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	return cfg
+}
+
+type runOptions struct {
+	// dash is set when the dashboard is shown
+	dash *Dashboard
+	// dryRun prints incidents to stdout instead of sending them
+	dryRun bool
+	// tags from --tag, kept over the config's on reload
+	tags map[string]string
+}
 
-	watcher, err := NewWatcher(cfg.LogPath)
+// run watches and reports until ctx is done, then stops the watchers and
+// delivers the events they already produced before returning.
+func run(ctx context.Context, cfg *Config, opts runOptions) {
+	dash := opts.dash
+	budget := cfg.memoryBudget()
+	budget.apply()
+	detector, err := detect.NewDetector(cfg.Patterns)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+		slog.Error("invalid patterns", "err", err)
 		os.Exit(1)
 	}
-	defer watcher.Close()
 
-	client := NewClient(cfg.ServerURL, cfg.RepoURL)
+	plugins, err := LoadPlugins(context.Background(), cfg.Plugins)
+	if err != nil {
+		slog.Error("load plugins failed", "err", err)
+		os.Exit(1)
+	}
+	defer plugins.Close()
+	detector.Plugins = plugins.Detectors()
+	workers := detect.NewPool(cfg.DetectWorkers)
+	defer workers.Close()
+	detector.Workers = workers
+
+	// Sender streams are not configured like targets, but their traces
+	// count against memory_limit_mb all the same
+	senderDefaults := cfg.traceLimits(Target{RepoURL: cfg.RepoURL})
+	var syslog *SyslogServer
+	if cfg.Syslog != nil && cfg.Syslog.Enabled {
+		syslog, err = NewSyslogServer(cfg.Syslog, senderDefaults, detector)
+		if err != nil {
+			slog.Error("start syslog listener failed", "err", err)
+			os.Exit(1)
+		}
+	}
+	var gelf *GELFServer
+	if cfg.GELF != nil && cfg.GELF.Enabled {
+		gelf, err = NewGELFServer(cfg.GELF, senderDefaults, detector)
+		if err != nil {
+			slog.Error("start gelf listener failed", "err", err)
+			os.Exit(1)
+		}
+	}
+	var forward *ForwardServer
+	if cfg.Forward != nil && cfg.Forward.Enabled {
+		forward, err = NewForwardServer(cfg.Forward, senderDefaults, detector)
+		if err != nil {
+			slog.Error("start forward listener failed", "err", err)
+			os.Exit(1)
+		}
+	}
+	var ingest *IngestServer
+	if cfg.Ingest != nil && cfg.Ingest.Enabled {
+		var repoPath string
+		if cfg.Git != nil {
+			repoPath = cfg.Git.RepoPath
+		}
+		ingest, err = NewIngestServer(cfg.Ingest, cfg.RepoURL, repoPath)
+		if err != nil {
+			slog.Error("start ingest endpoint failed", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		slog.Error("create client failed", "err", err)
+		os.Exit(1)
+	}
+	defer client.Close()
 	events := make(chan LogEvent, 100)
-	done := make(chan struct{})
+	spool := NewSpool(cfg.Spool)
+	spool.maxBytes = budget.spool
+	go spool.Fill(events)
 
-	go func() {
-		if err := watcher.Watch(events, done); err != nil {
-			fmt.Fprintf(os.Stderr, "Watcher error: %v\n", err)
+	// Panics are reported through the spool rather than events, which is
+	// closed once the watchers have stopped
+	var reportPanics atomic.Bool
+	reportPanics.Store(cfg.ReportPanics)
+	guard := &panicGuard{report: func(e LogEvent) {
+		if reportPanics.Load() {
+			spool.Push(e)
 		}
-	}()
+	}}
+	// Cancelling ctx stops everything that reads logs. In-flight events are
+	// still delivered afterwards, so sends are not cancelled with it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sendCtx := context.WithoutCancel(ctx)
 
+	checkpoints, err := OpenCheckpoints(CheckpointPath(cfg))
+	if err != nil {
+		slog.Error("load checkpoints failed", "err", err)
+		os.Exit(1)
+	}
+	if opts.dryRun {
+		// Like the dedupe cache, a dry run leaves the checkpoints alone
+		checkpoints.path = ""
+	}
+
+	watches := newWatchSet(ctx, events, cfg.Anomaly, checkpoints, cancel)
+	watches.guard = guard
+	for _, target := range cfg.WatchTargets() {
+		if err := watches.Start(target, detector, -1); err != nil {
+			if target.Dir != "" {
+				slog.Error("open log directory failed", "dir", target.Dir, "err", err)
+			} else {
+				slog.Error("open log file failed", "path", target.LogPath, "err", err)
+			}
+			os.Exit(1)
+		}
+	}
+
+	// Everything else that sends on events is tracked so shutdown can wait
+	// for it to stop before draining the channel.
+	var producers sync.WaitGroup
+	if syslog != nil {
+		syslog.guard = guard
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			syslog.Run(ctx, events)
+		}()
+	}
+	if gelf != nil {
+		gelf.guard = guard
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			gelf.Run(ctx, events)
+		}()
+	}
+	if forward != nil {
+		forward.guard = guard
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			forward.Run(ctx, events)
+		}()
+	}
+	if ingest != nil {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			ingest.Run(ctx, events)
+		}()
+	}
+
+	// Files found in watched directories and the senders of syslog, GELF
+	// and forwarded logs come and go, so anything reporting on watchers asks
+	// for the current set.
+	activeWatchers := func() []*Watcher {
+		all := watches.Watchers()
+		if syslog != nil {
+			all = append(all, syslog.Watchers()...)
+		}
+		if gelf != nil {
+			all = append(all, gelf.Watchers()...)
+		}
+		if forward != nil {
+			all = append(all, forward.Watchers()...)
+		}
+		return all
+	}
+	go checkpoints.Run(ctx, activeWatchers)
+
+	// A dry run sends nothing to follow up on
+	var fixes *FixTracker
+	if cfg.FixStatus != nil && cfg.FixStatus.Enabled && !opts.dryRun {
+		fixes = NewFixTracker(cfg.FixStatus)
+		go runFixStatus(ctx, cfg.FixStatus, client, fixes)
+	}
+
+	// A dry run must not send what an earlier run queued
+	var queue *OfflineQueue
+	if cfg.Queue != nil && cfg.Queue.Enabled && !opts.dryRun {
+		queue, err = OpenOfflineQueue(cfg.Queue, budget.queue)
+		if err != nil {
+			slog.Error("open offline queue failed", "err", err)
+			os.Exit(1)
+		}
+		queue.fixes = fixes
+		go queue.Run(ctx, client)
+	}
+
+	var history *History
+	if cfg.History != nil && cfg.History.Enabled && !opts.dryRun {
+		history, err = OpenHistory(cfg.History)
+		if err != nil {
+			slog.Error("open history failed", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	deduper, err := NewDeduper(cfg.Dedupe)
+	if err != nil {
+		slog.Error("load dedupe cache failed", "err", err)
+		os.Exit(1)
+	}
+	if opts.dryRun {
+		// Keep the persisted cache as it is so the dry run does not hide
+		// errors from the next real run
+		deduper.DisableSave()
+	}
+	producers.Add(1)
 	go func() {
-		for event := range events {
-			// Duplicate prevention - skip if same error within cooldown
-			if isDuplicate(event) {
-				continue
+		defer producers.Done()
+		deduper.Run(ctx, events)
+	}()
+
+	sender := NewSender(client, queue)
+	sender.fixes = fixes
+
+	// Severity rules and git settings take effect on reload, so the
+	// consumer loads the current ones for every event.
+	var git atomic.Pointer[GitEnricher]
+	var classifier atomic.Pointer[Classifier]
+	var ignore atomic.Pointer[IgnoreRules]
+	var script atomic.Pointer[Script]
+	var execHook atomic.Pointer[ExecHook]
+	var redactor atomic.Pointer[Redactor]
+	var repoRules atomic.Pointer[RepoRules]
+	var ignored atomic.Int64
+	rules, err := newConsumerRules(cfg)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	git.Store(NewGitEnricher(cfg.Git))
+	classifier.Store(rules.classifier)
+	ignore.Store(rules.ignore)
+	script.Store(newScriptOrNil(cfg.Script))
+	execHook.Store(newExecHookOrNil(cfg.Exec))
+	redactor.Store(rules.redactor)
+	repoRules.Store(rules.repoRules)
+	muter, err := NewMuter(cfg.Maintenance)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	var muted atomic.Int64
+	var lastIncident atomic.Int64
+
+	var sampler *Sampler
+	if cfg.Sampling != nil && cfg.Sampling.Enabled {
+		sampler = NewSampler(cfg.Sampling)
+	}
+
+	var limiter *RateLimiter
+	if cfg.RateLimit != nil && cfg.RateLimit.Enabled {
+		limiter = NewRateLimiter(cfg.RateLimit)
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			limiter.Run(ctx, events)
+		}()
+	}
+
+	sinks, err := NewSinks(cfg)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	sinks.AddPlugins(plugins.Sinks())
+	sinks.guard = guard
+	sinks.Run(sendCtx)
+
+	var batcher *Batcher
+	if cfg.Batch != nil && cfg.Batch.Enabled {
+		batcher = NewBatcher(cfg.Batch, func(payloads []IncidentPayload) {
+			sender.DeliverBatch(sendCtx, payloads)
+		})
+		batcher.maxBytes = budget.batch
+	}
+
+	record := func(event LogEvent, severity string, d Delivery) {
+		if dash != nil {
+			dash.Incident(event, severity, d.Outcome)
+		}
+		if history != nil {
+			entry := HistoryEntry{
+				DetectedAt:  event.Timestamp,
+				HandledAt:   time.Now().UTC(),
+				Fingerprint: detect.Fingerprint(event, deduper.Strategy()),
+				Target:      event.Target,
+				Severity:    severity,
+				ErrorLine:   event.Line,
+				Outcome:     d.Outcome,
+				IncidentID:  d.IncidentID,
+			}
+			if d.Err != nil {
+				entry.Error = d.Err.Error()
 			}
+			history.Add(entry)
+		}
+	}
 
-			if err := client.Send(event); err != nil {
-				fmt.Fprintf(os.Stderr, "Send failed: %v\n", err)
+	normalize := func(c *Candidate) {
+		if c.Payload == nil {
+			payload := client.Payload(c.Event)
+			if cfg.kubernetes() {
+				enrichKubernetes(&payload, c.Event.Source)
 			}
+			c.Payload = &payload
+			repoRules.Load().Apply(c)
 		}
+	}
+	processors := []Processor{
+		processorFunc{"ignore", func(c *Candidate) (string, bool) {
+			pattern, ok := ignore.Load().Match(c.Event)
+			if !ok {
+				return "", false
+			}
+			ignored.Add(1)
+			slog.Debug("ignored error", "line", c.Event.Line, "pattern", pattern)
+			return "ignored", true
+		}},
+		processorFunc{"classify", func(c *Candidate) (string, bool) {
+			severity, ok := classifier.Load().Classify(c.Event)
+			c.Severity = severity
+			return "", !ok
+		}},
+		processorFunc{"script", func(c *Candidate) (string, bool) {
+			s := script.Load()
+			if s == nil {
+				return "", false
+			}
+			normalize(c)
+			return "dropped by script", s.Apply(c)
+		}},
+		// Duplicate prevention - skip if same error within cooldown
+		processorFunc{"dedupe", func(c *Candidate) (string, bool) {
+			if deduper.IsDuplicate(&c.Event) {
+				return "duplicate", true
+			}
+			if c.Payload != nil {
+				c.Payload.SetOccurrences(c.Event)
+			}
+			return "", false
+		}},
+		// Muted incidents are still logged here
+		processorFunc{"mute", func(c *Candidate) (string, bool) {
+			reason, ok := muter.Muted(time.Now())
+			if !ok {
+				return "", false
+			}
+			muted.Add(1)
+			slog.Info("incident not sent while muted", "line", c.Event.Line, "severity", c.Severity, "reason", reason)
+			return "muted", true
+		}},
+		processorFunc{"sample", func(c *Candidate) (string, bool) {
+			if sampler == nil {
+				return "", false
+			}
+			if !sampler.Sample(&c.Event) {
+				return "sampled out", true
+			}
+			if c.Payload != nil {
+				c.Payload.OccurrenceCount = c.Event.Occurrences
+			}
+			return "", false
+		}},
+		processorFunc{"rate_limit", func(c *Candidate) (string, bool) {
+			return "rate limited", limiter != nil && !limiter.Allow(c.Event)
+		}},
+		// Masks secrets before normalize builds the payload from the event
+		processorFunc{"redact", func(c *Candidate) (string, bool) {
+			redactCandidate(redactor.Load(), c)
+			return "", false
+		}},
+		processorFunc{"normalize", func(c *Candidate) (string, bool) {
+			normalize(c)
+			return "", false
+		}},
+		processorFunc{"enrich", func(c *Candidate) (string, bool) {
+			git.Load().Enrich(c.Payload, c.Event.RepoPath)
+			return "", false
+		}},
+		processorFunc{"exec", func(c *Candidate) (string, bool) {
+			h := execHook.Load()
+			if h == nil {
+				return "", false
+			}
+			normalize(c)
+			return "dropped by exec hook", h.Apply(c)
+		}},
+		processorFunc{"plugins", func(c *Candidate) (string, bool) {
+			for _, p := range plugins.Enrichers() {
+				normalize(c)
+				drop, err := p.Enrich(c)
+				if err != nil {
+					slog.Warn("plugin failed", "plugin", p.name, "line", c.Event.Line, "err", err)
+					continue
+				}
+				if drop {
+					return "dropped by plugin " + p.name, true
+				}
+			}
+			return "", false
+		}},
+	}
+	var pipeline atomic.Pointer[Pipeline]
+	pipeline.Store(NewPipeline(cfg.PipelineSteps(), processors))
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		// An incident that makes the pipeline panic is dropped and the
+		// sender restarted, which sendCtx lets happen during shutdown too
+		guard.Run(sendCtx, "sender", func() {
+			for {
+				event, ok := spool.Pop()
+				if !ok {
+					return
+				}
+				c := &Candidate{Event: event}
+				if outcome, drop := pipeline.Load().Run(c); drop {
+					if outcome != "" {
+						record(c.Event, c.Severity, Delivery{Outcome: outcome})
+					}
+					continue
+				}
+				event, severity := c.Event, c.Severity
+				normalize(c)
+				payload := *c.Payload
+				payload.Severity = severity
+
+				lastIncident.Store(time.Now().UnixNano())
+				if opts.dryRun {
+					if dash == nil {
+						printDryRun(payload)
+					}
+					record(event, severity, Delivery{Outcome: "dry run"})
+					continue
+				}
+				sinks.Notify(payload)
+				if batcher != nil {
+					batcher.Add(payload)
+					record(event, severity, Delivery{Outcome: "batched"})
+					continue
+				}
+				record(event, severity, sender.Deliver(sendCtx, payload))
+			}
+		})
 	}()
 
-	fmt.Printf("Watching: %s\n", cfg.LogPath)
-	fmt.Printf("Server:   %s\n", cfg.ServerURL)
-	fmt.Println("Press Ctrl+C to stop\n")
+	reloader := NewReloader(cfg, func(prev, next *Config) error {
+		detector, err := detect.NewDetector(next.Patterns)
+		if err != nil {
+			return err
+		}
+		rules, err := newConsumerRules(next)
+		if err != nil {
+			return err
+		}
+		if err := muter.SetWindows(next.Maintenance); err != nil {
+			return err
+		}
+		next.Tags = withTags(next.Tags, opts.tags)
+		if err := client.Reconfigure(next.clientConfig()); err != nil {
+			return err
+		}
+		detector.Plugins = plugins.Detectors()
+		detector.Workers = workers
+		watches.Apply(next.WatchTargets(), detector)
+		if syslog != nil {
+			syslog.SetDetector(detector)
+		}
+		if gelf != nil {
+			gelf.SetDetector(detector)
+		}
+		if forward != nil {
+			forward.SetDetector(detector)
+		}
+		git.Store(NewGitEnricher(next.Git))
+		classifier.Store(rules.classifier)
+		ignore.Store(rules.ignore)
+		script.Store(newScriptOrNil(next.Script))
+		execHook.Store(newExecHookOrNil(next.Exec))
+		redactor.Store(rules.redactor)
+		repoRules.Store(rules.repoRules)
+		reportPanics.Store(next.ReportPanics)
+		pipeline.Store(NewPipeline(next.PipelineSteps(), processors))
+
+		if changed := restartRequired(prev, next); len(changed) > 0 {
+			slog.Warn("config changes need a restart to take effect", "sections", strings.Join(changed, ", "))
+		}
+		slog.Info("config reloaded", "server", next.ServerURL)
+		return nil
+	})
+	go reloader.Run(ctx)
+
+	startedAt := time.Now()
+	status := func() StatusReport {
+		stats := sender.Stats()
+		report := StatusReport{
+			PID:        os.Getpid(),
+			StartedAt:  startedAt,
+			Server:     reloader.Config().ServerURL,
+			DryRun:     opts.dryRun,
+			Sent:       stats.Sent,
+			Failed:     stats.Failed,
+			Duplicates: deduper.Duplicates(),
+			Ignored:    ignored.Load(),
+			Muted:      muted.Load(),
+			Spooled:    spool.Len(),
+			Dropped:    spool.Dropped(),
+			Panics:     guard.Panics(),
+		}
+		if queue != nil {
+			report.Queued = queue.Len()
+		}
+		if until, reason := muter.MutedUntil(); !until.IsZero() {
+			report.MutedUntil = &until
+			report.MuteReason = reason
+		}
+		if !stats.LastSend.IsZero() {
+			report.LastSendAt = &stats.LastSend
+		}
+		if stats.LastError != nil {
+			report.LastError = stats.LastError.Error()
+		}
+		report.LastIncidentID = stats.LastIncidentID
+		report.Fixes = fixes.Fixes()
+		if wait := client.ThrottledFor(); wait > 0 {
+			until := time.Now().Add(wait)
+			report.ThrottledUntil = &until
+		}
+		for _, w := range activeWatchers() {
+			lines, errs := w.Counts()
+			state, restarts, lastErr := w.Health()
+			report.Targets = append(report.Targets, TargetStatus{
+				LogPath:   w.Path(),
+				Label:     w.Target().Label,
+				Lines:     lines,
+				Errors:    errs,
+				State:     state,
+				Restarts:  restarts,
+				LastError: lastErr,
+			})
+		}
+		return report
+	}
+	if dash != nil {
+		go dash.Run(ctx, status, activeWatchers)
+	}
+
+	heartbeats := make(chan struct{})
+	if cfg.Heartbeat != nil && cfg.Heartbeat.Enabled && !opts.dryRun {
+		go func() {
+			defer close(heartbeats)
+			runHeartbeats(ctx, cfg.Heartbeat, client, status, func() time.Time {
+				if n := lastIncident.Load(); n != 0 {
+					return time.Unix(0, n).UTC()
+				}
+				return time.Time{}
+			})
+		}()
+	} else {
+		close(heartbeats)
+	}
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
+	control, err := NewControlServer(ControlSocketPath(cfg))
+	if err != nil {
+		slog.Warn("control socket disabled", "err", err)
+	} else {
+		defer control.Close()
+		control.Handle("/status", func() (any, error) {
+			return status(), nil
+		})
+		control.Handle("POST /reload", func() (any, error) {
+			if err := reloader.Reload(); err != nil {
+				return nil, err
+			}
+			return map[string]bool{"reloaded": true}, nil
+		})
+		control.HandleRequest("/history", func(r *http.Request) (any, error) {
+			if history == nil {
+				return nil, errors.New("history is not enabled")
+			}
+			filter, err := parseHistoryFilter(r.URL.Query())
+			if err != nil {
+				return nil, err
+			}
+			return history.Query(filter)
+		})
+		control.HandleRequest("POST /mute", func(r *http.Request) (any, error) {
+			d, err := time.ParseDuration(r.URL.Query().Get("for"))
+			if err != nil || d <= 0 {
+				return nil, errors.New("for must be a positive duration such as 2h")
+			}
+			until := muter.Mute(d, r.URL.Query().Get("reason"))
+			slog.Info("muted", "until", until.Format(time.RFC3339), "reason", r.URL.Query().Get("reason"))
+			return map[string]time.Time{"until": until}, nil
+		})
+		control.Handle("POST /unmute", func() (any, error) {
+			muter.Unmute()
+			slog.Info("unmuted")
+			return map[string]bool{"unmuted": true}, nil
+		})
+		control.Handle("POST /stop", func() (any, error) {
+			cancel()
+			return map[string]bool{"stopping": true}, nil
+		})
+		go control.Serve()
+	}
 
-	close(done)
-	fmt.Println("\nShutdown complete")
+	if syslog != nil {
+		slog.Info("listening for syslog", "addrs", strings.Join(syslog.Addrs(), ", "))
+	}
+	if gelf != nil {
+		slog.Info("listening for gelf", "addrs", strings.Join(gelf.Addrs(), ", "))
+	}
+	if forward != nil {
+		slog.Info("listening for forwarded logs", "addrs", strings.Join(forward.Addrs(), ", "))
+	}
+	if ingest != nil {
+		slog.Info("listening for error reports", "addrs", strings.Join(ingest.Addrs(), ", "))
+	}
+	if opts.dryRun {
+		slog.Info("dry run, incidents are printed instead of sent")
+	} else {
+		slog.Info("sending incidents", "server", cfg.ServerURL)
+	}
+	if cfg.Health != nil && cfg.Health.Enabled {
+		health, err := NewHealthServer(cfg.Health, activeWatchers, sender)
+		if err != nil {
+			slog.Warn("health endpoint disabled", "err", err)
+		} else {
+			defer health.Close()
+			go health.Serve()
+			slog.Info("health endpoint listening", "url", "http://"+health.Addr()+"/healthz")
+		}
+	}
+	// Everything that may need root is open by now
+	controlSocket := ""
+	if control != nil {
+		controlSocket = control.path
+	}
+	if err := dropPrivileges(cfg, controlSocket); err != nil {
+		slog.Error("drop privileges failed", "err", err)
+		os.Exit(1)
+	}
+
+	<-ctx.Done()
+	if dash != nil {
+		dash.Close()
+	}
+	// Stopped watchers keep their final offsets, but directory targets no
+	// longer list them
+	watched := activeWatchers()
+	watches.Stop()
+	if err := checkpoints.Save(watched); err != nil {
+		slog.Error("save checkpoints failed", "err", err)
+	}
+	producers.Wait()
+	close(events)
+	<-drained
+	if err := history.Close(); err != nil {
+		slog.Error("close history failed", "err", err)
+	}
+	sinks.Close()
+	if batcher != nil {
+		batcher.Close()
+	}
+	<-heartbeats
+	if err := deduper.Save(); err != nil {
+		slog.Error("save dedupe cache failed", "err", err)
+	}
+}
+
+// printDryRun writes the incident that would have been sent to stdout.
+func printDryRun(payload IncidentPayload) {
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		slog.Error("marshal incident failed", "err", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// consumerRules are the consumer's compiled settings, built together so a
+// reload that fails on one keeps all the current ones.
+type consumerRules struct {
+	classifier *Classifier
+	ignore     *IgnoreRules
+	redactor   *Redactor
+	repoRules  *RepoRules
+}
+
+func newConsumerRules(cfg *Config) (consumerRules, error) {
+	var r consumerRules
+	var err error
+	if r.classifier, err = NewClassifier(cfg.Severity); err != nil {
+		return r, err
+	}
+	if r.ignore, err = NewIgnoreRules(cfg.Ignore); err != nil {
+		return r, err
+	}
+	if r.redactor, err = redact.New(cfg.Redact); err != nil {
+		return r, err
+	}
+	if r.repoRules, err = NewRepoRules(cfg.RepoRules, cfg.Repositories); err != nil {
+		return r, err
+	}
+	return r, nil
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+// Rough per-item overheads, for the strings and structs around the text
+// that makes up most of an event or payload.
+const (
+	eventOverhead   = 256
+	payloadOverhead = 512
+	lineOverhead    = detect.LineOverhead
+	frameOverhead   = 64
+)
+
+// memoryBudget divides memory_limit_mb between the buffers that grow with
+// the volume of the log or with how long the server is unreachable. Zero
+// leaves a buffer bounded only by its count of lines or incidents.
+type memoryBudget struct {
+	limit int64
+	// spool, queue and batch are bytes for the whole buffer, trace for
+	// each trace being assembled, including the context kept before it,
+	// and traces for all of them together
+	spool, queue, batch, trace, traces int
+}
+
+// memoryBudget returns the budget for c's memory_limit_mb. The rest of the
+// limit is left for the runtime, regexes, caches and plugins.
+func (c *Config) memoryBudget() memoryBudget {
+	if c.MemoryLimitMB <= 0 {
+		return memoryBudget{}
+	}
+	limit := c.MemoryLimitMB << 20
+	return memoryBudget{
+		limit:  int64(limit),
+		spool:  limit / 4,
+		queue:  limit / 8,
+		batch:  limit / 16,
+		trace:  limit / 32,
+		traces: limit / 8,
+	}
+}
+
+var (
+	traceBudgetOnce sync.Once
+	traceBudget     *detect.TraceBudget
+)
+
+// sharedTraceBudget returns the one budget for the traces of every watcher
+// and sender stream, so their number does not multiply it. memory_limit_mb
+// needs a restart, so the first size asked for is the one kept.
+func sharedTraceBudget(limit int) *detect.TraceBudget {
+	traceBudgetOnce.Do(func() {
+		traceBudget = detect.NewTraceBudget(limit)
+	})
+	return traceBudget
+}
+
+// apply sets the Go runtime's soft memory limit, so the garbage collector
+// works harder before the heap grows past the budget.
+func (b memoryBudget) apply() {
+	if b.limit > 0 {
+		debug.SetMemoryLimit(b.limit)
+	}
+}
+
+func linesSize(lines []string) int {
+	n := 0
+	for _, line := range lines {
+		n += len(line) + lineOverhead
+	}
+	return n
+}
+
+func eventSize(e LogEvent) int {
+	return eventOverhead + len(e.Line) + linesSize(e.Context)
+}
+
+func payloadSize(p IncidentPayload) int {
+	n := payloadOverhead + len(p.ErrorLine) + linesSize(p.Context)
+	for _, f := range p.Frames {
+		n += frameOverhead + len(f.File) + len(f.Function)
+	}
+	return n
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceWindow is a time during which incidents are not sent. Start
+// and End are RFC 3339 times for a one-off window, or HH:MM in the host's
+// time zone for one that repeats every day, or on Days only.
+type MaintenanceWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func (w *MaintenanceWindow) Validate() error {
+	_, err := parseWindow(*w)
+	return err
+}
+
+// window is a parsed MaintenanceWindow. One-off windows set start and end;
+// daily ones set the minutes past midnight in from and to.
+type window struct {
+	start, end time.Time
+	daily      bool
+	from, to   int
+	days       map[time.Weekday]bool
+}
+
+func parseWindow(w MaintenanceWindow) (window, error) {
+	if w.Start == "" || w.End == "" {
+		return window{}, errors.New("start and end are required")
+	}
+
+	if from, err := parseClock(w.Start); err == nil {
+		to, err := parseClock(w.End)
+		if err != nil {
+			return window{}, fmt.Errorf("end: %w", err)
+		}
+		if from == to {
+			return window{}, errors.New("end must differ from start")
+		}
+		parsed := window{daily: true, from: from, to: to}
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return window{}, fmt.Errorf("days: unknown day %q, use sun, mon, ... sat", d)
+			}
+			if parsed.days == nil {
+				parsed.days = make(map[time.Weekday]bool)
+			}
+			parsed.days[day] = true
+		}
+		return parsed, nil
+	}
+
+	start, err := time.Parse(time.RFC3339, w.Start)
+	if err != nil {
+		return window{}, errors.New("start must be an RFC 3339 time or HH:MM")
+	}
+	end, err := time.Parse(time.RFC3339, w.End)
+	if err != nil {
+		return window{}, errors.New("end must be an RFC 3339 time, like start")
+	}
+	if !end.After(start) {
+		return window{}, errors.New("end must be after start")
+	}
+	if len(w.Days) > 0 {
+		return window{}, errors.New("days only applies to HH:MM windows")
+	}
+	return window{start: start, end: end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New("must be HH:MM")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w window) contains(t time.Time) bool {
+	if !w.daily {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case w.from < w.to:
+		if minute < w.from || minute >= w.to {
+			return false
+		}
+	case minute >= w.from:
+		// The evening part of a window that runs past midnight
+	case minute < w.to:
+		// The morning part, which belongs to the window started the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return w.days == nil || w.days[day]
+}
+
+// Muter decides whether incidents are held back, either because
+// `lacia-cli mute` was run or during a maintenance window.
+type Muter struct {
+	mu      sync.Mutex
+	until   time.Time
+	reason  string
+	windows []window
+}
+
+func NewMuter(windows []MaintenanceWindow) (*Muter, error) {
+	m := &Muter{}
+	if err := m.SetWindows(windows); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetWindows replaces the maintenance windows, e.g. on reload. On error the
+// current windows are kept.
+func (m *Muter) SetWindows(windows []MaintenanceWindow) error {
+	var parsed []window
+	for i, w := range windows {
+		p, err := parseWindow(w)
+		if err != nil {
+			return fmt.Errorf("maintenance[%d]: %w", i, err)
+		}
+		parsed = append(parsed, p)
+	}
+	m.mu.Lock()
+	m.windows = parsed
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Muter) Mute(d time.Duration, reason string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.until = time.Now().Add(d)
+	m.reason = reason
+	return m.until
+}
+
+func (m *Muter) Unmute() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.until = time.Time{}
+	m.reason = ""
+}
+
+// Muted reports whether incidents are held back at now, and why.
+func (m *Muter) Muted(now time.Time) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Before(m.until) {
+		if m.reason != "" {
+			return m.reason, true
+		}
+		return "muted", true
+	}
+	for _, w := range m.windows {
+		if w.contains(now) {
+			return "maintenance window", true
+		}
+	}
+	return "", false
+}
+
+// MutedUntil returns when a mute from `lacia-cli mute` ends, or the zero
+// time if there is none.
+func (m *Muter) MutedUntil() (time.Time, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Now().Before(m.until) {
+		return m.until, m.reason
+	}
+	return time.Time{}, ""
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpLogsPath           = "/v1/logs"
+	defaultOTLPServiceName = "lacia"
+)
+
+// OTLPConfig exports incidents as OpenTelemetry log records over OTLP/HTTP
+// with JSON encoding, e.g. to an OpenTelemetry Collector.
+type OTLPConfig struct {
+	// Endpoint is the collector's base URL, to which /v1/logs is added, or
+	// the full logs URL when it already has a path
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// ServiceName defaults to the incident's service, then "lacia"
+	ServiceName string `json:"service_name,omitempty"`
+	MinSeverity string `json:"min_severity,omitempty"`
+}
+
+func (c *OTLPConfig) Validate() error {
+	if c.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an http or https URL: %q", c.Endpoint)
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// otlpSeverity maps incident severities onto OpenTelemetry severity numbers
+// and texts.
+var otlpSeverity = map[string]struct {
+	number int
+	text   string
+}{
+	SeverityLow:      {9, "INFO"},
+	SeverityMedium:   {13, "WARN"},
+	SeverityHigh:     {17, "ERROR"},
+	SeverityCritical: {21, "FATAL"},
+}
+
+// OTLPSink sends each incident as a log record whose body is the trace,
+// with the host and repository as resource attributes.
+type OTLPSink struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	httpClient  *http.Client
+}
+
+func NewOTLPSink(cfg *OTLPConfig) (*OTLPSink, error) {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint: %w", err)
+	}
+	if u.Path == "" {
+		endpoint += otlpLogsPath
+	}
+	return &OTLPSink{
+		url:         endpoint,
+		headers:     cfg.Headers,
+		serviceName: cfg.ServiceName,
+		httpClient:  &http.Client{},
+	}, nil
+}
+
+func (s *OTLPSink) Name() string {
+	return "otlp"
+}
+
+func (s *OTLPSink) Send(ctx context.Context, payload IncidentPayload) error {
+	return postJSON(ctx, s.httpClient, s.url, s.request(payload), s.headers)
+}
+
+// request builds an ExportLogsServiceRequest in the OTLP JSON encoding.
+func (s *OTLPSink) request(p IncidentPayload) map[string]any {
+	resource := []map[string]any{
+		otlpAttribute("service.name", cmp.Or(s.serviceName, p.Service, defaultOTLPServiceName)),
+		otlpAttribute("host.name", p.Hostname),
+	}
+	if p.Environment != "" {
+		resource = append(resource, otlpAttribute("deployment.environment.name", p.Environment))
+	}
+	if p.Version != "" {
+		resource = append(resource, otlpAttribute("service.version", p.Version))
+	}
+	if c := p.Container; c != nil {
+		for _, a := range [][2]string{
+			{"container.id", c.ID},
+			{"container.image.name", c.Image},
+			{"k8s.pod.name", c.Pod},
+			{"k8s.namespace.name", c.Namespace},
+		} {
+			if a[1] != "" {
+				resource = append(resource, otlpAttribute(a[0], a[1]))
+			}
+		}
+	}
+	if p.RepoURL != "" {
+		resource = append(resource, otlpAttribute("vcs.repository.url.full", p.RepoURL))
+	}
+	if p.Git != nil {
+		resource = append(resource, otlpAttribute("vcs.ref.head.revision", p.Git.Commit))
+		if p.Git.Branch != "" {
+			resource = append(resource, otlpAttribute("vcs.ref.head.name", p.Git.Branch))
+		}
+	}
+
+	attributes := []map[string]any{
+		otlpAttribute("lacia.severity", p.Severity),
+		otlpAttribute("exception.message", p.ErrorLine),
+	}
+	if p.Target != "" {
+		attributes = append(attributes, otlpAttribute("lacia.target", p.Target))
+	}
+	if p.Language != "" {
+		attributes = append(attributes, otlpAttribute("lacia.language", p.Language))
+	}
+	if len(p.Frames) > 0 {
+		// The innermost frame, where the error was raised
+		f := p.Frames[0]
+		attributes = append(attributes,
+			otlpAttribute("code.filepath", f.File),
+			map[string]any{"key": "code.lineno", "value": map[string]any{"intValue": strconv.Itoa(f.Line)}})
+		if f.Function != "" {
+			attributes = append(attributes, otlpAttribute("code.function", f.Function))
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(p.Tags)) {
+		attributes = append(attributes, otlpAttribute("lacia.tag."+k, p.Tags[k]))
+	}
+	if p.OccurrenceCount > 1 {
+		attributes = append(attributes,
+			map[string]any{"key": "lacia.occurrence_count", "value": map[string]any{"intValue": strconv.Itoa(p.OccurrenceCount)}})
+	}
+
+	body := p.ErrorLine
+	if len(p.Context) > 0 {
+		body = strings.Join(p.Context, "\n")
+	}
+	severity, ok := otlpSeverity[p.Severity]
+	if !ok {
+		severity = otlpSeverity[SeverityHigh]
+	}
+	// The agent observed the error when it read it, which the log may
+	// date earlier
+	observed, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		observed = time.Now()
+	}
+	ts := observed
+	if logTime, err := time.Parse(time.RFC3339Nano, p.LogTimestamp); err == nil {
+		ts = logTime
+	}
+
+	// 64-bit integers are strings in the JSON encoding
+	record := map[string]any{
+		"timeUnixNano":         strconv.FormatInt(ts.UnixNano(), 10),
+		"observedTimeUnixNano": strconv.FormatInt(observed.UnixNano(), 10),
+		"severityNumber":       severity.number,
+		"severityText":         severity.text,
+		"body":                 map[string]any{"stringValue": body},
+		"attributes":           attributes,
+	}
+	return map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": map[string]any{"attributes": resource},
+			"scopeLogs": []any{map[string]any{
+				"scope":      map[string]any{"name": "lacia"},
+				"logRecords": []any{record},
+			}},
+		}},
+	}
+}
+
+func otlpAttribute(key, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
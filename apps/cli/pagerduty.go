@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const defaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type PagerDutyConfig struct {
+	RoutingKey  string `json:"routing_key"`
+	MinSeverity string `json:"min_severity,omitempty"`
+	// EventsURL overrides the Events API v2 endpoint, e.g. for the EU
+	// service region.
+	EventsURL    string `json:"events_url,omitempty"`
+	DashboardURL string `json:"dashboard_url,omitempty"`
+}
+
+func (c *PagerDutyConfig) Validate() error {
+	if c.RoutingKey == "" {
+		return errors.New("routing_key is required")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// pagerDutySeverity maps incident severities onto PagerDuty's.
+var pagerDutySeverity = map[string]string{
+	SeverityLow:      "info",
+	SeverityMedium:   "warning",
+	SeverityHigh:     "error",
+	SeverityCritical: "critical",
+}
+
+// PagerDutySink triggers PagerDuty incidents through the Events API v2. The
+// dedup_key is the error's fingerprint, so repeats of an error that is still
+// open are grouped into the same PagerDuty incident.
+type PagerDutySink struct {
+	routingKey   string
+	eventsURL    string
+	dashboardURL string
+	fingerprint  string
+	retry        RetryPolicy
+	httpClient   *http.Client
+}
+
+func NewPagerDutySink(cfg *PagerDutyConfig, serverURL, fingerprint string) *PagerDutySink {
+	s := &PagerDutySink{
+		routingKey:   cfg.RoutingKey,
+		eventsURL:    cfg.EventsURL,
+		dashboardURL: cfg.DashboardURL,
+		fingerprint:  fingerprint,
+		retry:        ship.NewRetryPolicy(nil),
+		httpClient:   &http.Client{},
+	}
+	if s.eventsURL == "" {
+		s.eventsURL = defaultPagerDutyEventsURL
+	}
+	if s.dashboardURL == "" {
+		s.dashboardURL = strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/api/webhook")
+	}
+	return s
+}
+
+func (s *PagerDutySink) Name() string {
+	return "pagerduty"
+}
+
+// Send retries like the server client does, since a page that is lost to a
+// 429 or a network blip may never be noticed.
+func (s *PagerDutySink) Send(ctx context.Context, payload IncidentPayload) error {
+	event := s.event(payload)
+	for attempt := 1; ; attempt++ {
+		err := postJSON(ctx, s.httpClient, s.eventsURL, event, nil)
+		if err == nil || !ship.IsRetryable(err) || attempt >= s.retry.MaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.retry.Delay(attempt)):
+		}
+	}
+}
+
+func (s *PagerDutySink) event(p IncidentPayload) map[string]any {
+	severity := pagerDutySeverity[p.Severity]
+	if severity == "" {
+		severity = "error"
+	}
+
+	details := map[string]any{
+		"repo_url": p.RepoURL,
+		"context":  p.Context,
+	}
+	if p.OccurrenceCount > 1 {
+		details["occurrence_count"] = p.OccurrenceCount
+	}
+	if len(p.Frames) > 0 {
+		details["frames"] = p.Frames
+	}
+	if p.Git != nil {
+		details["git"] = p.Git
+	}
+
+	body := map[string]any{
+		// PagerDuty truncates summaries at 1024 characters
+		"summary":        ship.Truncate(p.ErrorLine, 1000),
+		"source":         p.Hostname,
+		"severity":       severity,
+		"timestamp":      p.Timestamp,
+		"custom_details": details,
+	}
+	if p.Target != "" {
+		body["component"] = p.Target
+	}
+
+	event := map[string]any{
+		"routing_key":  s.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    detect.Fingerprint(LogEvent{Line: p.ErrorLine, Context: p.Context}, s.fingerprint),
+		"payload":      body,
+		"client":       "Lacia",
+	}
+	if s.dashboardURL != "" {
+		event["client_url"] = s.dashboardURL
+	}
+	return event
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	panicBaseDelay = time.Second
+	panicMaxDelay  = time.Minute
+	// panicTarget is the target of incidents about the agent itself
+	panicTarget = "lacia-cli"
+)
+
+// panicGuard recovers the agent's goroutines from panics, so a malformed
+// line or incident cannot silently stop detection or delivery, and reports
+// the panics. A nil guard still recovers but does not report.
+type panicGuard struct {
+	// report, when set, is given each panic as an event
+	report func(LogEvent)
+	panics atomic.Int64
+}
+
+// Run calls fn until it returns without panicking or ctx is done, waiting
+// longer after each panic that follows shortly after the last.
+func (g *panicGuard) Run(ctx context.Context, name string, fn func()) {
+	delay := panicBaseDelay
+	for {
+		started := time.Now()
+		if !g.call(name, fn) {
+			return
+		}
+		if time.Since(started) > panicMaxDelay {
+			delay = panicBaseDelay
+		}
+		slog.Warn("restarting after panic", "name", name, "in", delay.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, panicMaxDelay)
+	}
+}
+
+// call runs fn and reports whether it panicked.
+func (g *panicGuard) call(name string, fn func()) (panicked bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		panicked = true
+		stack := debug.Stack()
+		slog.Error("recovered from panic", "name", name, "panic", v, "stack", string(stack))
+		if g == nil {
+			return
+		}
+		g.panics.Add(1)
+		if g.report != nil {
+			g.report(panicEvent(name, v, stack))
+		}
+	}()
+	fn()
+	return false
+}
+
+// Panics returns how many panics were recovered from.
+func (g *panicGuard) Panics() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.panics.Load()
+}
+
+// panicEvent describes a panic of the agent as an incident with the stack
+// as its context.
+func panicEvent(name string, v any, stack []byte) LogEvent {
+	line := fmt.Sprintf("panic: %v [recovered in %s]", v, name)
+	context := []string{line}
+	for _, l := range strings.Split(strings.TrimSpace(string(stack)), "\n") {
+		context = append(context, strings.TrimSpace(l))
+	}
+	return LogEvent{
+		Line:      line,
+		Timestamp: time.Now().UTC(),
+		Context:   context,
+		Target:    panicTarget,
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// defaultPipeline is the order errors have always been processed in.
+var defaultPipeline = []string{"ignore", "classify", "script", "dedupe", "mute", "sample", "rate_limit", "redact", "normalize", "enrich", "exec", "plugins"}
+
+// Candidate is a detected error on its way through the pipeline. Payload is
+// nil until the normalize step builds it from Event.
+type Candidate struct {
+	Event    LogEvent
+	Severity string
+	Payload  *IncidentPayload
+}
+
+// Processor is one step between detecting an error and sending it. It
+// drops the candidate by returning drop, with the outcome to show for it on
+// the dashboard, or "" to drop it silently.
+type Processor interface {
+	Name() string
+	Process(c *Candidate) (outcome string, drop bool)
+}
+
+type processorFunc struct {
+	name string
+	fn   func(c *Candidate) (string, bool)
+}
+
+func (p processorFunc) Name() string {
+	return p.name
+}
+
+func (p processorFunc) Process(c *Candidate) (string, bool) {
+	return p.fn(c)
+}
+
+// validatePipeline checks the pipeline setting against the processors the
+// agent has. Steps after normalize see the payload, so enrich must follow
+// it; script, exec and plugins build the payload themselves when they come first.
+func validatePipeline(steps []string) error {
+	seen := map[string]bool{}
+	for _, name := range steps {
+		if !slices.Contains(defaultPipeline, name) {
+			return fmt.Errorf("unknown processor %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("%q is listed twice", name)
+		}
+		if name == "enrich" && !seen["normalize"] {
+			return fmt.Errorf("enrich must come after normalize")
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Pipeline runs candidates through its processors in order.
+type Pipeline struct {
+	steps []Processor
+}
+
+// NewPipeline orders the available processors by names, which the config
+// has already validated.
+func NewPipeline(names []string, available []Processor) *Pipeline {
+	p := &Pipeline{}
+	for _, name := range names {
+		i := slices.IndexFunc(available, func(s Processor) bool { return s.Name() == name })
+		if i >= 0 {
+			p.steps = append(p.steps, available[i])
+		}
+	}
+	return p
+}
+
+// Run passes c through each step until one drops it.
+func (p *Pipeline) Run(c *Candidate) (outcome string, drop bool) {
+	for _, step := range p.steps {
+		if outcome, drop := step.Process(c); drop {
+			return outcome, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,330 @@
+// Package dedupe suppresses repeats of the same error, by fingerprint,
+// within a cooldown.
+package dedupe
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+// FileName is where a persisted cache is kept when no path is given.
+const FileName = "lacia.dedupe"
+
+const (
+	dedupeMaxEntries = 1000
+	dedupeSaveEvery  = 10 * time.Second
+	defaultCooldown  = 30 * time.Second
+	defaultDedupeTTL = time.Hour
+)
+
+type Config struct {
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	Persist     bool            `json:"persist"`
+	Path        string          `json:"path,omitempty"`
+	MaxEntries  int             `json:"max_entries,omitempty"`
+	Cooldown    detect.Duration `json:"cooldown,omitempty"`
+	TTL         detect.Duration `json:"ttl,omitempty"`
+	// Aggregate counts the duplicates within each cooldown and sends them as
+	// one update incident instead of dropping them
+	Aggregate bool `json:"aggregate,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.MaxEntries < 0 {
+		return errors.New("max_entries must be positive")
+	}
+	if c.Cooldown < 0 || c.TTL < 0 {
+		return errors.New("cooldown and ttl must be positive")
+	}
+	if c.TTL > 0 && c.TTL < c.Cooldown {
+		return errors.New("ttl must not be shorter than cooldown")
+	}
+	if !detect.ValidFingerprintStrategy(c.Fingerprint) {
+		return fmt.Errorf("unknown fingerprint strategy %q", c.Fingerprint)
+	}
+	return nil
+}
+
+type dedupeEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	LastSent    time.Time `json:"last_sent"`
+	FirstSeen   time.Time `json:"first_seen"`
+
+	// Duplicates since LastSent, when aggregating
+	count    int
+	lastSeen time.Time
+	latest   detect.Event
+}
+
+// Deduper remembers when each error fingerprint was last sent and
+// suppresses repeats within the cooldown. Entries are kept in LRU order and
+// optionally persisted so restarts don't re-send recent errors.
+type Deduper struct {
+	mu         sync.Mutex
+	strategy   string
+	aggregate  bool
+	cooldown   time.Duration
+	ttl        time.Duration
+	maxEntries int
+	path       string
+	order      *list.List
+	entries    map[string]*list.Element
+	dirty      bool
+	duplicates int64
+}
+
+// New returns a deduper with the given settings, or the defaults for a nil
+// cfg, loading the persisted cache if there is one.
+func New(cfg *Config) (*Deduper, error) {
+	d := &Deduper{
+		cooldown:   defaultCooldown,
+		ttl:        defaultDedupeTTL,
+		maxEntries: dedupeMaxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+	if cfg == nil {
+		return d, nil
+	}
+
+	if cfg.MaxEntries > 0 {
+		d.maxEntries = cfg.MaxEntries
+	}
+	d.strategy = cfg.Fingerprint
+	d.aggregate = cfg.Aggregate
+	if cfg.Cooldown > 0 {
+		d.cooldown = time.Duration(cfg.Cooldown)
+	}
+	if cfg.TTL > 0 {
+		d.ttl = time.Duration(cfg.TTL)
+	}
+	d.ttl = max(d.ttl, d.cooldown)
+	if cfg.Persist {
+		d.path = cmp.Or(cfg.Path, FileName)
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// IsDuplicate reports whether event repeats one sent within the cooldown.
+// When aggregating, an event sent after the cooldown but before the update
+// for its duplicates went out carries their count instead.
+func (d *Deduper) IsDuplicate(event *detect.Event) bool {
+	// Updates are the deduper's own output
+	if event.Update {
+		return false
+	}
+	fingerprint := detect.Fingerprint(*event, d.strategy)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[fingerprint]; ok {
+		entry := elem.Value.(*dedupeEntry)
+		if now.Sub(entry.LastSent) < d.cooldown {
+			d.duplicates++
+			if d.aggregate {
+				entry.count++
+				entry.lastSeen = now
+				entry.latest = *event
+			}
+			slog.Debug("skipping duplicate error", "fingerprint", fingerprint, "cooldown", d.cooldown.String())
+			return true
+		}
+		if entry.count > 0 {
+			event.Occurrences = entry.count + 1
+			event.FirstSeen = entry.FirstSeen
+			event.LastSeen = now
+			entry.count = 0
+		}
+		entry.LastSent = now
+		d.order.MoveToFront(elem)
+		d.dirty = true
+		return false
+	}
+
+	d.entries[fingerprint] = d.order.PushFront(&dedupeEntry{Fingerprint: fingerprint, LastSent: now, FirstSeen: now})
+	d.evictOverflow()
+	d.dirty = true
+	return false
+}
+
+// Strategy returns the fingerprint strategy duplicates are found by.
+func (d *Deduper) Strategy() string {
+	return d.strategy
+}
+
+// DisableSave keeps the persisted cache as it is: it was read, but is not
+// written back, e.g. so a dry run does not hide errors from the next run.
+// Call it before Run.
+func (d *Deduper) DisableSave() {
+	d.path = ""
+}
+
+// Duplicates returns how many events have been suppressed.
+func (d *Deduper) Duplicates() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.duplicates
+}
+
+// Run periodically evicts expired entries and saves the cache until ctx is
+// done. When aggregating, it also emits an update for each fingerprint
+// whose cooldown ended with duplicates, and on shutdown for all of them.
+func (d *Deduper) Run(ctx context.Context, events chan<- detect.Event) {
+	interval := dedupeSaveEvery
+	if d.aggregate {
+		interval = min(interval, d.cooldown)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.flush(events, time.Time{})
+			return
+		case now := <-ticker.C:
+			d.flush(events, now)
+			d.evictExpired(now)
+			if err := d.Save(); err != nil {
+				slog.Error("save dedupe cache failed", "err", err)
+			}
+		}
+	}
+}
+
+// flush emits updates for the duplicates whose cooldown ended before now,
+// or for all of them with a zero now. The next cooldown starts with the
+// update.
+func (d *Deduper) flush(events chan<- detect.Event, now time.Time) {
+	if !d.aggregate || events == nil {
+		return
+	}
+	d.mu.Lock()
+	var updates []detect.Event
+	for elem := d.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*dedupeEntry)
+		if entry.count == 0 || (!now.IsZero() && now.Sub(entry.LastSent) < d.cooldown) {
+			continue
+		}
+		updates = append(updates, updateEvent(entry))
+		entry.count = 0
+		entry.LastSent = time.Now()
+		d.dirty = true
+	}
+	d.mu.Unlock()
+
+	for _, event := range updates {
+		events <- event
+	}
+}
+
+// updateEvent stands for the duplicates of entry since it was last sent.
+func updateEvent(entry *dedupeEntry) detect.Event {
+	event := entry.latest
+	event.Occurrences = entry.count
+	event.FirstSeen = entry.FirstSeen
+	event.LastSeen = entry.lastSeen
+	event.Timestamp = entry.lastSeen.UTC()
+	event.Update = true
+	return event
+}
+
+// evictExpired drops entries not sent within the TTL. The list is ordered
+// by last send, so expired entries are all at the back.
+func (d *Deduper) evictExpired(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for elem := d.order.Back(); elem != nil; elem = d.order.Back() {
+		entry := elem.Value.(*dedupeEntry)
+		if now.Sub(entry.LastSent) < d.ttl {
+			break
+		}
+		delete(d.entries, entry.Fingerprint)
+		d.order.Remove(elem)
+		d.dirty = true
+	}
+}
+
+func (d *Deduper) Save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	entries := make([]dedupeEntry, 0, d.order.Len())
+	for elem := d.order.Back(); elem != nil; elem = elem.Prev() {
+		entries = append(entries, *elem.Value.(*dedupeEntry))
+	}
+	d.dirty = false
+	d.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+func (d *Deduper) load() error {
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []dedupeEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid dedupe cache %s: %w", d.path, err)
+	}
+
+	// Entries are stored oldest first
+	for i := range entries {
+		if time.Since(entries[i].LastSent) >= d.ttl {
+			continue
+		}
+		entry := entries[i]
+		// Caches saved before first_seen was recorded
+		if entry.FirstSeen.IsZero() {
+			entry.FirstSeen = entry.LastSent
+		}
+		d.entries[entry.Fingerprint] = d.order.PushFront(&entry)
+	}
+	d.evictOverflow()
+	return nil
+}
+
+// evictOverflow drops least recently sent entries beyond maxEntries.
+// Callers must hold d.mu.
+func (d *Deduper) evictOverflow() {
+	for d.order.Len() > d.maxEntries {
+		oldest := d.order.Back()
+		delete(d.entries, oldest.Value.(*dedupeEntry).Fingerprint)
+		d.order.Remove(oldest)
+	}
+}
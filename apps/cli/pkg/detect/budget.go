@@ -0,0 +1,43 @@
+package detect
+
+import "sync/atomic"
+
+// TraceBudget is memory shared by the traces every watcher is putting
+// together, so many inputs cannot between them hold more than one budget
+// however many there are. A nil budget has no limit.
+type TraceBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewTraceBudget returns a budget of limit bytes, or nil for no limit.
+func NewTraceBudget(limit int) *TraceBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &TraceBudget{limit: int64(limit)}
+}
+
+// Take counts n more bytes held by a trace and reports whether they still
+// fit. They are counted either way, until given back with Release.
+func (b *TraceBudget) Take(n int) bool {
+	if b == nil {
+		return true
+	}
+	return b.used.Add(int64(n)) <= b.limit
+}
+
+// Release gives back n bytes of a trace that was sent or dropped.
+func (b *TraceBudget) Release(n int) {
+	if b != nil {
+		b.used.Add(-int64(n))
+	}
+}
+
+// Used returns the bytes held by traces in progress.
+func (b *TraceBudget) Used() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.used.Load())
+}
@@ -0,0 +1,45 @@
+package detect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssemblerTraceBudget(t *testing.T) {
+	d, err := NewDetector(&PatternsConfig{Multiline: &MultilineConfig{
+		Formats: []MultilineFormat{{Name: "java", Start: `Exception`, Continuation: []string{`^\s+at `}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := "\tat app.Main.run(Main.java:1)"
+	budget := NewTraceBudget(10 * (len(frame) + LineOverhead))
+	first, second := d.NewAssembler(0, budget), d.NewAssembler(0, budget)
+	now := time.Now()
+
+	var n int64
+	add := func(a *Assembler, line string) []*TraceGroup {
+		n++
+		return a.Add(line, n, false, now)
+	}
+	add(first, "IllegalStateException: first")
+	for range 6 {
+		if closed := add(first, frame); len(closed) != 0 {
+			t.Fatal("a group within the budget was closed")
+		}
+	}
+	add(second, "IllegalStateException: second")
+	var closed []*TraceGroup
+	for i := 0; i < 6 && len(closed) == 0; i++ {
+		closed = add(second, frame)
+	}
+	if len(closed) != 1 || len(closed[0].Lines) >= 7 {
+		t.Fatalf("the second group was not closed at the shared budget: %v", closed)
+	}
+
+	first.Flush()
+	second.Flush()
+	if used := budget.Used(); used != 0 {
+		t.Fatalf("budget.Used() = %d once every group closed, want 0", used)
+	}
+}
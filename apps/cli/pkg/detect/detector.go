@@ -0,0 +1,229 @@
+// Package detect finds the errors in log lines and groups the stack traces
+// around them into events.
+package detect
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var errorPatterns = []string{
+	// Severity levels
+	"ERROR", "FATAL", "CRITICAL", "SEVERE", "EMERGENCY",
+
+	// Generic exceptions
+	"Exception", "panic", "Traceback", "Uncaught",
+
+	// Stack trace indicators
+	"Caused by:", "Stack trace:", "Stacktrace:",
+	"at com.", "at org.", "at java.", "at sun.",
+	"goroutine", "runtime error:",
+
+	// Python
+	"raise ", "AssertionError", "AttributeError", "ImportError",
+	"KeyError", "ValueError", "IndentationError",
+
+	// JavaScript/Node.js
+	"TypeError", "ReferenceError", "SyntaxError", "RangeError",
+	"UnhandledPromiseRejection", "ECONNREFUSED", "ENOTFOUND",
+
+	// Java/Kotlin/JVM
+	"NullPointerException", "ClassNotFoundException",
+	"OutOfMemoryError", "StackOverflowError",
+
+	// Ruby
+	"RuntimeError", "NoMethodError", "undefined method",
+
+	// Rust
+	"thread 'main' panicked", "thread 'tokio' panicked",
+
+	// PHP
+	"Fatal error:", "Parse error:", "Warning:",
+
+	// C#/.NET
+	"Unhandled exception", "System.Exception", "System.NullReferenceException",
+
+	// System/OS level
+	"Segmentation fault", "core dumped", "SIGSEGV", "SIGABRT",
+	"killed", "OOM",
+
+	// HTTP/API failures
+	"500 Internal Server Error", "502 Bad Gateway",
+	"503 Service Unavailable", "504 Gateway Timeout",
+
+	// Database
+	"deadlock", "connection refused", "connection timed out",
+}
+
+var traceStartMarkers = []string{
+	"Traceback", "Exception in thread", "goroutine",
+	"panic:", "Error:", "ERROR:", "FATAL:",
+	"Caused by:", "Stack trace:", "Stacktrace:",
+	"Unhandled", "Thread", "Process",
+}
+
+var traceContMarkers = []string{
+	"at ", "    at ", "\tat ",
+	"File \"", "  File \"",
+	"    ", "\t",
+	"^",
+	"...",
+}
+
+type PatternsConfig struct {
+	Include        []string         `json:"include,omitempty"`
+	Exclude        []string         `json:"exclude,omitempty"`
+	Continuation   []string         `json:"continuation,omitempty"`
+	DisableBuiltin bool             `json:"disable_builtin,omitempty"`
+	Multiline      *MultilineConfig `json:"multiline,omitempty"`
+}
+
+// Detector decides which log lines are errors and how stack traces are
+// grouped, combining the built-in patterns with user supplied regexes.
+type Detector struct {
+	builtin      bool
+	include      []*regexp.Regexp
+	exclude      []*regexp.Regexp
+	continuation []*regexp.Regexp
+	multiline    *multilineRules
+	// Plugins are asked about the lines nothing else matched
+	Plugins []Matcher
+	// Workers, when set, detect batches of lines in parallel
+	Workers *Pool
+}
+
+// Matcher decides whether a line is an error, e.g. a detection plugin.
+type Matcher interface {
+	IsError(line string) bool
+}
+
+func NewDetector(cfg *PatternsConfig) (*Detector, error) {
+	d := &Detector{builtin: true}
+	if cfg == nil {
+		return d, nil
+	}
+
+	var err error
+	d.builtin = !cfg.DisableBuiltin
+	if d.include, err = compilePatterns("include", cfg.Include); err != nil {
+		return nil, err
+	}
+	if d.exclude, err = compilePatterns("exclude", cfg.Exclude); err != nil {
+		return nil, err
+	}
+	if d.continuation, err = compilePatterns("continuation", cfg.Continuation); err != nil {
+		return nil, err
+	}
+	if cfg.Multiline != nil {
+		if d.multiline, err = compileMultiline(cfg.Multiline); err != nil {
+			return nil, fmt.Errorf("multiline: %w", err)
+		}
+	}
+	return d, nil
+}
+
+func compilePatterns(field string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s pattern %q: %w", field, p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchAny tries each pattern in turn. Joining them into one alternation
+// looks cheaper but runs slower with Go's regexp engine, which loses the
+// literal prefix scans of the individual patterns.
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Detector) IsError(line string) bool {
+	if matchAny(d.exclude, line) {
+		return false
+	}
+	if d.builtin && isBuiltinError(line) {
+		return true
+	}
+	if matchAny(d.include, line) {
+		return true
+	}
+	for _, p := range d.Plugins {
+		if p.IsError(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTraceStart reports whether a trace may begin at line: one with a
+// built-in start marker, or matching an include pattern or a multiline
+// format's start.
+func (d *Detector) IsTraceStart(line string) bool {
+	if traceStarts.Match(line) || matchAny(d.include, line) {
+		return true
+	}
+	if d.multiline != nil {
+		for _, f := range d.multiline.formats {
+			if f.start.MatchString(line) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Detector) IsTraceContinuation(line string) bool {
+	for _, marker := range traceContMarkers {
+		if strings.HasPrefix(line, marker) {
+			return true
+		}
+	}
+	if matchAny(d.continuation, line) {
+		return true
+	}
+	return d.IsError(line)
+}
+
+// NewAssembler returns a multiline assembler for one input, or nil when the
+// built-in trace grouping is used. maxBytes, when set, closes groups whose
+// lines take up that much, and budget groups that no longer fit in it.
+func (d *Detector) NewAssembler(maxBytes int, budget *TraceBudget) *Assembler {
+	if d.multiline == nil {
+		return nil
+	}
+	a := newAssembler(d.multiline, d)
+	a.maxBytes = maxBytes
+	a.budget = budget
+	return a
+}
+
+// isFrameLine reports whether a trimmed line looks like a stack frame rather
+// than a message.
+func isFrameLine(line string) bool {
+	for _, marker := range traceContMarkers {
+		if strings.TrimSpace(marker) != "" && strings.HasPrefix(line, strings.TrimSpace(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	builtinErrors = newKeywordMatcher(errorPatterns, true)
+	traceStarts   = newKeywordMatcher(traceStartMarkers, false)
+)
+
+// isBuiltinError matches the built-in patterns ignoring ASCII case.
+func isBuiltinError(line string) bool {
+	return builtinErrors.Match(line)
+}
@@ -0,0 +1,29 @@
+package detect
+
+import "testing"
+
+func TestIsTraceStart(t *testing.T) {
+	d, err := NewDetector(&PatternsConfig{
+		Include: []string{`^E\d{4} `},
+		Multiline: &MultilineConfig{Formats: []MultilineFormat{
+			{Name: "rails", Start: `^Started (GET|POST) `},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"Traceback (most recent call last):", true},
+		{"E0117 12:00:00 handler failed", true},
+		{"Started GET /orders", true},
+		{"request served in 12ms", false},
+	}
+	for _, tt := range tests {
+		if got := d.IsTraceStart(tt.line); got != tt.want {
+			t.Errorf("IsTraceStart(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
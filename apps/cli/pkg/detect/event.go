@@ -0,0 +1,64 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event is an error found in a log, with the trace and the lines around it
+// as its context.
+type Event struct {
+	Line      string
+	Timestamp time.Time
+	Context   []string
+	Target    string
+	RepoURL   string
+	RepoPath  string
+	// Source is the file or stream the event was read from
+	Source string
+	// Occurrences is set on events that stand for several collapsed or
+	// sampled ones
+	Occurrences int
+	// LogTime is the timestamp read from the event's lines, unlike
+	// Timestamp, which is when the agent read them
+	LogTime time.Time
+	// FirstSeen and LastSeen are set on events that stand for duplicates
+	// aggregated over time
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// LineNumber is where the incident starts, counted from where the
+	// watcher started reading the file
+	LineNumber int64
+	// Truncated is set when a line of the event was cut at the maximum
+	// line length
+	Truncated bool
+	// Storm is set on the summary of an error storm, and Update on the
+	// count of duplicates sent after the event they repeat
+	Storm  bool
+	Update bool
+}
+
+// LineOverhead is roughly what holding a line costs beyond its text, for
+// keeping traces within a memory budget.
+const LineOverhead = 16
+
+// Duration is a time.Duration written as a string like "30s" in configs.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,128 @@
+package detect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Fingerprint strategies select which parts of an event identify it.
+// Every strategy except FingerprintTrace normalizes volatile tokens first.
+const (
+	FingerprintFrame      = "frame"      // exception type plus the top in-app frame
+	FingerprintHead       = "head"       // error line plus the first context lines
+	FingerprintLine       = "line"       // error line only
+	FingerprintTrace      = "trace"      // error line plus the whole trace, verbatim
+	FingerprintNormalized = "normalized" // error line plus the whole trace
+)
+
+// volatilePatterns replace tokens that differ between occurrences of the
+// same error. Order matters: broader shapes must run before plain numbers.
+var volatilePatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<ts>"},
+	{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(\.\d+)?\b`), "<ts>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), "<addr>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b|\b[0-9a-f]*[a-f][0-9a-f]*\d[0-9a-f]*\b`), "<hex>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+}
+
+// ValidFingerprintStrategy reports whether strategy is one of the above, or
+// empty for the default.
+func ValidFingerprintStrategy(strategy string) bool {
+	switch strategy {
+	case "", FingerprintFrame, FingerprintHead, FingerprintLine, FingerprintTrace, FingerprintNormalized:
+		return true
+	}
+	return false
+}
+
+// Fingerprint hashes the parts of event selected by strategy. The default
+// is FingerprintFrame, which falls back to FingerprintHead for errors
+// without a stack trace.
+func Fingerprint(event Event, strategy string) string {
+	var data string
+	switch strategy {
+	case "", FingerprintFrame:
+		data = frameSignature(event)
+		if data == "" {
+			data = headData(event)
+		}
+	case FingerprintLine:
+		data = event.Line
+	case FingerprintTrace:
+		data = event.Line + strings.Join(event.Context, "\n")
+	case FingerprintNormalized:
+		data = event.Line + strings.Join(event.Context, "\n")
+	default:
+		data = headData(event)
+	}
+	if strategy != FingerprintTrace {
+		data = normalizeForFingerprint(data)
+	}
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:8]) // First 8 bytes for shorter hash
+}
+
+// headData is the error line and first few context lines.
+func headData(event Event) string {
+	data := event.Line
+	if len(event.Context) > 3 {
+		for i := 0; i < 3; i++ {
+			data += event.Context[i]
+		}
+	}
+	return data
+}
+
+// libraryFrame matches frames in the runtime, standard library or
+// dependencies, which say little about where a bug is.
+var libraryFrame = regexp.MustCompile(`site-packages/|dist-packages/|node_modules/|^node:|^internal/|<frozen |/go/src/|/pkg/mod/|/vendor/|/rustc/|\.cargo/registry/|^(?:java|javax|jdk|sun|kotlin|scala)\.|^(?:System|Microsoft)\.`)
+
+// InApp reports whether f is in the application's own code rather than the
+// runtime, standard library or a dependency.
+func (f StackFrame) InApp() bool {
+	return !libraryFrame.MatchString(f.File) && !libraryFrame.MatchString(f.Function)
+}
+
+// frameSignature identifies an error by its exception type and the top
+// in-app frame's file and function, leaving out line numbers and messages
+// so the same bug groups together across requests and deploys. It returns
+// "" when the event has no stack trace.
+func frameSignature(event Event) string {
+	_, frames := ParseStackTrace(event.Context)
+	if len(frames) == 0 {
+		return ""
+	}
+	top := frames[0]
+	for _, f := range frames {
+		if f.InApp() {
+			top = f
+			break
+		}
+	}
+
+	// Without a recognizable exception the error line stands in for its type
+	exceptionType := event.Line
+	for _, line := range append([]string{event.Line}, event.Context...) {
+		if m := exceptionLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			exceptionType = m[1]
+			break
+		}
+	}
+	return exceptionType + "\n" + top.File + ":" + top.Function
+}
+
+// normalizeForFingerprint replaces timestamps, UUIDs, memory addresses,
+// IPs and ports, hex IDs and numbers with placeholders.
+func normalizeForFingerprint(s string) string {
+	for _, p := range volatilePatterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
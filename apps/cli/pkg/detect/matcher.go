@@ -0,0 +1,104 @@
+package detect
+
+// keywordMatcher finds any of a fixed set of keywords in a line in one pass,
+// using an Aho-Corasick automaton compiled to a state table. Matching does
+// not allocate, which matters since every line read is checked.
+type keywordMatcher struct {
+	// class maps each byte to its column in next; bytes that appear in no
+	// keyword share column 0.
+	class   [256]uint8
+	classes int
+	next    []int32
+	final   []bool
+}
+
+// newKeywordMatcher compiles keywords. With fold, ASCII letters match
+// regardless of case.
+func newKeywordMatcher(keywords []string, fold bool) *keywordMatcher {
+	m := &keywordMatcher{classes: 1}
+	for _, k := range keywords {
+		for i := 0; i < len(k); i++ {
+			b := k[i]
+			if fold {
+				b = upperASCII(b)
+			}
+			if m.class[b] == 0 {
+				m.class[b] = uint8(m.classes)
+				m.classes++
+			}
+		}
+	}
+	if fold {
+		for b := 'a'; b <= 'z'; b++ {
+			m.class[b] = m.class[b-'a'+'A']
+		}
+	}
+
+	// Build the trie, with -1 for missing edges
+	m.next = make([]int32, m.classes)
+	m.final = []bool{false}
+	for i := range m.next {
+		m.next[i] = -1
+	}
+	for _, k := range keywords {
+		state := int32(0)
+		for i := 0; i < len(k); i++ {
+			c := int32(m.class[k[i]])
+			if m.next[state*int32(m.classes)+c] < 0 {
+				m.next[state*int32(m.classes)+c] = int32(len(m.final))
+				m.final = append(m.final, false)
+				for range m.classes {
+					m.next = append(m.next, -1)
+				}
+			}
+			state = m.next[state*int32(m.classes)+c]
+		}
+		m.final[state] = true
+	}
+
+	// Fill in the missing edges from the failure links, breadth first, so
+	// matching is a single table lookup per byte.
+	fail := make([]int32, len(m.final))
+	queue := make([]int32, 0, len(m.final))
+	for c := range m.classes {
+		if s := m.next[c]; s > 0 {
+			queue = append(queue, s)
+		} else {
+			m.next[c] = 0
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.final[state] = m.final[state] || m.final[fail[state]]
+		for c := range m.classes {
+			i := int(state)*m.classes + c
+			if s := m.next[i]; s >= 0 {
+				fail[s] = m.next[int(fail[state])*m.classes+c]
+				queue = append(queue, s)
+			} else {
+				m.next[i] = m.next[int(fail[state])*m.classes+c]
+			}
+		}
+	}
+	return m
+}
+
+// Match reports whether line contains any of the keywords.
+func (m *keywordMatcher) Match(line string) bool {
+	state := int32(0)
+	for i := 0; i < len(line); i++ {
+		state = m.next[int(state)*m.classes+int(m.class[line[i]])]
+		if m.final[state] {
+			return true
+		}
+	}
+	return false
+}
+
+func upperASCII(b byte) byte {
+	if 'a' <= b && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
@@ -0,0 +1,289 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMultilineMaxLines    = 500
+	defaultMultilineTimeout     = time.Second
+	defaultMultilineMaxDuration = 10 * time.Second
+)
+
+// MultilineConfig replaces the built-in trace grouping with a per-thread
+// assembler. Formats are tried in order; a line that matches no format's
+// start but is an error opens a group that uses the built-in continuation
+// markers.
+type MultilineConfig struct {
+	Formats     []MultilineFormat `json:"formats,omitempty"`
+	Thread      string            `json:"thread,omitempty"`
+	MaxLines    int               `json:"max_lines,omitempty"`
+	Timeout     Duration          `json:"timeout,omitempty"`
+	MaxDuration Duration          `json:"max_duration,omitempty"`
+}
+
+type MultilineFormat struct {
+	Name         string   `json:"name"`
+	Start        string   `json:"start"`
+	Continuation []string `json:"continuation"`
+}
+
+func (c *MultilineConfig) Validate() error {
+	_, err := compileMultiline(c)
+	return err
+}
+
+type multilineFormat struct {
+	name         string
+	start        *regexp.Regexp
+	continuation []*regexp.Regexp
+}
+
+type multilineRules struct {
+	formats     []multilineFormat
+	thread      *regexp.Regexp
+	maxLines    int
+	timeout     time.Duration
+	maxDuration time.Duration
+}
+
+func compileMultiline(c *MultilineConfig) (*multilineRules, error) {
+	if c.MaxLines < 0 {
+		return nil, errors.New("max_lines must be positive")
+	}
+	if c.Timeout < 0 || c.MaxDuration < 0 {
+		return nil, errors.New("timeout and max_duration must be positive")
+	}
+
+	rules := &multilineRules{
+		maxLines:    c.MaxLines,
+		timeout:     time.Duration(c.Timeout),
+		maxDuration: time.Duration(c.MaxDuration),
+	}
+	if rules.maxLines == 0 {
+		rules.maxLines = defaultMultilineMaxLines
+	}
+	if rules.timeout == 0 {
+		rules.timeout = defaultMultilineTimeout
+	}
+	if rules.maxDuration == 0 {
+		rules.maxDuration = defaultMultilineMaxDuration
+	}
+
+	if c.Thread != "" {
+		thread, err := regexp.Compile(c.Thread)
+		if err != nil {
+			return nil, fmt.Errorf("thread pattern: %w", err)
+		}
+		if thread.NumSubexp() == 0 {
+			return nil, errors.New("thread pattern needs a capture group for the thread ID")
+		}
+		rules.thread = thread
+	}
+
+	for i, f := range c.Formats {
+		if f.Start == "" {
+			return nil, fmt.Errorf("formats[%d]: start is required", i)
+		}
+		start, err := regexp.Compile(f.Start)
+		if err != nil {
+			return nil, fmt.Errorf("formats[%d]: start: %w", i, err)
+		}
+		continuation, err := compilePatterns(fmt.Sprintf("formats[%d]: continuation", i), f.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		rules.formats = append(rules.formats, multilineFormat{
+			name:         f.Name,
+			start:        start,
+			continuation: continuation,
+		})
+	}
+	return rules, nil
+}
+
+// TraceGroup is a trace put together by an Assembler.
+type TraceGroup struct {
+	Lines []string
+	// LineNumber is where the trace starts in its input
+	LineNumber int64
+
+	thread   string
+	format   *multilineFormat
+	size     int
+	hasError bool
+	started  time.Time
+	last     time.Time
+}
+
+// Reportable reports whether the group is an incident: it has an error, or
+// it started like one of the configured formats.
+func (g *TraceGroup) Reportable() bool {
+	return g.hasError || g.format != nil
+}
+
+// Assembler groups multi-line traces separately per thread, so traces from
+// threads logging at the same time are not merged. Lines without a thread ID
+// (stack frames, usually) belong to the thread of the line before them.
+type Assembler struct {
+	rules      *multilineRules
+	detector   *Detector
+	open       map[string]*TraceGroup
+	lastThread string
+	// maxBytes, when set, closes groups whose lines take up that much, and
+	// budget those whose lines no longer fit in what all inputs share
+	maxBytes int
+	budget   *TraceBudget
+}
+
+func newAssembler(rules *multilineRules, detector *Detector) *Assembler {
+	return &Assembler{
+		rules:    rules,
+		detector: detector,
+		open:     make(map[string]*TraceGroup),
+	}
+}
+
+// Add feeds one raw line, the lineNumber-th of its file, and returns any
+// groups it completed.
+func (a *Assembler) Add(raw string, lineNumber int64, isError bool, now time.Time) []*TraceGroup {
+	thread := a.threadOf(raw)
+	a.lastThread = thread
+	line := strings.TrimSpace(raw)
+
+	var closed []*TraceGroup
+	if g := a.open[thread]; g != nil {
+		if a.continues(g, raw, line) {
+			g.Lines = append(g.Lines, line)
+			fits := a.grow(g, len(line)+LineOverhead)
+			g.hasError = g.hasError || isError
+			g.last = now
+			if len(g.Lines) >= a.rules.maxLines || (a.maxBytes > 0 && g.size >= a.maxBytes) || !fits {
+				closed = append(closed, a.close(thread, g))
+			}
+			return closed
+		}
+		closed = append(closed, a.close(thread, g))
+	}
+
+	if format, ok := a.startFormat(raw, isError); ok {
+		g := &TraceGroup{
+			thread:     thread,
+			format:     format,
+			Lines:      []string{line},
+			LineNumber: lineNumber,
+			hasError:   isError,
+			started:    now,
+			last:       now,
+		}
+		if a.grow(g, len(line)+LineOverhead) {
+			a.open[thread] = g
+		} else {
+			closed = append(closed, a.close(thread, g))
+		}
+	}
+	return closed
+}
+
+// grow counts n more bytes of g and reports whether they fit the budget.
+func (a *Assembler) grow(g *TraceGroup, n int) bool {
+	g.size += n
+	return a.budget.Take(n)
+}
+
+// close stops assembling g and gives its bytes back to the budget.
+func (a *Assembler) close(thread string, g *TraceGroup) *TraceGroup {
+	delete(a.open, thread)
+	a.budget.Release(g.size)
+	return g
+}
+
+// Expire returns groups that have been quiet for the timeout or open for
+// longer than max_duration.
+func (a *Assembler) Expire(now time.Time) []*TraceGroup {
+	var closed []*TraceGroup
+	for thread, g := range a.open {
+		if now.Sub(g.last) >= a.rules.timeout || now.Sub(g.started) >= a.rules.maxDuration {
+			closed = append(closed, a.close(thread, g))
+		}
+	}
+	return closed
+}
+
+// Flush returns every open group, e.g. when the input ends.
+func (a *Assembler) Flush() []*TraceGroup {
+	closed := make([]*TraceGroup, 0, len(a.open))
+	for thread, g := range a.open {
+		closed = append(closed, a.close(thread, g))
+	}
+	return closed
+}
+
+// NextDeadline returns when the next open group expires, or the zero time.
+func (a *Assembler) NextDeadline() time.Time {
+	var next time.Time
+	for _, g := range a.open {
+		deadline := g.last.Add(a.rules.timeout)
+		if limit := g.started.Add(a.rules.maxDuration); limit.Before(deadline) {
+			deadline = limit
+		}
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next
+}
+
+func (a *Assembler) threadOf(raw string) string {
+	if a.rules.thread == nil {
+		return ""
+	}
+	m := a.rules.thread.FindStringSubmatch(raw)
+	if m == nil {
+		return a.lastThread
+	}
+	if i := a.rules.thread.SubexpIndex("thread"); i > 0 {
+		return m[i]
+	}
+	return m[1]
+}
+
+func (a *Assembler) startFormat(raw string, isError bool) (*multilineFormat, bool) {
+	for i := range a.rules.formats {
+		if a.rules.formats[i].start.MatchString(raw) {
+			return &a.rules.formats[i], true
+		}
+	}
+	return nil, isError
+}
+
+func (a *Assembler) continues(g *TraceGroup, raw, line string) bool {
+	if g.format != nil {
+		return matchAny(g.format.continuation, raw)
+	}
+	return a.detector.IsTraceContinuation(line)
+}
+
+// ErrorLine picks the line that best describes the group: the last error
+// that is not itself a continuation (e.g. Python's final exception line),
+// falling back to the first line.
+func (a *Assembler) ErrorLine(g *TraceGroup) string {
+	for i := len(g.Lines) - 1; i > 0; i-- {
+		line := g.Lines[i]
+		if !a.detector.IsError(line) {
+			continue
+		}
+		if g.format != nil && matchAny(g.format.continuation, line) {
+			continue
+		}
+		if g.format == nil && isFrameLine(line) {
+			continue
+		}
+		return line
+	}
+	return g.Lines[0]
+}
@@ -0,0 +1,57 @@
+package detect
+
+import "sync"
+
+// MinChunk keeps small batches from being split into chunks that cost
+// more to hand over than to detect
+const MinChunk = 64
+
+// Pool is a fixed set of goroutines, shared by all watchers, that
+// decode and detect the lines of a batch in parallel. Watchers still group
+// the results into traces themselves, in the order the lines were read.
+type Pool struct {
+	workers int
+	jobs    chan func()
+}
+
+// NewPool starts workers goroutines, or returns nil for fewer than
+// two, in which case watchers detect lines themselves.
+func NewPool(workers int) *Pool {
+	if workers < 2 {
+		return nil
+	}
+	p := &Pool{workers: workers, jobs: make(chan func())}
+	for range workers {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Each calls fn for 0 to n-1, split into a chunk per worker, and returns
+// once all are done.
+func (p *Pool) Each(n int, fn func(i int)) {
+	size := max((n+p.workers-1)/p.workers, MinChunk)
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		end := min(start+size, n)
+		wg.Add(1)
+		p.jobs <- func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// Close stops the workers once they finish their current jobs.
+func (p *Pool) Close() {
+	if p != nil {
+		close(p.jobs)
+	}
+}
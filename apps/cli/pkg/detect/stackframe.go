@@ -0,0 +1,171 @@
+package detect
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is one frame of a parsed stack trace.
+type StackFrame struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+var (
+	pythonFrame = regexp.MustCompile(`^File "([^"]+)", line (\d+)(?:, in (.+))?`)
+	javaFrame   = regexp.MustCompile(`^at ([\w$.<>/]+)\(([^:()]+\.(?:java|kt|scala|groovy|clj))(?::(\d+))?\)`)
+	nodeFrame   = regexp.MustCompile(`^at (?:(?:async )?(.+?) \()?((?:file://)?[^()\s]+\.(?:js|mjs|cjs|ts|jsx|tsx)):(\d+):\d+\)?$`)
+	goFile      = regexp.MustCompile(`^(\S+\.go):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	goFunc      = regexp.MustCompile(`^([\w./*()-]+?)\(.*\)$`)
+	rustFrame   = regexp.MustCompile(`^at (\S+\.rs):(\d+)(?::\d+)?$`)
+	rustFunc    = regexp.MustCompile(`^\d+: (\S+)`)
+	rustPanic   = regexp.MustCompile(`panicked at (?:'.*', )?(\S+\.rs):(\d+)(?::\d+)?`)
+	dotnetFrame = regexp.MustCompile(`^at (.+?\(.*?\))(?: in (.+):line (\d+))?$`)
+)
+
+type stackParser struct {
+	language string
+	parse    func(lines []string) []StackFrame
+}
+
+// stackParsers are tried in order; the one that finds the most frames wins,
+// so ties go to the more specific formats listed first.
+var stackParsers = []stackParser{
+	{"python", parsePythonFrames},
+	{"java", parseJavaFrames},
+	{"node", parseNodeFrames},
+	{"go", parseGoFrames},
+	{"rust", parseRustFrames},
+	{"dotnet", parseDotnetFrames},
+}
+
+// ParseStackTrace detects the language of a trace and extracts its frames,
+// innermost (where the error was raised) first. It returns an empty language
+// when no frames are recognized.
+func ParseStackTrace(lines []string) (string, []StackFrame) {
+	var language string
+	var best []StackFrame
+	for _, p := range stackParsers {
+		if frames := p.parse(lines); len(frames) > len(best) {
+			language, best = p.language, frames
+		}
+	}
+	return language, best
+}
+
+func parsePythonFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range lines {
+		m := pythonFrame.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		frames = append(frames, StackFrame{File: m[1], Line: atoi(m[2]), Function: m[3]})
+	}
+	// Python prints the most recent call last
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+func parseJavaFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range lines {
+		m := javaFrame.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		frames = append(frames, StackFrame{File: m[2], Line: atoi(m[3]), Function: m[1]})
+	}
+	return frames
+}
+
+func parseNodeFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range lines {
+		m := nodeFrame.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		frames = append(frames, StackFrame{File: m[2], Line: atoi(m[3]), Function: m[1]})
+	}
+	return frames
+}
+
+// parseGoFrames reads panic output, where each frame is a function call line
+// followed by its file:line.
+func parseGoFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for i, line := range lines {
+		m := goFile.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		frame := StackFrame{File: m[1], Line: atoi(m[2])}
+		if i > 0 {
+			if fn := goFunc.FindStringSubmatch(strings.TrimSpace(lines[i-1])); fn != nil {
+				frame.Function = fn[1]
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func parseRustFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if m := rustPanic.FindStringSubmatch(line); m != nil {
+			frames = append(frames, StackFrame{File: m[1], Line: atoi(m[2])})
+			continue
+		}
+		m := rustFrame.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		frame := StackFrame{File: m[1], Line: atoi(m[2])}
+		if i > 0 {
+			if fn := rustFunc.FindStringSubmatch(strings.TrimSpace(lines[i-1])); fn != nil {
+				frame.Function = fn[1]
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// parseDotnetFrames only keeps frames with source information; frames
+// without it carry nothing the server can act on.
+func parseDotnetFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range lines {
+		m := dotnetFrame.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || m[2] == "" {
+			continue
+		}
+		frames = append(frames, StackFrame{File: m[2], Line: atoi(m[3]), Function: m[1]})
+	}
+	return frames
+}
+
+// exceptionLine splits "ValueError: bad input" into a type and a value.
+var exceptionLine = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?([\w.$]+(?:Error|Exception|Exit|Interrupt|panic)\w*):\s*(.*)$`)
+
+// SplitException splits an exception line like "ValueError: bad input" into
+// its type and message.
+func SplitException(line string) (typ, message string, ok bool) {
+	m := exceptionLine.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,238 @@
+// Package redact masks secrets and personal data in incidents before they
+// leave the host.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const (
+	// redactedMark replaces each secret found
+	redactedMark = "[REDACTED]"
+	// minSaltLength keeps hashes of guessable values like emails from
+	// being reversed by hashing candidates
+	minSaltLength = 16
+	// hashLength is the hex digits of a PII hash kept, plenty to tell the
+	// people in one incident stream apart
+	hashLength = 12
+)
+
+// Config masks secrets and personal data in the error line and context
+// before an incident leaves the host.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Patterns are masked as well as the built-in ones. A pattern with a
+	// capture group masks only the group, e.g. `password=(\S+)`.
+	Patterns       []string `json:"patterns,omitempty"`
+	DisableBuiltin bool     `json:"disable_builtin,omitempty"`
+
+	// HashPII replaces emails and user IDs with a hash salted with Salt
+	// instead of the mark, so incidents about the same person can still be
+	// told apart and correlated without the dashboard seeing who it is.
+	// UserIDs are patterns for IDs beyond the built-in key=value forms.
+	HashPII bool     `json:"hash_pii,omitempty"`
+	Salt    string   `json:"salt,omitempty"`
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	for i, p := range c.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("patterns[%d]: %w", i, err)
+		}
+	}
+	for i, p := range c.UserIDs {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("user_ids[%d]: %w", i, err)
+		}
+	}
+	if c.HashPII && len(c.Salt) < minSaltLength {
+		return fmt.Errorf("hash_pii needs a salt of at least %d characters", minSaltLength)
+	}
+	if !c.HashPII && len(c.UserIDs) > 0 {
+		return errors.New("user_ids needs hash_pii")
+	}
+	return nil
+}
+
+type redactRule struct {
+	name string
+	re   *regexp.Regexp
+	// valid, when set, rejects matches that only look like a secret
+	valid func(string) bool
+	// pii matches are hashed rather than masked in hash_pii mode
+	pii bool
+}
+
+// builtinRedactions run in order, so tokens are masked whole before the
+// email rule could match part of one.
+var builtinRedactions = []redactRule{
+	{name: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{name: "bearer", re: regexp.MustCompile(`(?i)\b(?:bearer|token)\s+([A-Za-z0-9._~+/-]{8,}=*)`)},
+	{name: "aws_key", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "aws_secret", re: regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{name: "email", re: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), pii: true},
+	{name: "credit_card", re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+}
+
+// userIDRedaction finds the common key=value and key: value forms of user
+// IDs, which are only worth hiding when they can be hashed: masked, they
+// would leave nothing to correlate by.
+var userIDRedaction = redactRule{
+	name: "user_id",
+	re:   regexp.MustCompile(`(?i)\b(?:user|customer|account|member)[_-]?id["']?\s*[=:]\s*["']?([\w.@-]+)`),
+	pii:  true,
+}
+
+// Redactor masks the secrets its rules find. The zero value masks nothing.
+type Redactor struct {
+	rules []redactRule
+	// salt, when set, hashes the matches of pii rules
+	salt []byte
+}
+
+// New returns the redactor for cfg, which masks nothing unless enabled.
+func New(cfg *Config) (*Redactor, error) {
+	r := &Redactor{}
+	if cfg == nil || !cfg.Enabled {
+		return r, nil
+	}
+	if !cfg.DisableBuiltin {
+		r.rules = append(r.rules, builtinRedactions...)
+	}
+	for i, p := range cfg.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact: patterns[%d]: %w", i, err)
+		}
+		r.rules = append(r.rules, redactRule{name: "pattern", re: re})
+	}
+	if cfg.HashPII {
+		r.salt = []byte(cfg.Salt)
+		if !cfg.DisableBuiltin {
+			r.rules = append(r.rules, userIDRedaction)
+		}
+		for i, p := range cfg.UserIDs {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("redact: user_ids[%d]: %w", i, err)
+			}
+			r.rules = append(r.rules, redactRule{name: "user_id", re: re, pii: true})
+		}
+	}
+	return r, nil
+}
+
+// Redact returns s with every secret replaced by redactedMark, or by its
+// hash for personal data in hash_pii mode.
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range r.rules {
+		s = r.replace(rule, s)
+	}
+	return s
+}
+
+// hash returns the mark for a piece of personal data: the same value
+// always gets the same mark, which says what kind of data it stood for.
+// Emails are compared case-insensitively.
+func (r *Redactor) hash(rule redactRule, value string) string {
+	if rule.name == "email" {
+		value = strings.ToLower(value)
+	}
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(value))
+	return "[" + rule.name + "#" + hex.EncodeToString(mac.Sum(nil))[:hashLength] + "]"
+}
+
+func (r *Redactor) replace(rule redactRule, s string) string {
+	matches := rule.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if start == end || (rule.valid != nil && !rule.valid(s[start:end])) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		if rule.pii && r.salt != nil {
+			b.WriteString(r.hash(rule, s[start:end]))
+		} else {
+			b.WriteString(redactedMark)
+		}
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Lines returns lines with each one redacted, or lines itself when there
+// is nothing to mask.
+func (r *Redactor) Lines(lines []string) []string {
+	if len(r.rules) == 0 || len(lines) == 0 {
+		return lines
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = r.Redact(line)
+	}
+	return out
+}
+
+// Event masks the error line and context of an event.
+func (r *Redactor) Event(e *detect.Event) {
+	if len(r.rules) == 0 {
+		return
+	}
+	e.Line = r.Redact(e.Line)
+	e.Context = r.Lines(e.Context)
+}
+
+// Payload masks the error line and context of an incident built elsewhere,
+// such as one forwarded by the relay.
+func (r *Redactor) Payload(p *ship.IncidentPayload) {
+	if len(r.rules) == 0 {
+		return
+	}
+	p.ErrorLine = r.Redact(p.ErrorLine)
+	p.Context = r.Lines(p.Context)
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum that
+// card numbers carry, so IDs and timestamps of the same length are kept.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,83 @@
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// requestIDHeaders are the headers a request ID is looked for in, so the
+// incident can be matched with the application's own logs.
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "Traceparent"}
+
+// Middleware recovers the panics of next, reports each with its stack and
+// the request it happened in, and answers 500 Internal Server Error.
+// http.ErrAbortHandler, which aborts a response on purpose, is passed on
+// unreported.
+func Middleware(r *Reporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			r.ReportPanic(v, req, req.Pattern)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ReportPanic reports v, recovered while serving req, as a critical
+// incident. route is the pattern the request was routed by, if known. It
+// takes the stack from the goroutine it is called on, so call it from the
+// deferred function that recovered.
+func (r *Reporter) ReportPanic(v any, req *http.Request, route string) {
+	line := fmt.Sprintf("panic: %v [recovered]", v)
+	stack := panicStack(debug.Stack())
+	event := NewEvent(line, time.Now().UTC(), append(requestContext(req, route), stack...))
+
+	tags := map[string]string{"http.method": req.Method}
+	if route != "" {
+		tags["http.route"] = route
+	}
+	for _, h := range requestIDHeaders {
+		if id := req.Header.Get(h); id != "" {
+			tags["request_id"] = id
+			break
+		}
+	}
+	r.report(event, SeverityCritical, tags)
+}
+
+// requestContext describes req in the lines of context before the stack.
+// The query string and headers other than the user agent are left out, as
+// they may hold credentials.
+func requestContext(req *http.Request, route string) []string {
+	lines := []string{"request: " + req.Method + " " + req.URL.Path}
+	if route != "" {
+		lines = append(lines, "route: "+route)
+	}
+	if ua := req.UserAgent(); ua != "" {
+		lines = append(lines, "user_agent: "+ua)
+	}
+	return lines
+}
+
+// panicStack returns the frames of a stack taken while recovering from a
+// panic, from the one that panicked outward.
+func panicStack(stack []byte) []string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") && i+2 <= len(lines) {
+			return lines[i+2:]
+		}
+	}
+	// Without the panic's frame, skip the goroutine header
+	return lines[1:]
+}
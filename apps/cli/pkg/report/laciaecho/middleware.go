@@ -0,0 +1,35 @@
+// Package laciaecho reports the panics of echo handlers to a lacia server,
+// through a report.Reporter.
+package laciaecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/report"
+)
+
+// Middleware recovers the panics of the handlers after it, reports each
+// with its stack and the request it happened in, and returns 500 Internal
+// Server Error to echo's error handler. Use it in place of
+// middleware.Recover:
+//
+//	e.Use(laciaecho.Middleware(reporter))
+func Middleware(r *report.Reporter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				r.ReportPanic(v, c.Request(), c.Path())
+				err = echo.ErrInternalServerError
+			}()
+			return next(c)
+		}
+	}
+}
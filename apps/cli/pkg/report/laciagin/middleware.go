@@ -0,0 +1,32 @@
+// Package laciagin reports the panics of gin handlers to a lacia server,
+// through a report.Reporter.
+package laciagin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/report"
+)
+
+// Middleware recovers the panics of the handlers after it, reports each
+// with its stack and the request it happened in, and aborts the request
+// with 500 Internal Server Error. Use it in place of gin.Recovery:
+//
+//	router.Use(laciagin.Middleware(reporter))
+func Middleware(r *report.Reporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			r.ReportPanic(v, c.Request, c.FullPath())
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+// Package laciazap reports the errors an application logs with zap to a
+// lacia server, through a report.Reporter.
+package laciazap
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/report"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core is a zapcore.Core that reports the entries it is enabled for, with
+// the stack they were logged from. It writes nothing itself, so combine it
+// with the application's own core:
+//
+//	logger := zap.New(zapcore.NewTee(core, laciazap.NewCore(reporter, zapcore.ErrorLevel)), zap.AddCaller())
+//
+// With zap.AddCaller the reported stack starts at the call that logged the
+// entry.
+type Core struct {
+	zapcore.LevelEnabler
+	reporter *report.Reporter
+	fields   []zapcore.Field
+}
+
+// NewCore returns a core reporting entries at the levels level enables to r.
+func NewCore(r *report.Reporter, level zapcore.LevelEnabler) *Core {
+	return &Core{LevelEnabler: level, reporter: r}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(slices.Clip(c.fields), fields...)
+	return &clone
+}
+
+func (c *Core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *Core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if report.Internal(entry.Caller.Function) {
+		return nil
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	var b strings.Builder
+	b.WriteString(entry.Level.CapitalString())
+	b.WriteByte(' ')
+	b.WriteString(entry.Message)
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		value := fmt.Sprint(enc.Fields[k])
+		if value == "" || strings.ContainsAny(value, " =\"\n") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + k + "=" + value)
+	}
+
+	event := report.NewEvent(b.String(), entry.Time.UTC(), report.Stack(entry.Caller.Function))
+	c.reporter.Report(event, levelSeverity(entry.Level))
+	return nil
+}
+
+func (c *Core) Sync() error {
+	return nil
+}
+
+// levelSeverity is the severity of an entry logged at level: errors are
+// high, the panic and fatal levels critical.
+func levelSeverity(level zapcore.Level) string {
+	switch {
+	case level > zapcore.ErrorLevel:
+		return report.SeverityCritical
+	case level == zapcore.ErrorLevel:
+		return report.SeverityHigh
+	case level == zapcore.WarnLevel:
+		return report.SeverityMedium
+	}
+	return report.SeverityLow
+}
@@ -0,0 +1,20 @@
+module github.com/noobiethe13/lacia/apps/cli/pkg/report/laciazap
+
+go 1.23.0
+
+require (
+	github.com/noobiethe13/lacia/apps/cli v0.0.0-00010101000000-000000000000
+	go.uber.org/zap v1.28.0
+)
+
+require (
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/net v0.40.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+	google.golang.org/grpc v1.71.0 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
+
+replace github.com/noobiethe13/lacia/apps/cli => ../../..
@@ -0,0 +1,267 @@
+// Package report sends errors straight from a Go application to a lacia
+// server, without a log file for the agent to tail. The slog Handler, the zap
+// core in package laciazap and the HTTP middleware here and in packages
+// laciagin and laciaecho all hand their incidents to a Reporter, which sends
+// them in the background. Those three are modules of their own, so
+// importing this package does not pull in zap, gin or echo.
+package report
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/dedupe"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/redact"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const defaultQueueSize = 100
+
+// Severity levels, lowest first, as the agent assigns them.
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// modulePrefix is what the functions of lacia's own packages are named with.
+const modulePrefix = "github.com/noobiethe13/lacia/"
+
+type Options struct {
+	// Target names the application in incidents, as a target's label does
+	// for the agent.
+	Target string
+	// Dedupe suppresses repeats of an error within its cooldown, so an error
+	// logged in a loop is reported once. Persist and Aggregate are not used.
+	Dedupe *dedupe.Config
+	// Redact masks secrets and personal data in the error line and context,
+	// as the agent's redact section does. Logged attributes and request
+	// details end up in the context, so enable it wherever they may hold any.
+	Redact *redact.Config
+	// QueueSize is how many incidents may wait to be sent; more are dropped
+	// so that logging never waits for the server.
+	QueueSize int
+	// OnError, when set, is called from the sending goroutine with the error
+	// of each incident that could not be sent.
+	OnError func(error)
+}
+
+type queued struct {
+	event    detect.Event
+	severity string
+	// tags are added to the client's
+	tags map[string]string
+}
+
+// Reporter sends incidents through a client from a goroutine of its own. It
+// is safe for concurrent use.
+type Reporter struct {
+	client   *ship.Client
+	target   string
+	deduper  *dedupe.Deduper
+	redactor *redact.Redactor
+	onError  func(error)
+
+	queue  chan queued
+	stop   chan struct{}
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed atomic.Bool
+
+	sent    atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+}
+
+// New starts a reporter that sends through client. Close it to send what is
+// still queued.
+func New(client *ship.Client, opts Options) (*Reporter, error) {
+	var cfg *dedupe.Config
+	if opts.Dedupe != nil {
+		// Nothing runs the deduper to persist or aggregate
+		c := *opts.Dedupe
+		c.Persist, c.Aggregate = false, false
+		cfg = &c
+	}
+	deduper, err := dedupe.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Redact != nil {
+		if err := opts.Redact.Validate(); err != nil {
+			return nil, fmt.Errorf("redact: %w", err)
+		}
+	}
+	redactor, err := redact.New(opts.Redact)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Reporter{
+		client:   client,
+		target:   opts.Target,
+		deduper:  deduper,
+		redactor: redactor,
+		onError:  opts.OnError,
+		queue:    make(chan queued, cmp.Or(opts.QueueSize, defaultQueueSize)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go r.run()
+	return r, nil
+}
+
+// Report queues event to be sent with the given severity unless it repeats
+// a recent one. It does not block: when the queue is full, or the reporter
+// is closed, the event is dropped.
+func (r *Reporter) Report(event detect.Event, severity string) {
+	r.report(event, severity, nil)
+}
+
+func (r *Reporter) report(event detect.Event, severity string, tags map[string]string) {
+	if r.closed.Load() {
+		r.dropped.Add(1)
+		return
+	}
+	if event.Target == "" {
+		event.Target = r.target
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if r.deduper.IsDuplicate(&event) {
+		return
+	}
+	select {
+	case r.queue <- queued{event: event, severity: severity, tags: tags}:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Counts returns how many incidents were sent, failed to send and were
+// dropped because the queue was full.
+func (r *Reporter) Counts() (sent, failed, dropped int64) {
+	return r.sent.Load(), r.failed.Load(), r.dropped.Load()
+}
+
+// Close stops taking incidents and sends the ones queued, giving up on them
+// once ctx is done.
+func (r *Reporter) Close(ctx context.Context) error {
+	if r.closed.Swap(true) {
+		<-r.done
+		return nil
+	}
+	close(r.stop)
+	defer r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.done
+		return ctx.Err()
+	}
+}
+
+func (r *Reporter) run() {
+	defer close(r.done)
+	for {
+		select {
+		case q := <-r.queue:
+			r.send(q)
+		case <-r.stop:
+			for {
+				select {
+				case q := <-r.queue:
+					r.send(q)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Reporter) send(q queued) {
+	if r.ctx.Err() != nil {
+		r.dropped.Add(1)
+		return
+	}
+	// Masked before the payload is built, so the fingerprint is of what is sent
+	r.redactor.Event(&q.event)
+	payload := r.client.Payload(q.event)
+	payload.Severity = q.severity
+	if len(q.tags) > 0 {
+		tags := maps.Clone(payload.Tags)
+		if tags == nil {
+			tags = make(map[string]string, len(q.tags))
+		}
+		maps.Copy(tags, q.tags)
+		payload.Tags = tags
+	}
+	if _, err := r.client.SendPayload(r.ctx, payload); err != nil {
+		r.failed.Add(1)
+		if r.onError != nil {
+			r.onError(err)
+		}
+		return
+	}
+	r.sent.Add(1)
+}
+
+// NewEvent returns the event for an error described by line, logged at
+// logTime, with stack as its trace.
+func NewEvent(line string, logTime time.Time, stack []string) detect.Event {
+	return detect.Event{
+		Line:      line,
+		Timestamp: time.Now().UTC(),
+		LogTime:   logTime,
+		Context:   append([]string{line}, stack...),
+	}
+}
+
+// Stack returns the calling goroutine's stack, formatted the way Go prints
+// it for a panic so the server can read its frames. It starts at the first
+// call to the function named from, e.g. the one that logged the error, or
+// at the caller of Stack when from is empty or not on the stack.
+func Stack(from string) []string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	var all, lines []string
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		line := []string{frame.Function + "(...)", "\t" + frame.File + ":" + strconv.Itoa(frame.Line)}
+		all = append(all, line...)
+		if lines != nil || frame.Function == from {
+			lines = append(lines, line...)
+		}
+		if !more {
+			break
+		}
+	}
+	if lines == nil {
+		return all
+	}
+	return lines
+}
+
+// Internal reports whether function belongs to lacia's own packages. Their
+// logs are not reported, so a send that fails cannot report its own
+// failure in a loop.
+func Internal(function string) bool {
+	return strings.HasPrefix(function, modulePrefix)
+}
@@ -0,0 +1,47 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/redact"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+func TestReporterRedacts(t *testing.T) {
+	payloads := make(chan ship.IncidentPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload ship.IncidentPayload
+		json.NewDecoder(req.Body).Decode(&payload)
+		payloads <- payload
+		w.Write([]byte(`{"success":true,"incidentId":1}`))
+	}))
+	defer server.Close()
+	client, err := ship.NewClient(ship.Config{ServerURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter, err := New(client, Options{Redact: &redact.Config{Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reporter.Report(NewEvent("login failed for ada@example.com", time.Now(), []string{"\tuser=ada@example.com"}), SeverityHigh)
+	if err := reporter.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var payload ship.IncidentPayload
+	select {
+	case payload = <-payloads:
+	default:
+		t.Fatal("nothing sent")
+	}
+	if sent := payload.ErrorLine + strings.Join(payload.Context, "\n"); strings.Contains(sent, "ada@example.com") {
+		t.Fatalf("email sent unmasked: %q", sent)
+	}
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HandlerOptions struct {
+	// Level is the least severe level reported, slog.LevelError by default.
+	// Records below it still reach the wrapped handler.
+	Level slog.Leveler
+}
+
+// Handler is a slog.Handler that reports records at or above its level,
+// with the stack they were logged from, and passes every record on to the
+// handler it wraps, if any.
+type Handler struct {
+	reporter *Reporter
+	next     slog.Handler
+	level    slog.Leveler
+	// attrs are the attributes added with WithAttrs, already formatted, and
+	// group the prefix of those added later
+	attrs string
+	group string
+}
+
+// NewHandler returns a handler reporting to r in front of next, which may be
+// nil to only report.
+func NewHandler(r *Reporter, next slog.Handler, opts *HandlerOptions) *Handler {
+	h := &Handler{reporter: r, next: next, level: slog.LevelError}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || (h.next != nil && h.next.Enabled(ctx, level))
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= h.level.Level() {
+		h.report(record)
+	}
+	if h.next != nil && h.next.Enabled(ctx, record.Level) {
+		return h.next.Handle(ctx, record)
+	}
+	return nil
+}
+
+func (h *Handler) report(record slog.Record) {
+	var function string
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		function = frame.Function
+	}
+	if Internal(function) {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(record.Level.String())
+	b.WriteByte(' ')
+	b.WriteString(record.Message)
+	b.WriteString(h.attrs)
+	record.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	logTime := record.Time
+	if logTime.IsZero() {
+		logTime = time.Now()
+	}
+	h.reporter.Report(NewEvent(b.String(), logTime.UTC(), Stack(function)), levelSeverity(record.Level))
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	clone.attrs = b.String()
+	if h.next != nil {
+		clone.next = h.next.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	if h.next != nil {
+		clone.next = h.next.WithGroup(name)
+	}
+	return &clone
+}
+
+// appendAttr writes a as " key=value", the way slog.TextHandler does, with
+// the keys of groups prefixed by the group's.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " =\"\n") {
+		value = strconv.Quote(value)
+	}
+	b.WriteByte(' ')
+	b.WriteString(prefix + a.Key)
+	b.WriteByte('=')
+	b.WriteString(value)
+}
+
+// levelSeverity is the severity of a record logged at level: errors are
+// high, levels above them critical.
+func levelSeverity(level slog.Level) string {
+	switch {
+	case level > slog.LevelError:
+		return SeverityCritical
+	case level >= slog.LevelError:
+		return SeverityHigh
+	case level >= slog.LevelWarn:
+		return SeverityMedium
+	}
+	return SeverityLow
+}
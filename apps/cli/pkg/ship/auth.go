@@ -0,0 +1,231 @@
+package ship
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIKeyHeader    = "X-API-Key"
+	defaultSignatureHeader = "X-Lacia-Signature"
+	timestampHeader        = "X-Lacia-Timestamp"
+	ed25519Header          = "X-Lacia-Ed25519-Signature"
+)
+
+type AuthConfig struct {
+	APIKey          string `json:"api_key,omitempty"`
+	APIKeyHeader    string `json:"api_key_header,omitempty"`
+	BearerToken     string `json:"bearer_token,omitempty"`
+	HMACSecret      string `json:"hmac_secret,omitempty"`
+	SignatureHeader string `json:"signature_header,omitempty"`
+	// SigningKey, or the file SigningKeyFile names, is an Ed25519 private
+	// key, base64 or PEM, that signs each request. Unlike an HMAC secret
+	// the server only holds the public key, so a leaked server config or
+	// webhook URL is not enough to submit incidents.
+	SigningKey     string `json:"signing_key,omitempty"`
+	SigningKeyFile string `json:"signing_key_file,omitempty"`
+	// PublicKey is what a relay checks agents' Ed25519 signatures with
+	PublicKey string `json:"public_key,omitempty"`
+
+	// signer and public are the parsed keys, loaded by Validate, which
+	// NewClient calls
+	signer ed25519.PrivateKey
+	public ed25519.PublicKey
+}
+
+func (c *AuthConfig) Validate() error {
+	if c.APIKey == "" && c.BearerToken == "" && c.HMACSecret == "" && c.SigningKey == "" && c.SigningKeyFile == "" && c.PublicKey == "" {
+		return errors.New("one of api_key, bearer_token, hmac_secret, signing_key, signing_key_file or public_key is required")
+	}
+	if c.SigningKey != "" && c.SigningKeyFile != "" {
+		return errors.New("signing_key and signing_key_file are exclusive")
+	}
+	key := c.SigningKey
+	if c.SigningKeyFile != "" {
+		data, err := os.ReadFile(c.SigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("signing_key_file: %w", err)
+		}
+		key = string(data)
+	}
+	if key != "" {
+		signer, err := parseSigningKey(key)
+		if err != nil {
+			return err
+		}
+		c.signer = signer
+	}
+	if c.PublicKey != "" {
+		public, err := parsePublicKey(c.PublicKey)
+		if err != nil {
+			return err
+		}
+		c.public = public
+	}
+	return nil
+}
+
+// parseSigningKey reads an Ed25519 private key as PKCS#8 PEM, as openssl
+// writes it, or as the base64 of its 32-byte seed or 64-byte key.
+func parseSigningKey(s string) (ed25519.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if signer, ok := key.(ed25519.PrivateKey); err == nil && ok {
+			return signer, nil
+		}
+		return nil, errors.New("signing key is not an Ed25519 private key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	switch {
+	case err == nil && len(raw) == ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case err == nil && len(raw) == ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, errors.New("signing key must be an Ed25519 private key in PEM or base64")
+}
+
+// parsePublicKey reads an Ed25519 public key as PKIX PEM or base64.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if public, ok := key.(ed25519.PublicKey); err == nil && ok {
+			return public, nil
+		}
+		return nil, errors.New("public_key is not an Ed25519 public key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("public_key must be an Ed25519 public key in PEM or base64")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// signs reports whether the credentials cover the request body.
+func (c *AuthConfig) signs() bool {
+	return c.HMACSecret != "" || c.signer != nil
+}
+
+// Apply adds the configured credentials to req.
+func (c *AuthConfig) Apply(req *http.Request, body []byte) {
+	for k, v := range c.Headers(body) {
+		req.Header.Set(k, v)
+	}
+}
+
+// Headers returns the configured credentials as headers. When an HMAC secret
+// or signing key is set the signatures cover "<unix timestamp>.<body>" so the
+// server can reject replayed requests as well as forged ones.
+func (c *AuthConfig) Headers(body []byte) map[string]string {
+	headers := make(map[string]string)
+	if c.APIKey != "" {
+		header := c.APIKeyHeader
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		headers[header] = c.APIKey
+	}
+
+	if c.BearerToken != "" {
+		headers["Authorization"] = "Bearer " + c.BearerToken
+	}
+
+	if !c.signs() {
+		return headers
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	headers[timestampHeader] = ts
+	if c.HMACSecret != "" {
+		header := c.SignatureHeader
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		mac := hmac.New(sha256.New, []byte(c.HMACSecret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		headers[header] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	if c.signer != nil {
+		signature := ed25519.Sign(c.signer, signedMessage(ts, body))
+		headers[ed25519Header] = base64.StdEncoding.EncodeToString(signature)
+	}
+	return headers
+}
+
+func signedMessage(ts string, body []byte) []byte {
+	return append([]byte(ts+"."), body...)
+}
+
+// maxSignatureAge matches the server's limit on how old a signed request
+// may be.
+const maxSignatureAge = 5 * time.Minute
+
+// Verify checks the credentials on a request received from an agent, read
+// with get, against the configured ones. body is the uncompressed body the
+// signature covers. Validate must have been called to load PublicKey.
+func (c *AuthConfig) Verify(get func(string) string, body []byte) error {
+	if c.APIKey != "" {
+		header := c.APIKeyHeader
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		if !hmac.Equal([]byte(get(header)), []byte(c.APIKey)) {
+			return errors.New("invalid API key")
+		}
+	}
+
+	if c.BearerToken != "" {
+		token, _ := strings.CutPrefix(get("Authorization"), "Bearer ")
+		if !hmac.Equal([]byte(token), []byte(c.BearerToken)) {
+			return errors.New("invalid bearer token")
+		}
+	}
+
+	if c.HMACSecret == "" && c.PublicKey == "" {
+		return nil
+	}
+	ts := get(timestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)).Abs() > maxSignatureAge {
+		return errors.New("missing or expired signature timestamp")
+	}
+
+	if c.PublicKey != "" {
+		if c.public == nil {
+			return errors.New("public_key is not loaded; call Validate first")
+		}
+		signature, err := base64.StdEncoding.DecodeString(get(ed25519Header))
+		if err != nil || !ed25519.Verify(c.public, signedMessage(ts, body), signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+	}
+
+	if c.HMACSecret != "" {
+		header := c.SignatureHeader
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		mac := hmac.New(sha256.New, []byte(c.HMACSecret))
+		mac.Write([]byte(ts + "."))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(get(header)), []byte(expected)) {
+			return errors.New("invalid signature")
+		}
+	}
+	return nil
+}
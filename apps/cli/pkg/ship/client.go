@@ -0,0 +1,512 @@
+// Package ship reports incidents to a lacia server, over its JSON webhook or
+// gRPC, with retries, auth and the server's throttling honored.
+package ship
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+// Config is what a Client needs to report incidents. The optional sections
+// are validated by the caller, e.g. as part of the agent's config file.
+type Config struct {
+	ServerURL string
+	// RepoURL is used for events that do not name their own repository.
+	RepoURL string
+	Retry   *RetryConfig
+	Auth    *AuthConfig
+	Payload *PayloadConfig
+	GRPC    *GRPCConfig
+	TLS     *TLSConfig
+	Proxy   *ProxyConfig
+	Tags    map[string]string
+
+	// Service, Environment and Version default to SERVICE_NAME, DEPLOY_ENV
+	// and SERVICE_VERSION.
+	Service     string
+	Environment string
+	Version     string
+	// AgentVersion is sent with each incident so the server can spot
+	// outdated senders.
+	AgentVersion string
+	// Fingerprint is the strategy the fingerprint sent with each incident
+	// is computed by, the one duplicates are found by.
+	Fingerprint string
+}
+
+type IncidentPayload struct {
+	ErrorLine string `json:"error_line"`
+	// Timestamp is when the agent read the error, by its clock, and
+	// LogTimestamp the time the log gives for it, so the server can spot
+	// clock skew and order incidents from different hosts
+	Timestamp    string   `json:"timestamp"`
+	LogTimestamp string   `json:"log_timestamp,omitempty"`
+	Hostname     string   `json:"hostname"`
+	RepoURL      string   `json:"repo_url,omitempty"`
+	Target       string   `json:"target,omitempty"`
+	Context      []string `json:"context,omitempty"`
+	Severity     string   `json:"severity,omitempty"`
+	// Truncated is set when a line was cut at the maximum line length
+	Truncated bool `json:"truncated,omitempty"`
+
+	OccurrenceCount int `json:"occurrence_count,omitempty"`
+	// FirstSeen and LastSeen (RFC 3339) bound the occurrences of aggregated
+	// duplicates
+	FirstSeen string `json:"first_seen,omitempty"`
+	LastSeen  string `json:"last_seen,omitempty"`
+	// Fingerprint identifies the error, so the server can count repeats,
+	// such as the update for aggregated duplicates, against the incident
+	// it already has
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	Language string              `json:"language,omitempty"`
+	Frames   []detect.StackFrame `json:"frames,omitempty"`
+	Git      *GitInfo            `json:"git,omitempty"`
+
+	// AgentVersion lets the server spot outdated agents
+	AgentVersion string `json:"agent_version,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Service, Environment and Version identify the application, which the
+	// hostname does not inside containers
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Version     string `json:"version,omitempty"`
+
+	Container *ContainerInfo `json:"container,omitempty"`
+}
+
+// StatusError is returned when the server answers with a non-2xx status.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %d", e.Code)
+}
+
+// defaultRetryAfter is how long to hold off when the server throttles
+// without saying for how long.
+const defaultRetryAfter = time.Minute
+
+// ThrottleError is returned when the server asks the agent to hold off,
+// with a throttle reply or a 429 or 503 with Retry-After, and for sends
+// attempted before that time is up. The incident was not accepted.
+type ThrottleError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("server throttled, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// IsRetryable reports whether a failed send may succeed later: network
+// errors, 5xx and 429 are transient, other 4xx responses are not.
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+	return err != nil
+}
+
+type Client struct {
+	hostname   string
+	container  *ContainerInfo
+	httpClient *http.Client
+	// grpc, when configured, replaces the JSON webhook for sending
+	// incidents.
+	grpc *grpcTransport
+
+	mu       sync.RWMutex
+	settings clientSettings
+	// throttledUntil is when the server said sending may resume, in Unix
+	// nanoseconds
+	throttledUntil atomic.Int64
+}
+
+// clientSettings are the parts of the config a reload can change while
+// requests are in flight.
+type clientSettings struct {
+	serverURL string
+	repoURL   string
+	retry     RetryPolicy
+	auth      *AuthConfig
+	payload   PayloadConfig
+	tags      map[string]string
+
+	service      string
+	environment  string
+	version      string
+	agentVersion string
+	fingerprint  string
+}
+
+func newClientSettings(cfg Config) clientSettings {
+	settings := clientSettings{
+		serverURL: cfg.ServerURL,
+		repoURL:   cfg.RepoURL,
+		retry:     NewRetryPolicy(cfg.Retry),
+		auth:      cfg.Auth,
+		tags:      cfg.Tags,
+
+		service:     cmp.Or(cfg.Service, os.Getenv("SERVICE_NAME")),
+		environment: cmp.Or(cfg.Environment, os.Getenv("DEPLOY_ENV")),
+		version:     cmp.Or(cfg.Version, os.Getenv("SERVICE_VERSION")),
+
+		agentVersion: cfg.AgentVersion,
+		fingerprint:  cfg.Fingerprint,
+	}
+	if cfg.Payload != nil {
+		settings.payload = *cfg.Payload
+	}
+	return settings
+}
+
+// NewClient checks cfg.Auth, loading its signing key, so a client never
+// sends unsigned requests in place of signed ones.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Auth != nil {
+		if err := cfg.Auth.Validate(); err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "unknown"
+	}
+
+	httpClient, err := NewHTTPClient(cfg.TLS, cfg.Proxy, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		hostname:   hostname,
+		container:  DetectContainer(),
+		settings:   newClientSettings(cfg),
+		httpClient: httpClient,
+	}
+	if cfg.GRPC != nil {
+		transport, err := newGRPCTransport(cfg.GRPC, cfg.Payload != nil && cfg.Payload.Gzip)
+		if err != nil {
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+		c.grpc = transport
+	}
+	return c, nil
+}
+
+// Close releases the gRPC connection, if any.
+func (c *Client) Close() error {
+	if c.grpc != nil {
+		return c.grpc.Close()
+	}
+	return nil
+}
+
+// Reconfigure switches to the server, repository, retry, auth, payload, tag
+// and service settings of a reloaded config. Requests already in flight finish with the old ones.
+// Like NewClient it checks cfg.Auth, and keeps the old settings when that fails.
+func (c *Client) Reconfigure(cfg Config) error {
+	if cfg.Auth != nil {
+		if err := cfg.Auth.Validate(); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settings = newClientSettings(cfg)
+	return nil
+}
+
+func (c *Client) current() clientSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings
+}
+
+// Hostname is the host name sent with incidents.
+func (c *Client) Hostname() string {
+	return c.hostname
+}
+
+// ServerURL is the webhook URL incidents are posted to.
+func (c *Client) ServerURL() string {
+	return c.current().serverURL
+}
+
+func (c *Client) Send(ctx context.Context, event detect.Event) error {
+	_, err := c.SendPayload(ctx, c.Payload(event))
+	return err
+}
+
+func (c *Client) Payload(event detect.Event) IncidentPayload {
+	settings := c.current()
+	repoURL := event.RepoURL
+	if repoURL == "" {
+		repoURL = settings.repoURL
+	}
+
+	language, frames := detect.ParseStackTrace(event.Context)
+	payload := IncidentPayload{
+		ErrorLine:    event.Line,
+		Timestamp:    event.Timestamp.Format(time.RFC3339),
+		Hostname:     c.hostname,
+		RepoURL:      repoURL,
+		Target:       event.Target,
+		Context:      capContext(event.Context, settings.payload.MaxContextBytes),
+		Truncated:    event.Truncated,
+		Fingerprint:  detect.Fingerprint(event, settings.fingerprint),
+		Language:     language,
+		Frames:       frames,
+		AgentVersion: settings.agentVersion,
+		Tags:         settings.tags,
+		Service:      settings.service,
+		Environment:  settings.environment,
+		Version:      settings.version,
+		Container:    c.container,
+	}
+	if !event.LogTime.IsZero() {
+		payload.LogTimestamp = event.LogTime.UTC().Format(time.RFC3339Nano)
+	}
+	payload.SetOccurrences(event)
+	return payload
+}
+
+// SetOccurrences copies the occurrences event stands for into p.
+func (p *IncidentPayload) SetOccurrences(event detect.Event) {
+	p.OccurrenceCount = event.Occurrences
+	if !event.FirstSeen.IsZero() {
+		p.FirstSeen = event.FirstSeen.UTC().Format(time.RFC3339)
+		p.LastSeen = event.LastSeen.UTC().Format(time.RFC3339)
+	}
+}
+
+// SendPayload sends payload and returns the ID the server gave the incident,
+// or 0 when it did not say.
+func (c *Client) SendPayload(ctx context.Context, payload IncidentPayload) (int, error) {
+	var id int
+	if c.grpc != nil {
+		err := c.withRetry(ctx, func() error {
+			n, err := c.grpc.Report(ctx, c.current().auth, payload)
+			id = int(n)
+			return err
+		})
+		return id, err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("marshal failed: %w", err)
+	}
+	err = c.withRetry(ctx, func() error {
+		reply, err := c.Post(ctx, c.current().serverURL, body)
+		id = parseReply(reply).IncidentID
+		return err
+	})
+	return id, err
+}
+
+// SendBatch posts several payloads as one JSON array to the batch endpoint
+// and returns the IDs the server gave them, if it says.
+func (c *Client) SendBatch(ctx context.Context, payloads []IncidentPayload) ([]int, error) {
+	if c.grpc != nil {
+		return nil, c.withRetry(ctx, func() error {
+			return c.grpc.ReportBatch(ctx, c.current().auth, payloads)
+		})
+	}
+	body, err := json.Marshal(payloads)
+	if err != nil {
+		return nil, fmt.Errorf("marshal failed: %w", err)
+	}
+	var ids []int
+	err = c.withRetry(ctx, func() error {
+		reply, err := c.Post(ctx, c.batchURL(), body)
+		ids = parseReply(reply).IncidentIDs
+		return err
+	})
+	return ids, err
+}
+
+func (c *Client) batchURL() string {
+	return strings.TrimSuffix(c.current().serverURL, "/") + "/batch"
+}
+
+// WebhookResponse is the server's reply to an incident. A server under load
+// may instead reply with status "throttle" and the seconds to retry_after.
+type WebhookResponse struct {
+	Success     bool   `json:"success"`
+	IncidentID  int    `json:"incidentId,omitempty"`
+	IncidentIDs []int  `json:"incidentIds,omitempty"`
+	Status      string `json:"status,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"`
+}
+
+// parseReply reads the parts of a reply the agent acts on; servers that
+// answer with something else are taken to have accepted the incident.
+func parseReply(body []byte) WebhookResponse {
+	var reply WebhookResponse
+	json.Unmarshal(body, &reply)
+	return reply
+}
+
+// SendWithResponse sends a single payload and returns the server's reply.
+func (c *Client) SendWithResponse(ctx context.Context, payload IncidentPayload) (*WebhookResponse, error) {
+	if c.grpc != nil {
+		id, err := c.grpc.Report(ctx, c.current().auth, payload)
+		if err != nil {
+			return nil, err
+		}
+		return &WebhookResponse{Success: true, IncidentID: int(id)}, nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal failed: %w", err)
+	}
+
+	respBody, err := c.Post(ctx, c.current().serverURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp WebhookResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, fmt.Errorf("invalid server response: %w", err)
+	}
+	return &resp, nil
+}
+
+// withRetry retries send per the retry policy, giving up early, with the
+// last error, once ctx is done. While the server has asked to hold off it
+// fails without sending, so callers queue the incident rather than wait.
+func (c *Client) withRetry(ctx context.Context, send func() error) error {
+	retry := c.current().retry
+	for attempt := 1; ; attempt++ {
+		if wait := c.ThrottledFor(); wait > 0 {
+			return &ThrottleError{RetryAfter: wait}
+		}
+		err := send()
+		var throttle *ThrottleError
+		if errors.As(err, &throttle) {
+			c.throttle(throttle.RetryAfter)
+			return err
+		}
+		if err == nil || !IsRetryable(err) || attempt >= retry.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(retry.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// ThrottledFor returns how long the server asked the agent to hold off
+// sending for, or 0.
+func (c *Client) ThrottledFor() time.Duration {
+	return max(time.Until(time.Unix(0, c.throttledUntil.Load())), 0)
+}
+
+func (c *Client) throttle(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	for {
+		prev := c.throttledUntil.Load()
+		if prev >= until {
+			return
+		}
+		if c.throttledUntil.CompareAndSwap(prev, until) {
+			slog.Warn("server asked to hold off sending", "retry_in", d.Round(time.Second).String())
+			return
+		}
+	}
+}
+
+// Post sends body to url with the client's auth, compression and TLS
+// settings, without retrying, and returns the server's reply. Callers use it
+// for the server's other endpoints.
+func (c *Client) Post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	settings := c.current()
+	data := body
+	compressed := settings.payload.Gzip && len(body) >= gzipMinSize
+	if compressed {
+		var err error
+		if data, err = gzipBody(body); err != nil {
+			return nil, fmt.Errorf("compress failed: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	// Signatures cover the uncompressed body
+	if settings.auth != nil {
+		settings.auth.Apply(req, body)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if wait, ok := retryAfter(resp, parseReply(respBody)); ok {
+		return nil, &ThrottleError{RetryAfter: wait}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return respBody, nil
+}
+
+// retryAfter reports whether the server throttled the request and for how
+// long: with a throttle reply, or a 429 or 503 saying when to retry.
+func retryAfter(resp *http.Response, reply WebhookResponse) (time.Duration, bool) {
+	if reply.Status == "throttle" {
+		if reply.RetryAfter > 0 {
+			return time.Duration(reply.RetryAfter) * time.Second, true
+		}
+		return defaultRetryAfter, true
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if reply.RetryAfter > 0 {
+		return time.Duration(reply.RetryAfter) * time.Second, true
+	}
+	header := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil && time.Until(at) > 0 {
+		return time.Until(at), true
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+package ship
+
+import (
+	"cmp"
+	"os"
+	"strings"
+)
+
+// serviceAccountNamespace is where Kubernetes mounts the pod's namespace.
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ContainerInfo tells which replica an incident came from when the agent
+// runs in a container.
+type ContainerInfo struct {
+	ID        string `json:"id,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// DetectContainer reads the container ID from the cgroups and the pod from
+// the downward API: POD_NAME and POD_NAMESPACE, which the pod spec must map
+// from metadata.name and metadata.namespace. Without them the pod's hostname
+// and service account namespace are used. The image is only known when
+// CONTAINER_IMAGE is set. It returns nil outside a container.
+func DetectContainer() *ContainerInfo {
+	info := &ContainerInfo{
+		ID:        containerID(),
+		Image:     os.Getenv("CONTAINER_IMAGE"),
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		if info.Pod == "" {
+			info.Pod, _ = os.Hostname()
+		}
+		if info.Namespace == "" {
+			data, _ := os.ReadFile(serviceAccountNamespace)
+			info.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if cmp.Or(info.ID, info.Image, info.Pod, info.Namespace) == "" {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,29 @@
+package ship
+
+import (
+	"os"
+	"regexp"
+)
+
+var (
+	// Docker, containerd and CRI-O name cgroups after the 64-digit ID, e.g.
+	// /docker/<id> or /kubepods/.../cri-containerd-<id>.scope
+	cgroupContainerID = regexp.MustCompile(`[0-9a-f]{64}`)
+	// With cgroup v2 the cgroup path is just /, but Docker still mounts
+	// /etc/hostname and friends from /var/lib/docker/containers/<id>/
+	mountContainerID = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+)
+
+func containerID() string {
+	if data, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		if ids := cgroupContainerID.FindAll(data, -1); len(ids) > 0 {
+			return string(ids[len(ids)-1])
+		}
+	}
+	if data, err := os.ReadFile("/proc/self/mountinfo"); err == nil {
+		if m := mountContainerID.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	}
+	return ""
+}
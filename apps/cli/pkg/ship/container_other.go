@@ -0,0 +1,7 @@
+//go:build !linux
+
+package ship
+
+func containerID() string {
+	return ""
+}
@@ -0,0 +1,288 @@
+package ship
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/incidentpb"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// grpcTimeout matches the HTTP client's timeout
+	grpcTimeout                 = 5 * time.Second
+	defaultGRPCKeepalive        = 30 * time.Second
+	defaultGRPCKeepaliveTimeout = 10 * time.Second
+)
+
+// GRPCConfig sends incidents to the server's gRPC endpoint instead of
+// posting JSON to server_url, which is still used for health checks and
+// dashboard links.
+type GRPCConfig struct {
+	Addr string `json:"addr"`
+	// Insecure disables TLS, e.g. behind a mesh that terminates it.
+	Insecure   bool   `json:"insecure,omitempty"`
+	CAFile     string `json:"ca_file,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	// Keepalive pings an idle connection this often, and drops it when a
+	// ping goes unanswered for KeepaliveTimeout.
+	Keepalive        detect.Duration `json:"keepalive,omitempty"`
+	KeepaliveTimeout detect.Duration `json:"keepalive_timeout,omitempty"`
+}
+
+func (c *GRPCConfig) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("addr must be host:port: %w", err)
+	}
+	if c.Insecure && (c.CAFile != "" || c.ServerName != "") {
+		return errors.New("ca_file and server_name require TLS")
+	}
+	if c.Keepalive < 0 || c.KeepaliveTimeout < 0 {
+		return errors.New("keepalive and keepalive_timeout must be positive")
+	}
+	return nil
+}
+
+// grpcTransport delivers incidents over a single long-lived connection.
+type grpcTransport struct {
+	conn   *grpc.ClientConn
+	client incidentpb.IncidentServiceClient
+}
+
+func newGRPCTransport(cfg *GRPCConfig, compress bool) (*grpcTransport, error) {
+	creds := insecure.NewCredentials()
+	if !cfg.Insecure {
+		tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+		if cfg.CAFile != "" {
+			pool, err := loadCertPool(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	params := keepalive.ClientParameters{
+		Time:                time.Duration(cfg.Keepalive),
+		Timeout:             time.Duration(cfg.KeepaliveTimeout),
+		PermitWithoutStream: true,
+	}
+	if params.Time == 0 {
+		params.Time = defaultGRPCKeepalive
+	}
+	if params.Timeout == 0 {
+		params.Timeout = defaultGRPCKeepaliveTimeout
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(params),
+	}
+	if compress {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	conn, err := grpc.NewClient(cfg.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcTransport{conn: conn, client: incidentpb.NewIncidentServiceClient(conn)}, nil
+}
+
+func (t *grpcTransport) Report(ctx context.Context, auth *AuthConfig, payload IncidentPayload) (int64, error) {
+	req := incidentProto(payload)
+	ctx, err := withAuth(ctx, auth, req)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, grpcTimeout)
+	defer cancel()
+	resp, err := t.client.Report(ctx, req)
+	if err != nil {
+		return 0, grpcError(err)
+	}
+	return resp.GetIncidentId(), nil
+}
+
+func (t *grpcTransport) ReportBatch(ctx context.Context, auth *AuthConfig, payloads []IncidentPayload) error {
+	req := &incidentpb.IncidentBatch{Incidents: make([]*incidentpb.Incident, len(payloads))}
+	for i, p := range payloads {
+		req.Incidents[i] = incidentProto(p)
+	}
+	ctx, err := withAuth(ctx, auth, req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, grpcTimeout)
+	defer cancel()
+	if _, err := t.client.ReportBatch(ctx, req); err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (t *grpcTransport) Close() error {
+	return t.conn.Close()
+}
+
+// withAuth sends the configured credentials as metadata, with the
+// signatures covering the encoded message in place of the JSON body.
+func withAuth(ctx context.Context, auth *AuthConfig, msg proto.Message) (context.Context, error) {
+	if auth == nil {
+		return ctx, nil
+	}
+	var body []byte
+	if auth.signs() {
+		var err error
+		// Deterministic, so the tags map encodes the same on both ends
+		if body, err = (proto.MarshalOptions{Deterministic: true}).Marshal(msg); err != nil {
+			return nil, fmt.Errorf("marshal failed: %w", err)
+		}
+	}
+	var kv []string
+	for k, v := range auth.Headers(body) {
+		kv = append(kv, strings.ToLower(k), v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...), nil
+}
+
+// grpcHTTPStatus maps gRPC codes onto the HTTP statuses isRetryable
+// understands; codes not listed are treated like network errors.
+var grpcHTTPStatus = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+}
+
+func grpcError(err error) error {
+	st := status.Convert(err)
+	if code, ok := grpcHTTPStatus[st.Code()]; ok {
+		return fmt.Errorf("%w: %s", &StatusError{Code: code}, st.Message())
+	}
+	return fmt.Errorf("send failed: %w", err)
+}
+
+func incidentProto(p IncidentPayload) *incidentpb.Incident {
+	msg := &incidentpb.Incident{
+		ErrorLine:       p.ErrorLine,
+		Timestamp:       p.Timestamp,
+		LogTimestamp:    p.LogTimestamp,
+		Hostname:        p.Hostname,
+		RepoUrl:         p.RepoURL,
+		Target:          p.Target,
+		Context:         p.Context,
+		Severity:        p.Severity,
+		Truncated:       p.Truncated,
+		OccurrenceCount: int32(p.OccurrenceCount),
+		FirstSeen:       p.FirstSeen,
+		LastSeen:        p.LastSeen,
+		Language:        p.Language,
+		AgentVersion:    p.AgentVersion,
+		Tags:            p.Tags,
+		Service:         p.Service,
+		Environment:     p.Environment,
+		Version:         p.Version,
+	}
+	for _, f := range p.Frames {
+		msg.Frames = append(msg.Frames, &incidentpb.StackFrame{
+			File:     f.File,
+			Line:     int32(f.Line),
+			Function: f.Function,
+		})
+	}
+	if c := p.Container; c != nil {
+		msg.Container = &incidentpb.ContainerInfo{Id: c.ID, Image: c.Image, Pod: c.Pod, Namespace: c.Namespace}
+	}
+	if p.Git != nil {
+		msg.Git = &incidentpb.GitInfo{Commit: p.Git.Commit, Branch: p.Git.Branch}
+		if b := p.Git.Blame; b != nil {
+			msg.Git.Blame = &incidentpb.Blame{
+				File:        b.File,
+				Line:        int32(b.Line),
+				Commit:      b.Commit,
+				Author:      b.Author,
+				AuthorEmail: b.AuthorEmail,
+				AuthoredAt:  b.AuthoredAt.Format(time.RFC3339),
+				Summary:     b.Summary,
+			}
+		}
+	}
+	return msg
+}
+
+// PayloadFromProto is the reverse of incidentProto, for incidents received
+// over gRPC.
+func PayloadFromProto(msg *incidentpb.Incident) IncidentPayload {
+	p := IncidentPayload{
+		ErrorLine:       msg.GetErrorLine(),
+		Timestamp:       msg.GetTimestamp(),
+		LogTimestamp:    msg.GetLogTimestamp(),
+		Hostname:        msg.GetHostname(),
+		RepoURL:         msg.GetRepoUrl(),
+		Target:          msg.GetTarget(),
+		Context:         msg.GetContext(),
+		Severity:        msg.GetSeverity(),
+		Truncated:       msg.GetTruncated(),
+		OccurrenceCount: int(msg.GetOccurrenceCount()),
+		FirstSeen:       msg.GetFirstSeen(),
+		LastSeen:        msg.GetLastSeen(),
+		Language:        msg.GetLanguage(),
+		AgentVersion:    msg.GetAgentVersion(),
+		Tags:            msg.GetTags(),
+		Service:         msg.GetService(),
+		Environment:     msg.GetEnvironment(),
+		Version:         msg.GetVersion(),
+	}
+	for _, f := range msg.GetFrames() {
+		p.Frames = append(p.Frames, detect.StackFrame{
+			File:     f.GetFile(),
+			Line:     int(f.GetLine()),
+			Function: f.GetFunction(),
+		})
+	}
+	if c := msg.GetContainer(); c != nil {
+		p.Container = &ContainerInfo{ID: c.GetId(), Image: c.GetImage(), Pod: c.GetPod(), Namespace: c.GetNamespace()}
+	}
+	if g := msg.GetGit(); g != nil {
+		p.Git = &GitInfo{Commit: g.GetCommit(), Branch: g.GetBranch()}
+		if b := g.GetBlame(); b != nil {
+			authoredAt, _ := time.Parse(time.RFC3339, b.GetAuthoredAt())
+			p.Git.Blame = &BlameInfo{
+				File:        b.GetFile(),
+				Line:        int(b.GetLine()),
+				Commit:      b.GetCommit(),
+				Author:      b.GetAuthor(),
+				AuthorEmail: b.GetAuthorEmail(),
+				AuthoredAt:  authoredAt,
+				Summary:     b.GetSummary(),
+			}
+		}
+	}
+	return p
+}
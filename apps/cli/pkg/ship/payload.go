@@ -0,0 +1,118 @@
+package ship
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// header outweighs the savings.
+const gzipMinSize = 1024
+
+// PayloadConfig keeps request bodies small: Gzip compresses them, and
+// MaxContextBytes caps the context sent with each incident.
+type PayloadConfig struct {
+	Gzip            bool `json:"gzip,omitempty"`
+	MaxContextBytes int  `json:"max_context_bytes,omitempty"`
+}
+
+func (c *PayloadConfig) Validate() error {
+	if c.MaxContextBytes < 0 {
+		return errors.New("max_context_bytes must be positive")
+	}
+	return nil
+}
+
+type GitInfo struct {
+	Commit string     `json:"commit"`
+	Branch string     `json:"branch,omitempty"`
+	Blame  *BlameInfo `json:"blame,omitempty"`
+}
+
+type BlameInfo struct {
+	File        string    `json:"file"`
+	Line        int       `json:"line"`
+	Commit      string    `json:"commit"`
+	Author      string    `json:"author,omitempty"`
+	AuthorEmail string    `json:"author_email,omitempty"`
+	AuthoredAt  time.Time `json:"authored_at"`
+	Summary     string    `json:"summary,omitempty"`
+}
+
+// capContext trims lines to about max bytes by dropping lines from the
+// middle, keeping the start of the trace and the error at its end. A line
+// in place of the dropped ones says how many there were.
+func capContext(lines []string, max int) []string {
+	total := 0
+	for _, line := range lines {
+		total += len(line)
+	}
+	if max <= 0 || total <= max {
+		return lines
+	}
+
+	// Taking lines from each end in turn keeps as much of both as fits
+	budget := max
+	var head, tail []string
+	i, j := 0, len(lines)-1
+	for i <= j {
+		if len(lines[i]) > budget {
+			break
+		}
+		head = append(head, lines[i])
+		budget -= len(lines[i])
+		i++
+		if i > j || len(lines[j]) > budget {
+			break
+		}
+		tail = append(tail, lines[j])
+		budget -= len(lines[j])
+		j--
+	}
+	if len(head) == 0 {
+		head = []string{Truncate(lines[0], max)}
+		i = 1
+	}
+
+	out := head
+	if omitted := j - i + 1; omitted > 0 {
+		out = append(out, fmt.Sprintf("… %d lines omitted …", omitted))
+	}
+	for k := len(tail) - 1; k >= 0; k-- {
+		out = append(out, tail[k])
+	}
+	return out
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Truncate shortens s to at most n bytes, ending with "…" when it is cut,
+// without splitting a character.
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	const ellipsis = "…"
+	if n < len(ellipsis) {
+		return ""
+	}
+	n -= len(ellipsis)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + ellipsis
+}
@@ -0,0 +1,80 @@
+package ship
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 500 * time.Millisecond
+	defaultMaxDelay    = 10 * time.Second
+	defaultJitter      = 0.2
+)
+
+type RetryConfig struct {
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	BaseDelay   detect.Duration `json:"base_delay,omitempty"`
+	MaxDelay    detect.Duration `json:"max_delay,omitempty"`
+	Jitter      float64         `json:"jitter,omitempty"`
+}
+
+func (c *RetryConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return errors.New("max_attempts must be positive")
+	}
+	if c.BaseDelay < 0 || c.MaxDelay < 0 {
+		return errors.New("delays must be positive")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// RetryPolicy decides how often and how long to wait between attempts.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+func NewRetryPolicy(cfg *RetryConfig) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		BaseDelay:   defaultBaseDelay,
+		MaxDelay:    defaultMaxDelay,
+		Jitter:      defaultJitter,
+	}
+	if cfg == nil {
+		return policy
+	}
+	if cfg.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.MaxAttempts
+	}
+	if cfg.BaseDelay > 0 {
+		policy.BaseDelay = time.Duration(cfg.BaseDelay)
+	}
+	if cfg.MaxDelay > 0 {
+		policy.MaxDelay = time.Duration(cfg.MaxDelay)
+	}
+	if cfg.Jitter > 0 {
+		policy.Jitter = cfg.Jitter
+	}
+	return policy
+}
+
+// Delay returns the wait before the given retry (1 for the first retry):
+// exponential growth capped at MaxDelay, spread by ±Jitter.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.BaseDelay << (retry - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	spread := 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(delay) * spread)
+}
@@ -0,0 +1,63 @@
+package watch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+func TestTraceBudgetSharedByWatchers(t *testing.T) {
+	detector, err := detect.NewDetector(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := "\tat handle (/srv/app/server.js:42:7)"
+	budget := detect.NewTraceBudget(20 * (len(frame) + detect.LineOverhead))
+	target := Target{LogPath: "a", MaxTraceLines: 1000, TraceBudget: budget}
+	first := NewStreamWatcher(target, detector, strings.NewReader(""))
+	second := NewStreamWatcher(target, detector, strings.NewReader(""))
+	events := make(chan detect.Event, 10)
+
+	trace := []rawLine{{raw: "TypeError: boom"}}
+	for range 15 {
+		trace = append(trace, rawLine{raw: frame})
+	}
+	first.handleLines(trace, events)
+	if len(events) != 0 {
+		t.Fatalf("a trace within the budget was sent early")
+	}
+	// The second trace only has what the first left over
+	second.handleLines(trace, events)
+	if len(events) != 1 {
+		t.Fatalf("%d events, want the second trace cut at the shared budget", len(events))
+	}
+	if event := <-events; len(event.Context) >= len(trace) {
+		t.Fatalf("second trace kept %d lines past the budget", len(event.Context))
+	}
+
+	first.flushTraces(events)
+	second.flushTraces(events)
+	second.Close()
+	first.Close()
+	if used := budget.Used(); used != 0 {
+		t.Fatalf("budget.Used() = %d once every trace was sent, want 0", used)
+	}
+}
+
+func TestCloseReleasesTraceBudget(t *testing.T) {
+	detector, err := detect.NewDetector(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := detect.NewTraceBudget(1 << 20)
+	w := NewStreamWatcher(Target{LogPath: "a", TraceBudget: budget}, detector, strings.NewReader(""))
+	w.handleLines([]rawLine{{raw: "panic: boom"}, {raw: "\tat main.go:1"}}, make(chan detect.Event, 1))
+	if budget.Used() == 0 {
+		t.Fatal("a trace in progress took nothing from the budget")
+	}
+	w.Close()
+	if used := budget.Used(); used != 0 {
+		t.Fatalf("budget.Used() = %d after Close, want 0", used)
+	}
+}
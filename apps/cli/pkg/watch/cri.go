@@ -0,0 +1,93 @@
+package watch
+
+import (
+	"strings"
+	"time"
+)
+
+// Formats a target's lines may be wrapped in
+const (
+	// FormatAuto reads a target as CRI when its first line is in that
+	// format, and as plain otherwise
+	FormatAuto = "auto"
+	// FormatPlain lines are read as they are
+	FormatPlain = "plain"
+	// FormatCRI lines are written by a container runtime for Kubernetes:
+	// "2024-01-17T12:00:00.000000000Z stderr F message"
+	FormatCRI = "cri"
+)
+
+func validFormat(format string) bool {
+	switch format {
+	case "", FormatAuto, FormatPlain, FormatCRI:
+		return true
+	}
+	return false
+}
+
+// parseCRILine splits a line of the CRI log format into the message, the
+// time the runtime recorded it and whether it is a partial line, which the
+// runtime split off a longer one and the next line continues. ok is false
+// for lines not in the format.
+func parseCRILine(line string) (message string, ts time.Time, partial, ok bool) {
+	stamp, rest, found := strings.Cut(line, " ")
+	if !found {
+		return "", time.Time{}, false, false
+	}
+	stream, rest, found := strings.Cut(rest, " ")
+	if !found || (stream != "stdout" && stream != "stderr") {
+		return "", time.Time{}, false, false
+	}
+	// The tag is P or F, possibly followed by more flags after a colon
+	tag, message, _ := strings.Cut(rest, " ")
+	flag, _, _ := strings.Cut(tag, ":")
+	if flag != "P" && flag != "F" {
+		return "", time.Time{}, false, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return "", time.Time{}, false, false
+	}
+	return message, ts, flag == "P", true
+}
+
+// unwrap takes a line read from the target out of what its format wraps it
+// in, settling an auto format on the first line. It returns false while the
+// line is incomplete.
+func (w *Watcher) unwrap(r rawLine) (rawLine, bool) {
+	if w.format == FormatAuto {
+		w.format = FormatPlain
+		if _, _, _, ok := parseCRILine(strings.TrimRight(r.raw, "\r\n")); ok {
+			w.format = FormatCRI
+		}
+	}
+	if w.format == FormatCRI {
+		return w.unwrapCRI(r)
+	}
+	return r, true
+}
+
+// unwrapCRI strips the CRI prefix off a line read from the target, joining
+// the parts of a line the runtime split. It returns false while the line is
+// incomplete. Lines not in the format are returned as they are.
+func (w *Watcher) unwrapCRI(r rawLine) (rawLine, bool) {
+	message, ts, partial, ok := parseCRILine(strings.TrimRight(r.raw, "\r\n"))
+	if !ok {
+		return r, true
+	}
+	w.criBytes += len(r.raw)
+	if !w.criTruncated {
+		w.criPending += message
+		if len(w.criPending) > w.maxLineLength {
+			w.criPending = trimPartialRune(w.criPending[:w.maxLineLength], w.encoding)
+			w.criTruncated = true
+		}
+	}
+	w.criTruncated = w.criTruncated || r.truncated
+	if partial {
+		return rawLine{}, false
+	}
+	line := rawLine{raw: w.criPending, truncated: w.criTruncated, time: ts}
+	w.criPending, w.criBytes, w.criTruncated = "", 0, false
+	return line, true
+}
@@ -0,0 +1,120 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+func TestParseCRILine(t *testing.T) {
+	stamp := "2024-01-17T12:00:00.123456789Z"
+	tests := []struct {
+		line    string
+		message string
+		partial bool
+		ok      bool
+	}{
+		{stamp + " stderr F panic: boom", "panic: boom", false, true},
+		{stamp + " stdout P first half", "first half", true, true},
+		{stamp + " stdout F:extra message", "message", false, true},
+		{stamp + " stdout F", "", false, true},
+		{stamp + " stdout F  indented", " indented", false, true},
+		{stamp + " stdin F message", "", false, false},
+		{stamp + " stdout X message", "", false, false},
+		{"yesterday stdout F message", "", false, false},
+		{stamp, "", false, false},
+		{stamp + " stdout", "", false, false},
+		{"plain log line", "", false, false},
+		{"", "", false, false},
+	}
+	for _, tt := range tests {
+		message, ts, partial, ok := parseCRILine(tt.line)
+		if message != tt.message || partial != tt.partial || ok != tt.ok {
+			t.Errorf("parseCRILine(%q) = %q, %v, %v; want %q, %v, %v", tt.line, message, partial, ok, tt.message, tt.partial, tt.ok)
+		}
+		if ok && !ts.Equal(time.Date(2024, 1, 17, 12, 0, 0, 123456789, time.UTC)) {
+			t.Errorf("parseCRILine(%q) time = %v", tt.line, ts)
+		}
+	}
+}
+
+func TestUnwrapCRI(t *testing.T) {
+	stamp := "2024-01-17T12:00:00Z stdout "
+	tests := []struct {
+		name      string
+		max       int
+		lines     []string
+		want      string
+		truncated bool
+	}{
+		{"full", 100, []string{"F hello\n"}, "hello", false},
+		{"joined", 100, []string{"P abc\n", "P def\n", "F ghi\n"}, "abcdefghi", false},
+		{"not cri", 100, []string{"plain line\n"}, "plain line\n", false},
+		{"cut", 5, []string{"P abc\n", "F defgh\n"}, "abcde", true},
+		{"cut inside a rune", 4, []string{"P abc\n", "F éé\n"}, "abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Watcher{format: FormatCRI, maxLineLength: tt.max}
+			var got rawLine
+			var ok bool
+			for i, line := range tt.lines {
+				if !strings.HasPrefix(line, "plain") {
+					line = stamp + line
+				}
+				got, ok = w.unwrap(rawLine{raw: line})
+				if last := i == len(tt.lines)-1; ok != last {
+					t.Fatalf("line %d: complete = %v, want %v", i, ok, last)
+				}
+			}
+			if got.raw != tt.want || got.truncated != tt.truncated {
+				t.Fatalf("got %q (truncated %v), want %q (truncated %v)", got.raw, got.truncated, tt.want, tt.truncated)
+			}
+			if !utf8.ValidString(got.raw) {
+				t.Fatalf("got invalid UTF-8 %q", got.raw)
+			}
+			if w.criPending != "" || w.criBytes != 0 || w.criTruncated {
+				t.Fatalf("CRI state not reset: %q %d %v", w.criPending, w.criBytes, w.criTruncated)
+			}
+		})
+	}
+}
+
+func TestTruncationDropsPartialCRILine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	stamp := "2024-01-17T12:00:00Z stdout "
+	if err := os.WriteFile(path, []byte(stamp+"P the start of a long line from before\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	detector, err := detect.NewDetector(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcherAt(Target{LogPath: path, Format: FormatCRI}, detector, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if lines, _ := w.readBatch(); len(lines) != 0 {
+		t.Fatalf("partial line returned: %v", lines)
+	}
+	if err := os.WriteFile(path, []byte(stamp+"F fresh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.checkRotation(make(chan detect.Event, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if offset := w.ResumeOffset(); offset != 0 {
+		t.Fatalf("ResumeOffset() = %d after truncation, want 0", offset)
+	}
+	lines, _ := w.readBatch()
+	if len(lines) != 1 || lines[0].raw != "fresh" {
+		t.Fatalf("readBatch() = %v, want the new file's line alone", lines)
+	}
+}
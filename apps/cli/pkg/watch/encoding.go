@@ -0,0 +1,146 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Log file encodings. EncodingAuto reads UTF-16LE files that start with a
+// byte order mark or look like UTF-16, and otherwise reads lines as UTF-8,
+// falling back to Latin-1 for lines that are not valid UTF-8.
+const (
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf8"
+	EncodingUTF16LE = "utf16le"
+	EncodingLatin1  = "latin1"
+)
+
+func validEncoding(enc string) bool {
+	switch enc {
+	case "", EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingLatin1:
+		return true
+	}
+	return false
+}
+
+// DetectEncoding looks at the start of an auto-encoded file. A UTF-16LE
+// file without a byte order mark has a zero high byte for every ASCII
+// character.
+func DetectEncoding(file *os.File) string {
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	head = head[:n]
+	if bytes.HasPrefix(head, []byte{0xff, 0xfe}) {
+		return EncodingUTF16LE
+	}
+	if len(head) < 4 {
+		return EncodingAuto
+	}
+	var oddZeros, evenZeros int
+	for i, b := range head {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 1 {
+			oddZeros++
+		} else {
+			evenZeros++
+		}
+	}
+	if oddZeros > len(head)/4 && evenZeros == 0 {
+		return EncodingUTF16LE
+	}
+	return EncodingAuto
+}
+
+// readEncodedLine reads up to and including the next newline as raw bytes,
+// keeping at most limit of them so a huge line does not have to fit in
+// memory. It returns the bytes kept and how many more were read and
+// dropped. In UTF-16LE a newline is the code unit 0x000a, so a 0x0a byte
+// only ends the line at an even offset from the line start and followed by
+// a zero. skew is the length of a partial line read before, which the
+// offsets continue from.
+func readEncodedLine(r *bufio.Reader, enc string, skew, limit int) (string, int, error) {
+	var line []byte
+	var n int
+	keep := func(b []byte) {
+		n += len(b)
+		if room := limit - len(line); room > 0 {
+			line = append(line, b[:min(room, len(b))]...)
+		}
+	}
+	for {
+		chunk, err := r.ReadSlice('\n')
+		keep(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil || enc != EncodingUTF16LE {
+			return string(line), n - len(line), err
+		}
+		if (skew+n)%2 == 0 {
+			continue
+		}
+		next, err := r.ReadByte()
+		if err != nil {
+			return string(line), n - len(line), err
+		}
+		keep([]byte{next})
+		if next == 0 {
+			return string(line), n - len(line), nil
+		}
+	}
+}
+
+// trimPartialRune drops a UTF-8 sequence that truncating a line cut in two,
+// which would otherwise have the line read as Latin-1.
+func trimPartialRune(raw, enc string) string {
+	if enc == EncodingUTF16LE || enc == EncodingLatin1 {
+		return raw
+	}
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(raw); i++ {
+		if utf8.RuneStart(raw[len(raw)-i]) {
+			if !utf8.FullRuneInString(raw[len(raw)-i:]) {
+				return raw[:len(raw)-i]
+			}
+			break
+		}
+	}
+	return raw
+}
+
+// decodeLine converts a raw line to UTF-8 and drops a byte order mark.
+func decodeLine(raw, enc string) string {
+	var s string
+	switch enc {
+	case EncodingUTF16LE:
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+		}
+		s = string(utf16.Decode(units))
+	case EncodingLatin1:
+		s = latin1ToUTF8(raw)
+	case EncodingUTF8:
+		s = raw
+	default:
+		s = raw
+		if !utf8.ValidString(raw) {
+			s = latin1ToUTF8(raw)
+		}
+	}
+	return strings.TrimPrefix(s, "\ufeff")
+}
+
+// latin1ToUTF8 maps each byte to the code point of the same value.
+func latin1ToUTF8(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,23 @@
+package watch
+
+import "time"
+
+const pollInterval = 50 * time.Millisecond
+
+// changeNotifier blocks until the watched file may have new data, or the
+// timeout elapses, whichever comes first.
+type changeNotifier interface {
+	Wait(timeout time.Duration)
+	Close() error
+}
+
+// pollNotifier is the portable fallback: it just sleeps.
+type pollNotifier struct{}
+
+func (pollNotifier) Wait(timeout time.Duration) {
+	time.Sleep(min(timeout, pollInterval))
+}
+
+func (pollNotifier) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package watch
+
+import (
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// kqueueNotifier watches the log file's directory for entries being added,
+// removed or renamed, as rotation does, and the file itself for writes.
+// kqueue watches open files, so the file is opened again after the
+// directory changes.
+type kqueueNotifier struct {
+	kq     int
+	dir    int
+	file   int
+	path   string
+	events [8]unix.Kevent_t
+}
+
+func newChangeNotifier(path string) changeNotifier {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return pollNotifier{}
+	}
+	unix.CloseOnExec(kq)
+	dir, err := unix.Open(filepath.Dir(path), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(kq)
+		return pollNotifier{}
+	}
+	n := &kqueueNotifier{kq: kq, dir: dir, file: -1, path: path}
+	if err := n.register(dir, unix.NOTE_WRITE); err != nil {
+		n.Close()
+		return pollNotifier{}
+	}
+	n.watchFile()
+	return n
+}
+
+func (n *kqueueNotifier) register(fd int, fflags uint32) error {
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	ev.Fflags = fflags
+	_, err := unix.Kevent(n.kq, []unix.Kevent_t{ev}, nil, nil)
+	return err
+}
+
+// watchFile starts watching the file now at path, which rotation may have
+// replaced. A missing file is picked up once the directory changes.
+func (n *kqueueNotifier) watchFile() {
+	n.closeFile()
+	fd, err := unix.Open(n.path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	if err := n.register(fd, unix.NOTE_WRITE|unix.NOTE_EXTEND|unix.NOTE_DELETE|unix.NOTE_RENAME|unix.NOTE_ATTRIB); err != nil {
+		unix.Close(fd)
+		return
+	}
+	n.file = fd
+}
+
+// closeFile stops watching the file; closing it removes its events.
+func (n *kqueueNotifier) closeFile() {
+	if n.file >= 0 {
+		unix.Close(n.file)
+		n.file = -1
+	}
+}
+
+func (n *kqueueNotifier) Wait(timeout time.Duration) {
+	if n.file < 0 {
+		n.watchFile()
+	}
+	ts := unix.NsecToTimespec(timeout.Nanoseconds())
+	count, err := unix.Kevent(n.kq, nil, n.events[:], &ts)
+	if err != nil {
+		return
+	}
+	for _, ev := range n.events[:count] {
+		switch {
+		case int(ev.Ident) == n.dir:
+			n.watchFile()
+		case ev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0:
+			n.closeFile()
+		}
+	}
+}
+
+func (n *kqueueNotifier) Close() error {
+	n.closeFile()
+	unix.Close(n.dir)
+	return unix.Close(n.kq)
+}
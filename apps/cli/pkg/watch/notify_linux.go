@@ -0,0 +1,62 @@
+package watch
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// inotifyNotifier watches the log file's directory rather than the file
+// itself so that renames and re-creations during rotation also wake us up.
+type inotifyNotifier struct {
+	file *os.File
+	name string
+	buf  [64 * 1024]byte
+}
+
+func newChangeNotifier(path string) changeNotifier {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return pollNotifier{}
+	}
+
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		return pollNotifier{}
+	}
+
+	return &inotifyNotifier{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		name: filepath.Base(path),
+	}
+}
+
+func (n *inotifyNotifier) Wait(timeout time.Duration) {
+	n.file.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		nr, err := n.file.Read(n.buf[:])
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= nr; {
+			mask := binary.NativeEndian.Uint32(n.buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(n.buf[off+12:]))
+			start := off + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(n.buf[start:start+nameLen]), "\x00")
+			if name == n.name || mask&syscall.IN_Q_OVERFLOW != 0 {
+				return
+			}
+			off = start + nameLen
+		}
+	}
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package watch
+
+func newChangeNotifier(path string) changeNotifier {
+	return pollNotifier{}
+}
@@ -0,0 +1,113 @@
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const dirChangeFilter = windows.FILE_NOTIFY_CHANGE_FILE_NAME |
+	windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// dirChangeNotifier watches the log file's directory with
+// ReadDirectoryChangesW, so writes as well as renames and re-creations
+// during rotation wake us up.
+type dirChangeNotifier struct {
+	dir     windows.Handle
+	event   windows.Handle
+	ov      windows.Overlapped
+	pending bool
+	name    string
+	// buf is written by the system while a read is pending; being large,
+	// it is allocated on its own DWORD-aligned pages
+	buf []byte
+}
+
+func newChangeNotifier(path string) changeNotifier {
+	dirName, err := windows.UTF16PtrFromString(filepath.Dir(path))
+	if err != nil {
+		return pollNotifier{}
+	}
+	dir, err := windows.CreateFile(dirName, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return pollNotifier{}
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(dir)
+		return pollNotifier{}
+	}
+	n := &dirChangeNotifier{
+		dir:   dir,
+		event: event,
+		name:  filepath.Base(path),
+		buf:   make([]byte, 64*1024),
+	}
+	n.ov.HEvent = event
+	return n
+}
+
+func (n *dirChangeNotifier) read() error {
+	windows.ResetEvent(n.event)
+	err := windows.ReadDirectoryChanges(n.dir, &n.buf[0], uint32(len(n.buf)), false, dirChangeFilter, nil, &n.ov, 0)
+	n.pending = err == nil
+	return err
+}
+
+func (n *dirChangeNotifier) Wait(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !n.pending {
+			if err := n.read(); err != nil {
+				time.Sleep(min(timeout, pollInterval))
+				return
+			}
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return
+		}
+		if ev, _ := windows.WaitForSingleObject(n.event, uint32(remaining.Milliseconds())); ev != windows.WAIT_OBJECT_0 {
+			return
+		}
+		var size uint32
+		err := windows.GetOverlappedResult(n.dir, &n.ov, &size, false)
+		n.pending = false
+		// An empty result means the buffer overflowed and changes were lost
+		if err != nil || size == 0 || n.changed(size) {
+			return
+		}
+	}
+}
+
+// changed reports whether the first size bytes of buf mention the file.
+func (n *dirChangeNotifier) changed(size uint32) bool {
+	for off := uint32(0); off < size; {
+		info := (*windows.FileNotifyInformation)(unsafe.Pointer(&n.buf[off]))
+		name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+		if strings.EqualFold(name, n.name) {
+			return true
+		}
+		if info.NextEntryOffset == 0 {
+			break
+		}
+		off += info.NextEntryOffset
+	}
+	return false
+}
+
+func (n *dirChangeNotifier) Close() error {
+	if n.pending {
+		// The system writes to buf until the read is cancelled
+		windows.CancelIoEx(n.dir, &n.ov)
+		var size uint32
+		windows.GetOverlappedResult(n.dir, &n.ov, &size, true)
+	}
+	windows.CloseHandle(n.event)
+	return windows.CloseHandle(n.dir)
+}
@@ -0,0 +1,38 @@
+package watch
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ansiEscape matches terminal escape sequences: CSI (colors, cursor
+// movement), OSC (titles, hyperlinks) and the two-byte escapes.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)?|\x1b[@-Z\\-_]`)
+
+// sanitizeLine strips escape sequences and control characters other than
+// tabs, which colored output leaves in logs and which would otherwise stop
+// patterns from matching and show up as garbage on the dashboard.
+func sanitizeLine(s string) string {
+	if !hasControl(s) {
+		return s
+	}
+	s = ansiEscape.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		if r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// hasControl reports whether s may contain a control character, so clean
+// lines skip the regex. 0xc2 leads the UTF-8 encoding of C1 controls.
+func hasControl(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; (b < 0x20 && b != '\t') || b == 0x7f || b == 0xc2 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package watch
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+const (
+	restartBaseDelay = time.Second
+	restartMaxDelay  = time.Minute
+)
+
+// Watcher states reported by Health
+const (
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+)
+
+// watchHealth is what the supervisor knows about a watcher, read by status
+// from other goroutines.
+type watchHealth struct {
+	mu       sync.Mutex
+	state    string
+	restarts int
+	lastErr  string
+}
+
+// Health returns the watcher's state, how often it was restarted and the
+// error it last failed with. The state is empty for watchers that are not
+// supervised, such as streams.
+func (w *Watcher) Health() (state string, restarts int, lastErr string) {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	return w.health.state, w.health.restarts, w.health.lastErr
+}
+
+func (w *Watcher) setState(state string, err error) {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	w.health.state = state
+	if err != nil {
+		w.health.lastErr = err.Error()
+	}
+	if state == StateRestarting {
+		w.health.restarts++
+	}
+}
+
+// Supervise watches the file until ctx is done. When the watcher fails, for
+// example because the file was deleted or became unreadable, it is reopened
+// and restarted, waiting longer after each failure that follows shortly
+// after the last.
+func (w *Watcher) Supervise(ctx context.Context, guard Guard, events chan<- detect.Event) {
+	delay := restartBaseDelay
+	for {
+		w.setState(StateRunning, nil)
+		started := time.Now()
+		err := w.WatchGuarded(ctx, guard, events)
+		if err == nil {
+			return
+		}
+		if time.Since(started) > restartMaxDelay {
+			delay = restartBaseDelay
+		}
+		for err != nil {
+			w.setState(StateRestarting, err)
+			slog.Warn("watcher failed, restarting", "path", w.path, "err", err, "in", delay.String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, restartMaxDelay)
+			err = w.reopen()
+		}
+		slog.Info("watcher restarted", "path", w.path)
+	}
+}
+
+// reopen opens the path again. The same file is read on from where the
+// watcher stopped; a file recreated in its place is read from the start.
+func (w *Watcher) reopen() error {
+	file, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	offset := w.ResumeOffset()
+	if prev, err := w.file.Stat(); (err == nil && !os.SameFile(prev, info)) || info.Size() < offset {
+		offset = 0
+		w.lineNumber = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	w.reader.Reset(file)
+	w.offset = offset
+	w.resetPending()
+	return nil
+}
@@ -0,0 +1,132 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+// Where a target starts reading when the watcher starts
+const (
+	StartFromEnd        = "end"
+	StartFromCheckpoint = "checkpoint"
+	StartFromBeginning  = "beginning"
+)
+
+func ValidStartFrom(s string) bool {
+	switch s {
+	case "", StartFromEnd, StartFromCheckpoint, StartFromBeginning:
+		return true
+	}
+	return false
+}
+
+// Target is a single log file, or a directory of log files, to watch and the
+// repository it belongs to. A Watcher reads the file at LogPath; Dir, Match,
+// Exclude, StartAt, IdleTTL, StartFrom and FollowSymlinks are for the
+// caller, which decides which files to watch and where each starts reading.
+type Target struct {
+	LogPath string `json:"log_path,omitempty"`
+	RepoURL string `json:"repo_url,omitempty"`
+	Label   string `json:"label,omitempty"`
+	// RepoPath is a local checkout of the repository, used to add the
+	// deployed commit to incidents.
+	RepoPath string `json:"repo_path,omitempty"`
+
+	Dir     string          `json:"dir,omitempty"`
+	Match   string          `json:"match,omitempty"`
+	Exclude string          `json:"exclude,omitempty"`
+	StartAt string          `json:"start_at,omitempty"`
+	IdleTTL detect.Duration `json:"idle_ttl,omitempty"`
+	// StartFrom is where files that exist when watching starts are read
+	// from: end, checkpoint or beginning. StartAt covers files that show
+	// up in a directory later.
+	StartFrom string `json:"start_from,omitempty"`
+	// FollowSymlinks also watches the files that links in Dir point to,
+	// such as the kubelet's links to container logs
+	FollowSymlinks bool `json:"follow_symlinks,omitempty"`
+
+	// ContextLines is how many lines before an error are searched for the
+	// start of its trace and kept as context, MaxTraceLines caps a trace and
+	// TraceTimeout is how long a trace waits for its next line
+	ContextLines  int             `json:"context_lines,omitempty"`
+	MaxTraceLines int             `json:"max_trace_lines,omitempty"`
+	TraceTimeout  detect.Duration `json:"trace_timeout,omitempty"`
+	// PostContextLines and PostContextTimeout keep the lines logged right
+	// after a trace, such as request IDs, in its context
+	PostContextLines   int             `json:"post_context_lines,omitempty"`
+	PostContextTimeout detect.Duration `json:"post_context_timeout,omitempty"`
+	// Timezone (an IANA name such as "Europe/Berlin", or "UTC") is what
+	// log timestamps without a zone are read in; the agent's by default
+	Timezone string `json:"timezone,omitempty"`
+	// Encoding is the file's character encoding: auto (default), utf8,
+	// utf16le or latin1
+	Encoding string `json:"encoding,omitempty"`
+	// Format is what lines are wrapped in: auto (default), plain, or cri
+	// for the logs container runtimes write, whose prefix is stripped
+	Format string `json:"format,omitempty"`
+	// MaxLineLength is how many bytes of a line are kept (default 64 KiB);
+	// the rest is dropped and the line marked as truncated
+	MaxLineLength int `json:"max_line_length,omitempty"`
+
+	// MaxTraceBytes caps the memory a trace in progress takes up, e.g. as
+	// its share of a memory limit; 0 for no cap
+	MaxTraceBytes int `json:"-"`
+	// TraceBudget caps the memory the traces in progress of every target
+	// sharing it take up together; nil for no cap
+	TraceBudget *detect.TraceBudget `json:"-"`
+}
+
+func (t *Target) Validate() error {
+	if t.LogPath == "" && t.Dir == "" {
+		return errors.New("log_path or dir is required")
+	}
+	if t.LogPath != "" && t.Dir != "" {
+		return errors.New("log_path and dir are mutually exclusive")
+	}
+	if t.RepoURL == "" {
+		return errors.New("repo_url is required")
+	}
+	if t.ContextLines < 0 || t.MaxTraceLines < 0 || t.TraceTimeout < 0 {
+		return errors.New("context_lines, max_trace_lines and trace_timeout must be positive")
+	}
+	if t.PostContextLines < 0 || t.PostContextTimeout < 0 {
+		return errors.New("post_context_lines and post_context_timeout must be positive")
+	}
+	if _, err := loadTimezone(t.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	if !validEncoding(t.Encoding) {
+		return fmt.Errorf("encoding must be auto, utf8, utf16le or latin1: %q", t.Encoding)
+	}
+	if !validFormat(t.Format) {
+		return fmt.Errorf("format must be auto, plain or cri: %q", t.Format)
+	}
+	if t.MaxLineLength < 0 {
+		return errors.New("max_line_length must be positive")
+	}
+	if t.Match != "" {
+		if _, err := regexp.Compile(t.Match); err != nil {
+			return fmt.Errorf("match: %w", err)
+		}
+	}
+	if t.Exclude != "" {
+		if _, err := regexp.Compile(t.Exclude); err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+	}
+	switch t.StartAt {
+	case "", "beginning", "end":
+	default:
+		return errors.New("start_at must be beginning or end")
+	}
+	if t.IdleTTL < 0 {
+		return errors.New("idle_ttl must be positive")
+	}
+	if !ValidStartFrom(t.StartFrom) {
+		return errors.New("start_from must be end, checkpoint or beginning")
+	}
+	return nil
+}
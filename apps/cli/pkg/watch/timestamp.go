@@ -0,0 +1,120 @@
+package watch
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// logTimeWithin is how far into a line its timestamp may start, past a
+// level or thread prefix, so times quoted in messages are not mistaken for
+// it.
+const logTimeWithin = 40
+
+var (
+	// 2006-01-02T15:04:05.000Z, 2006-01-02 15:04:05,000 (Python) and
+	// 2006/01/02 15:04:05 (Go), with an optional zone
+	isoLogTime = regexp.MustCompile(`(\d{4})[-/](\d{2})[-/](\d{2})[T ](\d{2}:\d{2}:\d{2})(?:[.,](\d{1,9}))? ?(Z|[+-]\d{2}:?\d{2})?`)
+	// 02/Jan/2006:15:04:05 -0700 (common log format)
+	clfLogTime = regexp.MustCompile(`\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`)
+	// Jan  2 15:04:05 (syslog), without a year
+	stampLogTime = regexp.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`)
+)
+
+// loadTimezone returns the location named by a timezone setting; "" and
+// "Local" are the agent's own.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// parseLogTime reads the timestamp a log line starts with. Times without a
+// zone are taken to be in loc.
+func parseLogTime(line string, loc *time.Location) (time.Time, bool) {
+	if m := isoLogTime.FindStringSubmatchIndex(line); m != nil && m[0] <= logTimeWithin {
+		sub := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return line[m[2*i]:m[2*i+1]]
+		}
+		value := sub(1) + "-" + sub(2) + "-" + sub(3) + "T" + sub(4)
+		if frac := sub(5); frac != "" {
+			value += "." + frac
+		}
+		zone := sub(6)
+		if zone == "" {
+			ts, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", value, loc)
+			return ts, err == nil
+		}
+		// -0700 and -07:00 alike
+		if zone != "Z" && !strings.Contains(zone, ":") {
+			zone = zone[:3] + ":" + zone[3:]
+		}
+		ts, err := time.Parse(time.RFC3339Nano, value+zone)
+		return ts, err == nil
+	}
+	if m := clfLogTime.FindStringIndex(line); m != nil && m[0] <= logTimeWithin {
+		ts, err := time.Parse("02/Jan/2006:15:04:05 -0700", line[m[0]:m[1]])
+		return ts, err == nil
+	}
+	if s := stampLogTime.FindString(line); s != "" {
+		ts, err := time.ParseInLocation(time.Stamp, s, loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		now := time.Now().In(loc)
+		ts = ts.AddDate(now.Year(), 0, 0)
+		// A December line read in January belongs to last year
+		if ts.After(now.Add(24 * time.Hour)) {
+			ts = ts.AddDate(-1, 0, 0)
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
+
+// eventLogTime returns the timestamp of the error line or, for traces whose
+// later lines carry none, of the closest line before it. Earlier context
+// may be from other records.
+func eventLogTime(line string, context []string, loc *time.Location) time.Time {
+	if ts, ok := parseLogTime(line, loc); ok {
+		return ts
+	}
+	for i := len(context) - 1; i >= 0; i-- {
+		if ts, ok := parseLogTime(context[i], loc); ok {
+			return ts
+		}
+	}
+	return time.Time{}
+}
+
+// OffsetSince returns the offset of the first line of file with a timestamp
+// at or after cutoff, or the end of the file when there is none. Lines
+// without a timestamp are skipped over.
+func OffsetSince(file *os.File, target Target, cutoff time.Time) (int64, error) {
+	loc := TargetLocation(target)
+	limit := cmp.Or(target.MaxLineLength, DefaultMaxLineLength)
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, dropped, err := readEncodedLine(reader, target.Encoding, 0, limit)
+		if ts, ok := parseLogTime(decodeLine(line, target.Encoding), loc); ok && !ts.Before(cutoff) {
+			return offset, nil
+		}
+		offset += int64(len(line) + dropped)
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
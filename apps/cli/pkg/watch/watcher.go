@@ -0,0 +1,898 @@
+// Package watch tails log files and streams, hands their lines to a
+// detect.Detector and sends the errors it finds, with their traces, as
+// events.
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+type Watcher struct {
+	target          Target
+	path            string
+	file            *os.File
+	reader          *bufio.Reader
+	notifier        changeNotifier
+	detector        *detect.Detector
+	lineBuffer      lineRing
+	collectingTrace bool
+	traceLines      []string
+	traceTimeout    time.Time
+	traceDuration   time.Duration
+	maxTraceLines   int
+	traceStart      int64
+	lineNumber      int64
+	offset          int64
+	readOffset      atomic.Int64
+	pending         string
+	lineCount       atomic.Int64
+	errorCount      atomic.Int64
+	lastRead        atomic.Int64
+	watching        atomic.Bool
+	stream          io.Reader
+	assembler       *detect.Assembler
+	// nextDetector is set by a config reload and picked up by Watch, which
+	// owns detector and assembler.
+	nextDetector atomic.Pointer[detect.Detector]
+	// recent keeps the last lines read for the --tui dashboard, which reads
+	// them from another goroutine.
+	recentMu    sync.Mutex
+	recent      lineRing
+	recentCount int64
+
+	// After a trace ends, up to postLines more lines or those within
+	// postDuration are added to its context; traceEnd is then the length
+	// the trace had when it ended
+	postLines    int
+	postDuration time.Duration
+	traceEnd     int
+	// location is what timestamps without a zone are read in
+	location *time.Location
+	// encoding is what raw lines are decoded from
+	encoding string
+	// format is what lines are wrapped in, auto until the first line
+	format string
+	// Lines are cut at maxLineLength bytes; pendingDropped counts the bytes
+	// of the partial line that were read but not kept
+	maxLineLength  int
+	pendingDropped int
+	// batch is reused by readBatch
+	batch []rawLine
+	// A stream is read by one goroutine for as long as the watcher lives,
+	// even when watchStream is restarted after a panic
+	streamOnce    sync.Once
+	streamBatches chan []rawLine
+	streamErr     chan error
+	// health is kept by supervise
+	health watchHealth
+	// A trace is also sent once its lines take up maxTraceBytes, or no
+	// longer fit in the traceBudget all watchers share
+	maxTraceBytes int
+	traceBytes    int
+	traceBudget   *detect.TraceBudget
+	overBudget    bool
+	// The parts of a CRI line split by the runtime are joined in
+	// criPending, and criBytes is what they took up in the file
+	criPending   string
+	criBytes     int
+	criTruncated bool
+	// lineTime is when the runtime recorded the last line read, for lines
+	// that carry no timestamp of their own
+	lineTime time.Time
+}
+
+const (
+	recentLines = 20
+	// detectBatchSize caps how many ready lines a watcher hands to the
+	// detection workers at once
+	detectBatchSize = 1024
+	// defaultTraceTimeout is long enough to capture a full stack trace
+	defaultTraceTimeout  = time.Second
+	DefaultContextLines  = 10
+	defaultMaxTraceLines = 500
+	DefaultMaxLineLength = 64 * 1024
+	// truncatedSuffix marks a line cut at the maximum line length
+	truncatedSuffix = " [truncated]"
+)
+
+// NewWatcher tails target.LogPath from its current end.
+func NewWatcher(target Target, detector *detect.Detector) (*Watcher, error) {
+	return NewWatcherAt(target, detector, -1)
+}
+
+// NewWatcherAt starts reading at offset, or at the end of the file when
+// offset is negative or past the end.
+func NewWatcherAt(target Target, detector *detect.Detector, offset int64) (*Watcher, error) {
+	path := target.LogPath
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset < 0 || offset > info.Size() {
+		offset = info.Size()
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	encoding := target.Encoding
+	if encoding == "" || encoding == EncodingAuto {
+		encoding = DetectEncoding(file)
+	}
+	w := &Watcher{
+		target:        target,
+		path:          path,
+		file:          file,
+		encoding:      encoding,
+		format:        cmp.Or(target.Format, FormatAuto),
+		reader:        bufio.NewReader(file),
+		notifier:      newChangeNotifier(path),
+		detector:      detector,
+		offset:        offset,
+		lineBuffer:    newLineRing(cmp.Or(target.ContextLines, DefaultContextLines) + 1),
+		recent:        newLineRing(recentLines),
+		traceDuration: cmp.Or(time.Duration(target.TraceTimeout), defaultTraceTimeout),
+		maxTraceLines: cmp.Or(target.MaxTraceLines, defaultMaxTraceLines),
+		maxLineLength: cmp.Or(target.MaxLineLength, DefaultMaxLineLength),
+		maxTraceBytes: target.MaxTraceBytes,
+		traceBudget:   target.TraceBudget,
+	}
+	w.assembler = detector.NewAssembler(w.maxTraceBytes, w.traceBudget)
+	w.setPostContext(target)
+	w.location = TargetLocation(target)
+	w.readOffset.Store(offset)
+	return w, nil
+}
+
+// StdinPath is the log_path that reads from standard input.
+const StdinPath = "-"
+
+// NewStreamWatcher reads lines from r until it is closed, e.g. a process
+// piped into `lacia-cli --stdin`, instead of tailing a file.
+func NewStreamWatcher(target Target, detector *detect.Detector, r io.Reader) *Watcher {
+	encoding := target.Encoding
+	// Files such as those scan reads can be looked at; pipes cannot
+	if f, ok := r.(*os.File); ok && (encoding == "" || encoding == EncodingAuto) {
+		encoding = DetectEncoding(f)
+	}
+	w := &Watcher{
+		target:        target,
+		path:          target.LogPath,
+		stream:        r,
+		encoding:      encoding,
+		format:        cmp.Or(target.Format, FormatAuto),
+		notifier:      pollNotifier{},
+		detector:      detector,
+		lineBuffer:    newLineRing(cmp.Or(target.ContextLines, DefaultContextLines) + 1),
+		recent:        newLineRing(recentLines),
+		traceDuration: cmp.Or(time.Duration(target.TraceTimeout), defaultTraceTimeout),
+		maxTraceLines: cmp.Or(target.MaxTraceLines, defaultMaxTraceLines),
+		maxLineLength: cmp.Or(target.MaxLineLength, DefaultMaxLineLength),
+		maxTraceBytes: target.MaxTraceBytes,
+		traceBudget:   target.TraceBudget,
+	}
+	w.assembler = detector.NewAssembler(w.maxTraceBytes, w.traceBudget)
+	w.setPostContext(target)
+	w.location = TargetLocation(target)
+	return w
+}
+
+// TargetLocation returns the target's validated timezone.
+func TargetLocation(target Target) *time.Location {
+	loc, err := loadTimezone(target.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// setPostContext applies the target's post-error capture window, which
+// without a timeout of its own lasts as long as the wait for a trace line.
+func (w *Watcher) setPostContext(target Target) {
+	w.postLines = target.PostContextLines
+	w.postDuration = time.Duration(target.PostContextTimeout)
+	if w.postLines > 0 && w.postDuration == 0 {
+		w.postDuration = w.traceDuration
+	}
+}
+
+func (w *Watcher) Close() {
+	w.dropTraces()
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.notifier.Close()
+}
+
+func (w *Watcher) Watch(ctx context.Context, events chan<- detect.Event) error {
+	w.watching.Store(true)
+	defer w.watching.Store(false)
+
+	if w.stream != nil {
+		return w.watchStream(ctx, events)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			w.readOffset.Store(w.ResumeOffset())
+			w.applyDetector(events)
+			lines, err := w.readBatch()
+			w.handleLines(lines, events)
+			if err != nil {
+				if err == io.EOF {
+					w.expireTraces(events)
+					if err := w.checkRotation(events); err != nil {
+						return err
+					}
+					w.notifier.Wait(w.idleTimeout())
+					continue
+				}
+				return err
+			}
+		}
+	}
+}
+
+// Guard runs fn until it returns without panicking or ctx is done,
+// recovering and reporting panics. It lets a watcher survive a line that
+// trips up detection.
+type Guard interface {
+	Run(ctx context.Context, name string, fn func())
+}
+
+// WatchGuarded runs Watch under guard, restarting it after a panic. The
+// trace being assembled when it panicked is dropped, since it may be what
+// caused the panic. Without a guard panics are not recovered.
+func (w *Watcher) WatchGuarded(ctx context.Context, guard Guard, events chan<- detect.Event) error {
+	if guard == nil {
+		return w.Watch(ctx, events)
+	}
+	var err error
+	restarted := false
+	guard.Run(ctx, "watcher "+w.path, func() {
+		if restarted {
+			w.dropTraces()
+		}
+		restarted = true
+		err = w.Watch(ctx, events)
+	})
+	return err
+}
+
+// dropTraces forgets the traces in progress without emitting them.
+func (w *Watcher) dropTraces() {
+	w.traceLines = nil
+	w.collectingTrace = false
+	w.traceEnd = 0
+	w.releaseTrace()
+	if w.assembler != nil {
+		w.assembler.Flush()
+	}
+	w.assembler = w.detector.NewAssembler(w.maxTraceBytes, w.traceBudget)
+}
+
+// readLine reads the next line of the file. A partial line is kept until
+// the writer finishes it, and is returned with the rest of it.
+func (w *Watcher) readLine() (string, bool, error) {
+	chunk, dropped, err := readEncodedLine(w.reader, w.encoding,
+		len(w.pending)+w.pendingDropped, w.maxLineLength-len(w.pending))
+	w.offset += int64(len(chunk) + dropped)
+	w.pending += chunk
+	w.pendingDropped += dropped
+	if err != nil {
+		return "", false, err
+	}
+	line, truncated := w.pending, w.pendingDropped > 0
+	w.pending, w.pendingDropped = "", 0
+	return line, truncated, nil
+}
+
+// rawLine is a line as it was read, before decoding.
+type rawLine struct {
+	raw       string
+	truncated bool
+	// time is when a container runtime recorded the line, if it did
+	time time.Time
+}
+
+// readBatch reads the lines that are ready, as many as the detection
+// workers take at once, or a single line without them. It returns the
+// lines read before an error along with it.
+func (w *Watcher) readBatch() ([]rawLine, error) {
+	size := 1
+	if w.detector.Workers != nil {
+		size = detectBatchSize
+	}
+	w.batch = w.batch[:0]
+	for len(w.batch) < size {
+		line, truncated, err := w.readLine()
+		if err != nil {
+			return w.batch, err
+		}
+		r, ok := w.unwrap(rawLine{raw: line, truncated: truncated})
+		if !ok {
+			continue
+		}
+		w.batch = append(w.batch, r)
+	}
+	return w.batch, nil
+}
+
+// watchStream reads the stream on a separate goroutine so that a pending
+// trace is still emitted on time while the writer is quiet.
+func (w *Watcher) watchStream(ctx context.Context, events chan<- detect.Event) error {
+	w.streamOnce.Do(func() { w.readStream(ctx) })
+	batches, readErr := w.streamBatches, w.streamErr
+
+	// One timer for the whole stream rather than one per line
+	idle := time.NewTimer(w.idleTimeout())
+	defer idle.Stop()
+	for {
+		w.applyDetector(events)
+		idle.Reset(w.idleTimeout())
+		select {
+		case <-ctx.Done():
+			return nil
+		case batch, ok := <-batches:
+			if !ok {
+				w.flushTraces(events)
+				select {
+				case err := <-readErr:
+					return err
+				default:
+					return nil
+				}
+			}
+			w.handleLines(batch, events)
+		case <-idle.C:
+			w.expireTraces(events)
+		}
+	}
+}
+
+// readStream starts the goroutine that reads the stream and sends its lines
+// in batches until the stream ends or ctx is done.
+func (w *Watcher) readStream(ctx context.Context) {
+	batches := make(chan []rawLine)
+	readErr := make(chan error, 1)
+	w.streamBatches, w.streamErr = batches, readErr
+	size := 1
+	if w.detector.Workers != nil {
+		size = detectBatchSize
+	}
+	go func() {
+		defer close(batches)
+		reader := bufio.NewReader(w.stream)
+		var batch []rawLine
+		for {
+			line, dropped, err := readEncodedLine(reader, w.encoding, 0, w.maxLineLength)
+			if line != "" {
+				if r, ok := w.unwrap(rawLine{raw: line, truncated: dropped > 0}); ok {
+					batch = append(batch, r)
+				}
+			}
+			// Lines that arrived together are detected together, but none
+			// waits for a line the writer has not finished
+			if len(batch) > 0 && (err != nil || len(batch) >= size || !lineReady(reader)) {
+				select {
+				case batches <- batch:
+					batch = nil
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+}
+
+// lineReady reports whether reader holds a whole line that can be read
+// without waiting for the writer.
+func lineReady(reader *bufio.Reader) bool {
+	buffered, _ := reader.Peek(reader.Buffered())
+	return bytes.IndexByte(buffered, '\n') >= 0
+}
+
+// SetDetector makes the watcher use detector for lines read from now on.
+func (w *Watcher) SetDetector(detector *detect.Detector) {
+	w.nextDetector.Store(detector)
+}
+
+// applyDetector switches to a detector set by SetDetector. Traces in
+// progress were grouped by the old patterns, so they are sent first.
+func (w *Watcher) applyDetector(events chan<- detect.Event) {
+	detector := w.nextDetector.Swap(nil)
+	if detector == nil {
+		return
+	}
+	w.flushTraces(events)
+	w.detector = detector
+	w.assembler = detector.NewAssembler(w.maxTraceBytes, w.traceBudget)
+}
+
+// idleTimeout bounds how long Watch blocks waiting for new data, so pending
+// traces are flushed on time and shutdown stays responsive.
+func (w *Watcher) idleTimeout() time.Duration {
+	if w.assembler != nil {
+		if next := w.assembler.NextDeadline(); !next.IsZero() {
+			return max(time.Until(next), pollInterval)
+		}
+		return time.Second
+	}
+	if w.collectingTrace {
+		return max(time.Until(w.traceTimeout), pollInterval)
+	}
+	return time.Second
+}
+
+// expireTraces emits traces that have waited long enough for more lines.
+func (w *Watcher) expireTraces(events chan<- detect.Event) {
+	if w.assembler != nil {
+		for _, g := range w.assembler.Expire(time.Now()) {
+			w.emitGroup(g, events)
+		}
+		return
+	}
+	if w.collectingTrace && time.Now().After(w.traceTimeout) {
+		if w.traceEnd == 0 {
+			w.endTrace(events, len(w.traceLines))
+		} else {
+			w.emitTrace(events)
+		}
+	}
+}
+
+// flushTraces emits every trace in progress, for when the input has ended.
+func (w *Watcher) flushTraces(events chan<- detect.Event) {
+	if w.assembler != nil {
+		for _, g := range w.assembler.Flush() {
+			w.emitGroup(g, events)
+		}
+		return
+	}
+	if w.collectingTrace {
+		w.emitTrace(events)
+	}
+}
+
+// parsedLine is a line decoded and run through the detector, which the
+// detection workers do for a batch of lines at once.
+type parsedLine struct {
+	// raw keeps the indentation that line is trimmed of
+	raw     string
+	line    string
+	isError bool
+	time    time.Time
+}
+
+// parseLine decodes and cleans up a line read from the file and detects
+// whether it is an error. It is safe to call from the detection workers.
+func (w *Watcher) parseLine(r rawLine, detector *detect.Detector) parsedLine {
+	line := r.raw
+	if r.truncated {
+		line = trimPartialRune(line, w.encoding)
+	}
+	raw := sanitizeLine(strings.TrimRight(decodeLine(line, w.encoding), "\r\n"))
+	if r.truncated {
+		raw += truncatedSuffix
+	}
+	p := parsedLine{raw: raw, line: strings.TrimSpace(raw), time: r.time}
+	if p.line != "" {
+		p.isError = detector.IsError(p.line)
+	}
+	return p
+}
+
+// handleLine handles a line read from the target, taking it out of its
+// format first.
+func (w *Watcher) handleLine(r rawLine, events chan<- detect.Event) {
+	if r, ok := w.unwrap(r); ok {
+		w.applyLine(w.parseLine(r, w.detector), events)
+	}
+}
+
+// handleLines handles a batch of lines, parsing them on the detection
+// workers when there are any.
+func (w *Watcher) handleLines(lines []rawLine, events chan<- detect.Event) {
+	pool := w.detector.Workers
+	if pool == nil || len(lines) < detect.MinChunk {
+		for _, r := range lines {
+			w.applyLine(w.parseLine(r, w.detector), events)
+		}
+		return
+	}
+	parsed := make([]parsedLine, len(lines))
+	detector := w.detector
+	pool.Each(len(lines), func(i int) {
+		parsed[i] = w.parseLine(lines[i], detector)
+	})
+	for _, p := range parsed {
+		w.applyLine(p, events)
+	}
+}
+
+// applyLine adds a parsed line to the context and the traces, in the order
+// lines were read.
+func (w *Watcher) applyLine(p parsedLine, events chan<- detect.Event) {
+	w.lineNumber++
+	raw, line, isError := p.raw, p.line, p.isError
+	if !p.time.IsZero() {
+		w.lineTime = p.time
+	}
+	if line == "" {
+		return
+	}
+
+	w.pushToBuffer(line)
+	w.recentMu.Lock()
+	w.recent.Push(line)
+	w.recentCount++
+	w.recentMu.Unlock()
+	w.lineCount.Add(1)
+	w.lastRead.Store(time.Now().UnixNano())
+	if isError {
+		w.errorCount.Add(1)
+	}
+
+	if w.assembler != nil {
+		// Continuation patterns may depend on indentation, so the assembler
+		// sees the untrimmed line.
+		for _, g := range w.assembler.Add(raw, w.lineNumber, isError, time.Now()) {
+			w.emitGroup(g, events)
+		}
+		return
+	}
+
+	if w.collectingTrace && w.traceEnd > 0 {
+		// A new error ends the post-error context and starts its own trace
+		if isError {
+			w.emitTrace(events)
+			w.startTrace(line)
+			return
+		}
+		w.appendTrace(line)
+		if (w.postLines > 0 && len(w.traceLines)-w.traceEnd >= w.postLines) || w.traceFull() {
+			w.emitTrace(events)
+		}
+		return
+	}
+
+	if w.collectingTrace {
+		w.appendTrace(line)
+		if w.traceFull() {
+			w.emitTrace(events)
+		} else if w.detector.IsTraceContinuation(line) {
+			w.traceTimeout = time.Now().Add(w.traceDuration)
+		} else if !isError {
+			w.endTrace(events, len(w.traceLines)-1)
+		}
+		return
+	}
+
+	if isError {
+		w.startTrace(line)
+	}
+}
+
+// checkRotation detects logrotate-style renames (the path now points to a
+// different file) and copytruncate-style truncation, and continues reading
+// from the beginning of the new contents.
+func (w *Watcher) checkRotation(events chan<- detect.Event) error {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// The path can briefly disappear between rename and recreate
+		return nil
+	}
+
+	current, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(info, current) {
+		file, err := os.Open(w.path)
+		if err != nil {
+			return nil
+		}
+		// Drain anything written to the old file after our last read
+		for {
+			line, truncated, err := w.readLine()
+			if err != nil {
+				break
+			}
+			w.handleLine(rawLine{raw: line, truncated: truncated}, events)
+		}
+		w.flushPending(events)
+		w.file.Close()
+		w.file = file
+		w.reader.Reset(file)
+		w.offset = 0
+		w.lineNumber = 0
+		return nil
+	}
+
+	if info.Size() < w.offset {
+		if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		w.resetPending()
+		w.reader.Reset(w.file)
+		w.offset = 0
+		w.lineNumber = 0
+	}
+	return nil
+}
+
+// flushPending treats an unterminated last line of a rotated file, and a
+// CRI line whose last part it never got, as complete.
+func (w *Watcher) flushPending(events chan<- detect.Event) {
+	if w.pending != "" {
+		w.handleLine(rawLine{raw: w.pending, truncated: w.pendingDropped > 0}, events)
+	}
+	if w.criPending != "" {
+		w.handleLines([]rawLine{{raw: w.criPending, truncated: w.criTruncated}}, events)
+	}
+	w.resetPending()
+}
+
+// resetPending forgets the partial line read so far, along with the parts
+// of a CRI line it continues.
+func (w *Watcher) resetPending() {
+	w.pending, w.pendingDropped = "", 0
+	w.criPending, w.criBytes, w.criTruncated = "", 0, false
+}
+
+// ResumeOffset is where a new watcher on the same file should start so that
+// nothing is skipped, including a line the writer has not finished yet. It
+// is only safe to call once Watch has returned.
+func (w *Watcher) ResumeOffset() int64 {
+	return w.offset - int64(len(w.pending)+w.pendingDropped+w.criBytes)
+}
+
+// Offset returns how far the file has been read, for checkpoints. Unlike
+// ResumeOffset it is safe to call while the watcher runs.
+func (w *Watcher) Offset() int64 {
+	return w.readOffset.Load()
+}
+
+// Path is the file or stream the watcher reads.
+func (w *Watcher) Path() string {
+	return w.path
+}
+
+// Target is the target the watcher was created for.
+func (w *Watcher) Target() Target {
+	return w.target
+}
+
+// IsStream reports whether the watcher reads a stream rather than tailing a
+// file, so it cannot be reopened.
+func (w *Watcher) IsStream() bool {
+	return w.stream != nil
+}
+
+// Counts returns the total number of lines and error lines read so far.
+func (w *Watcher) Counts() (lines, errors int64) {
+	return w.lineCount.Load(), w.errorCount.Load()
+}
+
+// Tail returns the recent lines after the first after lines read, and the
+// number read so far, which a caller following the log, such as the
+// dashboard, passes as after next time. Lines that have already left the
+// recent buffer are not returned.
+func (w *Watcher) Tail(after int64) ([]string, int64) {
+	w.recentMu.Lock()
+	defer w.recentMu.Unlock()
+	n := int(min(w.recentCount-after, int64(w.recent.Len())))
+	if n <= 0 {
+		return nil, w.recentCount
+	}
+	lines := make([]string, 0, n)
+	for i := w.recent.Len() - n; i < w.recent.Len(); i++ {
+		lines = append(lines, w.recent.At(i))
+	}
+	return lines, w.recentCount
+}
+
+// Watching reports whether Watch is still running on an open file.
+func (w *Watcher) Watching() bool {
+	return w.watching.Load()
+}
+
+// LastRead returns when the last line was read, or the zero time if none has
+// been read since startup.
+func (w *Watcher) LastRead() time.Time {
+	if ns := w.lastRead.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+func (w *Watcher) startTrace(triggerLine string) {
+	startIdx := w.findTraceStart()
+	w.traceLines = make([]string, 0, 20)
+	w.releaseTrace()
+
+	for i := startIdx; i < w.lineBuffer.Len(); i++ {
+		w.appendTrace(w.lineBuffer.At(i))
+	}
+
+	w.collectingTrace = true
+	w.traceStart = w.lineNumber
+	w.traceTimeout = time.Now().Add(w.traceDuration)
+}
+
+func (w *Watcher) appendTrace(line string) {
+	w.traceLines = append(w.traceLines, line)
+	n := len(line) + detect.LineOverhead
+	w.traceBytes += n
+	if !w.traceBudget.Take(n) {
+		w.overBudget = true
+	}
+}
+
+// releaseTrace gives the bytes of the trace that was sent or dropped back
+// to the budget shared with the other watchers.
+func (w *Watcher) releaseTrace() {
+	w.traceBudget.Release(w.traceBytes)
+	w.traceBytes = 0
+	w.overBudget = false
+}
+
+// traceFull reports whether the trace reached max_trace_lines or its share
+// of the memory budget.
+func (w *Watcher) traceFull() bool {
+	return len(w.traceLines) >= w.maxTraceLines || (w.maxTraceBytes > 0 && w.traceBytes >= w.maxTraceBytes) || w.overBudget
+}
+
+// findTraceStart looks back from the error line, the last in the buffer,
+// for the line its trace starts at. The buffer holds the error line and the
+// lines before it that may be context.
+func (w *Watcher) findTraceStart() int {
+	for i := w.lineBuffer.Len() - 1; i >= 0; i-- {
+		if w.detector.IsTraceStart(w.lineBuffer.At(i)) {
+			return i
+		}
+	}
+	return 0
+}
+
+// endTrace emits the trace, or first waits for the lines after it when
+// post-error context is configured. end is the length of the trace itself;
+// the line that ended it, if any, is the first after it.
+func (w *Watcher) endTrace(events chan<- detect.Event, end int) {
+	if w.postDuration == 0 {
+		w.emitTrace(events)
+		return
+	}
+	w.traceEnd = end
+	w.traceTimeout = time.Now().Add(w.postDuration)
+}
+
+func (w *Watcher) emitTrace(events chan<- detect.Event) {
+	end := len(w.traceLines)
+	if w.traceEnd > 0 {
+		end = w.traceEnd
+	}
+	w.traceEnd = 0
+	if len(w.traceLines) == 0 {
+		w.collectingTrace = false
+		return
+	}
+
+	events <- detect.Event{
+		Line:       w.traceLines[end-1],
+		Timestamp:  time.Now().UTC(),
+		LogTime:    w.logTime(w.traceLines[end-1], w.traceLines[:end]),
+		Context:    w.traceLines,
+		Target:     w.target.Label,
+		Source:     w.path,
+		RepoURL:    w.target.RepoURL,
+		RepoPath:   w.target.RepoPath,
+		LineNumber: w.traceStart,
+		Truncated:  anyTruncated(w.traceLines),
+	}
+
+	w.traceLines = nil
+	w.collectingTrace = false
+	w.releaseTrace()
+}
+
+func (w *Watcher) emitGroup(g *detect.TraceGroup, events chan<- detect.Event) {
+	if !g.Reportable() {
+		return
+	}
+
+	events <- detect.Event{
+		Line:       w.assembler.ErrorLine(g),
+		Timestamp:  time.Now().UTC(),
+		LogTime:    w.logTime(w.assembler.ErrorLine(g), g.Lines),
+		Context:    g.Lines,
+		Target:     w.target.Label,
+		Source:     w.path,
+		RepoURL:    w.target.RepoURL,
+		RepoPath:   w.target.RepoPath,
+		LineNumber: g.LineNumber,
+		Truncated:  anyTruncated(g.Lines),
+	}
+}
+
+// logTime is the timestamp of an error, read from its lines or, when they
+// have none, the time the container runtime recorded the last line read.
+func (w *Watcher) logTime(line string, context []string) time.Time {
+	if ts := eventLogTime(line, context, w.location); !ts.IsZero() {
+		return ts
+	}
+	return w.lineTime
+}
+
+// anyTruncated reports whether one of lines was cut at the maximum line
+// length.
+func anyTruncated(lines []string) bool {
+	for _, line := range lines {
+		if strings.HasSuffix(line, truncatedSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) pushToBuffer(line string) {
+	w.lineBuffer.Push(line)
+}
+
+// lineRing keeps the last lines pushed to it without reallocating.
+type lineRing struct {
+	lines []string
+	start int
+	n     int
+}
+
+func newLineRing(size int) lineRing {
+	return lineRing{lines: make([]string, size)}
+}
+
+func (r *lineRing) Push(line string) {
+	if r.n < len(r.lines) {
+		r.lines[(r.start+r.n)%len(r.lines)] = line
+		r.n++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+func (r *lineRing) Len() int {
+	return r.n
+}
+
+// At returns the i-th line, oldest first.
+func (r *lineRing) At(i int) string {
+	return r.lines[(r.start+i)%len(r.lines)]
+}
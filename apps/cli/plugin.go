@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	// pluginDetectTimeout is short as detect runs for every unmatched line
+	pluginDetectTimeout = time.Second
+	pluginCallTimeout   = 5 * time.Second
+)
+
+// PluginConfig loads a WebAssembly (WASI) plugin. What the plugin does
+// follows from the functions it exports:
+//
+//	lacia_alloc(size u32) u32            required: room for the input
+//	lacia_free(ptr, len u32)             optional: releases an input or output
+//	lacia_detect(ptr, len u32) u32       line in, 1 if it is an error
+//	lacia_enrich(ptr, len u32) u64       incident JSON in, incident JSON out
+//	lacia_send(ptr, len u32) u64         incident JSON in, HTTP request JSON out
+//
+// u64 results pack the output's pointer in the high and its length in the
+// low 32 bits; a length of 0 drops the incident or sends nothing. Every
+// input is placed in memory from lacia_alloc. With lacia_free, each input is
+// handed back once the call returns and each output once it has been
+// copied out; without it, lacia_alloc must reuse its memory, such as one
+// buffer grown as needed, or the module runs out of it.
+type PluginConfig struct {
+	Path string `json:"path"`
+	// Name defaults to the file name without .wasm
+	Name string `json:"name,omitempty"`
+	// Env is the plugin's environment, for settings such as API keys
+	Env map[string]string `json:"env,omitempty"`
+	// MinSeverity applies to plugins that are sinks
+	MinSeverity string `json:"min_severity,omitempty"`
+}
+
+func (c *PluginConfig) Validate() error {
+	if c.Path == "" {
+		return errors.New("path is required")
+	}
+	if _, err := os.Stat(c.Path); err != nil {
+		return err
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+func (c *PluginConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return strings.TrimSuffix(filepath.Base(c.Path), ".wasm")
+}
+
+// Plugin is an instantiated plugin module. Calls are serialized, as a
+// module instance is not safe for concurrent use.
+type Plugin struct {
+	name        string
+	minSeverity string
+	// runtime, compiled and config start the module again after a call
+	// that overran its deadline closed it
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	config   wazero.ModuleConfig
+	// exports records which functions the module exports, which does not
+	// change when it is started again
+	exports map[string]bool
+
+	mu     sync.Mutex
+	module api.Module
+	// functions caches the module's exported functions by name
+	functions map[string]api.Function
+	// failed is set once the module could not be started again
+	failed error
+}
+
+// Plugins owns the WebAssembly runtime the plugins run in.
+type Plugins struct {
+	runtime wazero.Runtime
+	list    []*Plugin
+}
+
+// LoadPlugins compiles and instantiates the configured plugins.
+func LoadPlugins(ctx context.Context, cfgs []PluginConfig) (*Plugins, error) {
+	p := &Plugins{}
+	if len(cfgs) == 0 {
+		return p, nil
+	}
+	// A call that overruns its deadline is interrupted, which also closes
+	// the module
+	p.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, p.runtime)
+	for i := range cfgs {
+		plugin, err := p.load(ctx, &cfgs[i])
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("plugin %s: %w", cfgs[i].name(), err)
+		}
+		p.list = append(p.list, plugin)
+	}
+	return p, nil
+}
+
+func (p *Plugins) load(ctx context.Context, cfg *PluginConfig) (*Plugin, error) {
+	code, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := p.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	// Reactor modules, such as Go's with -buildmode=c-shared, are set up by
+	// _initialize rather than run by _start
+	modCfg := wazero.NewModuleConfig().
+		WithName(cfg.name()).
+		WithStartFunctions("_initialize").
+		WithStderr(os.Stderr)
+	for k, v := range cfg.Env {
+		modCfg = modCfg.WithEnv(k, v)
+	}
+	module, err := p.runtime.InstantiateModule(ctx, compiled, modCfg)
+	if err != nil {
+		return nil, err
+	}
+	plugin := &Plugin{
+		name:        cfg.name(),
+		minSeverity: cfg.MinSeverity,
+		runtime:     p.runtime,
+		compiled:    compiled,
+		config:      modCfg,
+		exports:     make(map[string]bool),
+		module:      module,
+		functions:   make(map[string]api.Function),
+	}
+	for name := range compiled.ExportedFunctions() {
+		plugin.exports[name] = true
+	}
+	if !plugin.exports["lacia_alloc"] {
+		return nil, errors.New("does not export lacia_alloc")
+	}
+	if !plugin.exports["lacia_detect"] && !plugin.exports["lacia_enrich"] && !plugin.exports["lacia_send"] {
+		return nil, errors.New("exports none of lacia_detect, lacia_enrich and lacia_send")
+	}
+	return plugin, nil
+}
+
+// Close releases the runtime and every plugin in it.
+func (p *Plugins) Close() error {
+	if p.runtime == nil {
+		return nil
+	}
+	return p.runtime.Close(context.Background())
+}
+
+// Detectors returns the plugins that detect errors, for Detector.Plugins.
+func (p *Plugins) Detectors() []detect.Matcher {
+	var out []detect.Matcher
+	for _, plugin := range p.filter(func(plugin *Plugin) bool { return plugin.exports["lacia_detect"] }) {
+		out = append(out, plugin)
+	}
+	return out
+}
+
+// Enrichers returns the plugins that process incidents, in config order.
+func (p *Plugins) Enrichers() []*Plugin {
+	return p.filter(func(plugin *Plugin) bool { return plugin.exports["lacia_enrich"] })
+}
+
+// Sinks returns the plugins that deliver incidents.
+func (p *Plugins) Sinks() []*Plugin {
+	return p.filter(func(plugin *Plugin) bool { return plugin.exports["lacia_send"] })
+}
+
+func (p *Plugins) filter(keep func(*Plugin) bool) []*Plugin {
+	var out []*Plugin
+	for _, plugin := range p.list {
+		if keep(plugin) {
+			out = append(out, plugin)
+		}
+	}
+	return out
+}
+
+// call copies input into the module and calls the export name with it.
+// With output, the result is an output's packed pointer and length, and the
+// output is returned copied out of the module. A call that overran its
+// deadline closed the module, which is then started again.
+func (p *Plugin) call(timeout time.Duration, name string, input []byte, output bool) (uint64, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failed != nil {
+		return 0, nil, p.failed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, out, err := p.invoke(ctx, name, input, output)
+	if err != nil && p.module.IsClosed() {
+		p.restart(name, err)
+	}
+	return res, out, err
+}
+
+func (p *Plugin) invoke(ctx context.Context, name string, input []byte, output bool) (uint64, []byte, error) {
+	free := p.function("lacia_free")
+	res, err := p.function("lacia_alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return 0, nil, fmt.Errorf("lacia_alloc: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !p.module.Memory().Write(ptr, input) {
+		return 0, nil, errors.New("lacia_alloc returned memory out of range")
+	}
+	res, err = p.function(name).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return 0, nil, err
+	}
+	if free != nil {
+		if _, err := free.Call(ctx, uint64(ptr), uint64(len(input))); err != nil {
+			return 0, nil, fmt.Errorf("lacia_free: %w", err)
+		}
+	}
+	if !output {
+		return res[0], nil, nil
+	}
+
+	ptr, size := uint32(res[0]>>32), uint32(res[0])
+	if size == 0 {
+		return res[0], nil, nil
+	}
+	data, ok := p.module.Memory().Read(ptr, size)
+	if !ok {
+		return 0, nil, errors.New("output out of range")
+	}
+	// data is a view of the module's memory, which the next call reuses
+	out := append([]byte(nil), data...)
+	if free != nil {
+		if _, err := free.Call(ctx, uint64(ptr), uint64(size)); err != nil {
+			return 0, nil, fmt.Errorf("lacia_free: %w", err)
+		}
+	}
+	return res[0], out, nil
+}
+
+// restart starts the module again in place of one a call closed, so a
+// single slow call does not leave the plugin failing every call after it.
+// The module starts afresh, without the state the plugin had built up.
+func (p *Plugin) restart(name string, cause error) {
+	slog.Warn("plugin interrupted, restarting it", "plugin", p.name, "function", name, "err", cause)
+	module, err := p.runtime.InstantiateModule(context.Background(), p.compiled, p.config)
+	if err != nil {
+		p.failed = fmt.Errorf("plugin could not be restarted: %w", err)
+		slog.Error("plugin disabled", "plugin", p.name, "err", err)
+		return
+	}
+	p.module = module
+	clear(p.functions)
+}
+
+// function returns the module's export name, or nil. Callers must hold p.mu.
+func (p *Plugin) function(name string) api.Function {
+	fn, ok := p.functions[name]
+	if !ok {
+		fn = p.module.ExportedFunction(name)
+		p.functions[name] = fn
+	}
+	return fn
+}
+
+// IsError asks the plugin about a line; a failing plugin says no.
+func (p *Plugin) IsError(line string) bool {
+	res, _, err := p.call(pluginDetectTimeout, "lacia_detect", []byte(line), false)
+	if err != nil {
+		return false
+	}
+	return uint32(res) != 0
+}
+
+// Enrich passes the candidate's payload through the plugin and reports
+// whether the plugin dropped it. A plugin that fails leaves it unchanged.
+func (p *Plugin) Enrich(c *Candidate) (drop bool, err error) {
+	c.Payload.Severity = c.Severity
+	input, err := json.Marshal(c.Payload)
+	if err != nil {
+		return false, fmt.Errorf("marshal failed: %w", err)
+	}
+	_, output, err := p.call(pluginCallTimeout, "lacia_enrich", input, true)
+	if err != nil {
+		return false, err
+	}
+	if output == nil {
+		return true, nil
+	}
+	var out IncidentPayload
+	if err := json.Unmarshal(output, &out); err != nil {
+		return false, fmt.Errorf("invalid output: %w", err)
+	}
+	if out.Severity != "" {
+		if _, ok := severityRank[out.Severity]; !ok {
+			return false, fmt.Errorf("invalid output: unknown severity %q", out.Severity)
+		}
+		c.Severity = out.Severity
+	}
+	c.Payload = &out
+	c.Event.Line = out.ErrorLine
+	return false, nil
+}
+
+// pluginRequest is what lacia_send returns for the agent to send.
+type pluginRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// pluginSink delivers incidents by sending the HTTP requests a plugin
+// builds for them, so plugins need no network access of their own.
+type pluginSink struct {
+	plugin     *Plugin
+	httpClient *http.Client
+}
+
+func (s *pluginSink) Name() string {
+	return "plugin " + s.plugin.name
+}
+
+func (s *pluginSink) Send(ctx context.Context, payload IncidentPayload) error {
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	_, output, err := s.plugin.call(pluginCallTimeout, "lacia_send", input, true)
+	if err != nil || output == nil {
+		return err
+	}
+	var req pluginRequest
+	if err := json.Unmarshal(output, &req); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if req.Method == "" {
+		req.Method = http.MethodPost
+	}
+	contentType := req.Headers["Content-Type"]
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return postBody(ctx, s.httpClient, req.Method, req.URL, contentType, []byte(req.Body), req.Headers)
+}
+
+// AddPlugins adds a sink for each plugin that is one. It must be called
+// before Run.
+func (s *Sinks) AddPlugins(plugins []*Plugin) {
+	for _, p := range plugins {
+		s.add(&pluginSink{plugin: p, httpClient: &http.Client{}}, p.minSeverity, SeverityHigh)
+	}
+}
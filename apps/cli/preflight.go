@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// preflightCheck is the outcome of one check of the environment the watcher
+// runs in, made at startup and by `lacia-cli validate`.
+type preflightCheck struct {
+	Name string // what was checked, e.g. "Log file /var/log/app.log"
+	OK   string // shown when the check passed
+	Err  error
+	Hint string // how to fix a failure
+	// Fatal failures stop the watcher from starting; others only warn, e.g.
+	// a server that is down now may be up by the time an error is sent.
+	Fatal bool
+}
+
+// preflightChecks checks that log files and directories are readable, repo
+// URLs are well-formed and the server resolves and answers its health check.
+func preflightChecks(ctx context.Context, cfg *Config) []preflightCheck {
+	var checks []preflightCheck
+
+	for _, target := range cfg.WatchTargets() {
+		switch {
+		case target.LogPath == watch.StdinPath:
+			checks = append(checks, preflightCheck{OK: "Log lines are read from standard input"})
+		case target.Dir != "":
+			_, err := os.ReadDir(target.Dir)
+			checks = append(checks, preflightCheck{
+				Name:  "Log directory " + target.Dir,
+				OK:    fmt.Sprintf("Log directory %s is readable", target.Dir),
+				Err:   err,
+				Hint:  fileHint(err),
+				Fatal: true,
+			})
+		default:
+			err := checkLogFile(target.LogPath)
+			checks = append(checks, preflightCheck{
+				Name:  "Log file " + target.LogPath,
+				OK:    fmt.Sprintf("Log file %s is readable", target.LogPath),
+				Err:   err,
+				Hint:  fileHint(err),
+				Fatal: true,
+			})
+		}
+	}
+
+	seen := make(map[string]bool)
+	repoURLs := []string{cfg.RepoURL}
+	for _, target := range cfg.WatchTargets() {
+		repoURLs = append(repoURLs, target.RepoURL)
+	}
+	if cfg.Syslog != nil && cfg.Syslog.Enabled {
+		repoURLs = append(repoURLs, cfg.Syslog.RepoURL)
+	}
+	if cfg.GELF != nil && cfg.GELF.Enabled {
+		repoURLs = append(repoURLs, cfg.GELF.RepoURL)
+	}
+	if cfg.Forward != nil && cfg.Forward.Enabled {
+		repoURLs = append(repoURLs, cfg.Forward.RepoURL)
+	}
+	if cfg.Ingest != nil && cfg.Ingest.Enabled {
+		repoURLs = append(repoURLs, cfg.Ingest.RepoURL)
+	}
+	for _, rule := range cfg.RepoRules {
+		repoURLs = append(repoURLs, rule.RepoURL)
+	}
+	for _, repo := range cfg.Repositories {
+		repoURLs = append(repoURLs, repo.RepoURL)
+	}
+	for _, repoURL := range repoURLs {
+		if repoURL == "" || seen[repoURL] {
+			continue
+		}
+		seen[repoURL] = true
+		checks = append(checks, preflightCheck{
+			Name:  "Repository " + repoURL,
+			OK:    fmt.Sprintf("Repository URL %s is well-formed", repoURL),
+			Err:   checkRepoURL(repoURL),
+			Hint:  "use the repository's clone URL, e.g. https://github.com/owner/repo",
+			Fatal: true,
+		})
+	}
+
+	if cfg.TLS != nil && cfg.TLS.InsecureSkipVerify {
+		checks = append(checks, preflightCheck{
+			Name: "TLS",
+			Err:  errors.New("insecure_skip_verify is set, so the server's certificate is not verified"),
+			Hint: "set tls.ca_file to the CA that signed the server's certificate instead",
+		})
+	}
+
+	return append(checks, serverChecks(ctx, cfg)...)
+}
+
+// serverChecks stops at the first failure, since a host that does not
+// resolve cannot answer a health check either. Behind a proxy the name is
+// resolved by the proxy, so it is not looked up here.
+func serverChecks(ctx context.Context, cfg *Config) []preflightCheck {
+	serverURL := cfg.ServerURL
+	name := "Server " + serverURL
+	u, err := url.Parse(serverURL)
+	if err == nil && ((u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		err = errors.New("must be an http or https URL with a host")
+	}
+	if err != nil {
+		return []preflightCheck{{
+			Name:  name,
+			Err:   err,
+			Hint:  "set server_url to the Lacia server's webhook, e.g. http://lacia.internal:3000/api/webhook",
+			Fatal: true,
+		}}
+	}
+
+	if !ship.UsesProxy(cfg.Proxy, serverURL) {
+		if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+			return []preflightCheck{{
+				Name: name,
+				Err:  err,
+				Hint: "check the hostname and this host's DNS settings",
+			}}
+		}
+	}
+
+	client, err := ship.NewHTTPClient(cfg.TLS, cfg.Proxy, 0)
+	if err != nil {
+		return []preflightCheck{{
+			Name:  name,
+			Err:   err,
+			Hint:  "check the files in tls: ca_file must hold PEM certificates and cert_file and key_file a matching pair",
+			Fatal: true,
+		}}
+	}
+
+	return []preflightCheck{{
+		Name: name,
+		OK:   fmt.Sprintf("Server %s is reachable", healthURL(serverURL)),
+		Err:  checkServer(ctx, client, serverURL),
+		Hint: "make sure the Lacia server is running and reachable from this host; incidents are retried until it is",
+	}}
+}
+
+func fileHint(err error) string {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "check the path, or create it before starting the watcher"
+	case errors.Is(err, os.ErrPermission):
+		return "run the watcher as a user that can read it, e.g. one in the file's group"
+	}
+	return ""
+}
+
+// scpRepoURL matches scp-style git remotes such as git@github.com:owner/repo.git.
+var scpRepoURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[\w.-]+/[\w.-]+$`)
+
+func checkRepoURL(repoURL string) error {
+	if scpRepoURL.MatchString(repoURL) {
+		return nil
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ssh", "git":
+	default:
+		return errors.New("must be an https, ssh or git URL")
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+		return errors.New("missing owner/repository path")
+	}
+	return nil
+}
+
+// runPreflight logs failed checks and reports whether the watcher can start.
+func runPreflight(ctx context.Context, cfg *Config) bool {
+	ok := true
+	for _, check := range preflightChecks(ctx, cfg) {
+		if check.Err == nil {
+			continue
+		}
+		if check.Fatal {
+			slog.Error("preflight check failed", "check", check.Name, "err", check.Err, "hint", check.Hint)
+			ok = false
+		} else {
+			slog.Warn("preflight check failed", "check", check.Name, "err", check.Err, "hint", check.Hint)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// PrivilegesConfig is least-privilege mode: the agent does not keep running
+// as root. Started as root, it opens the log files, sockets and ports that
+// need root and then switches to User.
+type PrivilegesConfig struct {
+	Enabled bool   `json:"enabled"`
+	User    string `json:"user,omitempty"`
+	// Group defaults to the user's primary group
+	Group string `json:"group,omitempty"`
+}
+
+func (c *PrivilegesConfig) Validate() error {
+	if c.Group != "" && c.User == "" {
+		return errors.New("group needs a user")
+	}
+	return nil
+}
+
+// userIDs are the IDs the agent switches to.
+type userIDs struct {
+	uid, gid int
+	groups   []int
+}
+
+func (c *PrivilegesConfig) lookup() (userIDs, error) {
+	u, err := user.Lookup(c.User)
+	if err != nil {
+		return userIDs{}, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return userIDs{}, fmt.Errorf("user %s: uid %q is not numeric", c.User, u.Uid)
+	}
+	gidStr := u.Gid
+	if c.Group != "" {
+		g, err := user.LookupGroup(c.Group)
+		if err != nil {
+			return userIDs{}, err
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return userIDs{}, fmt.Errorf("group %s: gid %q is not numeric", c.Group, gidStr)
+	}
+	// Supplementary groups such as adm keep granting access to log files
+	// that are rotated after the switch
+	creds := userIDs{uid: uid, gid: gid, groups: []int{gid}}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return userIDs{}, err
+	}
+	for _, id := range ids {
+		if n, err := strconv.Atoi(id); err == nil && n != gid {
+			creds.groups = append(creds.groups, n)
+		}
+	}
+	return creds, nil
+}
+
+// checkPrivileges refuses to start as root in least-privilege mode unless
+// there is a user to switch to.
+func checkPrivileges(c *PrivilegesConfig) error {
+	if c == nil || !c.Enabled || os.Geteuid() != 0 {
+		return nil
+	}
+	if c.User == "" {
+		return errors.New("refusing to run as root: set privileges.user to switch to after startup, or start as an unprivileged user")
+	}
+	_, err := c.lookup()
+	return err
+}
+
+// stateFile is a file the agent keeps state in, named by its setting.
+type stateFile struct {
+	setting string
+	path    string
+}
+
+// stateFiles lists the files that are rewritten while the agent runs. Each
+// is replaced by writing a temporary file next to it and renaming it over.
+func stateFiles(cfg *Config) []stateFile {
+	files := []stateFile{{"checkpoint_file", CheckpointPath(cfg)}}
+	if cfg.Dedupe != nil && cfg.Dedupe.Persist {
+		files = append(files, stateFile{"dedupe.path", dedupeCachePath(cfg.Dedupe)})
+	}
+	if cfg.Queue != nil && cfg.Queue.Enabled {
+		files = append(files, stateFile{"queue.path", cfg.Queue.path()})
+	}
+	if cfg.Archive != nil && cfg.Archive.Enabled {
+		files = append(files, stateFile{"archive.path", cfg.Archive.path()})
+	}
+	if cfg.History != nil && cfg.History.Enabled {
+		files = append(files, stateFile{"history.path", cfg.History.path()})
+	}
+	return files
+}
+
+// handOverState gives creds the state files, so the switched agent can
+// replace them and read them after a restart. A missing directory is
+// created for creds, but an existing one they cannot write or reach, such
+// as root's config directory, is not taken over and makes the agent refuse
+// to start.
+func handOverState(files []stateFile, creds userIDs) error {
+	for _, f := range files {
+		dir := filepath.Dir(f.path)
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				return err
+			}
+			if err := os.Chown(dir, creds.uid, creds.gid); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !permitted(info, creds, 0o3) {
+			return fmt.Errorf("%s is kept in %s, which uid %d cannot write; set %s to a path in a directory it owns, such as /var/lib/lacia", f.setting, dir, creds.uid, f.setting)
+		}
+		for parent := filepath.Dir(dir); ; parent = filepath.Dir(parent) {
+			info, err := os.Stat(parent)
+			if err != nil {
+				return err
+			}
+			if !permitted(info, creds, 0o1) {
+				return fmt.Errorf("%s is kept in %s, which uid %d cannot reach through %s; set %s to a path it can", f.setting, dir, creds.uid, parent, f.setting)
+			}
+			if parent == filepath.Dir(parent) {
+				break
+			}
+		}
+		if err := os.Chown(f.path, creds.uid, creds.gid); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropPrivileges switches to the configured user and group, giving it the
+// control socket so commands run as that user still reach the agent, and
+// the state files it keeps rewriting.
+func dropPrivileges(cfg *Config, controlSocket string) error {
+	c := cfg.Privileges
+	if c == nil || !c.Enabled || os.Geteuid() != 0 {
+		return nil
+	}
+	creds, err := c.lookup()
+	if err != nil {
+		return err
+	}
+	if controlSocket != "" {
+		os.Chown(controlSocket, creds.uid, creds.gid)
+		// The default runtime directory is root's only, which would keep
+		// the user from reaching the socket
+		if dir := filepath.Dir(controlSocket); dir == defaultRuntimeDir() {
+			os.Chown(dir, creds.uid, creds.gid)
+		}
+	}
+	if err := handOverState(stateFiles(cfg), creds); err != nil {
+		return err
+	}
+	if err := setUserIDs(creds); err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 || (os.Getegid() == 0 && creds.gid != 0) {
+		return errors.New("still running as root after switching user")
+	}
+	slog.Info("dropped privileges", "user", c.User, "uid", creds.uid, "gid", creds.gid)
+	return nil
+}
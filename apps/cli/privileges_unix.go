@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"slices"
+	"syscall"
+)
+
+// setUserIDs switches every thread of the process to creds, groups
+// first since they can no longer be changed once the user has.
+func setUserIDs(creds userIDs) error {
+	if err := syscall.Setgroups(creds.groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(creds.uid)
+}
+
+// permitted reports whether creds have the permission bits perm, given as
+// for others (e.g. 0o3 for write and search), on the file info describes.
+func permitted(info os.FileInfo, creds userIDs, perm os.FileMode) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	mode := info.Mode().Perm()
+	switch {
+	case int(st.Uid) == creds.uid:
+		return mode&(perm<<6) == perm<<6
+	case slices.Contains(creds.groups, int(st.Gid)):
+		return mode&(perm<<3) == perm<<3
+	}
+	return mode&perm == perm
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func setUserIDs(creds userIDs) error {
+	return errors.New("switching user is not supported on Windows")
+}
+
+// permitted defers to setUserIDs, which refuses to switch user anyway.
+func permitted(info os.FileInfo, creds userIDs, perm os.FileMode) bool {
+	return true
+}
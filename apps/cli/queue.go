@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const (
+	queueFileName   = "lacia.queue"
+	queueBaseDelay  = 5 * time.Second
+	queueMaxSize    = 1000
+	queueMaxBackoff = 5 * time.Minute
+)
+
+type QueueConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Path       string   `json:"path,omitempty"`
+	MaxSize    int      `json:"max_size,omitempty"`
+	MaxBackoff Duration `json:"max_backoff,omitempty"`
+}
+
+func (c *QueueConfig) Validate() error {
+	if c.MaxSize < 0 {
+		return errors.New("max_size must be positive")
+	}
+	if c.MaxBackoff < 0 {
+		return errors.New("max_backoff must be positive")
+	}
+	return nil
+}
+
+func (c *QueueConfig) path() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(filepath.Dir(ConfigPath()), queueFileName)
+}
+
+// OfflineQueue persists payloads that could not be delivered as NDJSON and
+// retries them with exponential backoff until the server is reachable again.
+type OfflineQueue struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int
+	maxBackoff time.Duration
+	items      []IncidentPayload
+	// dropped counts the oldest incidents trim has removed, so a flush
+	// removes only those it sent that are still queued
+	dropped int
+	// maxBytes, when set, also bounds the incidents kept by their size
+	maxBytes int
+	// fixes, when set, follows the incidents flushed
+	fixes *FixTracker
+}
+
+// OpenOfflineQueue loads the queued incidents, keeping the newest that fit
+// in maxBytes when it is set.
+func OpenOfflineQueue(cfg *QueueConfig, maxBytes int) (*OfflineQueue, error) {
+	q := &OfflineQueue{
+		path:       cfg.path(),
+		maxSize:    cfg.MaxSize,
+		maxBackoff: time.Duration(cfg.MaxBackoff),
+		maxBytes:   maxBytes,
+	}
+	if q.maxSize == 0 {
+		q.maxSize = queueMaxSize
+	}
+	if q.maxBackoff == 0 {
+		q.maxBackoff = queueMaxBackoff
+	}
+
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var payload IncidentPayload
+		// Skip lines torn by a crash mid-write rather than refusing to start
+		if err := json.Unmarshal(scanner.Bytes(), &payload); err == nil {
+			q.items = append(q.items, payload)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read queue failed: %w", err)
+	}
+	if dropped := q.trim(); dropped > 0 {
+		slog.Warn("offline queue over its limits, dropped oldest incidents", "dropped", dropped)
+	}
+
+	if len(q.items) > 0 {
+		slog.Info("loaded queued incidents", "count", len(q.items), "path", q.path)
+	}
+	return q, nil
+}
+
+func (q *OfflineQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *OfflineQueue) Push(payload IncidentPayload) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items = append(q.items, payload)
+	if dropped := q.trim(); dropped > 0 {
+		slog.Warn("offline queue over its limits, dropped oldest incidents", "dropped", dropped)
+		return q.persist()
+	}
+
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// trim drops the oldest incidents beyond max_size or maxBytes, always
+// keeping the newest, and returns how many it dropped. Under maxBytes a
+// single large push can drop more incidents than a flush in progress has
+// sent, so every drop is counted for the flush to account for.
+func (q *OfflineQueue) trim() int {
+	n := max(len(q.items)-q.maxSize, 0)
+	if q.maxBytes > 0 {
+		bytes := 0
+		for i := len(q.items) - 1; i >= n; i-- {
+			bytes += payloadSize(q.items[i])
+			if bytes > q.maxBytes && i < len(q.items)-1 {
+				n = i + 1
+				break
+			}
+		}
+	}
+	q.items = q.items[n:]
+	q.dropped += n
+	return n
+}
+
+// Run retries queued payloads until ctx is done.
+func (q *OfflineQueue) Run(ctx context.Context, client *Client) {
+	backoff := queueBaseDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if err := q.flush(ctx, client); err != nil {
+			backoff = min(backoff*2, q.maxBackoff)
+			// The server's word on when to come back beats max_backoff
+			var throttle *ThrottleError
+			if errors.As(err, &throttle) {
+				backoff = max(backoff, throttle.RetryAfter)
+			}
+			slog.Warn("queue flush failed", "retry_in", backoff.String(), "err", err)
+			continue
+		}
+		backoff = queueBaseDelay
+	}
+}
+
+func (q *OfflineQueue) flush(ctx context.Context, client *Client) error {
+	q.mu.Lock()
+	pending := append([]IncidentPayload(nil), q.items...)
+	dropped := q.dropped
+	q.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	sent := 0
+	var sendErr error
+	for _, payload := range pending {
+		id, err := client.SendPayload(ctx, payload)
+		if err != nil {
+			if ship.IsRetryable(err) {
+				sendErr = err
+				break
+			}
+			slog.Warn("dropping queued incident", "err", err)
+		} else {
+			if id != 0 {
+				slog.Info("queued incident sent", "incident_id", id)
+			}
+			q.fixes.Track([]IncidentPayload{payload}, []int{id})
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		q.mu.Lock()
+		// Pushes while we were sending may have dropped some of the sent
+		// incidents off the head of the queue already
+		q.items = q.items[max(sent-(q.dropped-dropped), 0):]
+		err := q.persist()
+		q.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		slog.Info("flushed queued incidents", "count", sent)
+	}
+	return sendErr
+}
+
+// persist rewrites the queue file from memory. Callers must hold q.mu.
+func (q *OfflineQueue) persist() error {
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, payload := range q.items {
+		if err := enc.Encode(payload); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+const (
+	defaultRatePerMinute = 30
+	defaultStormWindow   = time.Minute
+	maxStormGroups       = 100
+)
+
+type RateLimitConfig struct {
+	Enabled     bool     `json:"enabled"`
+	PerMinute   float64  `json:"per_minute,omitempty"`
+	Burst       int      `json:"burst,omitempty"`
+	Aggregate   bool     `json:"aggregate,omitempty"`
+	StormWindow Duration `json:"storm_window,omitempty"`
+}
+
+func (c *RateLimitConfig) Validate() error {
+	if c.PerMinute < 0 {
+		return errors.New("per_minute must be positive")
+	}
+	if c.Burst < 0 {
+		return errors.New("burst must be positive")
+	}
+	if c.StormWindow < 0 {
+		return errors.New("storm_window must be positive")
+	}
+	return nil
+}
+
+type stormGroup struct {
+	first LogEvent
+	count int
+}
+
+// RateLimiter caps outgoing incidents with a token bucket. Incidents over
+// the limit are dropped or, with aggregate, collapsed per fingerprint into
+// one "Error storm" incident at the end of each storm window.
+type RateLimiter struct {
+	rate      float64 // tokens per second
+	burst     float64
+	aggregate bool
+	window    time.Duration
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	storms  map[string]*stormGroup
+	order   []string
+	dropped int
+}
+
+func NewRateLimiter(cfg *RateLimitConfig) *RateLimiter {
+	perMinute := cfg.PerMinute
+	if perMinute == 0 {
+		perMinute = defaultRatePerMinute
+	}
+	burst := cfg.Burst
+	if burst == 0 {
+		burst = max(1, int(perMinute/6))
+	}
+
+	r := &RateLimiter{
+		rate:      perMinute / 60,
+		burst:     float64(burst),
+		aggregate: cfg.Aggregate,
+		window:    time.Duration(cfg.StormWindow),
+		tokens:    float64(burst),
+		last:      time.Now(),
+		storms:    make(map[string]*stormGroup),
+	}
+	if r.window == 0 {
+		r.window = defaultStormWindow
+	}
+	return r
+}
+
+// Allow reports whether event may be sent now. A rejected event is counted
+// towards its storm.
+func (r *RateLimiter) Allow(event LogEvent) bool {
+	// Storm summaries are the limiter's own output
+	if event.Storm {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return true
+	}
+
+	if !r.aggregate {
+		r.dropped++
+		return false
+	}
+
+	key := detect.Fingerprint(event, detect.FingerprintNormalized)
+	if group, ok := r.storms[key]; ok {
+		group.count++
+		return false
+	}
+	if len(r.order) >= maxStormGroups {
+		r.dropped++
+		return false
+	}
+	r.storms[key] = &stormGroup{first: event, count: 1}
+	r.order = append(r.order, key)
+	return false
+}
+
+// Run emits storm summaries every storm window until ctx is done, then
+// emits whatever is left.
+func (r *RateLimiter) Run(ctx context.Context, events chan<- LogEvent) {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.flush(events)
+			return
+		case <-ticker.C:
+			r.flush(events)
+		}
+	}
+}
+
+func (r *RateLimiter) flush(events chan<- LogEvent) {
+	r.mu.Lock()
+	storms := make([]*stormGroup, 0, len(r.order))
+	for _, key := range r.order {
+		storms = append(storms, r.storms[key])
+	}
+	r.storms = make(map[string]*stormGroup)
+	r.order = nil
+	dropped := r.dropped
+	r.dropped = 0
+	r.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("rate limit dropped incidents", "count", dropped, "window", r.window.String())
+	}
+	for _, s := range storms {
+		events <- stormEvent(s, r.window)
+	}
+}
+
+func stormEvent(s *stormGroup, window time.Duration) LogEvent {
+	event := s.first
+	event.Line = fmt.Sprintf("Error storm: %d occurrences of %s", s.count, s.first.Line)
+	event.Context = append([]string{
+		fmt.Sprintf("%d occurrences over the rate limit within %v; first occurrence:", s.count, window),
+	}, s.first.Context...)
+	event.Timestamp = time.Now().UTC()
+	event.Occurrences = s.count
+	event.Storm = true
+	return event
+}
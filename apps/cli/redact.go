@@ -0,0 +1,9 @@
+package main
+
+// redactCandidate masks the candidate's event and, once built, its payload.
+func redactCandidate(r *Redactor, c *Candidate) {
+	r.Event(&c.Event)
+	if c.Payload != nil {
+		r.Payload(c.Payload)
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/incidentpb"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/redact"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultRelayListen = ":8787"
+	// relayQueueSize bounds the incidents accepted but not yet forwarded.
+	// Agents are told to retry once it is full.
+	relayQueueSize = 1000
+	relayMaxBody   = 10 << 20
+)
+
+// RelayConfig runs `lacia-cli relay`, which accepts incidents from many
+// agents and forwards them to server_url with fleet-wide dedupe and rate
+// limiting. Agents point their server_url (or grpc.addr) at the relay.
+type RelayConfig struct {
+	// Listen is the address for agents posting JSON
+	Listen string `json:"listen,omitempty"`
+	// GRPCListen also accepts incidents over gRPC when set
+	GRPCListen string `json:"grpc_listen,omitempty"`
+	// Auth is what agents must send; the top-level auth is still used
+	// towards the server. It is required unless the relay only listens on
+	// loopback addresses, since whatever it accepts reaches the server with
+	// the relay's own credentials.
+	Auth *AuthConfig `json:"auth,omitempty"`
+	// TLS serves both listeners over TLS
+	TLS *RelayTLSConfig `json:"tls,omitempty"`
+}
+
+// RelayTLSConfig is the relay's certificate and, to require agents to
+// present client certificates, the CA they are signed by.
+type RelayTLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+func (c *RelayTLSConfig) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("cert_file and key_file are required")
+	}
+	return nil
+}
+
+// Load reads the certificate and client CA.
+func (c *RelayTLSConfig) Load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client_ca_file %s has no certificates", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func (c *RelayConfig) Validate() error {
+	listen := c.Listen
+	if listen == "" {
+		listen = defaultRelayListen
+	}
+	public := false
+	for _, addr := range []string{listen, c.GRPCListen} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+		if !isLoopbackAddr(addr) {
+			public = true
+		}
+	}
+	if c.Auth == nil && public {
+		return errors.New("auth is required unless listen and grpc_listen are loopback addresses")
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Validate(); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if c.TLS != nil {
+		if err := c.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+	return nil
+}
+
+// Relay receives incidents from agents over HTTP and, optionally, gRPC.
+type Relay struct {
+	incidentpb.UnimplementedIncidentServiceServer
+
+	auth     *AuthConfig
+	tls      bool
+	incoming chan IncidentPayload
+	// upstream is the client for the server, which the requests agents make
+	// besides sending incidents are passed on to
+	upstream *Client
+	// acceptMu makes room checks and sends on incoming one step, so a batch
+	// is accepted whole or not at all
+	acceptMu sync.Mutex
+
+	httpListener net.Listener
+	httpServer   *http.Server
+	grpcListener net.Listener
+	grpcServer   *grpc.Server
+}
+
+func NewRelay(cfg *RelayConfig, upstream *Client) (*Relay, error) {
+	r := &Relay{
+		auth:     cfg.Auth,
+		tls:      cfg.TLS != nil,
+		incoming: make(chan IncidentPayload, relayQueueSize),
+		upstream: upstream,
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		if tlsConfig, err = cfg.TLS.Load(); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	addr := cfg.Listen
+	if addr == "" {
+		addr = defaultRelayListen
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	r.httpListener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("POST /api/webhook", r.handleHTTP(false))
+	mux.HandleFunc("POST /api/webhook/batch", r.handleHTTP(true))
+	mux.HandleFunc("POST /api/agents", r.proxy(agentsURL))
+	mux.HandleFunc("POST /api/incidents/status", r.proxy(incidentStatusURL))
+	r.httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	if cfg.GRPCListen != "" {
+		listener, err := net.Listen("tcp", cfg.GRPCListen)
+		if err != nil {
+			r.httpListener.Close()
+			return nil, err
+		}
+		r.grpcListener = listener
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		r.grpcServer = grpc.NewServer(opts...)
+		incidentpb.RegisterIncidentServiceServer(r.grpcServer, r)
+	}
+	return r, nil
+}
+
+// Addrs returns the addresses the relay listens on.
+func (r *Relay) Addrs() []string {
+	scheme := "http://"
+	if r.tls {
+		scheme = "https://"
+	}
+	addrs := []string{scheme + r.httpListener.Addr().String()}
+	if r.grpcListener != nil {
+		addrs = append(addrs, "grpc://"+r.grpcListener.Addr().String())
+	}
+	return addrs
+}
+
+// Incoming returns the accepted incidents. It is closed once Run returns.
+func (r *Relay) Incoming() <-chan IncidentPayload {
+	return r.incoming
+}
+
+// Run serves agents until ctx is done, then lets in-flight requests finish.
+func (r *Relay) Run(ctx context.Context) {
+	defer close(r.incoming)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := r.httpServer.Serve(r.httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("relay listener failed", "err", err)
+		}
+	}()
+	if r.grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.grpcServer.Serve(r.grpcListener); err != nil {
+				slog.Error("relay gRPC listener failed", "err", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.httpServer.Shutdown(shutdownCtx)
+	if r.grpcServer != nil {
+		r.grpcServer.GracefulStop()
+	}
+	wg.Wait()
+}
+
+// accept queues payloads for forwarding, or reports that there is no room
+// for all of them.
+func (r *Relay) accept(payloads []IncidentPayload) bool {
+	r.acceptMu.Lock()
+	defer r.acceptMu.Unlock()
+	if len(r.incoming)+len(payloads) > cap(r.incoming) {
+		return false
+	}
+	for _, p := range payloads {
+		r.incoming <- p
+	}
+	return true
+}
+
+func (r *Relay) handleHTTP(batch bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := readRelayBody(req)
+		if err != nil {
+			relayError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.auth != nil {
+			if err := r.auth.Verify(req.Header.Get, body); err != nil {
+				relayError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+		}
+
+		var payloads []IncidentPayload
+		if batch {
+			err = json.Unmarshal(body, &payloads)
+		} else {
+			payloads = make([]IncidentPayload, 1)
+			err = json.Unmarshal(body, &payloads[0])
+		}
+		if err != nil {
+			relayError(w, http.StatusBadRequest, "invalid incident: "+err.Error())
+			return
+		}
+		if !r.accept(payloads) {
+			relayError(w, http.StatusServiceUnavailable, "relay queue full")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"success":true}`)
+	}
+}
+
+// proxy passes an agent's request, such as a heartbeat, on to the server
+// endpoint derived from the relay's server_url, signed with the relay's own
+// credentials, and hands back the reply.
+func (r *Relay) proxy(endpoint func(serverURL string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := readRelayBody(req)
+		if err != nil {
+			relayError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.auth != nil {
+			if err := r.auth.Verify(req.Header.Get, body); err != nil {
+				relayError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+		}
+
+		reply, err := r.upstream.Post(req.Context(), endpoint(r.upstream.ServerURL()), body)
+		var statusErr *StatusError
+		var throttleErr *ThrottleError
+		switch {
+		case errors.As(err, &throttleErr):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttleErr.RetryAfter.Seconds()))))
+			relayError(w, http.StatusServiceUnavailable, err.Error())
+		case errors.As(err, &statusErr):
+			relayError(w, statusErr.Code, err.Error())
+		case err != nil:
+			relayError(w, http.StatusBadGateway, err.Error())
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(reply)
+		}
+	}
+}
+
+// readRelayBody reads the request body, decompressing it when the agent
+// sent it gzipped. Signatures cover the uncompressed body.
+func readRelayBody(req *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(nil, req.Body, relayMaxBody)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, relayMaxBody)
+	}
+	return io.ReadAll(body)
+}
+
+func relayError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"success": false, "error": message})
+}
+
+func (r *Relay) Report(ctx context.Context, req *incidentpb.Incident) (*incidentpb.ReportResponse, error) {
+	if err := r.verifyGRPC(ctx, req); err != nil {
+		return nil, err
+	}
+	if !r.accept([]IncidentPayload{ship.PayloadFromProto(req)}) {
+		return nil, status.Error(codes.ResourceExhausted, "relay queue full")
+	}
+	return &incidentpb.ReportResponse{}, nil
+}
+
+func (r *Relay) ReportBatch(ctx context.Context, req *incidentpb.IncidentBatch) (*incidentpb.ReportBatchResponse, error) {
+	if err := r.verifyGRPC(ctx, req); err != nil {
+		return nil, err
+	}
+	payloads := make([]IncidentPayload, len(req.GetIncidents()))
+	for i, msg := range req.GetIncidents() {
+		payloads[i] = ship.PayloadFromProto(msg)
+	}
+	if !r.accept(payloads) {
+		return nil, status.Error(codes.ResourceExhausted, "relay queue full")
+	}
+	return &incidentpb.ReportBatchResponse{}, nil
+}
+
+// verifyGRPC checks the credentials withAuth sent as metadata.
+func (r *Relay) verifyGRPC(ctx context.Context, msg proto.Message) error {
+	if r.auth == nil {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if values := md.Get(strings.ToLower(key)); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	var body []byte
+	if r.auth.HMACSecret != "" || r.auth.PublicKey != "" {
+		var err error
+		if body, err = (proto.MarshalOptions{Deterministic: true}).Marshal(msg); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if err := r.auth.Verify(get, body); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// relayEvent rebuilds the parts of the agent's event that fingerprints and
+// rate limiting look at.
+func relayEvent(p IncidentPayload) LogEvent {
+	ts, err := time.Parse(time.RFC3339, p.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	return LogEvent{
+		Line:        p.ErrorLine,
+		Timestamp:   ts,
+		Context:     p.Context,
+		Target:      p.Target,
+		RepoURL:     p.RepoURL,
+		Occurrences: p.OccurrenceCount,
+	}
+}
+
+// runRelayCommand forwards incidents from agents to server_url, so a fleet
+// of agents reaches the server as one well-behaved client.
+func runRelayCommand(args []string) {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli relay")
+		os.Exit(2)
+	}
+
+	cfg := loadConfigOrExit()
+	if cfg.Relay == nil {
+		fmt.Fprintln(os.Stderr, "✗ No relay section in the config")
+		os.Exit(1)
+	}
+	var logCfg LogConfig
+	if cfg.Log != nil {
+		logCfg = *cfg.Log
+	}
+	logOut, err := setupLogger(logCfg, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Log config error: %v\n", err)
+		os.Exit(1)
+	}
+	if logOut != nil {
+		defer logOut.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	sendCtx := context.WithoutCancel(ctx)
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		slog.Error("create client failed", "err", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	relay, err := NewRelay(cfg.Relay, client)
+	if err != nil {
+		slog.Error("start relay failed", "err", err)
+		os.Exit(1)
+	}
+
+	budget := cfg.memoryBudget()
+	budget.apply()
+	var queue *OfflineQueue
+	if cfg.Queue != nil && cfg.Queue.Enabled {
+		queue, err = OpenOfflineQueue(cfg.Queue, budget.queue)
+		if err != nil {
+			slog.Error("open offline queue failed", "err", err)
+			os.Exit(1)
+		}
+		go queue.Run(ctx, client)
+	}
+	sender := NewSender(client, queue)
+
+	deduper, err := NewDeduper(cfg.Dedupe)
+	if err != nil {
+		slog.Error("load dedupe cache failed", "err", err)
+		os.Exit(1)
+	}
+	// Duplicates the relay aggregates are counted on the next incident it
+	// forwards rather than sent as updates, which it has no payload for
+	go deduper.Run(ctx, nil)
+
+	// Storm summaries come from the relay itself, so they are built and
+	// classified like a watcher's incidents
+	classifier, err := NewClassifier(cfg.Severity)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	storms := make(chan LogEvent, 100)
+	var limiter *RateLimiter
+	if cfg.RateLimit != nil && cfg.RateLimit.Enabled {
+		limiter = NewRateLimiter(cfg.RateLimit)
+		go func() {
+			limiter.Run(ctx, storms)
+			close(storms)
+		}()
+	} else {
+		close(storms)
+	}
+
+	sinks, err := NewSinks(cfg)
+	if err != nil {
+		slog.Error("load config failed", "err", err)
+		os.Exit(1)
+	}
+	sinks.Run(sendCtx)
+
+	var batcher *Batcher
+	if cfg.Batch != nil && cfg.Batch.Enabled {
+		batcher = NewBatcher(cfg.Batch, func(payloads []IncidentPayload) {
+			sender.DeliverBatch(sendCtx, payloads)
+		})
+		batcher.maxBytes = budget.batch
+	}
+	forward := func(payload IncidentPayload) {
+		redactor.Payload(&payload)
+		sinks.Notify(payload)
+		if batcher != nil {
+			batcher.Add(payload)
+			return
+		}
+		sender.Deliver(sendCtx, payload)
+	}
+
+	go relay.Run(ctx)
+	slog.Info("relaying incidents", "listen", strings.Join(relay.Addrs(), ", "), "server", cfg.ServerURL)
+
+	incoming := relay.Incoming()
+	for incoming != nil || storms != nil {
+		select {
+		case payload, ok := <-incoming:
+			if !ok {
+				incoming = nil
+				continue
+			}
+			event := relayEvent(payload)
+			if deduper.IsDuplicate(&event) {
+				continue
+			}
+			if !event.FirstSeen.IsZero() {
+				payload.SetOccurrences(event)
+			}
+			if payload.Fingerprint == "" {
+				// Incidents sent over gRPC, or by older agents, carry none
+				payload.Fingerprint = detect.Fingerprint(event, deduper.Strategy())
+			}
+			if limiter != nil && !limiter.Allow(event) {
+				continue
+			}
+			forward(payload)
+		case event, ok := <-storms:
+			if !ok {
+				storms = nil
+				continue
+			}
+			severity, ok := classifier.Classify(event)
+			if !ok {
+				continue
+			}
+			payload := client.Payload(event)
+			payload.Severity = severity
+			forward(payload)
+		}
+	}
+
+	sinks.Close()
+	if batcher != nil {
+		batcher.Close()
+	}
+	if err := deduper.Save(); err != nil {
+		slog.Error("save dedupe cache failed", "err", err)
+	}
+	stats := sender.Stats()
+	slog.Info("relay stopped", "sent", stats.Sent, "failed", stats.Failed, "duplicates", deduper.Duplicates())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+func TestRelayProxies(t *testing.T) {
+	var gotPath, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		gotPath, gotBody = req.URL.Path, string(body)
+		if strings.Contains(gotBody, "unknown") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"success":true}`)
+	}))
+	defer server.Close()
+	client, err := ship.NewClient(ship.Config{ServerURL: server.URL + "/api/webhook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Relay{upstream: client}
+
+	tests := []struct {
+		endpoint func(string) string
+		path     string
+		body     string
+		want     int
+	}{
+		{agentsURL, "/api/agents", `{"name":"web01"}`, http.StatusOK},
+		{agentsURL, "/api/agents", `{"name":"unknown"}`, http.StatusBadRequest},
+		{incidentStatusURL, "/api/incidents/status", `{"ids":[],"fingerprints":[{"fingerprint":"ab12"}]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.proxy(tt.endpoint)(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.body, rec.Code, tt.want)
+		}
+		if gotPath != tt.path || gotBody != tt.body {
+			t.Errorf("%s: server got %s %q", tt.body, gotPath, gotBody)
+		}
+		if tt.want == http.StatusOK && rec.Body.String() != `{"success":true}` {
+			t.Errorf("%s: reply = %q, want the server's", tt.body, rec.Body.String())
+		}
+	}
+}
+
+func TestRelayProxyChecksAuth(t *testing.T) {
+	r := &Relay{auth: &AuthConfig{HMACSecret: "secret"}}
+	rec := httptest.NewRecorder()
+	r.proxy(agentsURL)(rec, httptest.NewRequest(http.MethodPost, "/api/agents", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned request: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
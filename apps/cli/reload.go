@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// Reloader re-reads the config on SIGHUP, when the config file changes, or
+// when asked over the control socket, and hands a validated config to apply.
+// An invalid config is logged and the current one stays in effect.
+type Reloader struct {
+	apply func(prev, next *Config) error
+
+	mu      sync.Mutex
+	current *Config
+	stamp   fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewReloader(cfg *Config, apply func(prev, next *Config) error) *Reloader {
+	return &Reloader{
+		apply:   apply,
+		current: cfg,
+		stamp:   statConfig(),
+	}
+}
+
+// Config returns the config currently in effect.
+func (r *Reloader) Config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload reads and validates the config file and applies it.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stamp = statConfig()
+	var next *Config
+	var err error
+	if r.current.stdin {
+		next, err = LoadStdinConfig()
+	} else {
+		next, err = LoadConfig()
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.apply(r.current, next); err != nil {
+		return err
+	}
+	r.current = next
+	return nil
+}
+
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading config", "reason", "SIGHUP")
+		case <-ticker.C:
+			r.mu.Lock()
+			changed := statConfig() != r.stamp
+			r.mu.Unlock()
+			if !changed {
+				continue
+			}
+			slog.Info("reloading config", "reason", "file changed")
+		}
+		if err := r.Reload(); err != nil {
+			slog.Error("config reload failed, keeping the current config", "err", err)
+		}
+	}
+}
+
+func statConfig() fileStamp {
+	info, err := os.Stat(ConfigPath())
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// restartRequired lists the config sections that differ between prev and
+// next but are only read at startup.
+func restartRequired(prev, next *Config) []string {
+	sections := []struct {
+		name       string
+		prev, next any
+	}{
+		{"control_socket", prev.ControlSocket, next.ControlSocket},
+		{"pid_file", prev.PIDFile, next.PIDFile},
+		{"checkpoint_file", prev.CheckpointFile, next.CheckpointFile},
+		{"anomaly", prev.Anomaly, next.Anomaly},
+		{"queue", prev.Queue, next.Queue},
+		{"batch", prev.Batch, next.Batch},
+		{"dedupe", prev.Dedupe, next.Dedupe},
+		{"health", prev.Health, next.Health},
+		{"log", prev.Log, next.Log},
+		{"kubernetes", prev.Kubernetes, next.Kubernetes},
+		{"syslog", prev.Syslog, next.Syslog},
+		{"gelf", prev.GELF, next.GELF},
+		{"forward", prev.Forward, next.Forward},
+		{"ingest", prev.Ingest, next.Ingest},
+		{"rate_limit", prev.RateLimit, next.RateLimit},
+		{"sampling", prev.Sampling, next.Sampling},
+		{"spool", prev.Spool, next.Spool},
+		{"slack", prev.Slack, next.Slack},
+		{"pagerduty", prev.PagerDuty, next.PagerDuty},
+		{"sentry", prev.Sentry, next.Sentry},
+		{"otlp", prev.OTLP, next.OTLP},
+		{"fluent", prev.Fluent, next.Fluent},
+		{"datadog", prev.Datadog, next.Datadog},
+		{"email", prev.Email, next.Email},
+		{"webhooks", prev.Webhooks, next.Webhooks},
+		{"routes", prev.Routes, next.Routes},
+		{"archive", prev.Archive, next.Archive},
+		{"grpc", prev.GRPC, next.GRPC},
+		{"tls", prev.TLS, next.TLS},
+		{"proxy", prev.Proxy, next.Proxy},
+		{"heartbeat", prev.Heartbeat, next.Heartbeat},
+		{"fix_status", prev.FixStatus, next.FixStatus},
+		{"history", prev.History, next.History},
+		{"privileges", prev.Privileges, next.Privileges},
+		{"plugins", prev.Plugins, next.Plugins},
+		{"detect_workers", prev.DetectWorkers, next.DetectWorkers},
+		{"memory_limit_mb", prev.MemoryLimitMB, next.MemoryLimitMB},
+	}
+
+	var changed []string
+	for _, s := range sections {
+		if !reflect.DeepEqual(s.prev, s.next) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
+
+// watchSet runs a watcher for each configured file or directory target and
+// brings the running set in line with the targets of a reloaded config.
+type watchSet struct {
+	ctx       context.Context
+	events    chan<- LogEvent
+	anomaly   *AnomalyConfig
+	streamEnd func()
+	// checkpoints decides where new targets start reading
+	checkpoints *Checkpoints
+	// guard restarts watchers that panic
+	guard *panicGuard
+
+	mu      sync.Mutex
+	entries map[string]*watchEntry
+	order   []string
+	stopped bool
+}
+
+type watchEntry struct {
+	target  Target
+	watcher *Watcher
+	dir     *DirWatcher
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// newWatchSet runs watchers until ctx is done or Stop is called.
+func newWatchSet(ctx context.Context, events chan<- LogEvent, anomaly *AnomalyConfig, checkpoints *Checkpoints, streamEnd func()) *watchSet {
+	return &watchSet{
+		ctx:         ctx,
+		events:      events,
+		anomaly:     anomaly,
+		streamEnd:   streamEnd,
+		checkpoints: checkpoints,
+		entries:     make(map[string]*watchEntry),
+	}
+}
+
+func targetKey(t Target) string {
+	if t.Dir != "" {
+		return "dir:" + t.Dir
+	}
+	return t.LogPath
+}
+
+// Start begins watching target. Files are read from offset, or from where
+// the target's start_from says when offset is negative.
+func (s *watchSet) Start(target Target, detector *Detector, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.start(target, detector, offset, nil)
+	if err != nil {
+		return err
+	}
+	key := targetKey(target)
+	s.entries[key] = e
+	s.order = append(s.order, key)
+	return nil
+}
+
+func (s *watchSet) start(target Target, detector *Detector, offset int64, prevDir *DirWatcher) (*watchEntry, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	e := &watchEntry{target: target, cancel: cancel}
+
+	switch {
+	case target.Dir != "":
+		dir, err := NewDirWatcher(target, detector, s.checkpoints)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if prevDir != nil {
+			dir.Resume(prevDir)
+		}
+		dir.guard = s.guard
+		e.dir = dir
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			s.guard.Run(ctx, "directory "+target.Dir, func() {
+				dir.Watch(ctx, s.events)
+			})
+		}()
+		slog.Info("watching directory", "dir", target.Dir, "match", target.Match)
+
+	default:
+		if target.LogPath == watch.StdinPath {
+			e.watcher = watch.NewStreamWatcher(target, detector, os.Stdin)
+			slog.Info("reading standard input")
+		} else {
+			if offset < 0 {
+				if info, err := os.Stat(target.LogPath); err == nil {
+					offset = s.checkpoints.StartOffset(target, target.LogPath, info.Size())
+				}
+			}
+			watcher, err := watch.NewWatcherAt(target, detector, offset)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			e.watcher = watcher
+			if target.Label != "" {
+				slog.Info("watching", "path", target.LogPath, "label", target.Label)
+			} else {
+				slog.Info("watching", "path", target.LogPath)
+			}
+		}
+
+		e.wg.Add(1)
+		go func(w *Watcher) {
+			defer e.wg.Done()
+			if !w.IsStream() {
+				w.Supervise(ctx, s.guard, s.events)
+				return
+			}
+			if err := w.WatchGuarded(ctx, s.guard, s.events); err != nil {
+				slog.Error("watcher stopped", "path", w.Path(), "err", err)
+			}
+			slog.Info("input stream closed", "path", w.Path())
+			s.streamEnd()
+		}(e.watcher)
+
+		if s.anomaly != nil && s.anomaly.Enabled {
+			e.wg.Add(1)
+			go func(w *Watcher) {
+				defer e.wg.Done()
+				NewAnomalyDetector(s.anomaly, w).Run(ctx, s.events)
+			}(e.watcher)
+		}
+	}
+
+	return e, nil
+}
+
+// stopEntry stops e and waits until it no longer sends events.
+func (s *watchSet) stopEntry(e *watchEntry) {
+	e.cancel()
+	e.wg.Wait()
+	if e.watcher != nil {
+		e.watcher.Close()
+	}
+}
+
+// Apply starts, stops and restarts watchers to match targets and switches the
+// ones that keep running to detector. A restarted file continues from where
+// the old watcher stopped reading.
+func (s *watchSet) Apply(targets []Target, detector *Detector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+
+	wanted := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		wanted[targetKey(t)] = t
+	}
+
+	var order []string
+	for _, key := range s.order {
+		e := s.entries[key]
+		target, ok := wanted[key]
+		switch {
+		case !ok:
+			s.stopEntry(e)
+			delete(s.entries, key)
+			if e.dir != nil {
+				slog.Info("stopped watching directory", "dir", e.target.Dir)
+			} else {
+				slog.Info("stopped watching", "path", e.target.LogPath)
+			}
+		// Standard input cannot be reopened, so it only takes new patterns
+		case target == e.target || key == watch.StdinPath:
+			s.setDetector(e, detector)
+			order = append(order, key)
+		default:
+			s.stopEntry(e)
+			delete(s.entries, key)
+			offset := int64(-1)
+			if e.watcher != nil {
+				offset = e.watcher.ResumeOffset()
+			}
+			restarted, err := s.start(target, detector, offset, e.dir)
+			if err != nil {
+				slog.Error("restart watcher failed", "path", key, "err", err)
+				continue
+			}
+			s.entries[key] = restarted
+			order = append(order, key)
+		}
+	}
+
+	for _, t := range targets {
+		key := targetKey(t)
+		if _, ok := s.entries[key]; ok {
+			continue
+		}
+		e, err := s.start(t, detector, -1, nil)
+		if err != nil {
+			slog.Error("start watcher failed", "path", key, "err", err)
+			continue
+		}
+		s.entries[key] = e
+		order = append(order, key)
+	}
+	s.order = order
+}
+
+func (s *watchSet) setDetector(e *watchEntry, detector *Detector) {
+	if e.dir != nil {
+		e.dir.SetDetector(detector)
+		return
+	}
+	e.watcher.SetDetector(detector)
+}
+
+// Watchers returns the files being tailed, including those found in watched
+// directories.
+func (s *watchSet) Watchers() []*Watcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var watchers []*Watcher
+	for _, key := range s.order {
+		e := s.entries[key]
+		if e.dir != nil {
+			watchers = append(watchers, e.dir.Watchers()...)
+		} else {
+			watchers = append(watchers, e.watcher)
+		}
+	}
+	return watchers
+}
+
+// Stop stops every watcher and waits for them to exit. Later calls to Apply
+// do nothing.
+func (s *watchSet) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	// Signal every watcher first so their idle waits overlap
+	for _, e := range s.entries {
+		e.cancel()
+	}
+	for _, e := range s.entries {
+		e.wg.Wait()
+		if e.watcher != nil {
+			e.watcher.Close()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+// runReplayCommand re-sends NDJSON incidents, such as the archive or the
+// offline queue file, gzip-compressed or not, to the server after an outage or a move to a new
+// server.
+func runReplayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	sinks := fs.Bool("sinks", false, "also notify the configured sinks, e.g. Slack, except the archive")
+	since := fs.String("since", "", "only replay incidents from this time on, as RFC 3339 or a duration such as 24h")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli replay [--sinks] [--since TIME] FILE")
+		os.Exit(2)
+	}
+
+	var cutoff time.Time
+	if *since != "" {
+		var err error
+		if cutoff, err = parseSince(*since); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ --since: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	file, err := openLogFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	cfg := loadConfigOrExit()
+	client, err := NewClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	notify := &Sinks{}
+	if *sinks {
+		// Replayed incidents are already in the archive
+		sinkCfg := *cfg
+		sinkCfg.Archive = nil
+		notify, err = NewSinks(&sinkCfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		notify.Run(context.WithoutCancel(ctx))
+	}
+
+	var sent, failed, skipped int
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() && ctx.Err() == nil {
+		var payload IncidentPayload
+		if err := json.Unmarshal(scanner.Bytes(), &payload); err != nil || payload.ErrorLine == "" {
+			skipped++
+			continue
+		}
+		if !cutoff.IsZero() {
+			if ts, err := time.Parse(time.RFC3339, payload.Timestamp); err == nil && ts.Before(cutoff) {
+				skipped++
+				continue
+			}
+		}
+
+		if _, err := client.SendPayload(ctx, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %s %s: %v\n", payload.Timestamp, ship.Truncate(payload.ErrorLine, 80), err)
+			failed++
+			continue
+		}
+		notify.NotifyWait(ctx, payload)
+		sent++
+	}
+	notify.Close()
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Read %s: %v\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Replayed %d incidents (%d failed, %d skipped)\n", sent, failed, skipped)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "✗ Interrupted before the end of the file")
+		os.Exit(1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseSince accepts an RFC 3339 time or a duration back from now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
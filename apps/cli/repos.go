@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RepoRuleConfig sends the incidents it matches to RepoURL rather than their
+// target's repository, for logs shared by applications from several
+// repositories. A rule matches when all its conditions do.
+type RepoRuleConfig struct {
+	// File is matched against the file of every stack frame
+	File string `json:"file,omitempty"`
+	// Pattern is matched against the error line and each context line
+	Pattern string `json:"pattern,omitempty"`
+	RepoURL string `json:"repo_url"`
+	// RepoPath is a local checkout of the repository for the git enricher
+	RepoPath string `json:"repo_path,omitempty"`
+}
+
+func (c *RepoRuleConfig) Validate() error {
+	if c.File == "" && c.Pattern == "" {
+		return errors.New("file or pattern is required")
+	}
+	if c.RepoURL == "" {
+		return errors.New("repo_url is required")
+	}
+	if _, err := regexp.Compile(c.File); err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return fmt.Errorf("pattern: %w", err)
+	}
+	return nil
+}
+
+// RepositoryConfig is a repository whose code sits under PathPrefixes in
+// stack traces, so incidents can be sent to it by where they were raised.
+type RepositoryConfig struct {
+	RepoURL string `json:"repo_url"`
+	// PathPrefixes start the files of the repository's frames: absolute
+	// ones such as /srv/payments/, relative ones such as services/payments/
+	// at any directory, or package prefixes such as com.acme.payments. or
+	// github.com/acme/payments/ that start the frames' functions
+	PathPrefixes []string `json:"path_prefixes"`
+	RepoPath     string   `json:"repo_path,omitempty"`
+}
+
+func (c *RepositoryConfig) Validate() error {
+	if c.RepoURL == "" {
+		return errors.New("repo_url is required")
+	}
+	if len(c.PathPrefixes) == 0 {
+		return errors.New("path_prefixes is required")
+	}
+	if slices.Contains(c.PathPrefixes, "") {
+		return errors.New("path_prefixes must not be empty")
+	}
+	return nil
+}
+
+type repoRule struct {
+	file     *regexp.Regexp
+	pattern  *regexp.Regexp
+	repoURL  string
+	repoPath string
+}
+
+// RepoRules picks the repository of an incident by the first rule it
+// matches or, failing that, by the repository its stack trace runs through.
+type RepoRules struct {
+	rules        []repoRule
+	repositories []RepositoryConfig
+}
+
+func NewRepoRules(rules []RepoRuleConfig, repositories []RepositoryConfig) (*RepoRules, error) {
+	r := &RepoRules{repositories: repositories}
+	for i, c := range rules {
+		rule := repoRule{repoURL: c.RepoURL, repoPath: c.RepoPath}
+		var err error
+		if c.File != "" {
+			if rule.file, err = regexp.Compile(c.File); err != nil {
+				return nil, fmt.Errorf("repo_rules[%d]: file: %w", i, err)
+			}
+		}
+		if c.Pattern != "" {
+			if rule.pattern, err = regexp.Compile(c.Pattern); err != nil {
+				return nil, fmt.Errorf("repo_rules[%d]: pattern: %w", i, err)
+			}
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Apply moves the candidate, whose payload normalize has built, to the
+// repository of the first rule it matches, or else to the one it infers
+// from the stack trace. The event's repo_path goes with it, so the git
+// enricher reads the right checkout.
+func (r *RepoRules) Apply(c *Candidate) {
+	repoURL, repoPath, ok := r.match(c)
+	if !ok {
+		return
+	}
+	c.Event.RepoURL = repoURL
+	c.Event.RepoPath = repoPath
+	c.Payload.RepoURL = repoURL
+}
+
+func (r *RepoRules) match(c *Candidate) (repoURL, repoPath string, ok bool) {
+	for _, rule := range r.rules {
+		if rule.match(c) {
+			return rule.repoURL, rule.repoPath, true
+		}
+	}
+	if repo, ok := r.infer(c.Payload.Frames); ok {
+		return repo.RepoURL, repo.RepoPath, true
+	}
+	return "", "", false
+}
+
+// infer finds the repository of the innermost in-app frame under one of the
+// configured prefixes, the longest prefix winning for nested repositories.
+// Frames outside every repository, such as those of a shared library, are
+// passed over for the ones that called them.
+func (r *RepoRules) infer(frames []StackFrame) (RepositoryConfig, bool) {
+	for _, f := range frames {
+		if !f.InApp() {
+			continue
+		}
+		best, longest := -1, 0
+		for i, repo := range r.repositories {
+			for _, prefix := range repo.PathPrefixes {
+				if len(prefix) > longest && frameUnder(f, prefix) {
+					best, longest = i, len(prefix)
+				}
+			}
+		}
+		if best >= 0 {
+			return r.repositories[best], true
+		}
+	}
+	return RepositoryConfig{}, false
+}
+
+// frameUnder reports whether f is under prefix: its file starts with it,
+// contains a relative one after a slash, or its function starts with it.
+func frameUnder(f StackFrame, prefix string) bool {
+	file := strings.TrimPrefix(strings.ReplaceAll(f.File, `\`, "/"), "file://")
+	if strings.HasPrefix(file, prefix) || strings.HasPrefix(f.Function, prefix) {
+		return true
+	}
+	return !strings.HasPrefix(prefix, "/") && strings.Contains(file, "/"+prefix)
+}
+
+func (r *repoRule) match(c *Candidate) bool {
+	p := c.Payload
+	if r.file != nil && !slices.ContainsFunc(p.Frames, func(f StackFrame) bool { return r.file.MatchString(f.File) }) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(p.ErrorLine) && !slices.ContainsFunc(p.Context, r.pattern.MatchString) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// RouteConfig sends the incidents it matches to Sinks only. Conditions
+// left empty match everything; a route without any is a catch-all.
+type RouteConfig struct {
+	Severity    []string `json:"severity,omitempty"`
+	Language    []string `json:"language,omitempty"`
+	Fingerprint []string `json:"fingerprint,omitempty"`
+	// File is matched against the file of every stack frame
+	File string `json:"file,omitempty"`
+	// Pattern is matched against the error line and each context line
+	Pattern string `json:"pattern,omitempty"`
+	// Sinks are named as in the log: slack, pagerduty, sentry, otlp,
+	// fluent, datadog, email, archive or "webhook <name>"
+	Sinks []string `json:"sinks"`
+}
+
+func (c *RouteConfig) Validate() error {
+	for _, s := range c.Severity {
+		if _, ok := severityRank[s]; !ok {
+			return fmt.Errorf("severity must be one of low, medium, high, critical: %q", s)
+		}
+	}
+	if _, err := regexp.Compile(c.File); err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return fmt.Errorf("pattern: %w", err)
+	}
+	if c.Sinks == nil {
+		return errors.New("sinks is required; use [] to send matching incidents nowhere")
+	}
+	return nil
+}
+
+type sinkRoute struct {
+	severity    []string
+	language    []string
+	fingerprint []string
+	file        *regexp.Regexp
+	pattern     *regexp.Regexp
+	sinks       []string
+}
+
+func newSinkRoutes(routes []RouteConfig) ([]sinkRoute, error) {
+	out := make([]sinkRoute, len(routes))
+	for i, r := range routes {
+		out[i] = sinkRoute{
+			severity:    r.Severity,
+			language:    r.Language,
+			fingerprint: r.Fingerprint,
+			sinks:       r.Sinks,
+		}
+		var err error
+		if r.File != "" {
+			if out[i].file, err = regexp.Compile(r.File); err != nil {
+				return nil, fmt.Errorf("routes[%d]: file: %w", i, err)
+			}
+		}
+		if r.Pattern != "" {
+			if out[i].pattern, err = regexp.Compile(r.Pattern); err != nil {
+				return nil, fmt.Errorf("routes[%d]: pattern: %w", i, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// match reports whether the route applies to p, whose fingerprint is
+// computed by fingerprint when the route asks for it.
+func (r *sinkRoute) match(p IncidentPayload, fingerprint func() string) bool {
+	if len(r.severity) > 0 && !slices.Contains(r.severity, p.Severity) {
+		return false
+	}
+	if len(r.language) > 0 && !slices.Contains(r.language, p.Language) {
+		return false
+	}
+	if r.file != nil && !slices.ContainsFunc(p.Frames, func(f StackFrame) bool { return r.file.MatchString(f.File) }) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(p.ErrorLine) && !slices.ContainsFunc(p.Context, r.pattern.MatchString) {
+		return false
+	}
+	if len(r.fingerprint) > 0 && !slices.Contains(r.fingerprint, fingerprint()) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []RouteConfig
+		err    string
+	}{
+		{"bad file", []RouteConfig{{File: "(", Sinks: []string{}}}, "routes[0]: file:"},
+		{"bad pattern", []RouteConfig{{Pattern: "[", Sinks: []string{}}}, "routes[0]: pattern:"},
+		{"missing sinks", []RouteConfig{{}}, "routes[0]: sinks is required"},
+		{"unknown sink", []RouteConfig{{Sinks: []string{"slack"}}}, `routes[0]: no sink named "slack"`},
+		{"webhook by host", []RouteConfig{{Sinks: []string{"webhook hooks.example.com"}}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				LogPath:   "/var/log/app.log",
+				RepoURL:   "https://github.com/example/app",
+				ServerURL: "https://lacia.example.com",
+				Webhooks:  []WebhookConfig{{URL: "https://hooks.example.com/incident"}},
+				Routes:    tt.routes,
+			}
+			err := cfg.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Validate() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewSinksInvalidRoute(t *testing.T) {
+	_, err := NewSinks(&Config{Routes: []RouteConfig{{File: "(", Sinks: []string{}}}})
+	if err == nil || !strings.Contains(err.Error(), "routes[0]: file:") {
+		t.Fatalf("NewSinks() = %v, want a routes[0] error", err)
+	}
+}
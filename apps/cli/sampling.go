@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+const (
+	defaultSampleFirst  = 10
+	defaultSampleRate   = 10
+	defaultSampleWindow = time.Minute
+	maxSampleGroups     = 1000
+)
+
+type SamplingConfig struct {
+	Enabled bool     `json:"enabled"`
+	First   int      `json:"first,omitempty"`
+	Rate    int      `json:"rate,omitempty"`
+	Window  Duration `json:"window,omitempty"`
+}
+
+func (c *SamplingConfig) Validate() error {
+	if c.First < 0 {
+		return errors.New("first must be positive")
+	}
+	if c.Rate < 0 {
+		return errors.New("rate must be positive")
+	}
+	if c.Window < 0 {
+		return errors.New("window must be positive")
+	}
+	return nil
+}
+
+type sampleGroup struct {
+	start   time.Time
+	seen    int
+	skipped int
+}
+
+// Sampler sends the first N occurrences of an error in each window and then
+// only one in every K, carrying the number of occurrences it stands for.
+type Sampler struct {
+	first  int
+	rate   int
+	window time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*sampleGroup
+}
+
+func NewSampler(cfg *SamplingConfig) *Sampler {
+	s := &Sampler{
+		first:  cfg.First,
+		rate:   cfg.Rate,
+		window: time.Duration(cfg.Window),
+		groups: make(map[string]*sampleGroup),
+	}
+	if s.first == 0 {
+		s.first = defaultSampleFirst
+	}
+	if s.rate == 0 {
+		s.rate = defaultSampleRate
+	}
+	if s.window == 0 {
+		s.window = defaultSampleWindow
+	}
+	return s
+}
+
+// Sample reports whether event should be sent, setting its Occurrences when
+// it also stands for skipped events.
+func (s *Sampler) Sample(event *LogEvent) bool {
+	key := detect.Fingerprint(*event, detect.FingerprintNormalized)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groups[key]
+	if g == nil || now.Sub(g.start) >= s.window {
+		if g == nil && len(s.groups) >= maxSampleGroups {
+			s.evict(now)
+		}
+		g = &sampleGroup{start: now}
+		s.groups[key] = g
+	}
+
+	g.seen++
+	if g.seen <= s.first {
+		return true
+	}
+	if (g.seen-s.first)%s.rate != 0 {
+		g.skipped++
+		return false
+	}
+
+	event.Occurrences = g.skipped + 1
+	g.skipped = 0
+	return true
+}
+
+// evict makes room for a group by dropping the expired ones or, when all
+// are live, the oldest, whose count starts over if it comes back.
+func (s *Sampler) evict(now time.Time) {
+	var oldest string
+	for key, g := range s.groups {
+		if now.Sub(g.start) >= s.window {
+			delete(s.groups, key)
+		} else if oldest == "" || g.start.Before(s.groups[oldest].start) {
+			oldest = key
+		}
+	}
+	if len(s.groups) >= maxSampleGroups {
+		delete(s.groups, oldest)
+	}
+}
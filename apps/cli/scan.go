@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// scanResult is one incident found by `lacia-cli scan --json`.
+type scanResult struct {
+	// File is set when several files are scanned
+	File        string   `json:"file,omitempty"`
+	Line        int64    `json:"line"`
+	Severity    string   `json:"severity"`
+	Fingerprint string   `json:"fingerprint"`
+	ErrorLine   string   `json:"error_line"`
+	Context     []string `json:"context"`
+}
+
+// runScanCommand runs the configured patterns, multiline, severity and
+// ignore rules over existing log files, gzip-compressed rotations included,
+// and lists the incidents a watcher would have raised, to check pattern
+// changes against old logs.
+func runScanCommand(args []string) {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print each incident as a line of JSON")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli scan [--json] FILE...")
+		os.Exit(2)
+	}
+
+	cfg := loadConfigOrExit()
+	detector, err := detect.NewDetector(cfg.Patterns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Invalid patterns: %v\n", err)
+		os.Exit(1)
+	}
+	plugins, err := LoadPlugins(context.Background(), cfg.Plugins)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	defer plugins.Close()
+	detector.Plugins = plugins.Detectors()
+	workers := detect.NewPool(cfg.DetectWorkers)
+	defer workers.Close()
+	detector.Workers = workers
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	classifier, err := NewClassifier(cfg.Severity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	ignore, err := NewIgnoreRules(cfg.Ignore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+	var strategy string
+	if cfg.Dedupe != nil {
+		strategy = cfg.Dedupe.Fingerprint
+	}
+
+	var lines int64
+	var incidents, ignored, below int
+	fingerprints := make(map[string]int)
+	encoder := json.NewEncoder(os.Stdout)
+	// Rotations are listed oldest first, e.g. app.log.2.gz app.log.1 app.log
+	for _, path := range fs.Args() {
+		if ctx.Err() != nil {
+			break
+		}
+		file, err := openLogFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		watcher := watch.NewStreamWatcher(cfg.readDefaults(Target{LogPath: path}), detector, file)
+		events := make(chan LogEvent, 100)
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- watcher.Watch(ctx, events)
+			close(events)
+		}()
+
+		for event := range events {
+			if _, ok := ignore.Match(event); ok {
+				ignored++
+				continue
+			}
+			severity, ok := classifier.Classify(event)
+			if !ok {
+				below++
+				continue
+			}
+			incidents++
+			fingerprint := detect.Fingerprint(event, strategy)
+			fingerprints[fingerprint]++
+
+			var name string
+			if fs.NArg() > 1 {
+				name = path
+			}
+			if *asJSON {
+				encoder.Encode(scanResult{
+					File:        name,
+					Line:        event.LineNumber,
+					Severity:    severity,
+					Fingerprint: fingerprint,
+					ErrorLine:   event.Line,
+					Context:     event.Context,
+				})
+				continue
+			}
+			if name != "" {
+				fmt.Printf("%s:", name)
+			}
+			fmt.Printf("%6d  %-8s  %s  %s\n", event.LineNumber, severity, fingerprint, event.Line)
+		}
+		err = <-watchErr
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ Read %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		n, _ := watcher.Counts()
+		lines += n
+	}
+
+	// Keep stdout to incidents so --json output can be piped
+	fmt.Fprintf(os.Stderr, "✓ Scanned %d lines: %d incidents with %d distinct fingerprints (%d ignored, %d below severity.min)\n",
+		lines, incidents, len(fingerprints), ignored, below)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "✗ Interrupted before the end of the file")
+		os.Exit(1)
+	}
+}
+
+// gzipFile reads a gzip-compressed file and closes both on Close.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// openLogFile opens a log file for reading, decompressing it if it is
+// gzip-compressed as logrotate's compress option leaves rotations. Plain
+// files are returned as the *os.File, so their encoding can be detected.
+func openLogFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	if n, _ := file.ReadAt(magic, 0); n < 2 || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return file, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &gzipFile{Reader: zr, file: file}, nil
+}
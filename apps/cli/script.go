@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ScriptConfig holds rules written as expr expressions
+// (https://expr-lang.org) for logic the pattern lists cannot express.
+type ScriptConfig struct {
+	Rules []ScriptRule `json:"rules"`
+}
+
+// ScriptRule applies its actions to the errors When matches. Rules run in
+// order and each sees the changes made by the ones before it.
+type ScriptRule struct {
+	When     string            `json:"when"`
+	Drop     bool              `json:"drop,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	// Set rewrites fields with the string results of expressions: one of
+	// scriptFields or "tags.<name>"
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// scriptFields are the fields a rule can set.
+var scriptFields = []string{"error_line", "target", "repo_url", "service", "environment", "version"}
+
+// scriptEnv is what expressions see of an error.
+type scriptEnv struct {
+	ErrorLine   string            `expr:"error_line"`
+	Context     []string          `expr:"context"`
+	Target      string            `expr:"target"`
+	RepoURL     string            `expr:"repo_url"`
+	Hostname    string            `expr:"hostname"`
+	Severity    string            `expr:"severity"`
+	Language    string            `expr:"language"`
+	Files       []string          `expr:"files"`
+	Service     string            `expr:"service"`
+	Environment string            `expr:"environment"`
+	Version     string            `expr:"version"`
+	Tags        map[string]string `expr:"tags"`
+}
+
+// scriptRegexps caches the patterns replaceRegex is called with.
+var scriptRegexps sync.Map
+
+// scriptOptions compile expressions against scriptEnv, with replaceRegex
+// added to expr's builtins.
+func scriptOptions(result expr.Option) []expr.Option {
+	return []expr.Option{
+		expr.Env(scriptEnv{}),
+		result,
+		expr.Function("replaceRegex", func(params ...any) (any, error) {
+			s, pattern, repl := params[0].(string), params[1].(string), params[2].(string)
+			re, ok := scriptRegexps.Load(pattern)
+			if !ok {
+				compiled, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, err
+				}
+				re, _ = scriptRegexps.LoadOrStore(pattern, compiled)
+			}
+			return re.(*regexp.Regexp).ReplaceAllString(s, repl), nil
+		}, new(func(s, pattern, repl string) string)),
+	}
+}
+
+func (c *ScriptConfig) Validate() error {
+	if len(c.Rules) == 0 {
+		return errors.New("rules is required")
+	}
+	_, err := NewScript(c)
+	return err
+}
+
+type scriptRule struct {
+	when     *vm.Program
+	drop     bool
+	severity string
+	tags     map[string]string
+	set      map[string]*vm.Program
+}
+
+// Script runs the configured rules against each candidate.
+type Script struct {
+	rules []scriptRule
+}
+
+func NewScript(cfg *ScriptConfig) (*Script, error) {
+	s := &Script{}
+	for i, r := range cfg.Rules {
+		if r.When == "" {
+			return nil, fmt.Errorf("rules[%d]: when is required", i)
+		}
+		when, err := expr.Compile(r.When, scriptOptions(expr.AsBool())...)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: when: %w", i, err)
+		}
+		if r.Severity != "" {
+			if _, ok := severityRank[r.Severity]; !ok {
+				return nil, fmt.Errorf("rules[%d]: severity must be one of low, medium, high, critical: %q", i, r.Severity)
+			}
+		}
+		rule := scriptRule{when: when, drop: r.Drop, severity: r.Severity, tags: r.Tags, set: map[string]*vm.Program{}}
+		for field, source := range r.Set {
+			if !strings.HasPrefix(field, "tags.") && !slices.Contains(scriptFields, field) {
+				return nil, fmt.Errorf("rules[%d]: cannot set %q", i, field)
+			}
+			program, err := expr.Compile(source, scriptOptions(expr.AsKind(reflect.String))...)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d]: set %s: %w", i, field, err)
+			}
+			rule.set[field] = program
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
+
+// newScriptOrNil compiles a validated config, or returns nil without one.
+func newScriptOrNil(cfg *ScriptConfig) *Script {
+	if cfg == nil {
+		return nil
+	}
+	s, _ := NewScript(cfg)
+	return s
+}
+
+// Apply runs the rules against c, which must be normalized, and reports
+// whether one dropped it. A rule that fails to evaluate is skipped.
+func (s *Script) Apply(c *Candidate) (drop bool) {
+	for i, r := range s.rules {
+		env := scriptEnvFor(c)
+		matched, err := expr.Run(r.when, env)
+		if err != nil {
+			slog.Warn("script rule failed", "rule", i, "err", err)
+			continue
+		}
+		if !matched.(bool) {
+			continue
+		}
+		if r.drop {
+			return true
+		}
+		if r.severity != "" {
+			c.Severity = r.severity
+		}
+		if len(r.tags) > 0 {
+			c.Payload.Tags = withTags(c.Payload.Tags, r.tags)
+		}
+		for field, program := range r.set {
+			value, err := expr.Run(program, env)
+			if err != nil {
+				slog.Warn("script rule failed", "rule", i, "set", field, "err", err)
+				continue
+			}
+			setScriptField(c, field, value.(string))
+		}
+	}
+	return false
+}
+
+func scriptEnvFor(c *Candidate) scriptEnv {
+	p := c.Payload
+	env := scriptEnv{
+		ErrorLine:   p.ErrorLine,
+		Context:     p.Context,
+		Target:      p.Target,
+		RepoURL:     p.RepoURL,
+		Hostname:    p.Hostname,
+		Severity:    c.Severity,
+		Language:    p.Language,
+		Service:     p.Service,
+		Environment: p.Environment,
+		Version:     p.Version,
+		Tags:        p.Tags,
+	}
+	for _, f := range p.Frames {
+		env.Files = append(env.Files, f.File)
+	}
+	return env
+}
+
+func setScriptField(c *Candidate, field, value string) {
+	p := c.Payload
+	switch field {
+	case "error_line":
+		// Later steps such as dedupe look at the event
+		c.Event.Line = value
+		p.ErrorLine = value
+	case "target":
+		c.Event.Target = value
+		p.Target = value
+	case "repo_url":
+		c.Event.RepoURL = value
+		p.RepoURL = value
+	case "service":
+		p.Service = value
+	case "environment":
+		p.Environment = value
+	case "version":
+		p.Version = value
+	default:
+		p.Tags = withTags(p.Tags, map[string]string{strings.TrimPrefix(field, "tags."): value})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+// Sender delivers payloads through the client, falling back to the offline
+// queue (when enabled) for transient failures.
+type Sender struct {
+	client *Client
+	queue  *OfflineQueue
+	// fixes, when set, follows the incidents sent
+	fixes  *FixTracker
+	sent   atomic.Int64
+	failed atomic.Int64
+
+	mu       sync.Mutex
+	lastSend time.Time
+	lastErr  error
+	// lastID is the server's ID for the last incident it accepted
+	lastID int
+}
+
+type SenderStats struct {
+	Sent           int64
+	Failed         int64
+	LastSend       time.Time
+	LastError      error
+	LastIncidentID int
+}
+
+func (s *Sender) Stats() SenderStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SenderStats{
+		Sent:           s.sent.Load(),
+		Failed:         s.failed.Load(),
+		LastSend:       s.lastSend,
+		LastError:      s.lastErr,
+		LastIncidentID: s.lastID,
+	}
+}
+
+// record counts payloads as sent or failed. ids are what the server called
+// the sent ones, if it said.
+func (s *Sender) record(payloads []IncidentPayload, err error, ids ...int) {
+	if err != nil {
+		s.failed.Add(int64(len(payloads)))
+	} else {
+		s.sent.Add(int64(len(payloads)))
+		s.fixes.Track(payloads, ids)
+	}
+	s.mu.Lock()
+	s.lastSend = time.Now()
+	s.lastErr = err
+	if len(ids) > 0 && ids[len(ids)-1] != 0 {
+		s.lastID = ids[len(ids)-1]
+	}
+	s.mu.Unlock()
+}
+
+func NewSender(client *Client, queue *OfflineQueue) *Sender {
+	return &Sender{client: client, queue: queue}
+}
+
+// Delivery is what became of one incident handed to the sender.
+type Delivery struct {
+	// Outcome says whether it was sent, failed, or queued behind earlier
+	// incidents
+	Outcome string
+	// IncidentID is the server's ID for it, when sent
+	IncidentID int
+	Err        error
+}
+
+// Deliver sends payload.
+func (s *Sender) Deliver(ctx context.Context, payload IncidentPayload) Delivery {
+	// Keep delivery in order while a backlog is waiting
+	if s.queue != nil && s.queue.Len() > 0 {
+		s.enqueue(payload)
+		return Delivery{Outcome: "queued"}
+	}
+
+	id, err := s.client.SendPayload(ctx, payload)
+	s.record([]IncidentPayload{payload}, err, id)
+	if err != nil {
+		slog.Error("send failed", "err", err)
+		if ship.IsRetryable(err) && s.queue != nil {
+			s.enqueue(payload)
+			return Delivery{Outcome: "failed, queued", Err: err}
+		}
+		return Delivery{Outcome: "failed", Err: err}
+	}
+	if id != 0 {
+		slog.Info("incident sent", "incident_id", id, "line", ship.Truncate(payload.ErrorLine, 80))
+		return Delivery{Outcome: fmt.Sprintf("sent, incident #%d", id), IncidentID: id}
+	}
+	return Delivery{Outcome: "sent"}
+}
+
+func (s *Sender) DeliverBatch(ctx context.Context, payloads []IncidentPayload) {
+	if len(payloads) == 0 {
+		return
+	}
+	if s.queue != nil && s.queue.Len() > 0 {
+		s.enqueue(payloads...)
+		return
+	}
+
+	ids, err := s.client.SendBatch(ctx, payloads)
+	s.record(payloads, err, ids...)
+	if err != nil {
+		slog.Error("batch send failed", "incidents", len(payloads), "err", err)
+		if ship.IsRetryable(err) {
+			s.enqueue(payloads...)
+		}
+		return
+	}
+	if len(ids) > 0 {
+		slog.Info("batch sent", "incident_ids", fmt.Sprint(ids))
+	}
+}
+
+func (s *Sender) enqueue(payloads ...IncidentPayload) {
+	if s.queue == nil {
+		return
+	}
+	for _, payload := range payloads {
+		if err := s.queue.Push(payload); err != nil {
+			slog.Error("queue incident failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+// Beyond this many distinct host/app pairs, messages share one stream so a
+// noisy network cannot make us spawn unbounded watchers.
+const maxSenderStreams = 256
+
+type senderStream struct {
+	watcher *Watcher
+	pipe    *io.PipeWriter
+}
+
+// senderStreams feeds the messages a listener receives through a stream
+// watcher per sending host/app pair, so traces from different senders are
+// grouped separately. Listeners embed it and call start before writing.
+type senderStreams struct {
+	// scheme names the streams' log paths, e.g. "syslog" for
+	// syslog://host/app
+	scheme string
+	// defaults is what every stream's target starts from: the repo_url
+	// and the limits on its traces
+	defaults Target
+	// label is used instead of the app's name when set
+	label string
+
+	mu       sync.Mutex
+	detector *Detector
+	streams  map[string]*senderStream
+	wg       sync.WaitGroup
+	events   chan<- LogEvent
+	ctx      context.Context
+	// guard restarts sender streams that panic
+	guard *panicGuard
+}
+
+func newSenderStreams(scheme, label string, defaults Target, detector *Detector) senderStreams {
+	return senderStreams{
+		scheme:   scheme,
+		defaults: defaults,
+		label:    label,
+		detector: detector,
+		streams:  make(map[string]*senderStream),
+	}
+}
+
+func (s *senderStreams) start(ctx context.Context, events chan<- LogEvent) {
+	s.events = events
+	s.ctx = ctx
+}
+
+// stop ends every stream once the listeners have stopped writing, and waits
+// for their watchers to deliver what they read.
+func (s *senderStreams) stop() {
+	s.mu.Lock()
+	for _, stream := range s.streams {
+		stream.pipe.CloseWithError(io.EOF)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Watchers returns a watcher per sender seen so far.
+func (s *senderStreams) Watchers() []*Watcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watchers := make([]*Watcher, 0, len(s.streams))
+	for _, stream := range s.streams {
+		watchers = append(watchers, stream.watcher)
+	}
+	return watchers
+}
+
+// SetDetector switches every sender, including ones seen later, to detector.
+func (s *senderStreams) SetDetector(detector *Detector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detector = detector
+	for _, stream := range s.streams {
+		stream.watcher.SetDetector(detector)
+	}
+}
+
+// write appends message as a line of the stream of host and app.
+func (s *senderStreams) write(host, app, message string) {
+	stream := s.stream(host, app)
+	// The pipe is closed on shutdown, which is the only time this fails
+	stream.pipe.Write([]byte(strings.TrimRight(message, "\n") + "\n"))
+}
+
+func (s *senderStreams) stream(host, app string) *senderStream {
+	key := host + "/" + app
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stream, ok := s.streams[key]; ok {
+		return stream
+	}
+	if len(s.streams) >= maxSenderStreams {
+		key, host, app = "*/*", "*", "*"
+		if stream, ok := s.streams[key]; ok {
+			return stream
+		}
+	}
+
+	label := s.label
+	if label == "" {
+		label = app
+	}
+	target := s.defaults
+	target.LogPath = s.scheme + "://" + key
+	target.Label = label
+
+	pr, pw := io.Pipe()
+	stream := &senderStream{
+		watcher: watch.NewStreamWatcher(target, s.detector, pr),
+		pipe:    pw,
+	}
+	s.streams[key] = stream
+	slog.Info("new "+s.scheme+" sender", "host", host, "app", app)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := stream.watcher.WatchGuarded(s.ctx, s.guard, s.events); err != nil {
+			slog.Error(s.scheme+" stream stopped", "source", key, "err", err)
+		}
+		// Unblock a sender still writing to a stream nobody reads any more
+		pr.Close()
+	}()
+	return stream
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+type SentryConfig struct {
+	DSN         string `json:"dsn"`
+	MinSeverity string `json:"min_severity,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+func (c *SentryConfig) Validate() error {
+	if c.DSN == "" {
+		return errors.New("dsn is required")
+	}
+	if _, _, err := parseSentryDSN(c.DSN); err != nil {
+		return err
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	return nil
+}
+
+// parseSentryDSN turns https://<key>@<host>/<project> into the project's
+// envelope endpoint and public key.
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("dsn: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return "", "", errors.New("dsn must look like https://<key>@<host>/<project>")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return "", "", errors.New("dsn is missing the project ID")
+	}
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project)
+	return endpoint, u.User.Username(), nil
+}
+
+// sentryLevel maps incident severities onto Sentry levels.
+var sentryLevel = map[string]string{
+	SeverityLow:      "info",
+	SeverityMedium:   "warning",
+	SeverityHigh:     "error",
+	SeverityCritical: "fatal",
+}
+
+// sentryPlatform maps the trace languages ParseStackTrace detects onto
+// Sentry platforms.
+var sentryPlatform = map[string]string{
+	"python": "python",
+	"java":   "java",
+	"node":   "node",
+	"go":     "go",
+	"rust":   "native",
+	"dotnet": "csharp",
+}
+
+// SentrySink reports incidents to a Sentry project as events with an
+// exception and its stack frames, so they can be compared with what the
+// Sentry SDKs report.
+type SentrySink struct {
+	dsn         string
+	endpoint    string
+	key         string
+	environment string
+	httpClient  *http.Client
+}
+
+func NewSentrySink(cfg *SentryConfig) (*SentrySink, error) {
+	endpoint, key, err := parseSentryDSN(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("dsn: %w", err)
+	}
+	return &SentrySink{
+		dsn:         cfg.DSN,
+		endpoint:    endpoint,
+		key:         key,
+		environment: cfg.Environment,
+		httpClient:  &http.Client{},
+	}, nil
+}
+
+func (s *SentrySink) Name() string {
+	return "sentry"
+}
+
+func (s *SentrySink) Send(ctx context.Context, payload IncidentPayload) error {
+	event := s.event(payload)
+
+	// An envelope is a header line followed by items, each a header line
+	// and a payload line.
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]string{
+		"event_id": event["event_id"].(string),
+		"dsn":      s.dsn,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	enc.Encode(map[string]string{"type": "event"})
+	if err := enc.Encode(event); err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=lacia/1.0", s.key)
+	return postBody(ctx, s.httpClient, http.MethodPost, s.endpoint, "application/x-sentry-envelope",
+		body.Bytes(), map[string]string{"X-Sentry-Auth": auth})
+}
+
+func (s *SentrySink) event(p IncidentPayload) map[string]any {
+	exceptionType, value := "Error", p.ErrorLine
+	for _, line := range append([]string{p.ErrorLine}, p.Context...) {
+		if typ, message, ok := detect.SplitException(line); ok {
+			exceptionType, value = typ, message
+			break
+		}
+	}
+
+	exception := map[string]any{"type": exceptionType, "value": value}
+	if len(p.Frames) > 0 {
+		// Sentry lists frames oldest first, the reverse of Frames
+		frames := make([]map[string]any, len(p.Frames))
+		for i, f := range p.Frames {
+			frames[len(frames)-1-i] = map[string]any{
+				"filename": f.File,
+				"lineno":   f.Line,
+				"function": f.Function,
+				"in_app":   true,
+			}
+		}
+		exception["stacktrace"] = map[string]any{"frames": frames}
+	}
+
+	// Hostname and severity win over configured tags of the same name
+	tags := maps.Clone(p.Tags)
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	tags["hostname"] = p.Hostname
+	tags["severity"] = p.Severity
+	if p.RepoURL != "" {
+		tags["repo_url"] = p.RepoURL
+	}
+	if p.Target != "" {
+		tags["target"] = p.Target
+	}
+	if p.Service != "" {
+		tags["service"] = p.Service
+	}
+
+	platform := sentryPlatform[p.Language]
+	if platform == "" {
+		platform = "other"
+	}
+	level := sentryLevel[p.Severity]
+	if level == "" {
+		level = "error"
+	}
+
+	extra := map[string]any{"context": p.Context}
+	if p.OccurrenceCount > 1 {
+		extra["occurrence_count"] = p.OccurrenceCount
+	}
+
+	event := map[string]any{
+		"event_id":    newEventID(),
+		"timestamp":   p.Timestamp,
+		"platform":    platform,
+		"level":       level,
+		"logger":      "lacia",
+		"server_name": p.Hostname,
+		"exception":   map[string]any{"values": []any{exception}},
+		"tags":        tags,
+		"extra":       extra,
+	}
+	if env := cmp.Or(s.environment, p.Environment); env != "" {
+		event["environment"] = env
+	}
+	if p.Version != "" {
+		event["release"] = p.Version
+	} else if p.Git != nil && p.Git.Commit != "" {
+		event["release"] = p.Git.Commit
+	}
+	return event
+}
+
+// newEventID returns a random 32-digit hex ID as Sentry expects.
+func newEventID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+type windowsService struct {
+	cfg *Config
+}
+
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+func runWindowsService(cfg *Config) error {
+	return svc.Run(serviceName, &windowsService{cfg: cfg})
+}
+
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	finished := make(chan struct{})
+	go func() {
+		run(ctx, s.cfg, runOptions{})
+		close(finished)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stop()
+				<-finished
+				return false, 0
+			}
+		case <-finished:
+			return false, 1
+		}
+	}
+}
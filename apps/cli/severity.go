@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+// Severity levels, lowest first.
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{
+	SeverityLow:      0,
+	SeverityMedium:   1,
+	SeverityHigh:     2,
+	SeverityCritical: 3,
+}
+
+var (
+	builtinCritical = regexp.MustCompile(`(?i)\b(fatal|panic|panicked|critical|emergency|emerg|alert)\b|segmentation fault|core dumped|SIGSEGV|SIGABRT|OutOfMemoryError|\bOOM\b|StackOverflowError`)
+	builtinHigh     = regexp.MustCompile(`(?i)\berror\b|exception|traceback|uncaught|unhandled|\b5\d\d (internal server error|bad gateway|service unavailable|gateway timeout)`)
+	builtinWarning  = regexp.MustCompile(`(?i)\bwarn(ing)?\b`)
+)
+
+type SeverityConfig struct {
+	Min            string         `json:"min,omitempty"`
+	Rules          []SeverityRule `json:"rules,omitempty"`
+	WarnRepeat     int            `json:"warn_repeat,omitempty"`
+	WarnWindow     Duration       `json:"warn_window,omitempty"`
+	DisableBuiltin bool           `json:"disable_builtin,omitempty"`
+}
+
+// SeverityRule assigns a severity to incidents whose error line or context
+// matches Pattern. Rules are checked in order before the built-in ones.
+type SeverityRule struct {
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+}
+
+func (c *SeverityConfig) Validate() error {
+	if c.Min != "" {
+		if _, ok := severityRank[c.Min]; !ok {
+			return fmt.Errorf("min must be one of low, medium, high, critical: %q", c.Min)
+		}
+	}
+	for i, r := range c.Rules {
+		if _, ok := severityRank[r.Severity]; !ok {
+			return fmt.Errorf("rules[%d]: severity must be one of low, medium, high, critical: %q", i, r.Severity)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+	}
+	if c.WarnRepeat < 0 || c.WarnWindow < 0 {
+		return errors.New("warn_repeat and warn_window must be positive")
+	}
+	return nil
+}
+
+type severityRule struct {
+	pattern  *regexp.Regexp
+	severity string
+}
+
+// Classifier scores incidents. Warnings start out low and become medium
+// once the same warning repeats warn_repeat times within warn_window.
+type Classifier struct {
+	rules      []severityRule
+	builtin    bool
+	min        int
+	warnRepeat int
+	warnWindow time.Duration
+
+	mu       sync.Mutex
+	warnings map[string][]time.Time
+}
+
+func NewClassifier(cfg *SeverityConfig) (*Classifier, error) {
+	c := &Classifier{
+		builtin:    true,
+		warnRepeat: 5,
+		warnWindow: 5 * time.Minute,
+		warnings:   make(map[string][]time.Time),
+	}
+	if cfg == nil {
+		return c, nil
+	}
+
+	c.builtin = !cfg.DisableBuiltin
+	c.min = severityRank[cfg.Min]
+	if cfg.WarnRepeat > 0 {
+		c.warnRepeat = cfg.WarnRepeat
+	}
+	if cfg.WarnWindow > 0 {
+		c.warnWindow = time.Duration(cfg.WarnWindow)
+	}
+	for i, r := range cfg.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("severity: rules[%d]: %w", i, err)
+		}
+		c.rules = append(c.rules, severityRule{re, r.Severity})
+	}
+	return c, nil
+}
+
+// Classify returns the event's severity and whether it meets the minimum
+// severity to be sent.
+func (c *Classifier) Classify(event LogEvent) (string, bool) {
+	severity := c.classify(event)
+	return severity, severityRank[severity] >= c.min
+}
+
+func (c *Classifier) classify(event LogEvent) string {
+	text := event.Line
+	if len(event.Context) > 0 {
+		text = strings.Join(event.Context, "\n")
+	}
+
+	for _, r := range c.rules {
+		if r.pattern.MatchString(text) {
+			return r.severity
+		}
+	}
+	if !c.builtin {
+		return SeverityHigh
+	}
+
+	switch {
+	case builtinCritical.MatchString(text):
+		return SeverityCritical
+	case builtinHigh.MatchString(text):
+		return SeverityHigh
+	case builtinWarning.MatchString(text):
+		if c.repeatedWarning(event, event.Timestamp) {
+			return SeverityMedium
+		}
+		return SeverityLow
+	}
+	return SeverityLow
+}
+
+func (c *Classifier) repeatedWarning(event LogEvent, now time.Time) bool {
+	key := detect.Fingerprint(event, detect.FingerprintNormalized)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := now.Add(-c.warnWindow)
+	seen := c.warnings[key][:0]
+	for _, t := range c.warnings[key] {
+		if t.After(cutoff) {
+			seen = append(seen, t)
+		}
+	}
+	seen = append(seen, now)
+
+	// Forget warnings that have gone quiet so the map does not grow forever
+	for k, times := range c.warnings {
+		if k != key && !times[len(times)-1].After(cutoff) {
+			delete(c.warnings, k)
+		}
+	}
+	c.warnings[key] = seen
+	return len(seen) >= c.warnRepeat
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/detect"
+)
+
+const (
+	// sinkQueueSize bounds the incidents waiting for each sink, so a slow or
+	// unreachable sink never holds up the server or the other sinks.
+	sinkQueueSize = 100
+	sinkTimeout   = 10 * time.Second
+)
+
+// Sink is a destination notified of incidents besides the Lacia server, such
+// as a chat or paging service.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, payload IncidentPayload) error
+}
+
+type sinkWorker struct {
+	sink  Sink
+	min   int
+	queue chan IncidentPayload
+}
+
+// Sinks hands each incident to every sink whose minimum severity it meets.
+// Each sink is served by its own goroutine; incidents for a sink that falls
+// behind are dropped.
+type Sinks struct {
+	workers []*sinkWorker
+	wg      sync.WaitGroup
+	// routes, when configured, pick the sinks each incident goes to
+	routes      []sinkRoute
+	fingerprint string
+	// guard keeps a sink that panics on one incident sending the rest
+	guard *panicGuard
+}
+
+// sinkSpec is a sink enabled in the config, named as routes refer to it
+// and built only when the sinks start.
+type sinkSpec struct {
+	name       string
+	min        string
+	defaultMin string
+	build      func() (Sink, error)
+}
+
+// sinkSpecs lists the sinks enabled in cfg without building them, so the
+// config can be checked without logging or opening anything.
+func sinkSpecs(cfg *Config, fingerprint string) []sinkSpec {
+	var specs []sinkSpec
+	if cfg.Slack != nil && cfg.Slack.WebhookURL != "" {
+		specs = append(specs, sinkSpec{"slack", cfg.Slack.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewSlackSink(cfg.Slack, cfg.ServerURL), nil
+		}})
+	}
+	if cfg.PagerDuty != nil && cfg.PagerDuty.RoutingKey != "" {
+		specs = append(specs, sinkSpec{"pagerduty", cfg.PagerDuty.MinSeverity, SeverityCritical, func() (Sink, error) {
+			return NewPagerDutySink(cfg.PagerDuty, cfg.ServerURL, fingerprint), nil
+		}})
+	}
+	if cfg.Sentry != nil && cfg.Sentry.DSN != "" {
+		specs = append(specs, sinkSpec{"sentry", cfg.Sentry.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewSentrySink(cfg.Sentry)
+		}})
+	}
+	if cfg.OTLP != nil && cfg.OTLP.Endpoint != "" {
+		specs = append(specs, sinkSpec{"otlp", cfg.OTLP.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewOTLPSink(cfg.OTLP)
+		}})
+	}
+	if cfg.Fluent != nil && cfg.Fluent.Addr != "" {
+		specs = append(specs, sinkSpec{"fluent", cfg.Fluent.MinSeverity, SeverityLow, func() (Sink, error) {
+			return NewFluentSink(cfg.Fluent), nil
+		}})
+	}
+	if cfg.Datadog != nil && cfg.Datadog.APIKey != "" {
+		specs = append(specs, sinkSpec{"datadog", cfg.Datadog.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewDatadogSink(cfg.Datadog, fingerprint), nil
+		}})
+	}
+	if cfg.Email != nil && len(cfg.Email.To) > 0 {
+		specs = append(specs, sinkSpec{"email", cfg.Email.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewEmailSink(cfg.Email)
+		}})
+	}
+	if cfg.Archive != nil && cfg.Archive.Enabled {
+		specs = append(specs, sinkSpec{"archive", cfg.Archive.MinSeverity, SeverityLow, func() (Sink, error) {
+			return NewArchiveSink(cfg.Archive), nil
+		}})
+	}
+	for i := range cfg.Webhooks {
+		webhook := &cfg.Webhooks[i]
+		specs = append(specs, sinkSpec{"webhook " + webhook.name(), webhook.MinSeverity, SeverityHigh, func() (Sink, error) {
+			return NewWebhookSink(webhook)
+		}})
+	}
+	return specs
+}
+
+// NewSinks builds the sinks enabled in cfg. A sink that cannot be built is
+// left out and logged; only invalid routes fail.
+func NewSinks(cfg *Config) (*Sinks, error) {
+	var fingerprint string
+	if cfg.Dedupe != nil {
+		fingerprint = cfg.Dedupe.Fingerprint
+	}
+	routes, err := newSinkRoutes(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sinks{routes: routes, fingerprint: fingerprint}
+	for _, spec := range sinkSpecs(cfg, fingerprint) {
+		sink, err := spec.build()
+		if err != nil {
+			slog.Error("sink disabled", "sink", spec.name, "err", err)
+			continue
+		}
+		s.add(sink, spec.min, spec.defaultMin)
+	}
+	return s, nil
+}
+
+func (s *Sinks) add(sink Sink, min, defaultMin string) {
+	if min == "" {
+		min = defaultMin
+	}
+	s.workers = append(s.workers, &sinkWorker{
+		sink:  sink,
+		min:   severityRank[min],
+		queue: make(chan IncidentPayload, sinkQueueSize),
+	})
+}
+
+// Run delivers queued incidents until Close is called. Sends are made with
+// ctx, which should outlive shutdown so queued incidents still go out.
+func (s *Sinks) Run(ctx context.Context) {
+	for _, w := range s.workers {
+		s.wg.Add(1)
+		go func(w *sinkWorker) {
+			defer s.wg.Done()
+			for payload := range w.queue {
+				sendCtx, cancel := context.WithTimeout(ctx, sinkTimeout)
+				s.guard.call("sink "+w.sink.Name(), func() {
+					if err := w.sink.Send(sendCtx, payload); err != nil {
+						slog.Error("sink send failed", "sink", w.sink.Name(), "err", err)
+					}
+				})
+				cancel()
+			}
+		}(w)
+	}
+}
+
+// targets returns the sinks payload is severe enough for and, with routes,
+// that the first matching route sends it to. Without a matching route it
+// goes nowhere.
+func (s *Sinks) targets(payload IncidentPayload) []*sinkWorker {
+	var routed []string
+	if len(s.routes) > 0 {
+		fingerprint := func() string {
+			return detect.Fingerprint(LogEvent{Line: payload.ErrorLine, Context: payload.Context}, s.fingerprint)
+		}
+		i := slices.IndexFunc(s.routes, func(r sinkRoute) bool { return r.match(payload, fingerprint) })
+		if i < 0 {
+			return nil
+		}
+		routed = s.routes[i].sinks
+	}
+
+	var workers []*sinkWorker
+	for _, w := range s.workers {
+		if severityRank[payload.Severity] < w.min {
+			continue
+		}
+		if len(s.routes) > 0 && !slices.Contains(routed, w.sink.Name()) {
+			continue
+		}
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+// Notify queues payload for every sink it is meant for. It never blocks.
+func (s *Sinks) Notify(payload IncidentPayload) {
+	for _, w := range s.targets(payload) {
+		select {
+		case w.queue <- payload:
+		default:
+			slog.Warn("sink is falling behind, dropping incident", "sink", w.sink.Name())
+		}
+	}
+}
+
+// NotifyWait is Notify for bulk sends, such as a replay: it waits for room
+// in a sink's queue, until ctx is done, rather than dropping the incident.
+func (s *Sinks) NotifyWait(ctx context.Context, payload IncidentPayload) {
+	for _, w := range s.targets(payload) {
+		select {
+		case w.queue <- payload:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close waits for queued incidents to be delivered, then closes the sinks
+// that hold resources such as open files.
+func (s *Sinks) Close() {
+	for _, w := range s.workers {
+		close(w.queue)
+	}
+	s.wg.Wait()
+	for _, w := range s.workers {
+		if closer, ok := w.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Error("close sink failed", "sink", w.sink.Name(), "err", err)
+			}
+		}
+	}
+}
+
+// postJSON posts body as JSON and fails on a non-2xx reply.
+func postJSON(ctx context.Context, client *http.Client, url string, body any, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	return postBody(ctx, client, http.MethodPost, url, "application/json", data, headers)
+}
+
+// postBody sends data and fails on a non-2xx reply.
+func postBody(ctx context.Context, client *http.Client, method, url, contentType string, data []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+const (
+	defaultSlackMaxLines = 20
+	// Slack rejects text blocks over 3000 characters; leave room for
+	// escaping
+	slackMaxBlockText = 2500
+)
+
+type SlackConfig struct {
+	WebhookURL  string `json:"webhook_url"`
+	MinSeverity string `json:"min_severity,omitempty"`
+	// DashboardURL is linked from each message; it defaults to the Lacia
+	// server that server_url points at.
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	MaxLines     int    `json:"max_lines,omitempty"`
+}
+
+func (c *SlackConfig) Validate() error {
+	if c.WebhookURL == "" {
+		return errors.New("webhook_url is required")
+	}
+	if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return errors.New("webhook_url must be an http or https URL")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	if c.MaxLines < 0 {
+		return errors.New("max_lines must be positive")
+	}
+	return nil
+}
+
+// SlackSink posts incidents to a Slack incoming webhook.
+type SlackSink struct {
+	webhookURL   string
+	dashboardURL string
+	maxLines     int
+	httpClient   *http.Client
+}
+
+func NewSlackSink(cfg *SlackConfig, serverURL string) *SlackSink {
+	s := &SlackSink{
+		webhookURL:   cfg.WebhookURL,
+		dashboardURL: cfg.DashboardURL,
+		maxLines:     cfg.MaxLines,
+		httpClient:   &http.Client{},
+	}
+	if s.dashboardURL == "" {
+		s.dashboardURL = strings.TrimSuffix(strings.TrimSuffix(serverURL, "/"), "/api/webhook")
+	}
+	if s.maxLines == 0 {
+		s.maxLines = defaultSlackMaxLines
+	}
+	return s
+}
+
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+func (s *SlackSink) Send(ctx context.Context, payload IncidentPayload) error {
+	return postJSON(ctx, s.httpClient, s.webhookURL, s.message(payload), nil)
+}
+
+func (s *SlackSink) message(p IncidentPayload) map[string]any {
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(p.Severity), p.ErrorLine)
+
+	fields := []string{"*Host:* " + slackEscape(p.Hostname)}
+	if p.Target != "" {
+		fields = append(fields, "*Target:* "+slackEscape(p.Target))
+	}
+	fields = append(fields, "*Time:* "+p.Timestamp)
+	if p.OccurrenceCount > 1 {
+		fields = append(fields, fmt.Sprintf("*Occurrences:* %d", p.OccurrenceCount))
+	}
+
+	blocks := []map[string]any{
+		slackSection(":rotating_light: *" + slackEscape(ship.Truncate(title, 250)) + "*"),
+		slackSection(strings.Join(fields, "   ")),
+	}
+
+	trace := p.Context
+	if len(trace) > s.maxLines {
+		trace = trace[:s.maxLines]
+	}
+	if len(trace) > 0 {
+		text := ship.Truncate(strings.Join(trace, "\n"), slackMaxBlockText)
+		if len(trace) < len(p.Context) {
+			text += fmt.Sprintf("\n… %d more lines", len(p.Context)-len(trace))
+		}
+		blocks = append(blocks, slackSection("```"+slackEscape(text)+"```"))
+	}
+	if s.dashboardURL != "" {
+		blocks = append(blocks, slackSection("<"+s.dashboardURL+"|Open the Lacia dashboard>"))
+	}
+
+	return map[string]any{
+		// Shown in notifications, which do not render blocks
+		"text":   title,
+		"blocks": blocks,
+	}
+}
+
+func slackSection(text string) map[string]any {
+	return map[string]any{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": text},
+	}
+}
+
+// slackEscape escapes the characters Slack treats as markup in mrkdwn.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultSpoolSize = 1000
+
+	SpoolDropOldest = "drop_oldest"
+	SpoolDropNewest = "drop_newest"
+)
+
+type SpoolConfig struct {
+	Size   int    `json:"size,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
+func (c *SpoolConfig) Validate() error {
+	if c.Size < 0 {
+		return errors.New("size must be positive")
+	}
+	switch c.Policy {
+	case "", SpoolDropOldest, SpoolDropNewest:
+	default:
+		return errors.New("policy must be drop_oldest or drop_newest")
+	}
+	return nil
+}
+
+// Spool is a bounded ring buffer between the event sources and the sender.
+// Push never blocks, so a stalled server cannot hold up log reading; when
+// the spool is full an event is dropped according to the policy.
+type Spool struct {
+	mu         sync.Mutex
+	ready      *sync.Cond
+	buf        []LogEvent
+	head       int
+	size       int
+	dropOldest bool
+	closed     bool
+	dropped    atomic.Int64
+	// maxBytes, when set, also bounds the spool by the size of its events
+	maxBytes int
+	bytes    int
+}
+
+func NewSpool(cfg *SpoolConfig) *Spool {
+	size, policy := defaultSpoolSize, SpoolDropOldest
+	if cfg != nil {
+		if cfg.Size > 0 {
+			size = cfg.Size
+		}
+		if cfg.Policy != "" {
+			policy = cfg.Policy
+		}
+	}
+
+	s := &Spool{
+		buf:        make([]LogEvent, size),
+		dropOldest: policy == SpoolDropOldest,
+	}
+	s.ready = sync.NewCond(&s.mu)
+	return s
+}
+
+// Fill reads from in until it is closed, then closes the spool.
+func (s *Spool) Fill(in <-chan LogEvent) {
+	for event := range in {
+		s.Push(event)
+	}
+	s.Close()
+}
+
+func (s *Spool) Push(event LogEvent) {
+	size := eventSize(event)
+	s.mu.Lock()
+	if s.full(size) && !s.dropOldest {
+		s.mu.Unlock()
+		s.drop()
+		return
+	}
+	var dropped int
+	for s.size > 0 && s.full(size) {
+		s.bytes -= eventSize(s.buf[s.head])
+		s.buf[s.head] = LogEvent{}
+		s.head = (s.head + 1) % len(s.buf)
+		s.size--
+		dropped++
+	}
+	s.buf[(s.head+s.size)%len(s.buf)] = event
+	s.size++
+	s.bytes += size
+	s.mu.Unlock()
+
+	s.ready.Signal()
+	for range dropped {
+		s.drop()
+	}
+}
+
+// full reports whether an event of size bytes does not fit.
+func (s *Spool) full(size int) bool {
+	return s.size == len(s.buf) || (s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+}
+
+func (s *Spool) drop() {
+	// Log the first drop and then every hundredth, not every event of a flood
+	if n := s.dropped.Add(1); n == 1 || n%100 == 0 {
+		slog.Warn("event spool full, dropping events", "dropped", n, "capacity", len(s.buf))
+	}
+}
+
+// Pop blocks until an event is available. It returns false once the spool
+// is closed and empty.
+func (s *Spool) Pop() (LogEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.size == 0 && !s.closed {
+		s.ready.Wait()
+	}
+	if s.size == 0 {
+		return LogEvent{}, false
+	}
+
+	event := s.buf[s.head]
+	s.buf[s.head] = LogEvent{}
+	s.head = (s.head + 1) % len(s.buf)
+	s.size--
+	s.bytes -= eventSize(event)
+	return event, true
+}
+
+func (s *Spool) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.ready.Broadcast()
+}
+
+// Len returns the number of events waiting to be sent.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Dropped returns how many events have been dropped because the spool was
+// full.
+func (s *Spool) Dropped() int64 {
+	return s.dropped.Load()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+type StatusReport struct {
+	PID        int            `json:"pid"`
+	StartedAt  time.Time      `json:"started_at"`
+	Server     string         `json:"server"`
+	DryRun     bool           `json:"dry_run,omitempty"`
+	Targets    []TargetStatus `json:"targets"`
+	Sent       int64          `json:"sent"`
+	Failed     int64          `json:"failed"`
+	Duplicates int64          `json:"duplicates"`
+	Ignored    int64          `json:"ignored"`
+	Muted      int64          `json:"muted"`
+	MutedUntil *time.Time     `json:"muted_until,omitempty"`
+	MuteReason string         `json:"mute_reason,omitempty"`
+	Spooled    int            `json:"spooled"`
+	Dropped    int64          `json:"dropped"`
+	Queued     int            `json:"queued"`
+	Panics     int64          `json:"panics,omitempty"`
+	LastSendAt *time.Time     `json:"last_send_at,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+	// LastIncidentID is the server's ID for the last incident it accepted
+	LastIncidentID int `json:"last_incident_id,omitempty"`
+	// ThrottledUntil is when the server said sending may resume
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+	// Fixes are the latest incidents sent and what the server made of them,
+	// newest first, when fix_status is enabled
+	Fixes []IncidentFix `json:"fixes,omitempty"`
+}
+
+type TargetStatus struct {
+	LogPath string `json:"log_path"`
+	Label   string `json:"label,omitempty"`
+	Lines   int64  `json:"lines"`
+	Errors  int64  `json:"errors"`
+	// State is running or restarting, for watched files
+	State     string `json:"state,omitempty"`
+	Restarts  int    `json:"restarts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (r *StatusReport) Print() {
+	fmt.Printf("Running:    pid %d, up %v\n", r.PID, time.Since(r.StartedAt).Round(time.Second))
+	fmt.Printf("Server:     %s\n", r.Server)
+	if r.DryRun {
+		fmt.Println("Dry run:    incidents are printed, not sent")
+	}
+	for _, t := range r.Targets {
+		name := t.LogPath
+		if t.Label != "" {
+			name += " (" + t.Label + ")"
+		}
+		fmt.Printf("Watching:   %s — %d lines, %d errors\n", name, t.Lines, t.Errors)
+		if t.State == watch.StateRestarting {
+			fmt.Printf("            restarting after: %s\n", t.LastError)
+		} else if t.Restarts > 0 {
+			fmt.Printf("            restarted %d times, last after: %s\n", t.Restarts, t.LastError)
+		}
+	}
+	fmt.Printf("Incidents:  %d sent, %d failed, %d duplicates skipped, %d ignored, %d muted, %d queued\n",
+		r.Sent, r.Failed, r.Duplicates, r.Ignored, r.Muted, r.Queued)
+	if r.MutedUntil != nil {
+		until := "Muted:      until " + r.MutedUntil.Local().Format(time.RFC3339)
+		if r.MuteReason != "" {
+			until += " (" + r.MuteReason + ")"
+		}
+		fmt.Println(until)
+	}
+	fmt.Printf("Events:     %d waiting, %d dropped (spool full)\n", r.Spooled, r.Dropped)
+	if r.Panics > 0 {
+		fmt.Printf("Panics:     %d recovered\n", r.Panics)
+	}
+	if r.LastSendAt != nil {
+		last := r.LastSendAt.Format(time.RFC3339)
+		if r.LastIncidentID != 0 {
+			last += fmt.Sprintf(" (incident #%d)", r.LastIncidentID)
+		}
+		fmt.Printf("Last send:  %s\n", last)
+	}
+	if r.ThrottledUntil != nil {
+		fmt.Printf("Throttled:  by the server until %s\n", r.ThrottledUntil.Local().Format(time.RFC3339))
+	}
+	if r.LastError != "" {
+		fmt.Printf("Last error: %s\n", r.LastError)
+	}
+	for i, f := range r.Fixes {
+		label := "            "
+		if i == 0 {
+			label = "Fixes:      "
+		}
+		ref := fmt.Sprintf("#%d", f.ID)
+		if f.ID == 0 {
+			ref = f.Fingerprint
+		}
+		fmt.Printf("%s%s %s — %s\n", label, ref, f.SentAt.Local().Format(time.RFC3339), f.Describe())
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSyslogAddr = ":514"
+	maxSyslogMessage  = 64 * 1024
+)
+
+type SyslogConfig struct {
+	Enabled bool   `json:"enabled"`
+	UDP     string `json:"udp,omitempty"`
+	TCP     string `json:"tcp,omitempty"`
+	RepoURL string `json:"repo_url,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+func (c *SyslogConfig) Validate() error {
+	for _, addr := range []string{c.UDP, c.TCP} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+type syslogMessage struct {
+	Priority  int
+	Timestamp time.Time
+	Hostname  string
+	App       string
+	Message   string
+}
+
+func (m syslogMessage) Severity() int {
+	return m.Priority & 7
+}
+
+var errNotSyslog = errors.New("not a syslog message")
+
+// parseSyslog accepts RFC 5424 and the BSD format of RFC 3164. Messages
+// without a valid priority are rejected.
+func parseSyslog(raw string) (syslogMessage, error) {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+	if !strings.HasPrefix(raw, "<") {
+		return syslogMessage{}, errNotSyslog
+	}
+	end := strings.IndexByte(raw, '>')
+	if end < 2 || end > 4 {
+		return syslogMessage{}, errNotSyslog
+	}
+	// Atoi would also take a sign
+	digits := raw[1:end]
+	if strings.TrimLeft(digits, "0123456789") != "" {
+		return syslogMessage{}, errNotSyslog
+	}
+	pri, err := strconv.Atoi(digits)
+	if err != nil || pri > 191 {
+		return syslogMessage{}, errNotSyslog
+	}
+	rest := raw[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(pri, rest[2:]), nil
+	}
+	return parseRFC3164(pri, rest), nil
+}
+
+func parseRFC5424(pri int, rest string) syslogMessage {
+	msg := syslogMessage{Priority: pri}
+	// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		msg.Message = rest
+		return msg
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		msg.Timestamp = ts
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.App = nilValue(fields[2])
+	msg.Message = strings.TrimPrefix(strings.TrimPrefix(skipStructuredData(fields[5]), " "), "\ufeff")
+	return msg
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// skipStructuredData drops "-" or one or more [id k="v"] elements, which may
+// contain escaped brackets and spaces.
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return s[1:]
+	}
+	if !strings.HasPrefix(s, "[") {
+		return s
+	}
+	inQuote, escaped := false, false
+	depth := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+			if depth == 0 && (i+1 == len(s) || s[i+1] != '[') {
+				return s[i+1:]
+			}
+		}
+	}
+	return s
+}
+
+func parseRFC3164(pri int, rest string) syslogMessage {
+	msg := syslogMessage{Priority: pri, Message: rest}
+
+	// "Jan  2 15:04:05 host tag[pid]: message"
+	if len(rest) >= 16 && rest[15] == ' ' {
+		if ts, err := time.ParseInLocation(time.Stamp, rest[:15], time.Local); err == nil {
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// A December message received in January belongs to last year
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = ts
+			rest = rest[16:]
+			if host, after, ok := strings.Cut(rest, " "); ok {
+				msg.Hostname = host
+				rest = after
+			}
+		}
+	}
+
+	if tag, after, ok := strings.Cut(rest, ": "); ok && !strings.ContainsAny(tag, " ") {
+		msg.App, _, _ = strings.Cut(tag, "[")
+		rest = after
+	}
+	msg.Message = rest
+	return msg
+}
+
+// SyslogServer receives syslog over UDP and/or TCP and feeds each host/app
+// pair through its own stream watcher, so traces from different senders are
+// grouped separately.
+type SyslogServer struct {
+	senderStreams
+	cfg SyslogConfig
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+func NewSyslogServer(cfg *SyslogConfig, defaults Target, detector *Detector) (*SyslogServer, error) {
+	s := &SyslogServer{cfg: *cfg}
+	if s.cfg.RepoURL == "" {
+		s.cfg.RepoURL = defaults.RepoURL
+	}
+	defaults.RepoURL = s.cfg.RepoURL
+	s.senderStreams = newSenderStreams("syslog", s.cfg.Label, defaults, detector)
+	if s.cfg.UDP == "" && s.cfg.TCP == "" {
+		s.cfg.UDP = defaultSyslogAddr
+	}
+
+	var err error
+	if s.cfg.UDP != "" {
+		if s.udp, err = net.ListenPacket("udp", s.cfg.UDP); err != nil {
+			return nil, fmt.Errorf("listen udp failed: %w", err)
+		}
+	}
+	if s.cfg.TCP != "" {
+		if s.tcp, err = net.Listen("tcp", s.cfg.TCP); err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return nil, fmt.Errorf("listen tcp failed: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (s *SyslogServer) Addrs() []string {
+	var addrs []string
+	if s.udp != nil {
+		addrs = append(addrs, "udp://"+s.udp.LocalAddr().String())
+	}
+	if s.tcp != nil {
+		addrs = append(addrs, "tcp://"+s.tcp.Addr().String())
+	}
+	return addrs
+}
+
+func (s *SyslogServer) Run(ctx context.Context, events chan<- LogEvent) {
+	s.start(ctx, events)
+
+	var listeners sync.WaitGroup
+	if s.udp != nil {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			s.serveUDP()
+		}()
+	}
+	if s.tcp != nil {
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			s.serveTCP()
+		}()
+	}
+
+	<-ctx.Done()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	listeners.Wait()
+
+	s.stop()
+}
+
+func (s *SyslogServer) serveUDP() {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("syslog udp read failed", "err", err)
+			continue
+		}
+		s.handle(string(buf[:n]))
+	}
+}
+
+func (s *SyslogServer) serveTCP() {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("syslog tcp accept failed", "err", err)
+			continue
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer conn.Close()
+			stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+			defer stop()
+			s.serveConn(bufio.NewReaderSize(conn, maxSyslogMessage))
+		}()
+	}
+}
+
+// serveConn handles the frames read from r until the sender disconnects or
+// sends a frame that cannot be read.
+func (s *SyslogServer) serveConn(r *bufio.Reader) {
+	for {
+		frame, err := readSyslogFrame(r)
+		if errors.Is(err, errSyslogFrame) {
+			slog.Warn("syslog frame rejected", "err", err)
+			return
+		}
+		if err != nil {
+			return
+		}
+		s.handle(frame)
+	}
+}
+
+var errSyslogFrame = errors.New("invalid frame")
+
+// readSyslogFrame reads an RFC 6587 frame: octet-counted ("<len> <msg>")
+// or, for senders that do not count, newline-terminated. r buffers
+// maxSyslogMessage bytes, which bounds what a frame can make us hold: longer
+// lines are dropped.
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return "", err
+		}
+
+		if first[0] >= '0' && first[0] <= '9' {
+			header, err := r.ReadSlice(' ')
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return "", fmt.Errorf("%w: octet count too long", errSyslogFrame)
+			}
+			if err != nil {
+				return "", err
+			}
+			size, err := strconv.Atoi(strings.TrimSpace(string(header)))
+			if err != nil || size <= 0 || size > maxSyslogMessage {
+				return "", fmt.Errorf("%w: octet count %q", errSyslogFrame, strings.TrimSpace(string(header)))
+			}
+			frame := make([]byte, size)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				return "", err
+			}
+			return string(frame), nil
+		}
+
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			slog.Warn("syslog line too long, dropped", "limit", maxSyslogMessage)
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		if len(line) > 0 {
+			return string(line), nil
+		}
+		return "", err
+	}
+}
+
+func (s *SyslogServer) handle(raw string) {
+	msg, err := parseSyslog(raw)
+	if err != nil {
+		slog.Debug("ignoring non-syslog input", "bytes", len(raw))
+		return
+	}
+
+	s.write(msg.Hostname, msg.App, msg.Message)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want syslogMessage
+		ts   bool
+	}{
+		{
+			"rfc5424",
+			"<165>1 2024-01-17T12:00:00.5Z web01 api 1234 ID47 - panic: boom\n",
+			syslogMessage{Priority: 165, Hostname: "web01", App: "api", Message: "panic: boom"},
+			true,
+		},
+		{
+			"rfc5424 structured data",
+			`<11>1 2024-01-17T12:00:00Z web01 api - - [exampleSDID@32473 iut="3" eventSource="App\] x"][meta seq="1"] ValueError: bad`,
+			syslogMessage{Priority: 11, Hostname: "web01", App: "api", Message: "ValueError: bad"},
+			true,
+		},
+		{
+			"rfc5424 nil values and bom",
+			"<14>1 - - - - - - \ufeffhello",
+			syslogMessage{Priority: 14, Message: "hello"},
+			false,
+		},
+		{
+			"rfc5424 missing fields",
+			"<14>1 2024-01-17T12:00:00Z web01",
+			syslogMessage{Priority: 14, Message: "2024-01-17T12:00:00Z web01"},
+			false,
+		},
+		{
+			"rfc3164",
+			"<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed\r\n",
+			syslogMessage{Priority: 34, Hostname: "mymachine", App: "su", Message: "'su root' failed"},
+			true,
+		},
+		{
+			"rfc3164 without timestamp",
+			"<13>app: message with: colons",
+			syslogMessage{Priority: 13, App: "app", Message: "message with: colons"},
+			false,
+		},
+		{
+			"rfc3164 untagged",
+			"<13>just a message",
+			syslogMessage{Priority: 13, Message: "just a message"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslog(tt.raw)
+			if err != nil {
+				t.Fatalf("parseSyslog() error = %v", err)
+			}
+			if got.Timestamp.IsZero() == tt.ts {
+				t.Fatalf("parseSyslog() timestamp = %v, want set: %v", got.Timestamp, tt.ts)
+			}
+			got.Timestamp = time.Time{}
+			if got != tt.want {
+				t.Fatalf("parseSyslog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSyslogRejects(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"no priority",
+		"<>1 message",
+		"<192>message",
+		"<1234>message",
+		"<abc>message",
+		"<-1>message",
+		"<+1>message",
+		"<13 message",
+	} {
+		if msg, err := parseSyslog(raw); !errors.Is(err, errNotSyslog) {
+			t.Errorf("parseSyslog(%q) = %+v, %v; want errNotSyslog", raw, msg, err)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	long := strings.Repeat("x", maxSyslogMessage+10)
+	tests := []struct {
+		name   string
+		input  string
+		frames []string
+		err    error
+	}{
+		{"newline framed", "<13>one\n<13>two\n", []string{"<13>one\n", "<13>two\n"}, io.EOF},
+		{"unterminated last line", "<13>one\n<13>two", []string{"<13>one\n", "<13>two"}, io.EOF},
+		{"octet counted", "7 <13>one8 <13>two\n", []string{"<13>one", "<13>two\n"}, io.EOF},
+		{"mixed", "7 <13>one<13>two\n", []string{"<13>one", "<13>two\n"}, io.EOF},
+		{"count spans newlines", "9 <13>a\nb\nc", []string{"<13>a\nb\nc"}, io.EOF},
+		{"long line dropped", long + "\n<13>next\n", []string{"<13>next\n"}, io.EOF},
+		{"long unterminated line", long, nil, io.EOF},
+		{"short frame", "20 <13>short", nil, io.ErrUnexpectedEOF},
+		{"count without a frame", "20", nil, io.EOF},
+		{"zero count", "0 <13>x", nil, errSyslogFrame},
+		{"count not a number", "1x2 <13>x", nil, errSyslogFrame},
+		{"count over the limit", "99999999 <13>x", nil, errSyslogFrame},
+		{"count too long", strings.Repeat("9", maxSyslogMessage+1), nil, errSyslogFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReaderSize(strings.NewReader(tt.input), maxSyslogMessage)
+			var frames []string
+			var err error
+			for {
+				var frame string
+				if frame, err = readSyslogFrame(r); err != nil {
+					break
+				}
+				frames = append(frames, frame)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("readSyslogFrame() error = %v, want %v", err, tt.err)
+			}
+			if strings.Join(frames, "|") != strings.Join(tt.frames, "|") || len(frames) != len(tt.frames) {
+				t.Fatalf("readSyslogFrame() frames = %q, want %q", frames, tt.frames)
+			}
+		})
+	}
+}
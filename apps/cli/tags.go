@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+func validateTags(tags map[string]string) error {
+	for k := range tags {
+		if strings.TrimSpace(k) == "" {
+			return errors.New("tag names must not be empty")
+		}
+	}
+	return nil
+}
+
+// tagFlags collects repeated --tag key=value flags.
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(t)) {
+		pairs = append(pairs, k+"="+t[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+	t[strings.TrimSpace(k)] = v
+	return nil
+}
+
+// withTags returns tags overridden by extra. tags itself is left untouched,
+// as payloads share the configured map.
+func withTags(tags map[string]string, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return tags
+	}
+	merged := maps.Clone(tags)
+	if merged == nil {
+		merged = make(map[string]string, len(extra))
+	}
+	maps.Copy(merged, extra)
+	return merged
+}
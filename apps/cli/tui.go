@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/watch"
+)
+
+const (
+	dashboardRefresh   = time.Second
+	dashboardIncidents = 50
+	dashboardLogs      = 50
+)
+
+// Dashboard draws the watcher's state on the terminal for `lacia-cli
+// --tui`: the watched files, their latest lines, recent incidents and what
+// happened to them, send counters and the watcher's own log.
+type Dashboard struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	seen      map[*Watcher]int64
+	tail      []string
+	incidents []string
+	logs      []string
+	closed    bool
+}
+
+func NewDashboard(out io.Writer) *Dashboard {
+	return &Dashboard{out: out, seen: make(map[*Watcher]int64)}
+}
+
+// Write takes the watcher's log output while the dashboard is shown, and
+// passes it to stderr once it is closed.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return os.Stderr.Write(p)
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logs = appendCapped(d.logs, line, dashboardLogs)
+	}
+	return len(p), nil
+}
+
+// Incident records what happened to a detected error, e.g. "sent" or
+// "duplicate".
+func (d *Dashboard) Incident(event LogEvent, severity, outcome string) {
+	line := fmt.Sprintf("%s %-8s %-14s %s", event.Timestamp.Local().Format("15:04:05"), severity, outcome, event.Line)
+	d.mu.Lock()
+	d.incidents = appendCapped(d.incidents, line, dashboardIncidents)
+	d.mu.Unlock()
+}
+
+// Run redraws the dashboard until ctx is done.
+func (d *Dashboard) Run(ctx context.Context, status func() StatusReport, watchers func() []*Watcher) {
+	prepareTerminal()
+	// Alternate screen, hidden cursor
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	for {
+		d.draw(status(), watchers())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close restores the terminal. Logs written after it go to stderr.
+func (d *Dashboard) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+	}
+}
+
+func (d *Dashboard) draw(report StatusReport, watchers []*Watcher) {
+	width, height := terminalSize()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	// Pick up the lines read since the last redraw
+	current := make(map[*Watcher]int64, len(watchers))
+	for _, w := range watchers {
+		lines, next := w.Tail(d.seen[w])
+		if missed := next - d.seen[w] - int64(len(lines)); d.seen[w] > 0 && missed > 0 {
+			d.tail = appendCapped(d.tail, fmt.Sprintf("%s │ … %d lines", filepath.Base(w.Path()), missed), height)
+		}
+		for _, line := range lines {
+			d.tail = appendCapped(d.tail, filepath.Base(w.Path())+" │ "+line, height)
+		}
+		current[w] = next
+	}
+	d.seen = current
+
+	var screen []string
+	screen = append(screen, fmt.Sprintf("\x1b[1mlacia-cli\x1b[0m pid %d, up %v, server %s",
+		report.PID, time.Since(report.StartedAt).Round(time.Second), report.Server))
+	screen = append(screen, fmt.Sprintf("Incidents: %d sent, %d failed, %d duplicates, %d ignored, %d muted",
+		report.Sent, report.Failed, report.Duplicates, report.Ignored, report.Muted))
+	screen = append(screen, fmt.Sprintf("Queue:     %d queued, %d waiting, %d dropped (spool full)",
+		report.Queued, report.Spooled, report.Dropped))
+	switch {
+	case report.LastError != "":
+		screen = append(screen, "\x1b[31mLast send failed: "+report.LastError+"\x1b[0m")
+	case report.LastSendAt != nil:
+		screen = append(screen, "Last send: "+report.LastSendAt.Local().Format("15:04:05"))
+	}
+	if report.MutedUntil != nil {
+		screen = append(screen, "\x1b[33mMuted until "+report.MutedUntil.Local().Format("15:04:05")+" "+report.MuteReason+"\x1b[0m")
+	}
+
+	screen = append(screen, dashboardHeading("Files", width))
+	for _, t := range report.Targets {
+		name := t.LogPath
+		if t.Label != "" {
+			name += " (" + t.Label + ")"
+		}
+		line := fmt.Sprintf("%s — %d lines, %d errors", name, t.Lines, t.Errors)
+		if t.State == watch.StateRestarting {
+			line += " \x1b[33mrestarting: " + t.LastError + "\x1b[0m"
+		}
+		screen = append(screen, line)
+	}
+
+	// The tail gets whatever room the other sections leave
+	incidents := lastN(d.incidents, 8)
+	logs := lastN(d.logs, 5)
+	rows := height - len(screen) - len(incidents) - len(logs) - 4
+	screen = append(screen, dashboardHeading("Incidents", width))
+	screen = append(screen, incidents...)
+	screen = append(screen, dashboardHeading("Tail", width))
+	screen = append(screen, lastN(d.tail, max(rows, 0))...)
+	screen = append(screen, dashboardHeading("Log", width))
+	screen = append(screen, logs...)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range screen {
+		if i == height {
+			break
+		}
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fitLine(line, width))
+		// Clear what the last frame left on the line
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(d.out, b.String())
+}
+
+func dashboardHeading(title string, width int) string {
+	return "\x1b[2m── " + title + " " + strings.Repeat("─", max(width-len(title)-4, 0)) + "\x1b[0m"
+}
+
+// fitLine cuts line to width columns, not counting escape sequences.
+func fitLine(line string, width int) string {
+	// 1 after ESC, 2 inside a CSI sequence such as a color
+	cols, escape := 0, 0
+	for i, r := range line {
+		switch {
+		case r == '\x1b':
+			escape = 1
+		case escape == 1 && r == '[':
+			escape = 2
+		case escape == 1 || escape == 2 && r >= '@' && r <= '~':
+			escape = 0
+		case escape == 2:
+		case cols == width:
+			return line[:i] + "\x1b[0m"
+		default:
+			cols++
+		}
+	}
+	return line
+}
+
+func appendCapped(lines []string, line string, n int) []string {
+	lines = append(lines, line)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func lastN(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	updateTimeout = 5 * time.Minute
+	// updateMaxSize bounds the download, well above the binary's size
+	updateMaxSize = 256 << 20
+)
+
+// UpdateConfig points `lacia-cli self-update` at a release manifest and the
+// key its binaries are signed with.
+type UpdateConfig struct {
+	URL string `json:"url"`
+	// PublicKey is the base64 Ed25519 key that signs released binaries
+	PublicKey string `json:"public_key"`
+}
+
+func (c *UpdateConfig) Validate() error {
+	// The manifest is what says which binary is newer, so it must not be
+	// open to tampering on the way
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "https" {
+		return errors.New("url must be an https URL")
+	}
+	if _, err := c.publicKey(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *UpdateConfig) publicKey() (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(c.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public_key must be a base64 Ed25519 public key")
+	}
+	return key, nil
+}
+
+// releaseManifest is what the update URL serves. Binaries are keyed by
+// GOOS/GOARCH, e.g. "linux/amd64".
+type releaseManifest struct {
+	Version  string                  `json:"version"`
+	Binaries map[string]releaseAsset `json:"binaries"`
+}
+
+type releaseAsset struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	// Signature is the base64 Ed25519 signature of the release's
+	// signedRelease message for the binary
+	Signature string `json:"signature"`
+}
+
+// signedRelease is the message a binary's signature covers: the release's
+// version, the platform and the binary's checksum, so a signed binary
+// cannot be passed off as another version, such as an older one with a
+// known flaw, or as one for another platform.
+func signedRelease(version, goos, goarch, sha256Hex string) []byte {
+	return []byte(version + "|" + goos + "|" + goarch + "|" + strings.ToLower(sha256Hex))
+}
+
+// runSelfUpdateCommand replaces the running executable with the latest
+// release once its checksum and signature check out.
+func runSelfUpdateCommand(args []string) {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether an update is available")
+	force := fs.Bool("force", false, "install the release even if it is not newer, including an older one")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli self-update [--check] [--force]")
+		os.Exit(2)
+	}
+
+	cfg := loadConfigOrExit()
+	if cfg.Update == nil {
+		fmt.Fprintln(os.Stderr, "✗ No update section in the config")
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
+	client := &http.Client{}
+	manifest, err := fetchManifest(ctx, client, cfg.Update.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Check for updates: %v\n", err)
+		os.Exit(1)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	asset, ok := manifest.Binaries[platform]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "✗ Release %s has no binary for %s\n", manifest.Version, platform)
+		os.Exit(1)
+	}
+	// The version is only trusted once its signature checks out
+	key, _ := cfg.Update.publicKey()
+	if err := verifyRelease(manifest.Version, asset, key); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Release %s: %v\n", manifest.Version, err)
+		os.Exit(1)
+	}
+	if !*force && !newerVersion(manifest.Version, agentVersion()) {
+		fmt.Printf("✓ %s is up to date (latest release is %s)\n", agentVersion(), manifest.Version)
+		return
+	}
+	if *check {
+		fmt.Printf("Update available: %s → %s\n", agentVersion(), manifest.Version)
+		return
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Find the running executable: %v\n", err)
+		os.Exit(1)
+	}
+	if err := installRelease(ctx, client, asset, exe); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Update to %s: %v\n", manifest.Version, err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Updated %s from %s to %s\n", exe, agentVersion(), manifest.Version)
+	fmt.Println("Restart the watcher to run the new version, e.g. lacia-cli service stop && lacia-cli service start")
+}
+
+func fetchManifest(ctx context.Context, client *http.Client, manifestURL string) (*releaseManifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	var manifest releaseManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version == "" {
+		return nil, errors.New("invalid manifest: version is missing")
+	}
+	return &manifest, nil
+}
+
+// verifyRelease checks that asset was signed with key as this platform's
+// binary of version.
+func verifyRelease(version string, asset releaseAsset, key ed25519.PublicKey) error {
+	want, err := hex.DecodeString(asset.SHA256)
+	if err != nil || len(want) != sha256.Size {
+		return errors.New("manifest has no valid sha256 for the binary")
+	}
+	signature, err := base64.StdEncoding.DecodeString(asset.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("manifest has no valid signature for the binary")
+	}
+	if !ed25519.Verify(key, signedRelease(version, runtime.GOOS, runtime.GOARCH, asset.SHA256), signature) {
+		return errors.New("signature does not verify against public_key")
+	}
+	return nil
+}
+
+// installRelease downloads asset, which verifyRelease has checked, next to
+// exe, checks it against the signed checksum and renames it over exe, so a
+// failed update leaves the old binary in place.
+func installRelease(ctx context.Context, client *http.Client, asset releaseAsset, exe string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	binary, err := io.ReadAll(io.LimitReader(resp.Body, updateMaxSize))
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+
+	if sum := sha256.Sum256(binary); !strings.EqualFold(hex.EncodeToString(sum[:]), asset.SHA256) {
+		return errors.New("checksum mismatch, the download is corrupt")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".lacia-cli-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		// A running executable cannot be replaced, but it can be renamed
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// newerVersion reports whether release is newer than current, comparing
+// dotted numbers such as v1.10.2. A development build is never current.
+func newerVersion(release, current string) bool {
+	if current == "dev" {
+		return true
+	}
+	r := strings.Split(strings.TrimPrefix(release, "v"), ".")
+	c := strings.Split(strings.TrimPrefix(current, "v"), ".")
+	for i := range max(len(r), len(c)) {
+		var rn, cn int
+		if i < len(r) {
+			rn, _ = strconv.Atoi(strings.SplitN(r[i], "-", 2)[0])
+		}
+		if i < len(c) {
+			cn, _ = strconv.Atoi(strings.SplitN(c[i], "-", 2)[0])
+		}
+		if rn != cn {
+			return rn > cn
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set when building a release, e.g.
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildDate=2026-01-02T15:04:05Z".
+// Otherwise they are filled in from the module and VCS information Go
+// embeds in the binary, and development builds report "dev".
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	Modified  bool
+	BuildDate string
+	GoVersion string
+	Platform  string
+}
+
+var readBuildInfo = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	embedded, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+		// Installed with go install module@version
+		info.Version = embedded.Main.Version
+	}
+	for _, s := range embedded.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			// Only describes the embedded revision
+			info.Modified = commit == "" && s.Value == "true"
+		}
+	}
+	return info
+})
+
+// agentVersion is the version sent with incidents and compared by
+// self-update.
+func agentVersion() string {
+	return readBuildInfo().Version
+}
+
+func runVersionCommand(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: lacia-cli version")
+		os.Exit(2)
+	}
+
+	info := readBuildInfo()
+	fmt.Printf("lacia-cli %s\n", info.Version)
+	if info.Commit != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("Commit: %s%s\n", info.Commit, modified)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("Built:  %s\n", info.BuildDate)
+	}
+	fmt.Printf("Go:     %s %s\n", info.GoVersion, info.Platform)
+}
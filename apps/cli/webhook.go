@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/noobiethe13/lacia/apps/cli/pkg/ship"
+)
+
+// WebhookConfig posts incidents to an arbitrary HTTP receiver, such as
+// Discord, Microsoft Teams or Opsgenie, with a body rendered from a
+// text/template over the IncidentPayload.
+type WebhookConfig struct {
+	// Name identifies the webhook in logs; it defaults to the URL's host.
+	Name        string            `json:"name,omitempty"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	// Template or TemplateFile renders the request body; without either
+	// the IncidentPayload is sent as JSON.
+	Template     string `json:"template,omitempty"`
+	TemplateFile string `json:"template_file,omitempty"`
+	MinSeverity  string `json:"min_severity,omitempty"`
+}
+
+func (c *WebhookConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("url is required")
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return errors.New("url must be an http or https URL")
+	}
+	if c.Template != "" && c.TemplateFile != "" {
+		return errors.New("template and template_file are mutually exclusive")
+	}
+	if c.MinSeverity != "" {
+		if _, ok := severityRank[c.MinSeverity]; !ok {
+			return fmt.Errorf("min_severity must be one of low, medium, high, critical: %q", c.MinSeverity)
+		}
+	}
+	if _, err := c.template(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// name is Name or, without one, the URL's host.
+func (c *WebhookConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if u, err := url.Parse(c.URL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+func (c *WebhookConfig) template() (*template.Template, error) {
+	text := c.Template
+	if c.TemplateFile != "" {
+		data, err := os.ReadFile(c.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("template_file: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		text = "{{json .}}"
+	}
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// webhookFuncs are available to webhook templates. json quotes a value for
+// use inside a JSON body, e.g. {"content": {{json .ErrorLine}}}.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"truncate": func(n int, s string) string { return ship.Truncate(s, n) },
+}
+
+// WebhookSink sends incidents to a WebhookConfig's receiver.
+type WebhookSink struct {
+	name        string
+	url         string
+	method      string
+	headers     map[string]string
+	contentType string
+	tmpl        *template.Template
+	httpClient  *http.Client
+}
+
+func NewWebhookSink(cfg *WebhookConfig) (*WebhookSink, error) {
+	tmpl, err := cfg.template()
+	if err != nil {
+		return nil, err
+	}
+	s := &WebhookSink{
+		name:        cfg.name(),
+		url:         cfg.URL,
+		method:      strings.ToUpper(cfg.Method),
+		headers:     cfg.Headers,
+		contentType: cfg.ContentType,
+		tmpl:        tmpl,
+		httpClient:  &http.Client{},
+	}
+	if s.method == "" {
+		s.method = http.MethodPost
+	}
+	if s.contentType == "" {
+		s.contentType = "application/json"
+	}
+	return s, nil
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook " + s.name
+}
+
+func (s *WebhookSink) Send(ctx context.Context, payload IncidentPayload) error {
+	var body bytes.Buffer
+	if err := s.tmpl.Execute(&body, payload); err != nil {
+		return fmt.Errorf("template failed: %w", err)
+	}
+	return postBody(ctx, s.httpClient, s.method, s.url, s.contentType, body.Bytes(), s.headers)
+}
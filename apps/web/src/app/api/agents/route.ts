@@ -0,0 +1,51 @@
+import { NextRequest, NextResponse } from "next/server";
+import { getAgents, recordHeartbeat } from "@/lib/db";
+import { readWebhookBody, verifyWebhookRequest } from "@/lib/webhook-auth";
+import type { AgentHeartbeat } from "@/types";
+
+export const dynamic = "force-dynamic";
+
+export async function GET() {
+  try {
+    const agents = await getAgents();
+    return NextResponse.json({ agents });
+  } catch (error) {
+    console.error("Failed to fetch agents:", error);
+    return NextResponse.json(
+      { error: "Failed to fetch agents" },
+      { status: 500 }
+    );
+  }
+}
+
+export async function POST(request: NextRequest) {
+  try {
+    const rawBody = await readWebhookBody(request);
+    if (rawBody === null) {
+      return NextResponse.json({ error: "Request body too large" }, { status: 413 });
+    }
+    const authError = verifyWebhookRequest(request.headers, rawBody);
+    if (authError) {
+      return NextResponse.json({ error: authError }, { status: 401 });
+    }
+
+    const body = JSON.parse(rawBody) as AgentHeartbeat;
+
+    if (!body.name || !(body.interval_seconds > 0)) {
+      return NextResponse.json(
+        { error: "Missing required fields: name, interval_seconds" },
+        { status: 400 }
+      );
+    }
+
+    await recordHeartbeat(body);
+
+    return NextResponse.json({ success: true }, { status: 200 });
+  } catch (error) {
+    console.error("Heartbeat error:", error);
+    return NextResponse.json(
+      { error: "Internal server error" },
+      { status: 500 }
+    );
+  }
+}
@@ -0,0 +1,71 @@
+import { NextRequest, NextResponse } from "next/server";
+import { getIncidentsByFingerprints, getIncidentsByIds } from "@/lib/db";
+import { readWebhookBody, verifyWebhookRequest } from "@/lib/webhook-auth";
+import type { IncidentStatusReply, IncidentStatusRequest } from "@/types";
+
+export const dynamic = "force-dynamic";
+
+// Bounds one poll (ids and fingerprints together), the watcher only follows
+// its recent incidents
+const MAX_IDS = 100;
+
+export async function POST(request: NextRequest) {
+  try {
+    const rawBody = await readWebhookBody(request);
+    if (rawBody === null) {
+      return NextResponse.json({ error: "Request body too large" }, { status: 413 });
+    }
+    const authError = verifyWebhookRequest(request.headers, rawBody);
+    if (authError) {
+      return NextResponse.json({ error: authError }, { status: 401 });
+    }
+
+    const body = JSON.parse(rawBody) as IncidentStatusRequest;
+
+    if (!Array.isArray(body.ids) || !body.ids.every(Number.isInteger)) {
+      return NextResponse.json(
+        { error: "ids must be an array of incident IDs" },
+        { status: 400 }
+      );
+    }
+    const fingerprints = body.fingerprints ?? [];
+    if (
+      !Array.isArray(fingerprints) ||
+      !fingerprints.every((key) => typeof key?.fingerprint === "string" && key.fingerprint)
+    ) {
+      return NextResponse.json(
+        { error: "fingerprints must be an array of {fingerprint, repo_url}" },
+        { status: 400 }
+      );
+    }
+    if (body.ids.length + fingerprints.length > MAX_IDS) {
+      return NextResponse.json(
+        { error: `At most ${MAX_IDS} incidents per request` },
+        { status: 400 }
+      );
+    }
+
+    const incidents = [
+      ...(await getIncidentsByIds(body.ids)),
+      ...(await getIncidentsByFingerprints(
+        fingerprints.map((key) => ({ fingerprint: key.fingerprint, repoUrl: key.repo_url }))
+      )),
+    ];
+    const reply: IncidentStatusReply = {
+      incidents: incidents.map((incident) => ({
+        id: incident.id,
+        status: incident.status,
+        pr_url: incident.prUrl || undefined,
+        fingerprint: incident.fingerprint || undefined,
+        repo_url: incident.repoUrl || undefined,
+      })),
+    };
+    return NextResponse.json(reply, { status: 200 });
+  } catch (error) {
+    console.error("Incident status error:", error);
+    return NextResponse.json(
+      { error: "Internal server error" },
+      { status: 500 }
+    );
+  }
+}
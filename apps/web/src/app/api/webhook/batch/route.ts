@@ -0,0 +1,71 @@
+import { NextRequest, NextResponse } from "next/server";
+import { receiveIncident } from "@/lib/incidents";
+import { readWebhookBody, verifyWebhookRequest } from "@/lib/webhook-auth";
+import type { IncidentPayload } from "@/types";
+
+// Bounds the work one request can queue, the watcher's max_size is capped
+// to match
+const MAX_ITEMS = 100;
+
+export async function POST(request: NextRequest) {
+  try {
+    const rawBody = await readWebhookBody(request);
+    if (rawBody === null) {
+      return NextResponse.json({ error: "Request body too large" }, { status: 413 });
+    }
+    const authError = verifyWebhookRequest(request.headers, rawBody);
+    if (authError) {
+      return NextResponse.json({ error: authError }, { status: 401 });
+    }
+
+    const body = JSON.parse(rawBody) as IncidentPayload[];
+
+    if (!Array.isArray(body)) {
+      return NextResponse.json(
+        { error: "Expected an array of incidents" },
+        { status: 400 }
+      );
+    }
+    if (body.length > MAX_ITEMS) {
+      return NextResponse.json(
+        { error: `At most ${MAX_ITEMS} incidents per request` },
+        { status: 400 }
+      );
+    }
+
+    const invalid = body.findIndex((item) => !item.error_line || !item.timestamp);
+    if (invalid !== -1) {
+      return NextResponse.json(
+        { error: `Missing required fields: error_line, timestamp (item ${invalid})` },
+        { status: 400 }
+      );
+    }
+
+    const baseUrl = process.env.NEXT_PUBLIC_BASE_URL || "http://localhost:3000";
+    const incidentIds: number[] = [];
+
+    for (const item of body) {
+      const { incident, created } = await receiveIncident(item);
+      incidentIds.push(incident.id);
+
+      if (created && item.repo_url) {
+        fetch(`${baseUrl}/api/queue/process`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ incidentId: incident.id }),
+        }).catch(() => {});
+      }
+    }
+
+    return NextResponse.json(
+      { success: true, incidentIds },
+      { status: 200 }
+    );
+  } catch (error) {
+    console.error("Batch webhook error:", error);
+    return NextResponse.json(
+      { error: "Internal server error" },
+      { status: 500 }
+    );
+  }
+}
@@ -1,10 +1,20 @@
 import { NextRequest, NextResponse } from "next/server";
-import { createIncident } from "@/lib/db";
+import { receiveIncident } from "@/lib/incidents";
+import { readWebhookBody, verifyWebhookRequest } from "@/lib/webhook-auth";
 import type { IncidentPayload } from "@/types";
 
 export async function POST(request: NextRequest) {
   try {
-    const body = (await request.json()) as IncidentPayload;
+    const rawBody = await readWebhookBody(request);
+    if (rawBody === null) {
+      return NextResponse.json({ error: "Request body too large" }, { status: 413 });
+    }
+    const authError = verifyWebhookRequest(request.headers, rawBody);
+    if (authError) {
+      return NextResponse.json({ error: authError }, { status: 401 });
+    }
+
+    const body = JSON.parse(rawBody) as IncidentPayload;
 
     if (!body.error_line || !body.timestamp) {
       return NextResponse.json(
@@ -13,14 +23,9 @@ export async function POST(request: NextRequest) {
       );
     }
 
-    const incident = await createIncident({
-      errorLog: body.error_line,
-      hostname: body.hostname || "unknown",
-      repoUrl: body.repo_url || undefined,
-      context: body.context ? JSON.stringify(body.context) : undefined,
-    });
+    const { incident, created } = await receiveIncident(body);
 
-    if (body.repo_url) {
+    if (created && body.repo_url) {
       const baseUrl = process.env.NEXT_PUBLIC_BASE_URL || "http://localhost:3000";
       fetch(`${baseUrl}/api/queue/process`, {
         method: "POST",
@@ -3,8 +3,8 @@ import fs from 'fs';
 import path from 'path';
 
 // Re-export types from central location
-export type { Incident, AgentSession, ToolCall, AgentLog } from '@/types';
-import type { Incident, AgentSession, ToolCall, AgentLog } from '@/types';
+export type { Incident, AgentSession, ToolCall, AgentLog, Agent } from '@/types';
+import type { Incident, AgentSession, ToolCall, AgentLog, Agent, AgentHeartbeat } from '@/types';
 
 type SqlParams = SqlValue[];
 
@@ -62,6 +62,8 @@ async function initDB(): Promise<Database> {
       const fileBuffer = fs.readFileSync(DB_PATH);
       globalForDb.sqlJsDb = new globalForDb.sqlJs.Database(fileBuffer);
       console.log(`[DB] Database loaded, size: ${fileBuffer.length} bytes`);
+      // Adds tables introduced since the file was created
+      createTables(globalForDb.sqlJsDb);
     } else {
       console.log(`[DB] Creating new database`);
       globalForDb.sqlJsDb = new globalForDb.sqlJs.Database();
@@ -87,9 +89,19 @@ function createTables(database: Database): void {
       context TEXT,
       pr_created INTEGER DEFAULT 0,
       pr_url TEXT,
-      created_at TEXT DEFAULT CURRENT_TIMESTAMP
+      created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+      fingerprint TEXT,
+      occurrence_count INTEGER DEFAULT 1,
+      last_seen TEXT
     )
   `);
+  // Added since incidents was first created
+  addMissingColumns(database, 'incidents', {
+    fingerprint: 'TEXT',
+    occurrence_count: 'INTEGER DEFAULT 1',
+    last_seen: 'TEXT',
+  });
+  database.run(`CREATE INDEX IF NOT EXISTS incidents_fingerprint ON incidents (fingerprint)`);
   
   database.run(`
     CREATE TABLE IF NOT EXISTS agent_sessions (
@@ -128,6 +140,35 @@ function createTables(database: Database): void {
       FOREIGN KEY (incident_id) REFERENCES incidents(id) ON DELETE CASCADE
     )
   `);
+
+  database.run(`
+    CREATE TABLE IF NOT EXISTS agents (
+      name TEXT PRIMARY KEY,
+      hostname TEXT NOT NULL,
+      agent_version TEXT,
+      pid INTEGER,
+      started_at TEXT,
+      interval_seconds INTEGER NOT NULL,
+      targets TEXT,
+      sent INTEGER DEFAULT 0,
+      failed INTEGER DEFAULT 0,
+      last_incident_at TEXT,
+      stopped INTEGER DEFAULT 0,
+      last_seen_at TEXT NOT NULL
+    )
+  `);
+}
+
+// Adds the columns a table created by an older version lacks
+function addMissingColumns(database: Database, table: string, columns: Record<string, string>): void {
+  const existing = new Set(
+    (database.exec(`PRAGMA table_info(${table})`)[0]?.values ?? []).map(row => row[1] as string)
+  );
+  for (const [name, type] of Object.entries(columns)) {
+    if (!existing.has(name)) {
+      database.run(`ALTER TABLE ${table} ADD COLUMN ${name} ${type}`);
+    }
+  }
 }
 
 // Save database to disk atomically
@@ -163,6 +204,9 @@ function rowToIncident(row: Record<string, unknown>): Incident {
     prCreated: Boolean(row.pr_created),
     prUrl: row.pr_url as string || null,
     createdAt: new Date(row.created_at as string),
+    fingerprint: row.fingerprint as string || null,
+    occurrenceCount: row.occurrence_count as number || 1,
+    lastSeenAt: row.last_seen ? new Date(row.last_seen as string) : null,
   };
 }
 
@@ -201,6 +245,31 @@ function rowToAgentLog(row: Record<string, unknown>): AgentLog {
   };
 }
 
+function rowToAgent(row: Record<string, unknown>): Agent {
+  const lastSeenAt = new Date(row.last_seen_at as string);
+  const intervalSeconds = row.interval_seconds as number;
+  let status: Agent["status"] = "alive";
+  if (row.stopped) {
+    status = "stopped";
+  } else if (Date.now() - lastSeenAt.getTime() > 3 * intervalSeconds * 1000) {
+    status = "down";
+  }
+  return {
+    name: row.name as string,
+    hostname: row.hostname as string,
+    agentVersion: row.agent_version as string | null,
+    pid: row.pid as number | null,
+    startedAt: row.started_at ? new Date(row.started_at as string) : null,
+    intervalSeconds,
+    targets: row.targets ? JSON.parse(row.targets as string) : null,
+    sent: row.sent as number,
+    failed: row.failed as number,
+    lastIncidentAt: row.last_incident_at ? new Date(row.last_incident_at as string) : null,
+    lastSeenAt,
+    status,
+  };
+}
+
 // ==================== INCIDENTS ====================
 
 export async function getIncidents(): Promise<Incident[]> {
@@ -233,11 +302,55 @@ export async function getIncidentById(id: number): Promise<Incident | null> {
   return null;
 }
 
+// Looks up the given incidents, skipping IDs that do not exist
+export async function getIncidentsByIds(ids: number[]): Promise<Incident[]> {
+  if (ids.length === 0) return [];
+  const database = await initDB();
+  const result = database.exec(
+    `SELECT * FROM incidents WHERE id IN (${ids.map(() => '?').join(', ')})`,
+    ids
+  );
+  if (!result[0]) return [];
+
+  const columns = result[0].columns;
+  return result[0].values.map(row => {
+    const obj: Record<string, unknown> = {};
+    columns.forEach((col, i) => { obj[col] = row[i]; });
+    return rowToIncident(obj);
+  });
+}
+
+// The newest incident for a fingerprint, which repeats are counted against
+function latestIncidentId(database: Database, fingerprint: string, repoUrl?: string): number | null {
+  const stmt = database.prepare(`
+    SELECT id FROM incidents
+    WHERE fingerprint = ? AND repo_url IS ?
+    ORDER BY id DESC LIMIT 1
+  `);
+  stmt.bind([fingerprint, repoUrl || null]);
+  const id = stmt.step() ? (stmt.getAsObject().id as number) : null;
+  stmt.free();
+  return id;
+}
+
+export async function getIncidentsByFingerprints(
+  keys: { fingerprint: string; repoUrl?: string }[]
+): Promise<Incident[]> {
+  const database = await initDB();
+  const ids = keys
+    .map((key) => latestIncidentId(database, key.fingerprint, key.repoUrl))
+    .filter((id): id is number => id !== null);
+  return getIncidentsByIds(ids);
+}
+
 export async function createIncident(data: {
   errorLog: string;
   hostname?: string;
   repoUrl?: string;
   context?: string;
+  fingerprint?: string;
+  occurrenceCount?: number;
+  lastSeen?: string;
 }): Promise<Incident> {
   const database = await initDB();
   
@@ -265,8 +378,17 @@ export async function createIncident(data: {
   // Use database.run() directly for more reliable INSERT
   console.log(`[DB] Inserting new incident...`);
   database.run(
-    `INSERT INTO incidents (error_log, hostname, repo_url, context) VALUES (?, ?, ?, ?)`,
-    [data.errorLog, data.hostname || 'unknown', data.repoUrl || null, data.context || null]
+    `INSERT INTO incidents (error_log, hostname, repo_url, context, fingerprint, occurrence_count, last_seen)
+      VALUES (?, ?, ?, ?, ?, ?, ?)`,
+    [
+      data.errorLog,
+      data.hostname || 'unknown',
+      data.repoUrl || null,
+      data.context || null,
+      data.fingerprint || null,
+      data.occurrenceCount || 1,
+      data.lastSeen || null,
+    ]
   );
   
   // Get the created incident ID
@@ -298,6 +420,26 @@ export async function createIncident(data: {
   console.log(`[DB] Successfully created incident ${id}`);
   return newIncident;
 }
+// Counts occurrences of an error against the incident already stored for
+// its fingerprint and repository, or returns null when there is none
+export async function addOccurrences(data: {
+  fingerprint: string;
+  repoUrl?: string;
+  count: number;
+  lastSeen: string;
+}): Promise<Incident | null> {
+  const database = await initDB();
+  const id = latestIncidentId(database, data.fingerprint, data.repoUrl);
+  if (id === null) return null;
+
+  database.run(
+    `UPDATE incidents SET occurrence_count = occurrence_count + ?, last_seen = ? WHERE id = ?`,
+    [data.count, data.lastSeen, id]
+  );
+  saveDB();
+  return getIncidentById(id);
+}
+
 export async function updateIncident(id: number, data: Partial<{
   status: string;
   prCreated: boolean;
@@ -517,6 +659,47 @@ export async function createAgentLog(data: {
   return rowToAgentLog(row as Record<string, unknown>);
 }
 
+// ==================== AGENTS ====================
+
+export async function getAgents(): Promise<Agent[]> {
+  const database = await initDB();
+  const result = database.exec('SELECT * FROM agents ORDER BY name ASC');
+  if (!result[0]) return [];
+
+  const columns = result[0].columns;
+  return result[0].values.map(row => {
+    const obj: Record<string, unknown> = {};
+    columns.forEach((col, i) => { obj[col] = row[i]; });
+    return rowToAgent(obj);
+  });
+}
+
+// Records a heartbeat, replacing the agent's previous one. Last seen is the
+// server's clock, so a skewed agent clock cannot make it look alive or down.
+export async function recordHeartbeat(data: AgentHeartbeat): Promise<void> {
+  const database = await initDB();
+  database.run(
+    `INSERT OR REPLACE INTO agents
+      (name, hostname, agent_version, pid, started_at, interval_seconds, targets, sent, failed, last_incident_at, stopped, last_seen_at)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+    [
+      data.name,
+      data.hostname || 'unknown',
+      data.agent_version || null,
+      data.pid || null,
+      data.started_at || null,
+      data.interval_seconds,
+      data.targets ? JSON.stringify(data.targets) : null,
+      data.sent || 0,
+      data.failed || 0,
+      data.last_incident_at || null,
+      data.stopping ? 1 : 0,
+      new Date().toISOString(),
+    ]
+  );
+  saveDB();
+}
+
 // Get incident with related data (for detail page)
 export async function getIncidentWithRelations(id: number): Promise<{
   incident: Incident;
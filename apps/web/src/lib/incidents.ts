@@ -0,0 +1,36 @@
+import { addOccurrences, createIncident } from "@/lib/db";
+import type { Incident, IncidentPayload } from "@/types";
+
+// Stores an incident an agent sent. A repeat of an error already stored,
+// such as the update an agent sends for aggregated duplicates, only adds to
+// that incident's occurrences; created is false then, so no fix is queued
+// for it again.
+export async function receiveIncident(
+  payload: IncidentPayload
+): Promise<{ incident: Incident; created: boolean }> {
+  const occurrenceCount = payload.occurrence_count || 1;
+  const lastSeen = payload.last_seen || payload.timestamp;
+
+  if (payload.fingerprint) {
+    const existing = await addOccurrences({
+      fingerprint: payload.fingerprint,
+      repoUrl: payload.repo_url || undefined,
+      count: occurrenceCount,
+      lastSeen,
+    });
+    if (existing) {
+      return { incident: existing, created: false };
+    }
+  }
+
+  const incident = await createIncident({
+    errorLog: payload.error_line,
+    hostname: payload.hostname || "unknown",
+    repoUrl: payload.repo_url || undefined,
+    context: payload.context ? JSON.stringify(payload.context) : undefined,
+    fingerprint: payload.fingerprint || undefined,
+    occurrenceCount,
+    lastSeen,
+  });
+  return { incident, created: true };
+}
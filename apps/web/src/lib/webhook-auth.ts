@@ -0,0 +1,123 @@
+/**
+ * Webhook Authentication
+ * Verifies requests from the Lacia watcher when LACIA_API_KEY,
+ * LACIA_WEBHOOK_SECRET and/or LACIA_SIGNING_PUBLIC_KEY are set. With none
+ * set, all requests are accepted.
+ */
+
+import crypto from "crypto";
+import { gunzipSync } from "zlib";
+
+const MAX_SIGNATURE_AGE_SECONDS = 300;
+
+// Matches the relay's limit, so whatever it forwards is accepted
+const MAX_BODY_BYTES = 10 << 20;
+
+// DER prefix that turns a raw 32-byte Ed25519 key into an SPKI structure
+const ED25519_SPKI_PREFIX = Buffer.from("302a300506032b6570032100", "hex");
+
+/**
+ * Reads LACIA_SIGNING_PUBLIC_KEY, a PEM or base64 Ed25519 public key
+ */
+function signingPublicKey(value: string): crypto.KeyObject {
+  if (value.includes("-----BEGIN")) {
+    return crypto.createPublicKey(value);
+  }
+  return crypto.createPublicKey({
+    key: Buffer.concat([ED25519_SPKI_PREFIX, Buffer.from(value.trim(), "base64")]),
+    format: "der",
+    type: "spki",
+  });
+}
+
+function safeEqual(a: string, b: string): boolean {
+  const bufA = Buffer.from(a);
+  const bufB = Buffer.from(b);
+  return bufA.length === bufB.length && crypto.timingSafeEqual(bufA, bufB);
+}
+
+/**
+ * Reads the request body, decompressing it when the watcher sent it gzipped.
+ * Signatures cover the uncompressed body. Returns null when the body, once
+ * decompressed, is over MAX_BODY_BYTES, since it is read before the request
+ * is authenticated.
+ */
+export async function readWebhookBody(request: Request): Promise<string | null> {
+  if (Number(request.headers.get("content-length")) > MAX_BODY_BYTES) {
+    return null;
+  }
+  if (request.headers.get("content-encoding") === "gzip") {
+    const compressed = Buffer.from(await request.arrayBuffer());
+    if (compressed.length > MAX_BODY_BYTES) {
+      return null;
+    }
+    try {
+      return gunzipSync(compressed, { maxOutputLength: MAX_BODY_BYTES }).toString("utf8");
+    } catch (error) {
+      if ((error as NodeJS.ErrnoException).code === "ERR_BUFFER_TOO_LARGE") {
+        return null;
+      }
+      throw error;
+    }
+  }
+  const body = await request.text();
+  return Buffer.byteLength(body) > MAX_BODY_BYTES ? null : body;
+}
+
+/**
+ * Returns an error message if the request fails verification, otherwise null
+ */
+export function verifyWebhookRequest(headers: Headers, rawBody: string): string | null {
+  const apiKey = process.env.LACIA_API_KEY;
+  if (apiKey) {
+    const provided =
+      headers.get("x-api-key") ||
+      headers.get("authorization")?.replace(/^Bearer\s+/i, "") ||
+      "";
+    if (!safeEqual(provided, apiKey)) {
+      return "Invalid API key";
+    }
+  }
+
+  const secret = process.env.LACIA_WEBHOOK_SECRET;
+  const publicKey = process.env.LACIA_SIGNING_PUBLIC_KEY;
+  if (!secret && !publicKey) {
+    return null;
+  }
+
+  const timestamp = headers.get("x-lacia-timestamp") || "";
+  const age = Math.abs(Date.now() / 1000 - Number(timestamp));
+  if (!timestamp || !Number.isFinite(age) || age > MAX_SIGNATURE_AGE_SECONDS) {
+    return "Missing or expired signature timestamp";
+  }
+
+  if (publicKey) {
+    const signature = Buffer.from(headers.get("x-lacia-ed25519-signature") || "", "base64");
+    let valid = false;
+    try {
+      valid = crypto.verify(
+        null,
+        Buffer.from(`${timestamp}.${rawBody}`),
+        signingPublicKey(publicKey),
+        signature
+      );
+    } catch {
+      valid = false;
+    }
+    if (!valid) {
+      return "Invalid Ed25519 signature";
+    }
+  }
+
+  if (secret) {
+    const signature = headers.get("x-lacia-signature") || "";
+    const expected =
+      "sha256=" +
+      crypto.createHmac("sha256", secret).update(`${timestamp}.${rawBody}`).digest("hex");
+    if (!safeEqual(signature, expected)) {
+      return "Invalid signature";
+    }
+  }
+
+  return null;
+}
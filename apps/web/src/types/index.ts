@@ -2,10 +2,105 @@
 
 export interface IncidentPayload {
   error_line: string;
+  // When the agent read the error, by its clock
   timestamp: string;
+  // The time the log gives for the error (RFC 3339), to spot clock skew
+  log_timestamp?: string;
   hostname: string;
   repo_url: string;
   context: string[];
+  // Set when a line was cut at the agent's maximum line length
+  truncated?: boolean;
+  target?: string;
+  severity?: "low" | "medium" | "high" | "critical";
+  // Set when the incident stands for a collapsed error storm, sampled events
+  // or aggregated duplicates
+  occurrence_count?: number;
+  // RFC 3339, set when the incident is an update for aggregated duplicates
+  first_seen?: string;
+  last_seen?: string;
+  // Identifies the error, so repeats count against the incident already
+  // stored for it
+  fingerprint?: string;
+  language?: "python" | "java" | "node" | "go" | "rust" | "dotnet";
+  // Innermost frame (where the error was raised) first
+  frames?: StackFrame[];
+  git?: {
+    commit: string;
+    branch?: string;
+    blame?: {
+      file: string;
+      line: number;
+      commit: string;
+      author?: string;
+      author_email?: string;
+      authored_at: string;
+      summary?: string;
+    };
+  };
+  // The lacia-cli release that sent the incident
+  agent_version?: string;
+  // Free-form labels such as env, team or service
+  tags?: Record<string, string>;
+  service?: string;
+  environment?: string;
+  version?: string;
+  // Set when the agent runs in a container
+  container?: {
+    id?: string;
+    image?: string;
+    pod?: string;
+    namespace?: string;
+  };
+}
+
+export interface StackFrame {
+  file: string;
+  line?: number;
+  function?: string;
+}
+
+export interface AgentHeartbeat {
+  name: string;
+  hostname: string;
+  agent_version: string;
+  pid: number;
+  started_at: string;
+  sent_at: string;
+  interval_seconds: number;
+  targets: {
+    log_path: string;
+    label?: string;
+    lines: number;
+    errors: number;
+    // Set for watched files; restarting while the file cannot be read
+    state?: "running" | "restarting";
+    restarts?: number;
+    last_error?: string;
+  }[] | null;
+  sent: number;
+  failed: number;
+  last_incident_at?: string;
+  // Set on the last heartbeat before a clean shutdown
+  stopping?: boolean;
+}
+
+// What a watcher polls /api/incidents/status with to follow the incidents
+// it reported
+export interface IncidentStatusRequest {
+  ids: number[];
+  // Incidents whose IDs the watcher never learned, sent through a relay
+  fingerprints?: { fingerprint: string; repo_url?: string }[];
+}
+
+export interface IncidentStatusReply {
+  incidents: {
+    id: number;
+    status: string;
+    pr_url?: string;
+    fingerprint?: string;
+    repo_url?: string;
+  }[];
 }
 
 // ==================== DATABASE MODEL TYPES ====================
@@ -20,6 +115,10 @@ export interface Incident {
   prCreated: boolean;
   prUrl: string | null;
   createdAt: Date;
+  // Identifies the error across the updates agents send for it
+  fingerprint: string | null;
+  occurrenceCount: number;
+  lastSeenAt: Date | null;
 }
 
 export interface AgentSession {
@@ -51,6 +150,21 @@ export interface AgentLog {
   createdAt: Date;
 }
 
+export interface Agent {
+  name: string;
+  hostname: string;
+  agentVersion: string | null;
+  pid: number | null;
+  startedAt: Date | null;
+  intervalSeconds: number;
+  targets: AgentHeartbeat["targets"];
+  sent: number;
+  failed: number;
+  lastIncidentAt: Date | null;
+  lastSeenAt: Date;
+  status: AgentStatus;
+}
+
 // ==================== STATUS TYPES ====================
 
 export type IncidentStatus = 
@@ -70,6 +184,9 @@ export type SessionStatus =
   | "clone_failed"
   | "dry_run";
 
+// "down" means the agent missed three heartbeats without saying it stopped
+export type AgentStatus = "alive" | "stopped" | "down";
+
 // ==================== UTILITY TYPES ====================
 
 export interface FileTreeNode {